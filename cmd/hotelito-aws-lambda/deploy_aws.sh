@@ -5,7 +5,6 @@ set -o errexit
 ORIGINAL_FILE_3CX=../../3cx/src/crm-template-cloudbeds-3cx-template.xml
 FINAL_FILE_3CX=../../3cx/crm-template-cloudbeds-3cx.xml
 FILE_ROOMID_EXTENSION_MAP=../../config.json
-FILE_3CX_API_CONF=../../cloudbeds_api_params.json
 
 # if samconfig.toml doesn't exist - advise to run sam deploy --guided first
 if [ ! -f samconfig.toml ]; then
@@ -68,9 +67,16 @@ while IFS= read -r line || [[ -n "$line" ]]; do
     if [ "$name" == "AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID" ]; then
       export AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID="${value}"
     fi
+    if [ "$name" == "HOSPITALITY_API_CONF_FILENAME" ]; then
+      export HOSPITALITY_API_CONF_FILENAME="${value}"
+    fi
   fi
 done <${ENV_FILE}
 
+# API configuration of the hospitality provider. Lambdas fetch it from S3 by this name
+HOSPITALITY_API_CONF_FILENAME="${HOSPITALITY_API_CONF_FILENAME:-cloudbeds_api_params.json}"
+FILE_3CX_API_CONF="../../${HOSPITALITY_API_CONF_FILENAME}"
+
 # read the .env file
 while IFS= read -r line || [[ -n "$line" ]]; do
 
@@ -118,7 +124,7 @@ sam deploy \
   --resolve-s3 \
   --capabilities CAPABILITY_IAM \
   --confirm-changeset \
-  --parameter-overrides "ParameterKey=LogLevel,ParameterValue=${LOG_LEVEL} ParameterKey=S3BucketMap3CXRoomExtClBedsRoomId,ParameterValue=${AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID} ParameterKey=ApplicationName,ParameterValue=${APPLICATION_NAME} ParameterKey=Environment,ParameterValue=${ENVIRONMENT}"
+  --parameter-overrides "ParameterKey=LogLevel,ParameterValue=${LOG_LEVEL} ParameterKey=S3BucketMap3CXRoomExtClBedsRoomId,ParameterValue=${AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID} ParameterKey=HospitalityApiConfFileName,ParameterValue=${HOSPITALITY_API_CONF_FILENAME} ParameterKey=ApplicationName,ParameterValue=${APPLICATION_NAME} ParameterKey=Environment,ParameterValue=${ENVIRONMENT}"
 
 sleep 10 # just in case - wait for the stack to be created
 
@@ -207,7 +213,7 @@ echo "Uploading ${FILE_ROOMID_EXTENSION_MAP} to s3://${AWS_S3_BUCKET_4_MAP_3CXRO
 aws s3 cp --profile ${AWS_CONFIG_PROFILE} "${FILE_ROOMID_EXTENSION_MAP}" "s3://${AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID}/"
 
 
-# 7. Upload cloudbeds_api_v1.2_params.json to S3
+# 7. Upload the API configuration (HOSPITALITY_API_CONF_FILENAME) to S3
 # Set the bucket name variable
 echo "Uploading ${FILE_3CX_API_CONF} to s3://${AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID}"
 # Upload the file to S3 bucket
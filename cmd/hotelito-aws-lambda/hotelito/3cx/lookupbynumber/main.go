@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/lambda_boilerplate"
+	"github.com/olegromanchuk/hotelito/internal/handlers"
 	"github.com/olegromanchuk/hotelito/pkg/pbx/pbx3cx"
+	"github.com/sirupsen/logrus"
 	"net/http"
 )
 
@@ -19,7 +23,15 @@ func HandleLookupByNumber(ctx context.Context, request events.APIGatewayProxyReq
 	log.Debugf("Handling lookup by number request: %v", request)
 	number := request.QueryStringParameters["Number"]
 
-	jsonAsBytes := pbx3cx.ProcessLookupByNumber(number) //returns dummy contact with "number"
+	jsonAsBytes, err := Execute(ctx, log, number, nil)
+	if err != nil {
+		//3CX sends the call journal request only if it gets a contact back. So we never fail here and reply with a dummy contact
+		log.Errorf("Error looking up guest for number %s: %v", number, err)
+		jsonAsBytes, err = pbx3cx.ProcessLookupByNumber(number, nil) //returns dummy contact with "number"
+		if err != nil {
+			log.Error(err)
+		}
+	}
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
@@ -27,6 +39,36 @@ func HandleLookupByNumber(ctx context.Context, request events.APIGatewayProxyReq
 	}, nil
 }
 
+// Execute returns contact information for number. If number is a room extension with an in-house guest, the guest is returned
+// customAWSConfig is needed for testing to redirect AWS traffic to localstack. In production, we pass nil for customAWSConfig.
+func Execute(ctx context.Context, log *logrus.Logger, number string, customAWSConfig *aws.Config) (jsonAsBytes []byte, returnError error) {
+	appName, environmentType, awsRegion := lambda_boilerplate.InitializeVariablesFromEnv(log)
+	storePrefix := fmt.Sprintf("%s/%s", appName, environmentType) //hotelito-app-production
+
+	//current secret store - aws env variables by default (SECRETS_STORE)
+	storeClient, err := lambda_boilerplate.InitializeStore(log, storePrefix, awsRegion, customAWSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	//get information about mapping: room extension -- cloudbeds room ID from S3 bucket
+	configMap, err := lambda_boilerplate.LoadConfigMap(log, storeClient, awsRegion, customAWSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	//create hospitality (HOTEL_PROVIDER) and PBX (PBX_PROVIDER) clients
+	hotelClient, pbxClient, err := lambda_boilerplate.NewProviders(log, storeClient, configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	//define handlers
+	h := handlers.NewHandler(log, pbxClient, hotelClient)
+
+	return h.LookupByNumber(ctx, number), nil
+}
+
 func main() {
 	lambda.Start(HandleLookupByNumber)
 }
@@ -7,97 +7,54 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/lambda_boilerplate"
-	"github.com/olegromanchuk/hotelito/internal/configuration"
 	"github.com/olegromanchuk/hotelito/internal/handlers"
-	"github.com/olegromanchuk/hotelito/pkg/hotel/cloudbeds"
-	"github.com/olegromanchuk/hotelito/pkg/pbx/pbx3cx"
-	"github.com/olegromanchuk/hotelito/pkg/secrets/awsstore"
+	"github.com/olegromanchuk/hotelito/pkg/pbx"
 	"github.com/sirupsen/logrus"
 	"net/http"
-	"os"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
-func HandleProcessOutboundCall(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-
-	fmt.Printf("[%s] Started HandleProcessOutboundCall", time.Now().String())
+// the environment of Execute. Replaced in tests that run without AWS
+var (
+	initializeStore = lambda_boilerplate.InitializeStore
+	loadConfigMap   = lambda_boilerplate.LoadConfigMap
+	newProviders    = lambda_boilerplate.NewProviders
+	newNotifier     = lambda_boilerplate.Notifier
+)
 
+// HandleProcessOutboundCall replies to 3CX with the status code chosen by Execute: 400 for invalid requests,
+// 401 if login is required, 503 if the hospitality provider is unavailable
+func HandleProcessOutboundCall(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log := lambda_boilerplate.InitializeLogger()
 	log.Debug(request)
 
-	responseApiGateway, err := Execute(log, request, nil)
+	responseApiGateway, err := Execute(ctx, log, request, nil)
 	if err != nil {
 		log.Errorf("Error executing handler: %v", err)
-		responseApiGateway.StatusCode = http.StatusOK //we need to reply with dignity: 200 to cloudbeds
-		responseApiGateway.Body = fmt.Sprintf("Error: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       fmt.Sprintf("Error: %v", err),
+		}, nil
 	}
-
-	responseApiGateway.StatusCode = http.StatusOK //we need to reply with dignity: 200 to cloudbeds, even if error is returned
 	return responseApiGateway, nil
 }
 
-func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAWSConfig *aws.Config) (responseApiGateway events.APIGatewayProxyResponse, returnError error) {
+func Execute(ctx context.Context, log *logrus.Logger, request events.APIGatewayProxyRequest, customAWSConfig *aws.Config) (responseApiGateway events.APIGatewayProxyResponse, returnError error) {
 	appName, environmentType, awsRegion := lambda_boilerplate.InitializeVariablesFromEnv(log)
 	storePrefix := fmt.Sprintf("%s/%s", appName, environmentType) //hotelito-app-production
 
-	//current secret store - aws env variables
-	storeClient, err := awsstore.Initialize(log, storePrefix, awsRegion, customAWSConfig)
+	//current secret store - aws env variables by default (SECRETS_STORE)
+	storeClient, err := initializeStore(log, storePrefix, awsRegion, customAWSConfig)
 	if err != nil {
 		return responseApiGateway, err
 	}
 
-	awsBucketName := os.Getenv("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID")
-	if awsBucketName == "" {
-		//get from awsstore if localenv is empty
-		log.Debug("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID env variable is not set. Trying store")
-		awsBucketName, err = storeClient.RetrieveVar("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID")
-		if err != nil {
-			errMsg := fmt.Sprintf("failed to retrieve AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID from store: %v", err)
-			log.Error(errMsg)
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Body:       errMsg,
-			}, nil
-		}
-	}
-	log.Debugf("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID: %s", awsBucketName)
-
-	log.Debugf("Fetching config.json from S3 bucket %s", awsBucketName)
-	//get information about mapping: room extension -- cloudbeds room ID
-	//fetchS3ObjectAndSaveToFile is a helper function to fetch object from S3 and save it to file
-	mapFullFileName, err := fetchS3ObjectAndSaveToFile(log, awsBucketName, "config.json", awsRegion, customAWSConfig) // Replace with your bucket name and the file name
-	if err != nil || mapFullFileName == "" {
-		errMsg := fmt.Sprintf("failed to fetch object: %v. Check if AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID is set and S3 bucket with config.json exists", err)
-		log.Error(errMsg)
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Body:       errMsg,
-		}, nil
-	}
-
-	log.Debugf("Fetching cloudbeds_api_params.json from S3 bucket %s", awsBucketName)
-	//get information about mapping: room extension -- cloudbeds room ID
-	//fetchS3ObjectAndSaveToFile is a helper function to fetch object from S3 and save it to file
-	clBedsApiConfigFile, err := fetchS3ObjectAndSaveToFile(log, awsBucketName, "cloudbeds_api_params.json", awsRegion, customAWSConfig) // Replace with your bucket name and the file name
-	if err != nil || mapFullFileName == "" {
-		errMsg := fmt.Sprintf("failed to fetch object: %v. Check if AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID is set and S3 bucket with cloudbeds_api_params.json exists", err)
-		log.Error(errMsg)
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Body:       errMsg,
-		}, nil
-	}
-
-	//parse config.json
-	configMap, err := configuration.New(log, mapFullFileName, clBedsApiConfigFile)
+	//get information about mapping: room extension -- cloudbeds room ID from S3 bucket
+	configMap, err := loadConfigMap(log, storeClient, awsRegion, customAWSConfig)
 	if err != nil {
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusInternalServerError,
@@ -105,10 +62,10 @@ func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAW
 		}, nil
 	}
 
-	//create cloudbeds client
-	clbClient, err := cloudbeds.New(log, storeClient, configMap)
+	//create hospitality (HOTEL_PROVIDER) and PBX (PBX_PROVIDER) clients
+	hotelClient, pbxClient, err := newProviders(log, storeClient, configMap)
 	if err != nil {
-		log.Errorf("Error creating cloudbeds client: %v", err)
+		log.Errorf("Error creating providers: %v", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusInternalServerError,
 			Body:       fmt.Sprintf("Error: %v", err),
@@ -116,10 +73,10 @@ func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAW
 	}
 
 	//option via handler interface. Helpful for testing
-	//create 3cx client
-	pbx3cxClient := pbx3cx.New(log, configMap)
 	//define handlers
-	h := handlers.NewHandler(log, pbx3cxClient, clbClient)
+	h := handlers.NewHandler(log, pbxClient, hotelClient)
+	h.Notifier = newNotifier(log)
+	h.UpdateFailures = lambda_boilerplate.UpdateFailures() //failures in a row are counted across invocations
 
 	body := request.Body
 	if request.IsBase64Encoded {
@@ -132,20 +89,16 @@ func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAW
 
 	log.Debugf("Request body: %s", body)
 	decoder := json.NewDecoder(strings.NewReader(body))
-	room, err := h.PBX.ProcessPBXRequest(decoder)
+	room, err := h.PBX.ProcessPBXRequest(ctx, decoder)
 	if err != nil {
-		if err.Error() == "incoming-call-ignoring" { //ignore incoming calls. Specific of 3CX. 3CX sends 2 request for each call: incoming(through loopback) and outgoing
-			h.Log.Debugf("Ignoring incoming call")
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
-		}
-		if err.Error() == "outgoing-regular-call-ignoring" { //regular outbound call is not related to room status
-			h.Log.Debugf("Ignoring regular outgoing call")
+		//3CX sends 2 request for each call: incoming(through loopback) and outgoing. Incoming and regular outgoing calls are not related to room status
+		if errors.Is(err, pbx.ErrIgnored) {
+			h.Log.Debugf("Ignoring PBX request: %s", err)
 			return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
 		}
 		h.Log.Error(err)
-		log.Error(err)
 		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: handlers.StatusCode(err),
 			Body:       fmt.Sprintf("Error: %v", err),
 		}, nil
 	}
@@ -153,19 +106,16 @@ func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAW
 		h.Log.Error("Room phone number is empty")
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusBadRequest,
-			Body:       fmt.Sprintf("Error: %v", err),
+			Body:       "Error: room phone number is empty",
 		}, nil
 	}
 	h.Log.Debugf("Room phone number: %s", room.PhoneNumber)
 
-	//get provider
-	hotelProvider := h.Hotel
-
-	msg, err := hotelProvider.UpdateRoom(room.PhoneNumber, room.RoomCondition, room.HousekeeperName)
+	msg, err := h.UpdateRoomFromPBX(ctx, room)
 	if err != nil {
 		h.Log.Error(err)
 		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: handlers.StatusCode(err),
 			Body:       fmt.Sprintf("Error: %v", err),
 		}, nil
 	}
@@ -178,42 +128,6 @@ func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAW
 
 }
 
-// fetchS3ObjectAndSaveToFile is a helper function to fetch object from S3 and save it to file
-func fetchS3ObjectAndSaveToFile(log *logrus.Logger, bucket, fileName string, awsRegion string, customAWSConfig *aws.Config) (filename string, err error) {
-
-	awsConfig := awsstore.PrepareAWSConfig(awsRegion, customAWSConfig)
-	fullFileName := fmt.Sprintf("/tmp/%s", fileName)
-	sess, err := session.NewSession(awsConfig)
-
-	if err != nil {
-		return "", err
-	}
-
-	downloader := s3manager.NewDownloader(sess)
-	log.Tracef("Downloading %s from bucket %s", fileName, bucket)
-	file, err := os.Create(fullFileName) //save file to current directory. Exists only for current lambda execution
-	if err != nil {
-		errMsg := fmt.Sprintf("Unable to open file %q for writing - %v", fileName, err)
-		log.Error(errMsg)
-		return "", errors.New(errMsg)
-	}
-
-	defer file.Close()
-
-	bytesDownloaded, err := downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(fileName),
-		})
-	if err != nil {
-		errMsg := fmt.Sprintf("Unable to download item %q, %v", fileName, err)
-		log.Error(errMsg)
-		return "", errors.New(errMsg)
-	}
-	log.Tracef("Stored to %s from bucket %s, %d bytes", fullFileName, bucket, bytesDownloaded)
-	return fullFileName, nil
-}
-
 func main() {
 	lambda.Start(HandleProcessOutboundCall)
 }
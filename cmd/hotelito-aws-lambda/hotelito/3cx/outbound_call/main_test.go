@@ -2,17 +2,26 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/lambda_boilerplate"
 	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/localstacktest"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
+	"github.com/olegromanchuk/hotelito/pkg/pbx"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
@@ -164,7 +173,7 @@ func TestExecute(t *testing.T) {
 				localstacktest.CreateFilesInS3(tt.args.customAWSConfig, awsBucketName, tt.createFileInS3BucketFileName)
 			}
 
-			gotResponseApiGateway, _ := Execute(tt.args.log, tt.args.request, tt.args.customAWSConfig)
+			gotResponseApiGateway, _ := Execute(context.Background(), tt.args.log, tt.args.request, tt.args.customAWSConfig)
 
 			assert.Equal(t, tt.wantResponseApiGateway.StatusCode, gotResponseApiGateway.StatusCode)
 			assert.Contains(t, gotResponseApiGateway.Body, tt.wantResponseApiGateway.Body)
@@ -233,7 +242,7 @@ func TestFetchS3ObjectAndSaveToFile(t *testing.T) {
 
 			tt.customAWSConfig.Region = aws.String(tt.awsRegion)
 			// Test
-			downloadedFileName, err := fetchS3ObjectAndSaveToFile(log, tt.bucket, tt.fileName, tt.awsRegion, &tt.customAWSConfig)
+			downloadedFileName, err := lambda_boilerplate.FetchS3ObjectAndSaveToFile(log, tt.bucket, tt.fileName, tt.awsRegion, &tt.customAWSConfig)
 			if tt.expectError {
 				require.Error(t, err)
 				return
@@ -265,13 +274,13 @@ func TestHandleProcessOutboundCall(t *testing.T) {
 		want    events.APIGatewayProxyResponse
 		wantErr assert.ErrorAssertionFunc
 	}{
-		{name: "test 1",
+		{name: "test 1. Config map is not available",
 			args: args{
 				ctx:     context.Background(),
 				request: events.APIGatewayProxyRequest{},
 			},
 			want: events.APIGatewayProxyResponse{
-				StatusCode: 200,
+				StatusCode: 500,
 			},
 			wantErr: assert.NoError,
 		},
@@ -287,3 +296,100 @@ func TestHandleProcessOutboundCall(t *testing.T) {
 		})
 	}
 }
+
+// failingHotel is a hospitality provider whose room updates fail. Only UpdateRoom is implemented
+type failingHotel struct {
+	hotel.Provider
+	err error
+}
+
+func (p *failingHotel) UpdateRoom(ctx context.Context, roomNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	return "", p.err
+}
+
+// roomPBX is a PBX provider that always reports the room update of extension 1001
+type roomPBX struct {
+	pbx.PBXProvider
+}
+
+func (p *roomPBX) ProcessPBXRequest(ctx context.Context, jsonDecoder *json.Decoder) (pbx.Room, error) {
+	return pbx.Room{PhoneNumber: "1001", RoomCondition: "clean", HousekeeperName: "John Doe"}, nil
+}
+
+type fakeAlertSender struct {
+	alerts []notify.Alert
+}
+
+func (f *fakeAlertSender) Send(ctx context.Context, alert notify.Alert) error {
+	f.alerts = append(f.alerts, alert)
+	return nil
+}
+
+// every invocation creates a new handler: failures in a row are counted by the container
+func TestExecute_UpdateFailuresAlert(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	sender := &fakeAlertSender{}
+	notifier := notify.New(log, sender)
+	hotelClient := &failingHotel{err: hotel.NewError(hotel.ErrTransient, errors.New("cloudbeds is unavailable"))}
+
+	initialize, load, providers, notifierFunc := initializeStore, loadConfigMap, newProviders, newNotifier
+	defer func() {
+		initializeStore, loadConfigMap, newProviders, newNotifier = initialize, load, providers, notifierFunc
+	}()
+	initializeStore = func(*logrus.Logger, string, string, *aws.Config) (secrets.SecretsStore, error) { return nil, nil }
+	loadConfigMap = func(*logrus.Logger, secrets.SecretsStore, string, *aws.Config) (*configuration.ConfigMap, error) {
+		return &configuration.ConfigMap{}, nil
+	}
+	newProviders = func(*logrus.Logger, secrets.SecretsStore, *configuration.ConfigMap) (hotel.Provider, pbx.PBXProvider, error) {
+		return hotelClient, &roomPBX{}, nil
+	}
+	newNotifier = func(*logrus.Logger) *notify.Notifier { return notifier }
+
+	lambda_boilerplate.UpdateFailures().Record(false) //other tests may leave failures in the shared counter
+	request := events.APIGatewayProxyRequest{Body: `{"Number": "1001"}`}
+	for i := 0; i < 3; i++ {
+		response, err := Execute(context.Background(), log, request, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	}
+
+	require.Len(t, sender.alerts, 1)
+	assert.Equal(t, notify.KindUpdateFailures, sender.alerts[0].Kind)
+	assert.Contains(t, sender.alerts[0].Message, "3 room updates failed in a row")
+}
+
+// the status code chosen by Execute is returned to 3CX
+func TestHandleProcessOutboundCall_StatusCode(t *testing.T) {
+	initialize, load, providers, notifierFunc := initializeStore, loadConfigMap, newProviders, newNotifier
+	defer func() {
+		initializeStore, loadConfigMap, newProviders, newNotifier = initialize, load, providers, notifierFunc
+	}()
+	initializeStore = func(*logrus.Logger, string, string, *aws.Config) (secrets.SecretsStore, error) { return nil, nil }
+	loadConfigMap = func(*logrus.Logger, secrets.SecretsStore, string, *aws.Config) (*configuration.ConfigMap, error) {
+		return &configuration.ConfigMap{}, nil
+	}
+	newNotifier = func(*logrus.Logger) *notify.Notifier { return nil }
+
+	tests := []struct {
+		name           string
+		updateErr      error
+		expectedStatus int
+	}{
+		{"login required", hotel.NewError(hotel.ErrAuthorization, errors.New("invalid token")), http.StatusUnauthorized},
+		{"provider unavailable", hotel.NewError(hotel.ErrTransient, errors.New("cloudbeds is unavailable")), http.StatusServiceUnavailable},
+		{"rejected update", hotel.NewError(hotel.ErrValidation, errors.New("unknown room")), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		updateErr := tt.updateErr
+		t.Run(tt.name, func(t *testing.T) {
+			newProviders = func(*logrus.Logger, secrets.SecretsStore, *configuration.ConfigMap) (hotel.Provider, pbx.PBXProvider, error) {
+				return &failingHotel{err: updateErr}, &roomPBX{}, nil
+			}
+			response, err := HandleProcessOutboundCall(context.Background(), events.APIGatewayProxyRequest{Body: `{"Number": "1001"}`})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, response.StatusCode)
+		})
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/olegromanchuk/hotelito/internal/handlers"
 	"github.com/olegromanchuk/hotelito/pkg/hotel/cloudbeds"
 	"github.com/olegromanchuk/hotelito/pkg/pbx/pbx3cx"
-	"github.com/olegromanchuk/hotelito/pkg/secrets/awsstore"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"time"
@@ -23,7 +22,7 @@ func HandleInit(ctx context.Context, request events.APIGatewayProxyRequest) (eve
 	log := lambda_boilerplate.InitializeLogger()
 	log.Debug(request)
 
-	responseApiGateway, err := Execute(log, nil)
+	responseApiGateway, err := Execute(ctx, log, nil)
 	if err != nil {
 		log.Errorf("Error executing handler: %v", err)
 		responseApiGateway.StatusCode = http.StatusInternalServerError //
@@ -33,20 +32,18 @@ func HandleInit(ctx context.Context, request events.APIGatewayProxyRequest) (eve
 	return responseApiGateway, nil
 }
 
-func Execute(log *logrus.Logger, customAWSConfig *aws.Config) (responseApiGateway events.APIGatewayProxyResponse, returnError error) {
+func Execute(ctx context.Context, log *logrus.Logger, customAWSConfig *aws.Config) (responseApiGateway events.APIGatewayProxyResponse, returnError error) {
 
 	appName, environmentType, awsRegion := lambda_boilerplate.InitializeVariablesFromEnv(log)
 	storePrefix := fmt.Sprintf("%s/%s", appName, environmentType) //hotelito-app-production
 
-	//Initialize current secret store - aws env variables
-	storeClient, err := awsstore.Initialize(log, storePrefix, awsRegion, customAWSConfig)
+	//Initialize current secret store - aws env variables by default (SECRETS_STORE)
+	storeClient, err := lambda_boilerplate.InitializeStore(log, storePrefix, awsRegion, customAWSConfig)
 	if err != nil {
-		errMsg := fmt.Sprintf("error initializing AWS SSM store with store prefix %s in region %s. Error: %v", storePrefix, awsRegion, err)
+		errMsg := fmt.Sprintf("error initializing secret store with store prefix %s in region %s. Error: %v", storePrefix, awsRegion, err)
 		return responseApiGateway, errors.New(errMsg)
 	}
 
-	storeClient.Log = log //set logger for store client
-
 	//create cloudbeds client
 	clbClient, err := cloudbeds.NewClient4CallbackAndInit(log, storeClient)
 	if err != nil {
@@ -65,7 +62,7 @@ func Execute(log *logrus.Logger, customAWSConfig *aws.Config) (responseApiGatewa
 	pbx3cxClient := &pbx3cx.PBX3CX{} //we do not need full-blown 3cx client for initial authorization
 	//define handlers
 	h := handlers.NewHandler(log, pbx3cxClient, clbClient)
-	url, err := h.Hotel.HandleInitialLogin()
+	url, err := h.Hotel.HandleInitialLogin(ctx)
 	if err != nil {
 		log.Error(err)
 		responseApiGateway = events.APIGatewayProxyResponse{
@@ -74,6 +71,11 @@ func Execute(log *logrus.Logger, customAWSConfig *aws.Config) (responseApiGatewa
 		}
 		return responseApiGateway, err
 	}
+	if url == "" { //provider doesn't use interactive login (Cloudbeds API key)
+		responseApiGateway.StatusCode = http.StatusOK
+		responseApiGateway.Body = "Login is not required"
+		return responseApiGateway, nil
+	}
 	log.Debugf("redirect url: %s", url)
 
 	responseApiGateway.StatusCode = http.StatusFound
@@ -105,7 +105,7 @@ func TestExecute(t *testing.T) {
 			mapOfValues := map[string]string{"state": "someRandomString"}
 			localstacktest.SaveValuesToLocalStack(mapOfValues, tt.args.customAWSConfig)
 
-			resp, err := Execute(tt.args.log, tt.args.customAWSConfig)
+			resp, err := Execute(context.Background(), tt.args.log, tt.args.customAWSConfig)
 
 			if tt.hasError {
 				assert.Error(t, err)
@@ -8,7 +8,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/lambda_boilerplate"
 	"github.com/olegromanchuk/hotelito/pkg/hotel/cloudbeds"
-	"github.com/olegromanchuk/hotelito/pkg/secrets/awsstore"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"time"
@@ -21,7 +20,7 @@ func HandleCallback(ctx context.Context, request events.APIGatewayProxyRequest)
 	log := lambda_boilerplate.InitializeLogger()
 	log.Debug(request)
 
-	responseApiGateway, err := Execute(log, request, nil)
+	responseApiGateway, err := Execute(ctx, log, request, nil)
 	if err != nil {
 		log.Errorf("Error executing handler: %v", err)
 		responseApiGateway.StatusCode = http.StatusOK //we need to reply with dignity: 200 to cloudbeds
@@ -33,14 +32,14 @@ func HandleCallback(ctx context.Context, request events.APIGatewayProxyRequest)
 
 // Execute is the main function that handles the request
 // customAWSConfig is needed for testing to redirect AWS.SSM traffic to localstack. In production, we pass nil for customAWSConfig.
-func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAWSConfig *aws.Config) (responseApiGateway events.APIGatewayProxyResponse, returnError error) {
+func Execute(ctx context.Context, log *logrus.Logger, request events.APIGatewayProxyRequest, customAWSConfig *aws.Config) (responseApiGateway events.APIGatewayProxyResponse, returnError error) {
 	responseApiGateway = events.APIGatewayProxyResponse{}
 
 	appName, environmentType, awsRegion := lambda_boilerplate.InitializeVariablesFromEnv(log)
 	storePrefix := fmt.Sprintf("%s/%s", appName, environmentType) //hotelito-app-production
 
-	//current secret store - aws env variables
-	storeClient, err := awsstore.Initialize(log, storePrefix, awsRegion, customAWSConfig)
+	//current secret store - aws env variables by default (SECRETS_STORE)
+	storeClient, err := lambda_boilerplate.InitializeStore(log, storePrefix, awsRegion, customAWSConfig)
 	if err != nil {
 		return responseApiGateway, err
 	}
@@ -57,7 +56,7 @@ func Execute(log *logrus.Logger, request events.APIGatewayProxyRequest, customAW
 	state := request.QueryStringParameters["state"]
 	code := request.QueryStringParameters["code"]
 
-	err = clbClient.HandleOAuthCallback(state, code)
+	err = clbClient.HandleOAuthCallback(ctx, state, code)
 	if err != nil {
 		log.Error(err)
 		return responseApiGateway, err
@@ -133,7 +133,7 @@ func TestExecute(t *testing.T) {
 				localstacktest.SaveValuesToLocalStack(mapOfValues, tt.args.customAWSConfig)
 			}
 
-			gotResponseApiGateway, err := Execute(tt.args.log, tt.args.request, tt.args.customAWSConfig)
+			gotResponseApiGateway, err := Execute(context.Background(), tt.args.log, tt.args.request, tt.args.customAWSConfig)
 
 			if tt.expectedErrorContains != nil {
 				assert.Contains(t, err.Error(), tt.expectedErrorContains.Error())
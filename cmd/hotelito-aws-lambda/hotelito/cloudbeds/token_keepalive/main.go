@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/lambda_boilerplate"
+	"github.com/olegromanchuk/hotelito/internal/rules"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleKeepAlive is invoked by EventBridge schedule. The returned error fails the invocation, so the failure is seen
+// by CloudWatch alarm on the Errors metric of the function
+func HandleKeepAlive(ctx context.Context, event events.CloudWatchEvent) error {
+
+	log := lambda_boilerplate.InitializeLogger()
+	log.Debug(event)
+
+	return Execute(ctx, log, nil)
+}
+
+// Execute refreshes the token of the hospitality provider, so it doesn't go stale if nobody calls for a long time.
+// customAWSConfig is needed for testing to redirect AWS traffic to localstack. In production, we pass nil for customAWSConfig.
+func Execute(ctx context.Context, log *logrus.Logger, customAWSConfig *aws.Config) error {
+	appName, environmentType, awsRegion := lambda_boilerplate.InitializeVariablesFromEnv(log)
+	storePrefix := fmt.Sprintf("%s/%s", appName, environmentType) //hotelito-app-production
+
+	//current secret store - aws env variables by default (SECRETS_STORE)
+	storeClient, err := lambda_boilerplate.InitializeStore(log, storePrefix, awsRegion, customAWSConfig)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	//get information about mapping: room extension -- cloudbeds room ID from S3 bucket
+	configMap, err := lambda_boilerplate.LoadConfigMap(log, storeClient, awsRegion, customAWSConfig)
+	if err != nil {
+		return err
+	}
+
+	//create hospitality (HOTEL_PROVIDER) and PBX (PBX_PROVIDER) clients
+	hotelClient, _, err := lambda_boilerplate.NewProviders(log, storeClient, configMap)
+	if err != nil {
+		log.Errorf("Error creating providers: %v", err)
+		return err
+	}
+
+	return keepTokenAlive(ctx, log, hotelClient, lambda_boilerplate.Notifier(log))
+}
+
+// keepTokenAlive refreshes the token once and alerts admins via notifier if it fails. Providers without refresh token are skipped
+func keepTokenAlive(ctx context.Context, log *logrus.Logger, hotelClient hotel.Provider, notifier *notify.Notifier) error {
+	tokenKeeper, ok := hotelClient.(hotel.TokenKeeper)
+	if !ok {
+		log.Infof("Hospitality provider doesn't need token refresh. Disable the schedule of this function")
+		return nil
+	}
+	keepAlive := rules.NewKeepAlive(log, tokenKeeper, 0)
+	keepAlive.Notifier = notifier
+	return keepAlive.Refresh(ctx)
+}
+
+func main() {
+	lambda.Start(HandleKeepAlive)
+}
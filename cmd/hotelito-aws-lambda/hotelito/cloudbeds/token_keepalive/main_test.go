@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// tokenKeeperProvider is a hospitality provider with refresh token. Only KeepTokenAlive is implemented
+type tokenKeeperProvider struct {
+	hotel.Provider
+	err   error
+	calls int
+}
+
+func (p *tokenKeeperProvider) KeepTokenAlive(ctx context.Context) error {
+	p.calls++
+	return p.err
+}
+
+// providerWithoutToken is a hospitality provider that doesn't need the token refresh
+type providerWithoutToken struct {
+	hotel.Provider
+}
+
+// only the refresh is tested here. Creation of the provider needs localstack
+func TestKeepTokenAlive(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	sender := &fakeAlertSender{}
+	notifier := notify.New(log, sender)
+
+	provider := &tokenKeeperProvider{}
+	assert.NoError(t, keepTokenAlive(context.Background(), log, provider, notifier))
+	assert.Equal(t, 1, provider.calls)
+	assert.Empty(t, sender.alerts)
+
+	//the failure fails the invocation and alerts admins
+	provider.err = errors.New("refresh token error")
+	assert.EqualError(t, keepTokenAlive(context.Background(), log, provider, notifier), "refresh token error")
+	assert.Len(t, sender.alerts, 1)
+	assert.Equal(t, notify.KindTokenRefreshFailed, sender.alerts[0].Kind)
+
+	assert.NoError(t, keepTokenAlive(context.Background(), log, &providerWithoutToken{}, nil))
+}
+
+type fakeAlertSender struct {
+	alerts []notify.Alert
+}
+
+func (f *fakeAlertSender) Send(ctx context.Context, alert notify.Alert) error {
+	f.alerts = append(f.alerts, alert)
+	return nil
+}
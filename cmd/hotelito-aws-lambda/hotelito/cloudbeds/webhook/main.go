@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/lambda_boilerplate"
+	"github.com/olegromanchuk/hotelito/internal/handlers"
+	"github.com/olegromanchuk/hotelito/internal/rules"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const subscribePathSuffix = "/subscribe"
+
+func HandleWebhook(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	log := lambda_boilerplate.InitializeLogger()
+	log.Debug(request)
+
+	return Execute(ctx, log, request, nil), nil
+}
+
+// Execute handles Cloudbeds webhook (POST /api/v1/cloudbeds/webhook) or subscribes to webhooks (POST /api/v1/cloudbeds/webhook/subscribe).
+// Both requests must have HOSPITALITY_WEBHOOK_TOKEN in the token query parameter.
+// customAWSConfig is needed for testing to redirect AWS traffic to localstack. In production, we pass nil for customAWSConfig.
+func Execute(ctx context.Context, log *logrus.Logger, request events.APIGatewayProxyRequest, customAWSConfig *aws.Config) events.APIGatewayProxyResponse {
+	isSubscribeRequest := strings.HasSuffix(request.Path, subscribePathSuffix)
+
+	//check the token before touching any AWS resources. Subscribe requests need the same token as webhooks
+	webhookToken := os.Getenv("HOSPITALITY_WEBHOOK_TOKEN")
+	if webhookToken == "" {
+		log.Errorf("Request to %s from %s is rejected: HOSPITALITY_WEBHOOK_TOKEN is not set", request.Path, request.RequestContext.Identity.SourceIP)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusUnauthorized}
+	}
+	if !handlers.ValidWebhookToken(webhookToken, request.QueryStringParameters["token"]) {
+		log.Warnf("Request to %s with invalid token received from %s", request.Path, request.RequestContext.Identity.SourceIP)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusUnauthorized}
+	}
+
+	appName, environmentType, awsRegion := lambda_boilerplate.InitializeVariablesFromEnv(log)
+	storePrefix := fmt.Sprintf("%s/%s", appName, environmentType) //hotelito-app-production
+
+	//current secret store - aws env variables by default (SECRETS_STORE)
+	storeClient, err := lambda_boilerplate.InitializeStore(log, storePrefix, awsRegion, customAWSConfig)
+	if err != nil {
+		log.Error(err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       fmt.Sprintf("Error: %v", err),
+		}
+	}
+
+	//get information about mapping: room extension -- cloudbeds room ID from S3 bucket
+	configMap, err := lambda_boilerplate.LoadConfigMap(log, storeClient, awsRegion, customAWSConfig)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       err.Error(),
+		}
+	}
+
+	//create hospitality (HOTEL_PROVIDER) and PBX (PBX_PROVIDER) clients
+	hotelClient, pbxClient, err := lambda_boilerplate.NewProviders(log, storeClient, configMap)
+	if err != nil {
+		log.Errorf("Error creating providers: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       fmt.Sprintf("Error: %v", err),
+		}
+	}
+
+	//define handlers
+	h := handlers.NewHandler(log, pbxClient, hotelClient)
+	h.Events = hotel.NewEventDispatcher()
+	h.WebhookURL = os.Getenv("HOSPITALITY_WEBHOOK_URL")
+	h.WebhookToken = webhookToken
+	rules.RegisterFromEnv(log, h.Events, hotelClient)
+
+	if isSubscribeRequest {
+		err = h.SubscribeWebhooks(ctx)
+		if err != nil {
+			log.Error(err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       fmt.Sprintf("Error: %v", err),
+			}
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK,
+			Body:       "Subscribed to webhooks",
+		}
+	}
+
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			log.Errorf("Error decoding base64 string: %v", err)
+		}
+		body = string(decoded)
+	}
+	log.Debugf("Request body: %s", body)
+
+	statusCode, msg := h.ProcessHospitalityWebhook(ctx, []byte(body))
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       msg,
+	}
+}
+
+func main() {
+	lambda.Start(HandleWebhook)
+}
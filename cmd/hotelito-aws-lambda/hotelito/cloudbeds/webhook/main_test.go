@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// only the token check is tested here. It happens before any AWS resources are used, so localstack is not needed
+func TestExecute_InvalidToken(t *testing.T) {
+	log := logrus.New()
+	os.Setenv("HOSPITALITY_WEBHOOK_TOKEN", "secretToken")
+	defer os.Unsetenv("HOSPITALITY_WEBHOOK_TOKEN")
+
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+	}{
+		{
+			name:    "no token",
+			request: events.APIGatewayProxyRequest{Path: "/api/v1/cloudbeds/webhook"},
+		},
+		{
+			name: "wrong token",
+			request: events.APIGatewayProxyRequest{
+				Path:                  "/api/v1/cloudbeds/webhook",
+				QueryStringParameters: map[string]string{"token": "wrongToken"},
+			},
+		},
+		{
+			name:    "subscribe without token",
+			request: events.APIGatewayProxyRequest{Path: "/api/v1/cloudbeds/webhook/subscribe"},
+		},
+		{
+			name: "subscribe with wrong token",
+			request: events.APIGatewayProxyRequest{
+				Path:                  "/api/v1/cloudbeds/webhook/subscribe",
+				QueryStringParameters: map[string]string{"token": "wrongToken"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := Execute(context.Background(), log, tt.request, nil)
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+		})
+	}
+}
+
+// webhooks and subscribe requests are rejected if the token is not configured
+func TestExecute_TokenNotSet(t *testing.T) {
+	log := logrus.New()
+	os.Unsetenv("HOSPITALITY_WEBHOOK_TOKEN")
+
+	request := events.APIGatewayProxyRequest{
+		Path:                  "/api/v1/cloudbeds/webhook",
+		QueryStringParameters: map[string]string{"token": ""},
+	}
+	response := Execute(context.Background(), log, request, nil)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request.Path = "/api/v1/cloudbeds/webhook/subscribe"
+	response = Execute(context.Background(), log, request, nil)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
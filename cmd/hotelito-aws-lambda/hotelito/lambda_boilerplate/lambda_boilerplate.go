@@ -1,9 +1,24 @@
 package lambda_boilerplate
 
 import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/handlers"
 	"github.com/olegromanchuk/hotelito/internal/logging"
+	_ "github.com/olegromanchuk/hotelito/internal/providers"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
+	"github.com/olegromanchuk/hotelito/pkg/pbx"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/olegromanchuk/hotelito/pkg/secrets/awsstore"
 	"github.com/sirupsen/logrus"
 	"os"
+	"sync"
 )
 
 // vars below are used ONLY if env vars are not set (testing only). It is not supposed to happen in production.
@@ -13,6 +28,9 @@ var (
 	defaultAwsRegion       = "us-east-2"
 )
 
+// defaultSecretsStore is used by lambdas when SECRETS_STORE is not set
+const defaultSecretsStore = "aws"
+
 func InitializeVariablesFromEnv(log *logrus.Logger) (appName, environmentType, awsRegion string) {
 	//get APP_NAME from env
 	appName = os.Getenv("APPLICATION_NAME")
@@ -39,6 +57,65 @@ func InitializeVariablesFromEnv(log *logrus.Logger) (appName, environmentType, a
 	return appName, environmentType, awsRegion
 }
 
+// InitializeStore creates the secret store SECRETS_STORE (aws by default) with prefix storePrefix (application-name/environment)
+// customAWSConfig is needed for testing to redirect AWS traffic to localstack. In production, we pass nil for customAWSConfig.
+func InitializeStore(log *logrus.Logger, storePrefix, awsRegion string, customAWSConfig *aws.Config) (secrets.SecretsStore, error) {
+	storeName := os.Getenv("SECRETS_STORE")
+	if storeName == "" {
+		storeName = defaultSecretsStore
+	}
+	log.Debugf("SECRETS_STORE: %s", storeName)
+
+	options := secrets.Options{
+		StorePrefix: storePrefix,
+		AWSRegion:   awsRegion,
+		AWSConfig:   customAWSConfig,
+	}
+	return secrets.NewStore(storeName, log, options)
+}
+
+// NewProviders creates the hospitality provider HOTEL_PROVIDER (cloudbeds by default) and PBX provider PBX_PROVIDER (3cx by default)
+func NewProviders(log *logrus.Logger, storeClient secrets.SecretsStore, configMap *configuration.ConfigMap) (hotel.Provider, pbx.PBXProvider, error) {
+	hotelClient, err := hotel.NewProvider(os.Getenv("HOTEL_PROVIDER"), log, storeClient, configMap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pbxClient, err := pbx.NewProvider(os.Getenv("PBX_PROVIDER"), log, configMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hotelClient, pbxClient, nil
+}
+
+// notifier is shared by the invocations of a warm lambda container, so repeated alerts are de-duplicated and rate limited
+var (
+	notifier     *notify.Notifier
+	notifierOnce sync.Once
+)
+
+// updateFailures counts room updates failed in a row across the invocations of a warm lambda container
+var updateFailures = &handlers.FailureCounter{}
+
+// UpdateFailures returns the counter of room updates failed in a row shared by the invocations of the lambda container
+func UpdateFailures() *handlers.FailureCounter {
+	return updateFailures
+}
+
+// Notifier returns the notifier configured with NOTIFY_* env variables. It is created once per lambda container.
+// Invalid configuration is logged and the alerts are only logged then
+func Notifier(log *logrus.Logger) *notify.Notifier {
+	notifierOnce.Do(func() {
+		n, err := notify.NewFromEnv(log)
+		if err != nil {
+			log.Errorf("failed to configure alerts: %v", err)
+			return
+		}
+		notifier = n
+	})
+	return notifier
+}
+
 func InitializeLogger() *logrus.Logger {
 	//define logger
 	log := logrus.New()
@@ -65,3 +142,85 @@ func InitializeLogger() *logrus.Logger {
 
 	return log
 }
+
+// defaultApiConfFileName is the API configuration of Cloudbeds, the default hospitality provider
+const defaultApiConfFileName = "cloudbeds_api_params.json"
+
+// LoadConfigMap fetches config.json and the API configuration of the hospitality provider HOSPITALITY_API_CONF_FILENAME
+// (cloudbeds_api_params.json by default) from S3 bucket AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID and parses them.
+// The bucket name is taken from env or from the store if env is empty.
+func LoadConfigMap(log *logrus.Logger, storeClient secrets.SecretsStore, awsRegion string, customAWSConfig *aws.Config) (*configuration.ConfigMap, error) {
+	var err error
+	awsBucketName := os.Getenv("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID")
+	if awsBucketName == "" {
+		//get from awsstore if localenv is empty
+		log.Debug("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID env variable is not set. Trying store")
+		awsBucketName, err = storeClient.RetrieveVar("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID")
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to retrieve AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID from store: %v", err)
+			log.Error(errMsg)
+			return nil, errors.New(errMsg)
+		}
+	}
+	log.Debugf("AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID: %s", awsBucketName)
+
+	log.Debugf("Fetching config.json from S3 bucket %s", awsBucketName)
+	//get information about mapping: room extension -- cloudbeds room ID
+	mapFullFileName, err := FetchS3ObjectAndSaveToFile(log, awsBucketName, "config.json", awsRegion, customAWSConfig)
+	if err != nil || mapFullFileName == "" {
+		errMsg := fmt.Sprintf("failed to fetch object: %v. Check if AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID is set and S3 bucket with config.json exists", err)
+		log.Error(errMsg)
+		return nil, errors.New(errMsg)
+	}
+
+	apiConfFileName := os.Getenv("HOSPITALITY_API_CONF_FILENAME")
+	if apiConfFileName == "" {
+		apiConfFileName = defaultApiConfFileName
+	}
+	log.Debugf("Fetching %s from S3 bucket %s", apiConfFileName, awsBucketName)
+	apiConfigFile, err := FetchS3ObjectAndSaveToFile(log, awsBucketName, apiConfFileName, awsRegion, customAWSConfig)
+	if err != nil || apiConfigFile == "" {
+		errMsg := fmt.Sprintf("failed to fetch object: %v. Check if AWS_S3_BUCKET_4_MAP_3CXROOMEXT_CLBEDSROOMID is set and S3 bucket with %s exists", err, apiConfFileName)
+		log.Error(errMsg)
+		return nil, errors.New(errMsg)
+	}
+
+	//parse config.json
+	return configuration.New(log, mapFullFileName, apiConfigFile)
+}
+
+// FetchS3ObjectAndSaveToFile is a helper function to fetch object from S3 and save it to file
+func FetchS3ObjectAndSaveToFile(log *logrus.Logger, bucket, fileName string, awsRegion string, customAWSConfig *aws.Config) (filename string, err error) {
+
+	awsConfig := awsstore.PrepareAWSConfig(awsRegion, customAWSConfig)
+	fullFileName := fmt.Sprintf("/tmp/%s", fileName)
+	sess, err := session.NewSession(awsConfig)
+
+	if err != nil {
+		return "", err
+	}
+
+	downloader := s3manager.NewDownloader(sess)
+	log.Tracef("Downloading %s from bucket %s", fileName, bucket)
+	file, err := os.Create(fullFileName) //save file to current directory. Exists only for current lambda execution
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to open file %q for writing - %v", fileName, err)
+		log.Error(errMsg)
+		return "", errors.New(errMsg)
+	}
+
+	defer file.Close()
+
+	bytesDownloaded, err := downloader.Download(file,
+		&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(fileName),
+		})
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to download item %q, %v", fileName, err)
+		log.Error(errMsg)
+		return "", errors.New(errMsg)
+	}
+	log.Tracef("Stored to %s from bucket %s, %d bytes", fullFileName, bucket, bytesDownloaded)
+	return fullFileName, nil
+}
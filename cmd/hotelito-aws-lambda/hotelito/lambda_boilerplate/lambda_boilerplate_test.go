@@ -3,11 +3,15 @@ package lambda_boilerplate
 import (
 	"bytes"
 	"github.com/olegromanchuk/hotelito/cmd/hotelito-aws-lambda/hotelito/localstacktest"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/secrets/boltstore"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -108,3 +112,33 @@ func TestInitializeLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestInitializeStore(t *testing.T) {
+	os.Setenv("SECRETS_STORE", "vault")
+	defer os.Unsetenv("SECRETS_STORE")
+
+	_, err := InitializeStore(logrus.New(), "hotelito-app/dev", "us-east-2", nil)
+	assert.EqualError(t, err, "unknown secret store 'vault'. Supported: aws, bolt")
+}
+
+func TestNewProviders(t *testing.T) {
+	storeClient, err := boltstore.Initialize(filepath.Join(t.TempDir(), "test.db"), "test_bucket")
+	require.NoError(t, err)
+	defer storeClient.Close()
+	configMap := &configuration.ConfigMap{ExtensionMap: []configuration.Extension{{RoomExtension: "1001", HospitalityRoomID: "544559-0"}}}
+
+	os.Setenv("HOTEL_PROVIDER", "opera-suite-8")
+	defer os.Unsetenv("HOTEL_PROVIDER")
+	_, _, err = NewProviders(logrus.New(), storeClient, configMap)
+	assert.EqualError(t, err, "unknown hospitality provider 'opera-suite-8'. Supported: apaleo, cloudbeds, generichttp, memory, mews, opera")
+
+	os.Setenv("HOTEL_PROVIDER", "memory")
+	_, pbxClient, err := NewProviders(logrus.New(), storeClient, configMap)
+	require.NoError(t, err)
+	assert.NotNil(t, pbxClient)
+
+	os.Setenv("PBX_PROVIDER", "asterisk")
+	defer os.Unsetenv("PBX_PROVIDER")
+	_, _, err = NewProviders(logrus.New(), storeClient, configMap)
+	assert.EqualError(t, err, "unknown PBX provider 'asterisk'. Supported: 3cx")
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
@@ -10,16 +9,23 @@ import (
 	"github.com/olegromanchuk/hotelito/internal/configuration"
 	"github.com/olegromanchuk/hotelito/internal/handlers"
 	"github.com/olegromanchuk/hotelito/internal/logging"
-	"github.com/olegromanchuk/hotelito/pkg/hotel/cloudbeds"
-	"github.com/olegromanchuk/hotelito/pkg/pbx/pbx3cx"
-	"github.com/olegromanchuk/hotelito/pkg/secrets/boltstore"
+	_ "github.com/olegromanchuk/hotelito/internal/providers"
+	"github.com/olegromanchuk/hotelito/internal/rules"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
+	"github.com/olegromanchuk/hotelito/pkg/pbx"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
 	"github.com/sirupsen/logrus"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"time"
 )
 
+// defaultSecretsStore is used by the standalone version when SECRETS_STORE is not set
+const defaultSecretsStore = "bolt"
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Received request: %s %s", r.Method, r.URL)
@@ -81,27 +87,76 @@ func runServer(envFileName string, log *logrus.Logger, quit chan struct{}) {
 		log.Fatal(err) //TODO: add error handling. Try to load previous version of configMap
 	}
 
-	//   ---------------------- Cloudbed parts ----------------------
+	//ctx is cancelled after the server shutdown. It stops the poller and calls to the providers that are still running
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//   ---------------------- Hospitality provider parts ----------------------
 
-	//current secret store - boltDB
-	storeClient, err := InitializeStore()
+	//current secret store - boltDB by default
+	storeClient, err := InitializeStore(log)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	//create cloudbeds client
-	clbClient, err := cloudbeds.New(log, storeClient, configMap)
+	//create hospitality provider client: cloudbeds (default), mews, apaleo, opera, generichttp or memory (demo)
+	hotelClient, err := hotel.NewProvider(os.Getenv("HOTEL_PROVIDER"), log, storeClient, configMap)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer clbClient.Close()
+	defer hotelClient.Close()
 
-	//create 3cx client
-	pbx3cxClient := pbx3cx.New(log, configMap)
-	defer clbClient.Close()
+	//create PBX client: 3cx (default)
+	pbxClient, err := pbx.NewProvider(os.Getenv("PBX_PROVIDER"), log, configMap)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	//define handlers
-	h := handlers.NewHandler(log, pbx3cxClient, clbClient)
+	h := handlers.NewHandler(log, pbxClient, hotelClient)
+	h.Events = hotel.NewEventDispatcher()
+	h.WebhookURL = os.Getenv("HOSPITALITY_WEBHOOK_URL")
+	h.WebhookToken = os.Getenv("HOSPITALITY_WEBHOOK_TOKEN")
+	rules.RegisterFromEnv(log, h.Events, hotelClient)
+
+	//alert admins by email, Slack/Teams or webhook (NOTIFY_* variables). Without senders alerts are only logged
+	notifier, err := notify.NewFromEnv(log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	h.Notifier = notifier
+	h.CheckLogin(ctx)
+
+	//poll reservations if webhooks are not available
+	pollInterval := os.Getenv("HOSPITALITY_POLL_INTERVAL")
+	if pollInterval != "" {
+		interval, err := time.ParseDuration(pollInterval)
+		if err != nil || interval <= 0 {
+			log.Fatalf("HOSPITALITY_POLL_INTERVAL has invalid value '%s'. Example: 5m", pollInterval)
+		}
+		eventPoller, ok := hotelClient.(hotel.EventPoller)
+		if !ok {
+			log.Fatalf("hospitality provider doesn't support polling. Unset HOSPITALITY_POLL_INTERVAL")
+		}
+		poller := rules.NewPoller(log, eventPoller, h.Events, interval)
+		go poller.Run(ctx)
+	}
+
+	//refresh the token periodically, so it doesn't go stale if nobody calls for a long time
+	tokenRefreshInterval := os.Getenv("HOSPITALITY_TOKEN_REFRESH_INTERVAL")
+	if tokenRefreshInterval != "" {
+		interval, err := time.ParseDuration(tokenRefreshInterval)
+		if err != nil || interval <= 0 {
+			log.Fatalf("HOSPITALITY_TOKEN_REFRESH_INTERVAL has invalid value '%s'. Example: 12h", tokenRefreshInterval)
+		}
+		tokenKeeper, ok := hotelClient.(hotel.TokenKeeper)
+		if !ok {
+			log.Fatalf("hospitality provider doesn't need token refresh. Unset HOSPITALITY_TOKEN_REFRESH_INTERVAL")
+		}
+		keepAlive := rules.NewKeepAlive(log, tokenKeeper, interval)
+		keepAlive.Notifier = notifier
+		go keepAlive.Run(ctx)
+	}
 
 	//auth urls
 	api.HandleFunc("/", h.HandleMain).Methods("GET")
@@ -119,6 +174,22 @@ func runServer(envFileName string, log *logrus.Logger, quit chan struct{}) {
 	api.HandleFunc("/3cx/lookupbynumber", h.Handle3cxLookup).Methods("GET")
 	api.HandleFunc("/3cx/outbound_call", h.Handle3cxCallInfo).Methods("POST")
 
+	//cloudbeds webhook receiver
+	api.HandleFunc("/cloudbeds/webhook", h.HandleHospitalityWebhook).Methods("POST")
+	api.HandleFunc("/cloudbeds/webhook/subscribe", h.HandleSubscribeWebhooks).Methods("POST")
+
+	if h.WebhookToken == "" {
+		log.Error("HOSPITALITY_WEBHOOK_TOKEN is not set: all webhooks are rejected")
+	}
+
+	//subscribe to webhooks on start. Subscription is idempotent
+	if h.WebhookURL != "" {
+		err = h.SubscribeWebhooks(ctx)
+		if err != nil {
+			log.Errorf("failed to subscribe to webhooks: %s", err)
+		}
+	}
+
 	http.Handle("/", api)
 
 	port := ":" + os.Getenv("PORT")
@@ -127,7 +198,10 @@ func runServer(envFileName string, log *logrus.Logger, quit chan struct{}) {
 		port = ":8080"
 	}
 
-	server := &http.Server{Addr: port}
+	server := &http.Server{
+		Addr:        port,
+		BaseContext: func(net.Listener) context.Context { return ctx }, //requests are cancelled with ctx
+	}
 
 	go func() {
 		log.Printf("✅ Starting server on port %s", port)
@@ -139,10 +213,12 @@ func runServer(envFileName string, log *logrus.Logger, quit chan struct{}) {
 	<-quit
 
 	// Shutdown the server
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	err = server.Shutdown(shutdownCtx)
+	cancel() //cancel requests to the providers that didn't finish in time
+	if err != nil {
 		log.Fatalf("Server Shutdown Failed:%+v", err)
 	}
 }
@@ -155,29 +231,16 @@ func readAuthVarsFromFile(fileName string, log *logrus.Logger) {
 	}
 }
 
-func InitializeStore() (*boltstore.BoltDBStore, error) {
-
-	//get file name from env
-	dbFileName := os.Getenv("STANDALONE_VERSION_BOLT_DB_FILENAME")
-	if dbFileName == "" {
-		return nil, fmt.Errorf("STANDALONE_VERSION_BOLT_DB_FILENAME env variable is not set")
-	}
-
-	bucketName := os.Getenv("STANDALONE_VERSION_BOLT_DB_BUCKET_NAME")
-	if bucketName == "" {
-		return nil, fmt.Errorf("STANDALONE_VERSION_BOLT_DB_BUCKET_NAME env variable is not set")
+// InitializeStore creates the secret store SECRETS_STORE. Empty SECRETS_STORE means bolt
+func InitializeStore(log *logrus.Logger) (secrets.SecretsStore, error) {
+	storeName := os.Getenv("SECRETS_STORE")
+	if storeName == "" {
+		storeName = defaultSecretsStore
 	}
 
-	storeClient, err := boltstore.Initialize(dbFileName, bucketName)
-	if err != nil {
-		errMsg := fmt.Sprintf("error initializing bolt store: %s", err)
-		return nil, errors.New(errMsg)
-	}
-
-	//check returned store object has BucketName is set
-	if storeClient.BucketName == "" {
-		return nil, fmt.Errorf("error durin store initialization. storeClient.BucketName variable is not set")
+	options := secrets.Options{
+		StorePrefix: fmt.Sprintf("%s/%s", os.Getenv("APPLICATION_NAME"), os.Getenv("ENVIRONMENT")), //hotelito-app/production
+		AWSRegion:   os.Getenv("AWS_REGION"),
 	}
-
-	return storeClient, nil
+	return secrets.NewStore(storeName, log, options)
 }
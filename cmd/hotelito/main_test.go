@@ -119,7 +119,19 @@ func createAPIParamsConfigFile(jsonTestFileName string) (file *os.File) {
 	jsonContent := `{
   "apiURLs": {
     "getRooms": "https://hotels.cloudbeds.com/api/v1.2/getRooms",
-    "postHousekeepingStatus": "https://hotels.cloudbeds.com/api/v1.2/postHousekeepingStatus"
+    "postHousekeepingStatus": "https://hotels.cloudbeds.com/api/v1.2/postHousekeepingStatus",
+    "getReservations": "https://hotels.cloudbeds.com/api/v1.2/getReservations",
+    "getHousekeepingStatus": "https://hotels.cloudbeds.com/api/v1.2/getHousekeepingStatus",
+    "getHousekeepers": "https://hotels.cloudbeds.com/api/v1.2/getHousekeepers",
+    "postHousekeepingAssignment": "https://hotels.cloudbeds.com/api/v1.2/postHousekeepingAssignment",
+    "postRoomBlock": "https://hotels.cloudbeds.com/api/v1.2/postRoomBlock",
+    "getRoomBlocks": "https://hotels.cloudbeds.com/api/v1.2/getRoomBlocks",
+    "deleteRoomBlock": "https://hotels.cloudbeds.com/api/v1.2/deleteRoomBlock",
+    "putRoomBlock": "https://hotels.cloudbeds.com/api/v1.2/putRoomBlock",
+    "postCustomItem": "https://hotels.cloudbeds.com/api/v1.2/postCustomItem",
+    "getReservation": "https://hotels.cloudbeds.com/api/v1.2/getReservation",
+    "getWebhooks": "https://hotels.cloudbeds.com/api/v1.2/getWebhooks",
+    "postWebhook": "https://hotels.cloudbeds.com/api/v1.2/postWebhook"
   },
   "roomStatuses": [
     "clean",
@@ -165,55 +177,16 @@ func Test_readAuthVarsFromFile(t *testing.T) {
 }
 
 func TestInitializeStore(t *testing.T) {
-
-	dbFileName := "test.db"
-	tests := []struct {
-		name           string
-		dbEnv          string
-		bucketEnv      string
-		expectError    bool
-		expectedErrMsg string
-		expectedBucket string
-	}{
-		{
-			name:           "valid environment variables",
-			dbEnv:          dbFileName,
-			bucketEnv:      "test_bucket",
-			expectError:    false,
-			expectedBucket: "test_bucket",
-		},
-		{
-			name:           "missing db env",
-			dbEnv:          "",
-			bucketEnv:      "test_bucket",
-			expectError:    true,
-			expectedErrMsg: "STANDALONE_VERSION_BOLT_DB_FILENAME env variable is not set",
-		},
-		{
-			name:           "missing bucket env",
-			dbEnv:          dbFileName,
-			bucketEnv:      "",
-			expectError:    true,
-			expectedErrMsg: "STANDALONE_VERSION_BOLT_DB_BUCKET_NAME env variable is not set",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Mock environment variables
-			os.Setenv("STANDALONE_VERSION_BOLT_DB_FILENAME", tt.dbEnv)
-			os.Setenv("STANDALONE_VERSION_BOLT_DB_BUCKET_NAME", tt.bucketEnv)
-
-			store, err := InitializeStore()
-
-			if tt.expectError {
-				assert.Error(t, err)
-				assert.Equal(t, tt.expectedErrMsg, err.Error())
-			} else {
-				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedBucket, store.BucketName)
-			}
-		})
-	}
-	os.Remove(dbFileName)
+	os.Setenv("SECRETS_STORE", "")
+	os.Setenv("STANDALONE_VERSION_BOLT_DB_FILENAME", "")
+	defer os.Unsetenv("STANDALONE_VERSION_BOLT_DB_FILENAME")
+
+	//bolt is the default store of the standalone version
+	_, err := InitializeStore(logrus.New())
+	assert.EqualError(t, err, "STANDALONE_VERSION_BOLT_DB_FILENAME env variable is not set")
+
+	os.Setenv("SECRETS_STORE", "vault")
+	defer os.Unsetenv("SECRETS_STORE")
+	_, err = InitializeStore(logrus.New())
+	assert.EqualError(t, err, "unknown secret store 'vault'. Supported: aws, bolt")
 }
@@ -13,22 +13,99 @@ type Extension struct {
 	RoomExtension       string `json:"room_extension"`
 	HospitalityRoomID   string `json:"hospitality_room_id"`
 	HospitalityRoomName string `json:"hospitality_room_name"`
+	// HospitalityPropertyID is the property the room belongs to. Optional for single-property deployments
+	HospitalityPropertyID string `json:"hospitality_property_id,omitempty"`
 }
 
+// Number types of the housekeeper mapping that are not room conditions
+const (
+	NumberTypeDoNotDisturbOn  = "dnd_on"       // set "Do Not Disturb" on the room
+	NumberTypeDoNotDisturbOff = "dnd_off"      // clear "Do Not Disturb" on the room
+	NumberTypeRoomBlock       = "room_block"   // take the room out of service (out of order / maintenance)
+	NumberTypeRoomUnblock     = "room_unblock" // return the room to inventory
+	// NumberTypeCharge is a posting prefix. The dialed number is the prefix, item_code from item_catalog and an optional quantity (1 by default)
+	NumberTypeCharge = "charge"
+)
+
 // Housekeeper represents the housekeeper mapping
 type Housekeeper struct {
 	RoomStatusPhoneNumber string `json:"room_status_phone_number"`
 	HousekeeperName       string `json:"housekeeper_name"`
 	NumberType            string `json:"number_type"`
+	// HospitalityHousekeeperID is the housekeeper ID in the hospitality provider. Optional: if empty, the housekeeper is searched by name
+	HospitalityHousekeeperID string `json:"hospitality_housekeeper_id,omitempty"`
+	// BlockReason is the reason of the room block for number type room_block. E.g. "AC repair"
+	BlockReason string `json:"block_reason,omitempty"`
+}
+
+// Item represents a chargeable item (minibar, amenity) that can be posted to the guest folio from the room phone
+type Item struct {
+	ItemCode string  `json:"item_code"` // digits dialed after the charge prefix
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	// HospitalityItemID is the item ID (SKU) in the hospitality provider. Optional: item_code is used if empty
+	HospitalityItemID string `json:"hospitality_item_id,omitempty"`
 }
 
-// ConfigMap contains arrays of Extension and Housekeeper
+// ConfigMap contains arrays of Extension, Housekeeper and Item
 type ConfigMap struct {
 	ExtensionMap   []Extension   `json:"extension_map"`
 	HousekeeperMap []Housekeeper `json:"housekeeper_map"`
+	ItemCatalog    []Item        `json:"item_catalog,omitempty"`
 	ApiCfgFileName string        `json:"api_config_file_name"`
 }
 
+// RoomConditions returns unique number types of the housekeeper mapping that are room conditions (e.g. clean, dirty) in the order of the mapping
+func (c *ConfigMap) RoomConditions() (roomConditions []string) {
+	seen := make(map[string]bool)
+	for _, housekeeper := range c.HousekeeperMap {
+		switch housekeeper.NumberType {
+		case "", NumberTypeDoNotDisturbOn, NumberTypeDoNotDisturbOff, NumberTypeRoomBlock, NumberTypeRoomUnblock, NumberTypeCharge:
+			continue
+		}
+		if seen[housekeeper.NumberType] {
+			continue
+		}
+		seen[housekeeper.NumberType] = true
+		roomConditions = append(roomConditions, housekeeper.NumberType)
+	}
+	return roomConditions
+}
+
+// SearchItemByCode returns the catalog item with itemCode
+func (c *ConfigMap) SearchItemByCode(itemCode string) (Item, bool) {
+	for _, item := range c.ItemCatalog {
+		if item.ItemCode == itemCode {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+// SearchExtensionByPhoneNumber returns the extension with room extension phoneNumber
+func (c *ConfigMap) SearchExtensionByPhoneNumber(phoneNumber string) (Extension, bool) {
+	for _, extension := range c.ExtensionMap {
+		if extension.RoomExtension == phoneNumber {
+			return extension, true
+		}
+	}
+	return Extension{}, false
+}
+
+// SearchExtensionByRoomID returns the extension of the hospitality room roomID. Empty propertyID (or an extension without property) matches any property
+func (c *ConfigMap) SearchExtensionByRoomID(roomID, propertyID string) (Extension, bool) {
+	for _, extension := range c.ExtensionMap {
+		if extension.HospitalityRoomID != roomID {
+			continue
+		}
+		if propertyID != "" && extension.HospitalityPropertyID != "" && extension.HospitalityPropertyID != propertyID {
+			continue
+		}
+		return extension, true
+	}
+	return Extension{}, false
+}
+
 func New(log *logrus.Logger, mapFileName string, clBedsApiConfigFile string) (*ConfigMap, error) {
 	configMapInfo := &ConfigMap{}
 	//get configuration from mapFileName
@@ -54,3 +54,79 @@ func TestNew(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestConfigMap_SearchItemByCode(t *testing.T) {
+	configMap := &ConfigMap{
+		ItemCatalog: []Item{
+			{ItemCode: "1", Name: "Water", Price: 2},
+			{ItemCode: "12", Name: "Beer", Price: 5.5},
+		},
+	}
+
+	item, ok := configMap.SearchItemByCode("12")
+	assert.True(t, ok)
+	assert.Equal(t, Item{ItemCode: "12", Name: "Beer", Price: 5.5}, item)
+
+	_, ok = configMap.SearchItemByCode("3")
+	assert.False(t, ok)
+}
+
+func TestConfigMap_SearchExtensionByRoomID(t *testing.T) {
+	configMap := &ConfigMap{
+		ExtensionMap: []Extension{
+			{RoomExtension: "1001", HospitalityRoomID: "544559-0", HospitalityPropertyID: "297652"},
+			{RoomExtension: "2001", HospitalityRoomID: "544559-0", HospitalityPropertyID: "311111"},
+			{RoomExtension: "1002", HospitalityRoomID: "544559-1"},
+		},
+	}
+
+	extension, ok := configMap.SearchExtensionByRoomID("544559-0", "311111")
+	assert.True(t, ok)
+	assert.Equal(t, "2001", extension.RoomExtension)
+
+	extension, ok = configMap.SearchExtensionByRoomID("544559-0", "")
+	assert.True(t, ok)
+	assert.Equal(t, "1001", extension.RoomExtension)
+
+	extension, ok = configMap.SearchExtensionByRoomID("544559-1", "297652")
+	assert.True(t, ok)
+	assert.Equal(t, "1002", extension.RoomExtension)
+
+	_, ok = configMap.SearchExtensionByRoomID("544559-0", "999999")
+	assert.False(t, ok)
+
+	_, ok = configMap.SearchExtensionByRoomID("544559-9", "")
+	assert.False(t, ok)
+}
+
+func TestConfigMap_SearchExtensionByPhoneNumber(t *testing.T) {
+	configMap := &ConfigMap{
+		ExtensionMap: []Extension{
+			{RoomExtension: "1001", HospitalityRoomID: "544559-0"},
+			{RoomExtension: "1002", HospitalityRoomID: "544559-1"},
+		},
+	}
+
+	extension, ok := configMap.SearchExtensionByPhoneNumber("1002")
+	assert.True(t, ok)
+	assert.Equal(t, "544559-1", extension.HospitalityRoomID)
+
+	_, ok = configMap.SearchExtensionByPhoneNumber("1003")
+	assert.False(t, ok)
+}
+
+func TestConfigMap_RoomConditions(t *testing.T) {
+	configMap := &ConfigMap{
+		HousekeeperMap: []Housekeeper{
+			{RoomStatusPhoneNumber: "5551", NumberType: "dirty"},
+			{RoomStatusPhoneNumber: "5552", NumberType: "clean"},
+			{RoomStatusPhoneNumber: "5553", NumberType: "dirty"},
+			{RoomStatusPhoneNumber: "5554", NumberType: NumberTypeDoNotDisturbOn},
+			{RoomStatusPhoneNumber: "5555", NumberType: NumberTypeRoomBlock},
+			{RoomStatusPhoneNumber: "5556", NumberType: NumberTypeCharge},
+			{RoomStatusPhoneNumber: "5557", NumberType: "inspected"},
+		},
+	}
+	assert.Equal(t, []string{"dirty", "clean", "inspected"}, configMap.RoomConditions())
+	assert.Empty(t, (&ConfigMap{}).RoomConditions())
+}
@@ -1,31 +1,75 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
 	"github.com/olegromanchuk/hotelito/pkg/pbx"
 	"github.com/sirupsen/logrus"
+	"io"
 	"net/http"
+	"sync"
 )
 
+// updateFailuresAlertThreshold is the amount of room updates failed in a row after which admins are alerted
+const updateFailuresAlertThreshold = 3
+
 type Handler struct {
 	Log   *logrus.Logger
 	PBX   pbx.PBXProvider
 	Hotel hotel.HospitalityProvider
+	// Events receives events of the hospitality provider (webhooks). Optional: if nil, events are only logged
+	Events *hotel.EventDispatcher
+	// WebhookURL is the public URL of the webhook receiver the hospitality provider is subscribed to. Optional
+	WebhookURL string
+	// WebhookToken protects the webhook receiver. Webhook requests must contain it in the "token" query parameter.
+	// All webhooks are rejected if it is not set
+	WebhookToken string
+	// Notifier alerts admins when login is required or room updates keep failing. Optional: if nil, failures are only logged
+	Notifier *notify.Notifier
+	// UpdateFailures counts room updates failed in a row. Lambdas replace it with the counter shared by the container,
+	// because every invocation creates its own handler. Optional: if nil, failures are not counted
+	UpdateFailures *FailureCounter
+}
+
+// FailureCounter counts failures in a row. It is safe for concurrent use
+type FailureCounter struct {
+	mu       sync.Mutex
+	failures int
+}
+
+// Record counts the result of an attempt and returns the amount of failures in a row. Success resets the count.
+// nil counter always returns 0
+func (c *FailureCounter) Record(failed bool) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if failed {
+		c.failures++
+	} else {
+		c.failures = 0
+	}
+	return c.failures
 }
 
 func NewHandler(log *logrus.Logger, pbx pbx.PBXProvider, hotel hotel.HospitalityProvider) *Handler {
 	return &Handler{
-		Log:   log,
-		PBX:   pbx,
-		Hotel: hotel,
+		Log:            log,
+		PBX:            pbx,
+		Hotel:          hotel,
+		UpdateFailures: &FailureCounter{},
 	}
 }
 
 func (h *Handler) HandleManualLogin(w http.ResponseWriter, r *http.Request) {
-	url, err := h.Hotel.HandleInitialLogin()
+	url, err := h.Hotel.HandleInitialLogin(r.Context())
 	if err != nil {
 		h.Log.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -36,6 +80,14 @@ func (h *Handler) HandleManualLogin(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if url == "" { //provider doesn't use interactive login
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte("Login is not required"))
+		if err != nil {
+			h.Log.Error(err)
+		}
+		return
+	}
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
@@ -43,7 +95,7 @@ func (h *Handler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	h.Log.Debugf("Handling callback")
 	state := r.FormValue("state")
 	code := r.FormValue("code")
-	err := h.Hotel.HandleOAuthCallback(state, code)
+	err := h.Hotel.HandleOAuthCallback(r.Context(), state, code)
 	if err != nil {
 		h.Log.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -66,12 +118,18 @@ func (h *Handler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) Handle3cxCallInfo(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	//h.Log.Debugf("Received 3cx call info")
-	room, err := h.PBX.ProcessPBXRequest(decoder)
+	room, err := h.PBX.ProcessPBXRequest(r.Context(), decoder)
 	if err != nil {
-		if err.Error() == "incoming-call-ignoring" { //ignore incoming calls
+		if errors.Is(err, pbx.ErrIgnored) { //incoming and regular calls are not related to rooms
+			h.Log.Debugf("Ignoring PBX request: %s", err)
 			return
 		}
 		h.Log.Error(err)
+		w.WriteHeader(StatusCode(err))
+		_, err = w.Write([]byte(err.Error()))
+		if err != nil {
+			h.Log.Error(err)
+		}
 		return
 	}
 
@@ -81,12 +139,10 @@ func (h *Handler) Handle3cxCallInfo(w http.ResponseWriter, r *http.Request) {
 	}
 	h.Log.Debugf("Room phone number: %s", room.PhoneNumber)
 
-	//get provider
-	hotelProvider := h.Hotel
-	msg, err := hotelProvider.UpdateRoom(room.PhoneNumber, room.RoomCondition, room.HousekeeperName)
+	msg, err := h.UpdateRoomFromPBX(r.Context(), room)
 	if err != nil {
 		h.Log.Error(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(StatusCode(err))
 		_, err = w.Write([]byte(err.Error()))
 		if err != nil {
 			h.Log.Error(err)
@@ -102,11 +158,79 @@ func (h *Handler) Handle3cxCallInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UpdateRoomFromPBX applies the room action received from PBX to the hospitality provider.
+// Admins are alerted if login is required or the updates keep failing
+func (h *Handler) UpdateRoomFromPBX(ctx context.Context, room pbx.Room) (msg string, err error) {
+	msg, err = h.updateRoom(ctx, room)
+	h.reportUpdate(ctx, room, err)
+	return msg, err
+}
+
+func (h *Handler) updateRoom(ctx context.Context, room pbx.Room) (msg string, err error) {
+	switch room.Action.Type {
+	case pbx.ActionRoomCondition:
+		return h.Hotel.UpdateRoom(ctx, room.PhoneNumber, room.RoomCondition, room.HousekeeperName)
+	case pbx.ActionDoNotDisturbOn, pbx.ActionDoNotDisturbOff:
+		return h.Hotel.UpdateRoomDoNotDisturb(ctx, room.PhoneNumber, room.Action.Type == pbx.ActionDoNotDisturbOn, room.HousekeeperName)
+	case pbx.ActionRoomBlock, pbx.ActionRoomUnblock:
+		return h.Hotel.UpdateRoomBlock(ctx, room.PhoneNumber, room.Action.Type == pbx.ActionRoomBlock, room.Action.Reason, room.HousekeeperName)
+	case pbx.ActionPostCharge:
+		return h.Hotel.PostCharge(ctx, room.PhoneNumber, room.Action.ItemCode, room.Action.Quantity, room.HousekeeperName)
+	default:
+		return "", fmt.Errorf("unknown room action %s", room.Action.Type)
+	}
+}
+
+// reportUpdate counts room updates failed in a row and alerts admins. Rejected requests (validation) are not failures of the
+// provider and don't change the count
+func (h *Handler) reportUpdate(ctx context.Context, room pbx.Room, err error) {
+	if errors.Is(err, hotel.ErrValidation) || errors.Is(err, pbx.ErrInvalidRequest) {
+		return
+	}
+
+	failures := h.UpdateFailures.Record(err != nil)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, hotel.ErrAuthorization) {
+		_ = h.Notifier.Notify(ctx, notify.Alert{
+			Kind:    notify.KindReloginRequired,
+			Subject: "Hospitality provider login required",
+			Message: fmt.Sprintf("Room updates are rejected by the hospitality provider: %s. Login again: /api/v1/login", err),
+		})
+		return
+	}
+	if failures >= updateFailuresAlertThreshold {
+		_ = h.Notifier.Notify(ctx, notify.Alert{
+			Kind:    notify.KindUpdateFailures,
+			Subject: "Room updates are failing",
+			Message: fmt.Sprintf("%d room updates failed in a row. Last one (extension %s): %s", failures, room.PhoneNumber, err),
+		})
+	}
+}
+
+// CheckLogin alerts admins if the hospitality provider needs an interactive login (hotel.LoginChecker)
+func (h *Handler) CheckLogin(ctx context.Context) {
+	loginChecker, ok := h.Hotel.(hotel.LoginChecker)
+	if !ok {
+		return
+	}
+	msg, required := loginChecker.LoginRequired()
+	if !required {
+		return
+	}
+	_ = h.Notifier.Notify(ctx, notify.Alert{
+		Kind:    notify.KindReloginRequired,
+		Subject: "Hospitality provider login required",
+		Message: msg,
+	})
+}
+
 func (h *Handler) Handle3cxLookup(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	number := query.Get("Number")
 
-	jsonAsBytes := h.PBX.ProcessLookupByNumber(number) //returns dummy contact with "number"
+	jsonAsBytes := h.LookupByNumber(r.Context(), number)
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
@@ -118,6 +242,171 @@ func (h *Handler) Handle3cxLookup(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// LookupByNumber returns PBX contact information for number. If number is a room extension with an in-house guest, the guest is returned.
+// Otherwise, PBX returns a dummy contact with "number", so the follow-up call journal request is still sent.
+func (h *Handler) LookupByNumber(ctx context.Context, number string) []byte {
+	var guest *pbx.Guest
+	hotelGuest, err := h.Hotel.GetRoomGuest(ctx, number)
+	if err != nil {
+		h.Log.Debugf("No guest found for number %s: %v", number, err)
+	} else {
+		guest = &pbx.Guest{
+			ReservationID: hotelGuest.ReservationID,
+			FirstName:     hotelGuest.FirstName,
+			LastName:      hotelGuest.LastName,
+			Email:         hotelGuest.Email,
+		}
+	}
+	return h.PBX.ProcessLookupByNumber(ctx, number, guest)
+}
+
+// HandleHospitalityWebhook receives webhooks of the hospitality provider and dispatches them as events
+func (h *Handler) HandleHospitalityWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeWebhookRequest(w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Log.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	statusCode, msg := h.ProcessHospitalityWebhook(r.Context(), body)
+	w.WriteHeader(statusCode)
+	_, err = w.Write([]byte(msg))
+	if err != nil {
+		h.Log.Error(err)
+		return
+	}
+}
+
+// StatusCode maps errors returned by the hospitality and PBX providers to HTTP status codes:
+// validation failures and invalid PBX requests to 400, authorization failures to 401, transient failures to 503 and everything else to 500
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, hotel.ErrValidation), errors.Is(err, pbx.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, hotel.ErrAuthorization):
+		return http.StatusUnauthorized
+	case errors.Is(err, hotel.ErrTransient):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ProcessHospitalityWebhook parses the webhook body and dispatches the events to the registered event handlers.
+// Returns http status code and message for the reply to the hospitality provider
+func (h *Handler) ProcessHospitalityWebhook(ctx context.Context, body []byte) (statusCode int, msg string) {
+	webhookProvider, ok := h.Hotel.(hotel.WebhookProvider)
+	if !ok {
+		h.Log.Error("hospitality provider doesn't support webhooks")
+		return http.StatusNotImplemented, "webhooks are not supported"
+	}
+
+	hotelEvents, err := webhookProvider.ParseWebhook(ctx, body)
+	if err != nil {
+		h.Log.Error(err)
+		if errors.Is(err, hotel.ErrTransient) || errors.Is(err, hotel.ErrAuthorization) {
+			//non-2xx reply makes the provider resend the webhook once the hotel API is reachable again
+			return StatusCode(err), err.Error()
+		}
+		if errors.Is(err, hotel.ErrValidation) {
+			return http.StatusBadRequest, err.Error()
+		}
+		var detailedError *hotel.DetailedError
+		if errors.As(err, &detailedError) {
+			return http.StatusBadRequest, err.Error()
+		}
+		return http.StatusInternalServerError, err.Error()
+	}
+
+	amountOfFailed := 0
+	for _, event := range hotelEvents {
+		h.Log.Infof("Event %s for room %s (extension %s), reservation %s", event.Type, event.RoomID, event.PhoneNumber, event.ReservationID)
+		if h.Events == nil || !h.Events.HasHandlers(event.Type) {
+			h.Log.Debugf("No handlers registered for event %s", event.Type)
+			continue
+		}
+		err = h.Events.Dispatch(ctx, event)
+		if err != nil {
+			h.Log.Error(err)
+			amountOfFailed++
+		}
+	}
+
+	if amountOfFailed > 0 {
+		//non-2xx reply makes the provider resend the webhook
+		return http.StatusInternalServerError, fmt.Sprintf("failed to handle %d of %d events", amountOfFailed, len(hotelEvents))
+	}
+	return http.StatusOK, fmt.Sprintf("handled %d events", len(hotelEvents))
+}
+
+// authorizeWebhookRequest checks the token query parameter of webhook and subscribe requests.
+// Rejected requests are answered with 401
+func (h *Handler) authorizeWebhookRequest(w http.ResponseWriter, r *http.Request) bool {
+	if h.WebhookToken == "" {
+		h.Log.Errorf("Request to %s from %s is rejected: HOSPITALITY_WEBHOOK_TOKEN is not set", r.URL.Path, r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	if !ValidWebhookToken(h.WebhookToken, r.URL.Query().Get("token")) {
+		h.Log.Warnf("Request to %s with invalid token received from %s", r.URL.Path, r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// HandleSubscribeWebhooks subscribes WebhookURL to webhooks of the hospitality provider. The request needs the webhook token
+func (h *Handler) HandleSubscribeWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeWebhookRequest(w, r) {
+		return
+	}
+	err := h.SubscribeWebhooks(r.Context())
+	if err != nil {
+		h.Log.Error(err)
+		w.WriteHeader(StatusCode(err))
+		_, err = w.Write([]byte(err.Error()))
+		if err != nil {
+			h.Log.Error(err)
+			return
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte("Subscribed to webhooks"))
+	if err != nil {
+		h.Log.Error(err)
+		return
+	}
+}
+
+// SubscribeWebhooks subscribes WebhookURL to webhooks of the hospitality provider. WebhookToken must be set: webhooks are rejected without it
+func (h *Handler) SubscribeWebhooks(ctx context.Context) error {
+	if h.WebhookURL == "" {
+		return errors.New("webhook url is not set")
+	}
+	if h.WebhookToken == "" {
+		return errors.New("webhook token is not set. Set HOSPITALITY_WEBHOOK_TOKEN and add ?token=... to the webhook url")
+	}
+	webhookProvider, ok := h.Hotel.(hotel.WebhookProvider)
+	if !ok {
+		return errors.New("hospitality provider doesn't support webhooks")
+	}
+	return webhookProvider.SubscribeWebhooks(ctx, h.WebhookURL)
+}
+
+// ValidWebhookToken checks the token of a webhook request. No token is valid if expectedToken is not set
+func ValidWebhookToken(expectedToken, receivedToken string) bool {
+	if expectedToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expectedToken), []byte(receivedToken)) == 1
+}
+
 func (h *Handler) HandleSetHousekeepingStatus(w http.ResponseWriter, r *http.Request) {
 	h.Log.Debugf("HandleSetHousekeepingStatus")
 
@@ -132,10 +421,10 @@ func (h *Handler) HandleSetHousekeepingStatus(w http.ResponseWriter, r *http.Req
 	h.Log.Debugf("roomPhoneNumber: %s, housekeepingStatus: %s, housekeeperID: %s", roomPhoneNumber, housekeepingStatus, housekeeperID)
 	hotelProvider := h.Hotel
 	//roomPhoneNumber = 1001
-	msg, err := hotelProvider.UpdateRoom(roomPhoneNumber, housekeepingStatus, housekeeperID)
+	msg, err := hotelProvider.UpdateRoom(r.Context(), roomPhoneNumber, housekeepingStatus, housekeeperID)
 	if err != nil {
 		h.Log.Error(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(StatusCode(err))
 		_, err = w.Write([]byte(err.Error()))
 		if err != nil {
 			h.Log.Error(err)
@@ -155,10 +444,10 @@ func (h *Handler) HandleGetRooms(w http.ResponseWriter, r *http.Request) {
 	h.Log.Debugf("HandleGetRooms")
 
 	hotelProvider := h.Hotel
-	rooms, err := hotelProvider.GetRooms()
+	rooms, err := hotelProvider.GetRooms(r.Context())
 	if err != nil {
 		h.Log.Error(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(StatusCode(err))
 		_, err = w.Write([]byte(err.Error()))
 		if err != nil {
 			h.Log.Error(err)
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
 	"github.com/olegromanchuk/hotelito/pkg/pbx"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -21,13 +23,13 @@ type MockPBXProvider struct {
 	mock.Mock
 }
 
-func (m *MockPBXProvider) ProcessPBXRequest(jsonDecoder *json.Decoder) (pbx.Room, error) {
+func (m *MockPBXProvider) ProcessPBXRequest(ctx context.Context, jsonDecoder *json.Decoder) (pbx.Room, error) {
 	args := m.Called(jsonDecoder)
 	return args.Get(0).(pbx.Room), args.Error(1)
 }
 
-func (m *MockPBXProvider) ProcessLookupByNumber(number string) []byte {
-	args := m.Called(number)
+func (m *MockPBXProvider) ProcessLookupByNumber(ctx context.Context, number string, guest *pbx.Guest) []byte {
+	args := m.Called(number, guest)
 	return args.Get(0).([]byte)
 }
 
@@ -35,22 +37,27 @@ type MockHospitalityProvider struct {
 	mock.Mock
 }
 
-func (m *MockHospitalityProvider) GetRooms() ([]hotel.Room, error) {
+func (m *MockHospitalityProvider) GetRooms(ctx context.Context) ([]hotel.Room, error) {
 	args := m.Called()
 	return args.Get(0).([]hotel.Room), args.Error(1)
 }
 
-func (m *MockHospitalityProvider) GetRoom(roomNumber string, mapFileName string) (hotel.Room, error) {
+func (m *MockHospitalityProvider) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
 	args := m.Called(roomNumber, mapFileName)
 	return args.Get(0).(hotel.Room), args.Error(1)
 }
 
-func (m *MockHospitalityProvider) HandleInitialLogin() (url string, err error) {
+func (m *MockHospitalityProvider) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	args := m.Called(roomNumber)
+	return args.Get(0).(hotel.Guest), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) HandleInitialLogin(ctx context.Context) (url string, err error) {
 	args := m.Called()
 	return args.Get(0).(string), args.Error(1)
 }
 
-func (m *MockHospitalityProvider) HandleOAuthCallback(state, code string) (err error) {
+func (m *MockHospitalityProvider) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
 	args := m.Called(state, code)
 	return args.Error(1)
 }
@@ -62,11 +69,41 @@ func (m *MockHospitalityProvider) HandleOAuthCallback(state, code string) (err e
 //	return fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomNumber, housekeepingStatus), nil
 //}
 
-func (m *MockHospitalityProvider) UpdateRoom(roomNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+func (m *MockHospitalityProvider) UpdateRoom(ctx context.Context, roomNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
 	args := m.Called(roomNumber, housekeepingStatus, housekeeperName)
 	return args.Get(0).(string), args.Error(1)
 }
 
+func (m *MockHospitalityProvider) PostCharge(ctx context.Context, roomNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	args := m.Called(roomNumber, itemCode, quantity, housekeeperName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) UpdateRoomBlock(ctx context.Context, roomNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	args := m.Called(roomNumber, blocked, reason, staffName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) UpdateRoomDoNotDisturb(ctx context.Context, roomNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	args := m.Called(roomNumber, doNotDisturb, housekeeperName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+// MockWebhookHospitalityProvider is a hospitality provider that supports webhooks
+type MockWebhookHospitalityProvider struct {
+	MockHospitalityProvider
+}
+
+func (m *MockWebhookHospitalityProvider) ParseWebhook(ctx context.Context, body []byte) ([]hotel.Event, error) {
+	args := m.Called(body)
+	return args.Get(0).([]hotel.Event), args.Error(1)
+}
+
+func (m *MockWebhookHospitalityProvider) SubscribeWebhooks(ctx context.Context, endpointURL string) error {
+	args := m.Called(endpointURL)
+	return args.Error(0)
+}
+
 func TestHandler_HandleSetHousekeepingStatus(t *testing.T) {
 	// Setup Logger
 	log := logrus.New()
@@ -104,6 +141,26 @@ func TestHandler_HandleSetHousekeepingStatus(t *testing.T) {
 			expectedError:  true,
 			expCode:        http.StatusInternalServerError,
 			expMessage:     "test error",
+		}, {
+			name:           "Rejected UpdateRoom",
+			roomPhone:      "1002",
+			roomCondition:  "clean",
+			housekeeperID:  "1",
+			responseString: "",
+			responseError:  hotel.NewError(hotel.ErrValidation, errors.New("failed to update room status: Parameter roomID is required")),
+			expectedError:  true,
+			expCode:        http.StatusBadRequest,
+			expMessage:     "failed to update room status: Parameter roomID is required",
+		}, {
+			name:           "Hotel API unavailable",
+			roomPhone:      "1003",
+			roomCondition:  "clean",
+			housekeeperID:  "1",
+			responseString: "",
+			responseError:  hotel.NewError(hotel.ErrTransient, errors.New("cloudbeds request failed with status 503")),
+			expectedError:  true,
+			expCode:        http.StatusServiceUnavailable,
+			expMessage:     "cloudbeds request failed with status 503",
 		},
 	}
 
@@ -163,6 +220,9 @@ func TestHandler_Handle3cxLookup(t *testing.T) {
 	tests := []struct {
 		name                                  string
 		fields                                fields
+		responseGuest                         hotel.Guest
+		responseGuestErr                      error
+		expectedGuest                         *pbx.Guest
 		responseErrorProcessLookupByNumberErr error
 		responseErrorProcessLookupByNumber    string
 		args                                  args
@@ -176,6 +236,8 @@ func TestHandler_Handle3cxLookup(t *testing.T) {
 				PBX:   &MockPBXProvider{},
 				Hotel: &MockHospitalityProvider{},
 			},
+			responseGuestErr:                      errors.New("phone number 14523 not found"),
+			expectedGuest:                         nil,
 			responseErrorProcessLookupByNumberErr: nil,
 			responseErrorProcessLookupByNumber:    "12345",
 			args: args{
@@ -185,6 +247,24 @@ func TestHandler_Handle3cxLookup(t *testing.T) {
 			expectedBody:     `12345`,
 			expectedHttpCode: http.StatusOK,
 		},
+		{
+			name: "Succesful lookup of room with guest",
+			fields: fields{
+				Log:   logrus.New(),
+				PBX:   &MockPBXProvider{},
+				Hotel: &MockHospitalityProvider{},
+			},
+			responseGuest:                         hotel.Guest{ReservationID: "9876543210", FirstName: "Jane", LastName: "Smith", Email: "jane.smith@example.com"},
+			expectedGuest:                         &pbx.Guest{ReservationID: "9876543210", FirstName: "Jane", LastName: "Smith", Email: "jane.smith@example.com"},
+			responseErrorProcessLookupByNumberErr: nil,
+			responseErrorProcessLookupByNumber:    "9876543210",
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("GET", "/test/url?Number=1001", nil),
+			},
+			expectedBody:     `9876543210`,
+			expectedHttpCode: http.StatusOK,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -194,7 +274,8 @@ func TestHandler_Handle3cxLookup(t *testing.T) {
 				Hotel: tt.fields.Hotel,
 			}
 
-			h.PBX.(*MockPBXProvider).On("ProcessLookupByNumber", mock.Anything).Return([]byte(tt.responseErrorProcessLookupByNumber), tt.responseErrorProcessLookupByNumberErr)
+			h.Hotel.(*MockHospitalityProvider).On("GetRoomGuest", mock.Anything).Return(tt.responseGuest, tt.responseGuestErr)
+			h.PBX.(*MockPBXProvider).On("ProcessLookupByNumber", mock.Anything, tt.expectedGuest).Return([]byte(tt.responseErrorProcessLookupByNumber), tt.responseErrorProcessLookupByNumberErr)
 
 			h.Handle3cxLookup(tt.args.w, tt.args.r)
 			assert.Equal(t, tt.expectedHttpCode, tt.args.w.(*httptest.ResponseRecorder).Code)
@@ -239,11 +320,68 @@ func TestHandler_Handle3cxCallInfo(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   "Updated",
 		},
+		{
+			name: "Do not disturb on",
+			pbxMock: func() pbx.PBXProvider {
+				m := new(MockPBXProvider)
+				m.On("ProcessPBXRequest", mock.Anything).Return(pbx.Room{PhoneNumber: "123", HousekeeperName: "John Doe", Action: pbx.Action{Type: pbx.ActionDoNotDisturbOn}}, nil)
+				return m
+			}(),
+			hotelMock: func() *MockHospitalityProvider {
+				m := new(MockHospitalityProvider)
+				m.On("UpdateRoomDoNotDisturb", "123", true, "John Doe").Return("DND updated", nil)
+				return m
+			}(),
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/3cxCallInfo", strings.NewReader(`{"Number": "14523", "CallType": "Outbound"}`)),
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "DND updated",
+		},
+		{
+			name: "Room block",
+			pbxMock: func() pbx.PBXProvider {
+				m := new(MockPBXProvider)
+				m.On("ProcessPBXRequest", mock.Anything).Return(pbx.Room{PhoneNumber: "123", HousekeeperName: "Engineer", Action: pbx.Action{Type: pbx.ActionRoomBlock, Reason: "AC repair"}}, nil)
+				return m
+			}(),
+			hotelMock: func() *MockHospitalityProvider {
+				m := new(MockHospitalityProvider)
+				m.On("UpdateRoomBlock", "123", true, "AC repair", "Engineer").Return("Room blocked", nil)
+				return m
+			}(),
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/3cxCallInfo", strings.NewReader(`{"Number": "14523", "CallType": "Outbound"}`)),
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "Room blocked",
+		},
+		{
+			name: "Post charge",
+			pbxMock: func() pbx.PBXProvider {
+				m := new(MockPBXProvider)
+				m.On("ProcessPBXRequest", mock.Anything).Return(pbx.Room{PhoneNumber: "123", HousekeeperName: "John Doe", Action: pbx.Action{Type: pbx.ActionPostCharge, ItemCode: "12", Quantity: 2}}, nil)
+				return m
+			}(),
+			hotelMock: func() *MockHospitalityProvider {
+				m := new(MockHospitalityProvider)
+				m.On("PostCharge", "123", "12", 2, "John Doe").Return("Charge posted", nil)
+				return m
+			}(),
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/3cxCallInfo", strings.NewReader(`{"Number": "14523", "CallType": "Outbound"}`)),
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "Charge posted",
+		},
 		{
 			name: "Ignore incoming call",
 			pbxMock: func() pbx.PBXProvider {
 				m := new(MockPBXProvider)
-				m.On("ProcessPBXRequest", mock.Anything).Return(pbx.Room{}, errors.New("incoming-call-ignoring"))
+				m.On("ProcessPBXRequest", mock.Anything).Return(pbx.Room{}, pbx.ErrIncomingCall)
 				return m
 			}(),
 			hotelMock: new(MockHospitalityProvider), // No methods expected to be called
@@ -254,6 +392,36 @@ func TestHandler_Handle3cxCallInfo(t *testing.T) {
 			expectedStatus: http.StatusOK, // Status code not set
 			expectedBody:   "",
 		},
+		{
+			name: "Ignore regular outgoing call",
+			pbxMock: func() pbx.PBXProvider {
+				m := new(MockPBXProvider)
+				m.On("ProcessPBXRequest", mock.Anything).Return(pbx.Room{}, pbx.ErrRegularCall)
+				return m
+			}(),
+			hotelMock: new(MockHospitalityProvider), // No methods expected to be called
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/3cxCallInfo", strings.NewReader(`{"Number": "987654321", "CallType": "Outbound"}`)),
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "",
+		},
+		{
+			name: "Invalid PBX request",
+			pbxMock: func() pbx.PBXProvider {
+				m := new(MockPBXProvider)
+				m.On("ProcessPBXRequest", mock.Anything).Return(pbx.Room{}, fmt.Errorf("%w: unknown item code in dialed number 222609", pbx.ErrInvalidRequest))
+				return m
+			}(),
+			hotelMock: new(MockHospitalityProvider), // No methods expected to be called
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/3cxCallInfo", strings.NewReader(`{"Number": "222609", "CallType": "Outbound"}`)),
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid pbx request: unknown item code in dialed number 222609",
+		},
 
 		{
 			name: "failed update room",
@@ -312,6 +480,94 @@ func TestHandler_Handle3cxCallInfo(t *testing.T) {
 	}
 }
 
+type fakeAlertSender struct {
+	alerts []notify.Alert
+}
+
+func (f *fakeAlertSender) Send(ctx context.Context, alert notify.Alert) error {
+	f.alerts = append(f.alerts, alert)
+	return nil
+}
+
+func TestHandler_UpdateRoomFromPBX_Alerts(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	sender := &fakeAlertSender{}
+	hotelMock := new(MockHospitalityProvider)
+	h := NewHandler(log, new(MockPBXProvider), hotelMock)
+	h.Notifier = notify.New(log, sender)
+
+	room := pbx.Room{PhoneNumber: "1001", RoomCondition: "clean", HousekeeperName: "John Doe"}
+	failed := hotel.NewError(hotel.ErrTransient, errors.New("cloudbeds is unavailable"))
+
+	// failures below the threshold are not alerted
+	hotelMock.On("UpdateRoom", "1001", "clean", "John Doe").Return("", failed).Times(2)
+	for i := 0; i < updateFailuresAlertThreshold-1; i++ {
+		_, err := h.UpdateRoomFromPBX(context.Background(), room)
+		assert.ErrorIs(t, err, hotel.ErrTransient)
+	}
+	assert.Empty(t, sender.alerts)
+
+	// success resets the count
+	hotelMock.On("UpdateRoom", "1001", "clean", "John Doe").Return("Updated", nil).Once()
+	_, err := h.UpdateRoomFromPBX(context.Background(), room)
+	assert.NoError(t, err)
+
+	// rejected requests are not counted
+	hotelMock.On("UpdateRoom", "1001", "clean", "John Doe").Return("", hotel.NewError(hotel.ErrValidation, errors.New("unknown room"))).Once()
+	_, err = h.UpdateRoomFromPBX(context.Background(), room)
+	assert.Error(t, err)
+
+	hotelMock.On("UpdateRoom", "1001", "clean", "John Doe").Return("", failed).Times(updateFailuresAlertThreshold)
+	for i := 0; i < updateFailuresAlertThreshold; i++ {
+		_, _ = h.UpdateRoomFromPBX(context.Background(), room)
+	}
+	assert.Len(t, sender.alerts, 1)
+	assert.Equal(t, notify.KindUpdateFailures, sender.alerts[0].Kind)
+	assert.Equal(t, "3 room updates failed in a row. Last one (extension 1001): cloudbeds is unavailable", sender.alerts[0].Message)
+
+	// authorization failure asks for login right away
+	hotelMock.On("UpdateRoom", "1001", "clean", "John Doe").Return("", hotel.NewError(hotel.ErrAuthorization, errors.New("invalid token"))).Once()
+	_, _ = h.UpdateRoomFromPBX(context.Background(), room)
+	assert.Len(t, sender.alerts, 2)
+	assert.Equal(t, notify.KindReloginRequired, sender.alerts[1].Kind)
+	hotelMock.AssertExpectations(t)
+}
+
+// MockLoginCheckerHospitalityProvider is a hospitality provider that needs an interactive login
+type MockLoginCheckerHospitalityProvider struct {
+	MockHospitalityProvider
+}
+
+func (m *MockLoginCheckerHospitalityProvider) LoginRequired() (msg string, required bool) {
+	args := m.Called()
+	return args.String(0), args.Bool(1)
+}
+
+func TestHandler_CheckLogin(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	sender := &fakeAlertSender{}
+	hotelMock := new(MockLoginCheckerHospitalityProvider)
+	hotelMock.On("LoginRequired").Return("", false).Once()
+	h := NewHandler(log, new(MockPBXProvider), hotelMock)
+	h.Notifier = notify.New(log, sender)
+
+	h.CheckLogin(context.Background())
+	assert.Empty(t, sender.alerts)
+
+	hotelMock.On("LoginRequired").Return("No refresh token found. Please run this link in browser to login to Cloudbeds: https://example.com", true).Once()
+	h.CheckLogin(context.Background())
+	assert.Len(t, sender.alerts, 1)
+	assert.Equal(t, notify.KindReloginRequired, sender.alerts[0].Kind)
+
+	// providers without interactive login and handlers without notifier are fine
+	h = NewHandler(log, new(MockPBXProvider), new(MockHospitalityProvider))
+	h.CheckLogin(context.Background())
+}
+
 func TestNewHandler(t *testing.T) {
 	mockLog := logrus.New()
 	mockPBX := new(MockPBXProvider)
@@ -407,6 +663,22 @@ func TestHandleManualLogin(t *testing.T) {
 	}
 }
 
+func TestHandleManualLogin_LoginNotRequired(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = io.Discard // discard log output for test
+
+	mockProvider := &MockHospitalityProvider{}
+	mockProvider.On("HandleInitialLogin").Return("", nil)
+	h := NewHandler(logger, nil, mockProvider)
+
+	req := httptest.NewRequest("GET", "/login", nil)
+	rr := httptest.NewRecorder()
+	h.HandleManualLogin(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Login is not required", rr.Body.String())
+}
+
 func TestHandleCallback(t *testing.T) {
 	// Define the test cases as a slice of structs
 	testCases := []struct {
@@ -545,6 +817,34 @@ func TestHandleGetRooms(t *testing.T) {
 	}
 }
 
+// contextRecordingProvider records the context GetRooms is called with
+type contextRecordingProvider struct {
+	MockHospitalityProvider
+	ctx context.Context
+}
+
+func (p *contextRecordingProvider) GetRooms(ctx context.Context) ([]hotel.Room, error) {
+	p.ctx = ctx
+	return nil, ctx.Err()
+}
+
+func TestHandleGetRooms_RequestContext(t *testing.T) {
+	mockLogger := logrus.New()
+	mockLogger.SetLevel(logrus.PanicLevel)
+
+	// client has gone: the provider gets the cancelled context of the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/rooms", nil).WithContext(ctx)
+	provider := &contextRecordingProvider{}
+	recorder := httptest.NewRecorder()
+	NewHandler(mockLogger, nil, provider).HandleGetRooms(recorder, req)
+
+	assert.Equal(t, ctx, provider.ctx)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "context canceled", recorder.Body.String())
+}
+
 func TestHandleMain(t *testing.T) {
 	mockLogger := logrus.New()
 	mockLogger.SetLevel(logrus.PanicLevel) // Set log level to panic to suppress logs during testing
@@ -583,3 +883,220 @@ func TestHandleMain(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusCode(t *testing.T) {
+	assert.Equal(t, http.StatusBadRequest, StatusCode(hotel.NewError(hotel.ErrValidation, errors.New("Parameter roomID is required"))))
+	assert.Equal(t, http.StatusUnauthorized, StatusCode(fmt.Errorf("update room: %w", hotel.NewError(hotel.ErrAuthorization, errors.New("refresh token error")))))
+	assert.Equal(t, http.StatusServiceUnavailable, StatusCode(hotel.NewError(hotel.ErrTransient, errors.New("status 502"))))
+	assert.Equal(t, http.StatusBadRequest, StatusCode(fmt.Errorf("%w: no item code dialed", pbx.ErrInvalidRequest)))
+	assert.Equal(t, http.StatusInternalServerError, StatusCode(errors.New("unknown")))
+}
+
+func TestHandleHospitalityWebhook(t *testing.T) {
+	mockLogger := logrus.New()
+	mockLogger.SetLevel(logrus.PanicLevel) // Set log level to panic to suppress logs during testing
+
+	checkOut := hotel.Event{Type: hotel.EventCheckOut, RoomID: "544559-0", PhoneNumber: "1001"}
+	roomConditionChanged := hotel.Event{Type: hotel.EventRoomConditionChanged, RoomID: "544559-1", RoomCondition: "clean"}
+
+	testCases := []struct {
+		name           string
+		url            string
+		webhookToken   string
+		mockEvents     []hotel.Event
+		mockError      error
+		handlerError   error
+		expectedCode   int
+		expectedBody   string
+		expectedEvents []hotel.Event
+	}{
+		{
+			name:           "Events dispatched",
+			url:            "/cloudbeds/webhook?token=secret",
+			webhookToken:   "secret",
+			mockEvents:     []hotel.Event{checkOut, roomConditionChanged},
+			expectedCode:   http.StatusOK,
+			expectedBody:   "handled 2 events",
+			expectedEvents: []hotel.Event{checkOut},
+		},
+		{
+			name:         "Invalid token",
+			url:          "/cloudbeds/webhook?token=wrong",
+			webhookToken: "secret",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "",
+		},
+		{
+			name:         "Token is not configured",
+			url:          "/cloudbeds/webhook?token=",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: "",
+		},
+		{
+			name:         "Bad webhook body",
+			url:          "/cloudbeds/webhook?token=secret",
+			webhookToken: "secret",
+			mockError:    &hotel.DetailedError{Msg: errors.New("bad webhook"), Details: "bad webhook"},
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "bad webhook",
+		},
+		{
+			name:         "Webhook without room",
+			url:          "/cloudbeds/webhook?token=secret",
+			webhookToken: "secret",
+			mockError:    hotel.NewError(hotel.ErrValidation, errors.New("webhook housekeeping/room_condition_changed has no roomID")),
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "webhook housekeeping/room_condition_changed has no roomID",
+		},
+		{
+			name:         "Provider error",
+			url:          "/cloudbeds/webhook?token=secret",
+			webhookToken: "secret",
+			mockError:    errors.New("refresh token error"),
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: "refresh token error",
+		},
+		{
+			name:         "Hotel API unavailable",
+			url:          "/cloudbeds/webhook?token=secret",
+			webhookToken: "secret",
+			mockError:    hotel.NewError(hotel.ErrTransient, &hotel.DetailedError{Msg: errors.New("cloudbeds request failed with status 503"), Details: "Service Unavailable"}),
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: "cloudbeds request failed with status 503",
+		},
+		{
+			name:           "Event handler failed",
+			url:            "/cloudbeds/webhook?token=secret",
+			webhookToken:   "secret",
+			mockEvents:     []hotel.Event{checkOut},
+			handlerError:   errors.New("update failed"),
+			expectedCode:   http.StatusInternalServerError,
+			expectedBody:   "failed to handle 1 of 1 events",
+			expectedEvents: []hotel.Event{checkOut},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"event":"reservation/status_changed"}`
+			mockProvider := new(MockWebhookHospitalityProvider)
+			mockProvider.On("ParseWebhook", []byte(body)).Return(tc.mockEvents, tc.mockError)
+
+			var handledEvents []hotel.Event
+			handler := NewHandler(mockLogger, nil, mockProvider)
+			handler.WebhookToken = tc.webhookToken
+			handler.Events = hotel.NewEventDispatcher()
+			handler.Events.Register(hotel.EventCheckOut, hotel.EventHandlerFunc(func(ctx context.Context, event hotel.Event) error {
+				handledEvents = append(handledEvents, event)
+				return tc.handlerError
+			}))
+
+			req := httptest.NewRequest("POST", tc.url, strings.NewReader(body))
+			recorder := httptest.NewRecorder()
+			handler.HandleHospitalityWebhook(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tc.expectedCode, res.StatusCode)
+			resBody, _ := io.ReadAll(res.Body)
+			assert.Equal(t, tc.expectedBody, string(resBody))
+			assert.Equal(t, tc.expectedEvents, handledEvents)
+		})
+	}
+
+	t.Run("Provider without webhooks", func(t *testing.T) {
+		handler := NewHandler(mockLogger, nil, new(MockHospitalityProvider))
+		handler.WebhookToken = "secret"
+		req := httptest.NewRequest("POST", "/cloudbeds/webhook?token=secret", strings.NewReader("{}"))
+		recorder := httptest.NewRecorder()
+		handler.HandleHospitalityWebhook(recorder, req)
+		assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+	})
+}
+
+func TestHandleSubscribeWebhooks(t *testing.T) {
+	mockLogger := logrus.New()
+	mockLogger.SetLevel(logrus.PanicLevel) // Set log level to panic to suppress logs during testing
+
+	webhookURL := "https://mypublic.api.address/api/v1/cloudbeds/webhook"
+	testCases := []struct {
+		name         string
+		webhookURL   string
+		webhookToken string
+		requestToken string // webhookToken if not set
+		mockError    error
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Subscribed",
+			webhookURL:   webhookURL,
+			webhookToken: "secret",
+			expectedCode: http.StatusOK,
+			expectedBody: "Subscribed to webhooks",
+		},
+		{
+			name:         "Webhook url is not set",
+			webhookToken: "secret",
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: "webhook url is not set",
+		},
+		{
+			name:         "Webhook token is not set",
+			webhookURL:   webhookURL,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Wrong token",
+			webhookURL:   webhookURL,
+			webhookToken: "secret",
+			requestToken: "wrong",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Provider error",
+			webhookURL:   webhookURL,
+			webhookToken: "secret",
+			mockError:    errors.New("some error"),
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: "some error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockProvider := new(MockWebhookHospitalityProvider)
+			mockProvider.On("SubscribeWebhooks", webhookURL).Return(tc.mockError)
+
+			handler := NewHandler(mockLogger, nil, mockProvider)
+			handler.WebhookURL = tc.webhookURL
+			handler.WebhookToken = tc.webhookToken
+
+			requestToken := tc.requestToken
+			if requestToken == "" {
+				requestToken = tc.webhookToken
+			}
+			req := httptest.NewRequest("POST", "/cloudbeds/webhook/subscribe?token="+requestToken, nil)
+			recorder := httptest.NewRecorder()
+			handler.HandleSubscribeWebhooks(recorder, req)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code)
+			assert.Equal(t, tc.expectedBody, recorder.Body.String())
+		})
+	}
+
+	// subscription on start needs the token as well
+	handler := NewHandler(mockLogger, nil, new(MockWebhookHospitalityProvider))
+	handler.WebhookURL = webhookURL
+	err := handler.SubscribeWebhooks(context.Background())
+	assert.EqualError(t, err, "webhook token is not set. Set HOSPITALITY_WEBHOOK_TOKEN and add ?token=... to the webhook url")
+}
+
+func TestValidWebhookToken(t *testing.T) {
+	assert.False(t, ValidWebhookToken("", ""))
+	assert.False(t, ValidWebhookToken("", "any"))
+	assert.True(t, ValidWebhookToken("secret", "secret"))
+	assert.False(t, ValidWebhookToken("secret", ""))
+	assert.False(t, ValidWebhookToken("secret", "Secret"))
+}
@@ -0,0 +1,221 @@
+// Package hoteltest provides the fake PMS server and the checks shared by the tests of hospitality providers.
+// Tests of every provider use the same rooms: phone 1001 is an occupied room with guest Jane Smith, 1002 is a vacant room
+// and 9999 is not in the extension map
+package hoteltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Housekeeper is the staff name passed to the updates. The quotes check the escaping of request bodies
+const Housekeeper = `John "JD" Doe`
+
+// tokenReply is the reply of the token endpoint
+const tokenReply = `{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`
+
+// Request is a request received by Server
+type Request struct {
+	Method string
+	Path   string
+	// URI is the path with the query
+	URI    string
+	Query  map[string]string
+	Form   map[string]string
+	Header http.Header
+	Body   string
+}
+
+// Server imitates a PMS API. Replies are looked up by Key of the request, "METHOD path" by default.
+// The fields are read on every request, so they can be changed after NewServer
+type Server struct {
+	*httptest.Server
+	Requests []Request
+	// TokenRequests are the requests to TokenPath. They are not in Requests
+	TokenRequests []Request
+
+	Replies     map[string]string
+	ReplyStatus map[string]int
+	Key         func(r *http.Request) string
+	// TokenPath is the path of OAuth2 token endpoint. It issues "access-token"
+	TokenPath string
+	// AccessToken is required in Bearer Authorization header if set. Other requests get 401 with UnauthorizedReply
+	AccessToken       string
+	UnauthorizedReply string
+	// Intercept is called before anything else. The request is not processed further if it returns true
+	Intercept func(w http.ResponseWriter, r *http.Request) bool
+}
+
+// NewServer starts Server with replies. It is closed at the end of the test
+func NewServer(t *testing.T, replies map[string]string) *Server {
+	s := &Server{
+		Replies:     replies,
+		ReplyStatus: make(map[string]int),
+		Key: func(r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handle(t, w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) handle(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	if s.Intercept != nil && s.Intercept(w, r) {
+		return
+	}
+
+	if s.TokenPath != "" && r.URL.Path == s.TokenPath {
+		s.TokenRequests = append(s.TokenRequests, newRequest(t, r))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(tokenReply))
+		return
+	}
+
+	if s.AccessToken != "" && r.Header.Get("Authorization") != "Bearer "+s.AccessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(s.UnauthorizedReply))
+		return
+	}
+	s.Requests = append(s.Requests, newRequest(t, r))
+
+	key := s.Key(r)
+	reply, ok := s.Replies[key]
+	if !ok {
+		t.Errorf("unexpected request %s", key)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"unexpected request"}`))
+		return
+	}
+	if status, ok := s.ReplyStatus[key]; ok {
+		w.WriteHeader(status)
+	}
+	_, _ = w.Write([]byte(reply))
+}
+
+func newRequest(t *testing.T, r *http.Request) Request {
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(t, err)
+	request := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		URI:    r.URL.RequestURI(),
+		Query:  make(map[string]string),
+		Form:   make(map[string]string),
+		Header: r.Header,
+		Body:   string(body),
+	}
+	for key := range r.URL.Query() {
+		request.Query[key] = r.URL.Query().Get(key)
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(request.Body)
+		assert.NoError(t, err)
+		for key := range values {
+			request.Form[key] = values.Get(key)
+		}
+	}
+	return request
+}
+
+// Extensions returns the extension map of phones 1001, 1002... of rooms 101, 102... with roomIDs in the PMS
+func Extensions(propertyID string, roomIDs ...string) []configuration.Extension {
+	extensions := make([]configuration.Extension, 0, len(roomIDs))
+	for i, roomID := range roomIDs {
+		extensions = append(extensions, configuration.Extension{
+			RoomExtension:         strconv.Itoa(1001 + i),
+			HospitalityRoomID:     roomID,
+			HospitalityRoomName:   strconv.Itoa(101 + i),
+			HospitalityPropertyID: propertyID,
+		})
+	}
+	return extensions
+}
+
+// Guest returns the guest staying in room 1001
+func Guest(reservationID string) hotel.Guest {
+	return hotel.Guest{ReservationID: reservationID, FirstName: "Jane", LastName: "Smith", Email: "jane.smith@example.com"}
+}
+
+// CheckGetRoomGuest checks the guest of the occupied room 1001, vacant room 1002 and unknown phone 9999
+func CheckGetRoomGuest(t *testing.T, provider hotel.HospitalityProvider, reservationID string) {
+	guest, err := provider.GetRoomGuest(context.Background(), "1001")
+	require.NoError(t, err)
+	assert.Equal(t, Guest(reservationID), guest)
+
+	_, err = provider.GetRoomGuest(context.Background(), "1002")
+	assert.EqualError(t, err, "no in-house reservation found for room 1002")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+
+	_, err = provider.GetRoomGuest(context.Background(), "9999")
+	assert.EqualError(t, err, "phone number 9999 not found")
+}
+
+// CheckUpdateRoom updates room 1001 to roomCondition and returns the request sent to server.
+// Updates to invalidCondition and of unknown phone 9999 must fail without requests
+func CheckUpdateRoom(t *testing.T, provider hotel.HospitalityProvider, server *Server, roomCondition, invalidCondition string) Request {
+	sent := len(server.Requests)
+	msg, err := provider.UpdateRoom(context.Background(), "1001", roomCondition, Housekeeper)
+	require.NoError(t, err)
+	assert.Equal(t, "Finish UpdateRoom successfully updated room 1001 to "+roomCondition, msg)
+	require.Len(t, server.Requests, sent+1)
+	request := server.Requests[sent]
+
+	_, err = provider.UpdateRoom(context.Background(), "1001", invalidCondition, Housekeeper)
+	assert.EqualError(t, err, fmt.Sprintf("room condition %s is not valid", invalidCondition))
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+
+	_, err = provider.UpdateRoom(context.Background(), "9999", roomCondition, Housekeeper)
+	assert.EqualError(t, err, "phone number 9999 not found")
+	assert.Len(t, server.Requests, sent+1)
+	return request
+}
+
+// CheckUpdateRoomBlock blocks room 1001 for "AC repair" by "Engineer" and unblocks it
+func CheckUpdateRoomBlock(t *testing.T, provider hotel.HospitalityProvider, expectedBlockMessage, expectedUnblockMessage string) {
+	msg, err := provider.UpdateRoomBlock(context.Background(), "1001", true, "AC repair", "Engineer")
+	require.NoError(t, err)
+	assert.Equal(t, expectedBlockMessage, msg)
+
+	msg, err = provider.UpdateRoomBlock(context.Background(), "1001", false, "", "Engineer")
+	require.NoError(t, err)
+	assert.Equal(t, expectedUnblockMessage, msg)
+}
+
+// CheckApiError checks that the error reply to the update of room 1001 is returned as *hotel.DetailedError
+func CheckApiError(t *testing.T, provider hotel.HospitalityProvider, expectedError string) {
+	_, err := provider.UpdateRoom(context.Background(), "1001", "clean", Housekeeper)
+	assert.EqualError(t, err, expectedError)
+	var detailedError *hotel.DetailedError
+	assert.True(t, errors.As(err, &detailedError))
+}
+
+// CheckNotSupported checks that provider doesn't need the interactive login and fails the operations it doesn't support.
+// Empty expectedChargeError means that charges are supported
+func CheckNotSupported(t *testing.T, provider hotel.HospitalityProvider, expectedDoNotDisturbError, expectedChargeError string) {
+	loginURL, err := provider.HandleInitialLogin(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, loginURL)
+	assert.NoError(t, provider.HandleOAuthCallback(context.Background(), "state", "code"))
+
+	_, err = provider.UpdateRoomDoNotDisturb(context.Background(), "1001", true, Housekeeper)
+	assert.EqualError(t, err, expectedDoNotDisturbError)
+	if expectedChargeError != "" {
+		_, err = provider.PostCharge(context.Background(), "1001", "12", 1, Housekeeper)
+		assert.EqualError(t, err, expectedChargeError)
+	}
+}
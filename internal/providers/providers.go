@@ -0,0 +1,21 @@
+// Package providers links every hospitality provider, PBX provider and secret store into the binary.
+// Importing it for side effects registers them by name, so entrypoints create the ones named in configuration
+// (HOTEL_PROVIDER, PBX_PROVIDER, SECRETS_STORE). A new backend is added here, not in every entrypoint
+package providers
+
+import (
+	// hospitality providers
+	_ "github.com/olegromanchuk/hotelito/pkg/hotel/apaleo"
+	_ "github.com/olegromanchuk/hotelito/pkg/hotel/cloudbeds"
+	_ "github.com/olegromanchuk/hotelito/pkg/hotel/generichttp"
+	_ "github.com/olegromanchuk/hotelito/pkg/hotel/memory"
+	_ "github.com/olegromanchuk/hotelito/pkg/hotel/mews"
+	_ "github.com/olegromanchuk/hotelito/pkg/hotel/opera"
+
+	// PBX providers
+	_ "github.com/olegromanchuk/hotelito/pkg/pbx/pbx3cx"
+
+	// secret stores
+	_ "github.com/olegromanchuk/hotelito/pkg/secrets/awsstore"
+	_ "github.com/olegromanchuk/hotelito/pkg/secrets/boltstore"
+)
@@ -0,0 +1,34 @@
+package providers
+
+import (
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/pbx"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegisteredBackends(t *testing.T) {
+	assert.Equal(t, []string{"apaleo", "cloudbeds", "generichttp", "memory", "mews", "opera"}, hotel.Providers())
+	assert.Equal(t, []string{"3cx"}, pbx.Providers())
+	assert.Equal(t, []string{"aws", "bolt"}, secrets.Stores())
+}
+
+func TestNewProvider(t *testing.T) {
+	_, err := hotel.NewProvider("opera-suite-8", logrus.New(), nil, &configuration.ConfigMap{})
+	assert.EqualError(t, err, "unknown hospitality provider 'opera-suite-8'. Supported: apaleo, cloudbeds, generichttp, memory, mews, opera")
+
+	_, err = pbx.NewProvider("asterisk", logrus.New(), &configuration.ConfigMap{})
+	assert.EqualError(t, err, "unknown PBX provider 'asterisk'. Supported: 3cx")
+
+	_, err = secrets.NewStore("vault", logrus.New(), secrets.Options{})
+	assert.EqualError(t, err, "unknown secret store 'vault'. Supported: aws, bolt")
+
+	//empty name means 3cx
+	pbxClient, err := pbx.NewProvider("", logrus.New(), &configuration.ConfigMap{})
+	require.NoError(t, err)
+	assert.NotNil(t, pbxClient)
+}
@@ -0,0 +1,63 @@
+// Package registry keeps the factories of pluggable backends (hospitality providers, PBX providers, secret stores) by name.
+// Backends register themselves in init() and binaries create the one named in configuration
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry maps a backend name to its factory F. Names are case-insensitive
+type Registry[F any] struct {
+	kind      string // what is registered. Used in error messages: "unknown <kind> 'name'"
+	mu        sync.RWMutex
+	factories map[string]F
+}
+
+// New creates an empty registry of kind backends
+func New[F any](kind string) *Registry[F] {
+	return &Registry[F]{
+		kind:      kind,
+		factories: make(map[string]F),
+	}
+}
+
+// Register adds factory under name. It panics if name is empty or already registered, same as database/sql drivers
+func (r *Registry[F]) Register(name string, factory F) {
+	name = strings.ToLower(name)
+	if name == "" {
+		panic(fmt.Sprintf("registry: %s name is empty", r.kind))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.factories[name]; exists {
+		panic(fmt.Sprintf("registry: %s '%s' is registered twice", r.kind, name))
+	}
+	r.factories[name] = factory
+}
+
+// Lookup returns the factory registered under name
+func (r *Registry[F]) Lookup(name string) (factory F, err error) {
+	r.mu.RLock()
+	factory, ok := r.factories[strings.ToLower(name)]
+	r.mu.RUnlock()
+	if !ok {
+		return factory, fmt.Errorf("unknown %s '%s'. Supported: %s", r.kind, name, strings.Join(r.Names(), ", "))
+	}
+	return factory, nil
+}
+
+// Names returns sorted names of the registered backends
+func (r *Registry[F]) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
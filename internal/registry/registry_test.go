@@ -0,0 +1,30 @@
+package registry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New[func() string]("test backend")
+	r.Register("Beta", func() string { return "beta" })
+	r.Register("alpha", func() string { return "alpha" })
+
+	assert.Equal(t, []string{"alpha", "beta"}, r.Names())
+
+	factory, err := r.Lookup("BETA")
+	require.NoError(t, err)
+	assert.Equal(t, "beta", factory())
+
+	_, err = r.Lookup("gamma")
+	assert.EqualError(t, err, "unknown test backend 'gamma'. Supported: alpha, beta")
+}
+
+func TestRegistry_RegisterPanics(t *testing.T) {
+	r := New[int]("test backend")
+	r.Register("alpha", 1)
+
+	assert.PanicsWithValue(t, "registry: test backend 'alpha' is registered twice", func() { r.Register("ALPHA", 2) })
+	assert.PanicsWithValue(t, "registry: test backend name is empty", func() { r.Register("", 3) })
+}
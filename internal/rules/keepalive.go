@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// KeepAlive periodically refreshes the token of a hospitality provider, so the refresh token doesn't go stale when nobody
+// dials from the rooms for a long time. It records the last successful refresh and alerts when the refresh fails
+type KeepAlive struct {
+	log      *logrus.Logger
+	provider hotel.TokenKeeper
+	interval time.Duration
+	// Alert is called on every failed refresh. failures is the amount of failures in a row, lastRefresh is zero if there
+	// was no successful refresh since the start. By default the failure is logged with error level and sent to Notifier
+	Alert func(ctx context.Context, err error, failures int, lastRefresh time.Time)
+	// Notifier alerts admins about the failed refresh. Optional
+	Notifier    *notify.Notifier
+	mu          sync.Mutex
+	lastRefresh time.Time
+	failures    int
+	now         func() time.Time
+}
+
+func NewKeepAlive(log *logrus.Logger, provider hotel.TokenKeeper, interval time.Duration) *KeepAlive {
+	k := &KeepAlive{
+		log:      log,
+		provider: provider,
+		interval: interval,
+		now:      time.Now,
+	}
+	k.Alert = k.logAlert
+	return k
+}
+
+// Run refreshes the token at start and then every interval until ctx is cancelled
+func (k *KeepAlive) Run(ctx context.Context) {
+	k.log.Infof("Refreshing hospitality provider token every %s", k.interval)
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		_ = k.Refresh(ctx) //the failure is already alerted
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh refreshes the token once. The failure is alerted unless ctx is cancelled
+func (k *KeepAlive) Refresh(ctx context.Context) error {
+	err := k.provider.KeepTokenAlive(ctx)
+
+	k.mu.Lock()
+	if err == nil {
+		k.lastRefresh = k.now()
+		k.failures = 0
+	} else {
+		k.failures++
+	}
+	failures, lastRefresh := k.failures, k.lastRefresh
+	k.mu.Unlock()
+
+	if err != nil {
+		if ctx.Err() == nil {
+			k.Alert(ctx, err, failures, lastRefresh)
+		}
+		return err
+	}
+	k.log.Infof("Hospitality provider token is refreshed")
+	return nil
+}
+
+// LastRefresh returns the time of the last successful refresh. Zero if there was none since the start
+func (k *KeepAlive) LastRefresh() time.Time {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lastRefresh
+}
+
+func (k *KeepAlive) logAlert(ctx context.Context, err error, failures int, lastRefresh time.Time) {
+	last := "never since the start"
+	if !lastRefresh.IsZero() {
+		last = lastRefresh.Format(time.RFC3339)
+	}
+	msg := fmt.Sprintf("failed to refresh hospitality provider token (%d time(s) in a row, last successful refresh: %s): %s. "+
+		"If it keeps failing, login again: /api/v1/login", failures, last, err)
+	k.log.Error(msg)
+	_ = k.Notifier.Notify(ctx, notify.Alert{
+		Kind:    notify.KindTokenRefreshFailed,
+		Subject: "Hospitality provider token refresh failed",
+		Message: msg,
+	})
+}
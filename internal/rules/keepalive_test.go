@@ -0,0 +1,122 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"github.com/olegromanchuk/hotelito/pkg/notify"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeTokenKeeper struct {
+	err   error
+	calls int
+}
+
+func (f *fakeTokenKeeper) KeepTokenAlive(ctx context.Context) error {
+	f.calls++
+	return f.err
+}
+
+func TestKeepAlive_Refresh(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	now := time.Date(2023, 8, 2, 12, 0, 0, 0, time.UTC)
+	provider := &fakeTokenKeeper{}
+	keepAlive := NewKeepAlive(log, provider, time.Hour)
+	keepAlive.now = func() time.Time { return now }
+
+	type alert struct {
+		err         error
+		failures    int
+		lastRefresh time.Time
+	}
+	var alerts []alert
+	keepAlive.Alert = func(ctx context.Context, err error, failures int, lastRefresh time.Time) {
+		alerts = append(alerts, alert{err, failures, lastRefresh})
+	}
+
+	// success is recorded
+	assert.NoError(t, keepAlive.Refresh(context.Background()))
+	assert.Equal(t, now, keepAlive.LastRefresh())
+	assert.Empty(t, alerts)
+
+	// every failure is alerted with the amount of failures in a row
+	refreshErr := errors.New("refresh token error")
+	provider.err = refreshErr
+	assert.ErrorIs(t, keepAlive.Refresh(context.Background()), refreshErr)
+	assert.ErrorIs(t, keepAlive.Refresh(context.Background()), refreshErr)
+	assert.Equal(t, []alert{{refreshErr, 1, now}, {refreshErr, 2, now}}, alerts)
+	assert.Equal(t, now, keepAlive.LastRefresh())
+
+	// success resets the failures
+	provider.err = nil
+	now = now.Add(time.Hour)
+	assert.NoError(t, keepAlive.Refresh(context.Background()))
+	assert.Equal(t, now, keepAlive.LastRefresh())
+	provider.err = refreshErr
+	assert.Error(t, keepAlive.Refresh(context.Background()))
+	assert.Equal(t, alert{refreshErr, 1, now}, alerts[len(alerts)-1])
+
+	// cancelled refresh (server shutdown) is not alerted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, keepAlive.Refresh(ctx))
+	assert.Len(t, alerts, 3)
+}
+
+func TestKeepAlive_DefaultAlert(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	// without notifier the default alert only logs the failure
+	keepAlive := NewKeepAlive(log, &fakeTokenKeeper{err: errors.New("refresh token error")}, time.Hour)
+	assert.Error(t, keepAlive.Refresh(context.Background()))
+	assert.True(t, keepAlive.LastRefresh().IsZero())
+
+	// with notifier admins are alerted
+	sender := &fakeAlertSender{}
+	keepAlive.Notifier = notify.New(log, sender)
+	assert.Error(t, keepAlive.Refresh(context.Background()))
+	assert.Len(t, sender.alerts, 1)
+	assert.Equal(t, notify.KindTokenRefreshFailed, sender.alerts[0].Kind)
+	assert.Contains(t, sender.alerts[0].Message, "2 time(s) in a row, last successful refresh: never since the start")
+}
+
+type fakeAlertSender struct {
+	alerts []notify.Alert
+}
+
+func (f *fakeAlertSender) Send(ctx context.Context, alert notify.Alert) error {
+	f.alerts = append(f.alerts, alert)
+	return nil
+}
+
+func TestKeepAlive_Run(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	provider := &fakeTokenKeeper{}
+	keepAlive := NewKeepAlive(log, provider, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		keepAlive.Run(ctx)
+		close(done)
+	}()
+
+	// the token is refreshed at start
+	assert.Eventually(t, func() bool { return !keepAlive.LastRefresh().IsZero() }, time.Second, 10*time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("keep-alive didn't stop after ctx was cancelled")
+	}
+	assert.Equal(t, 1, provider.calls)
+}
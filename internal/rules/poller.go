@@ -0,0 +1,95 @@
+package rules
+
+import (
+	"context"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// pollWindow is how far back the poller asks the provider for events. Events already dispatched inside the window are skipped
+const pollWindow = 24 * time.Hour
+
+// Poller periodically requests events from a hospitality provider that can't push them (or when webhooks are not configured)
+// and dispatches new ones. Events found by the first poll are only remembered, so a restart doesn't repeat old check-outs
+type Poller struct {
+	log         *logrus.Logger
+	provider    hotel.EventPoller
+	dispatcher  *hotel.EventDispatcher
+	interval    time.Duration
+	seen        map[string]time.Time
+	initialized bool
+	now         func() time.Time
+}
+
+func NewPoller(log *logrus.Logger, provider hotel.EventPoller, dispatcher *hotel.EventDispatcher, interval time.Duration) *Poller {
+	return &Poller{
+		log:        log,
+		provider:   provider,
+		dispatcher: dispatcher,
+		interval:   interval,
+		seen:       make(map[string]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Run polls the provider every interval until ctx is cancelled. Cancelling ctx also stops the poll in progress
+func (p *Poller) Run(ctx context.Context) {
+	p.log.Infof("Polling hospitality provider events every %s", p.interval)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		err := p.Poll(ctx)
+		if err != nil {
+			p.log.Errorf("failed to poll events: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll requests events of the last pollWindow and dispatches those not dispatched before
+func (p *Poller) Poll(ctx context.Context) error {
+	now := p.now()
+	hotelEvents, err := p.provider.PollEvents(ctx, now.Add(-pollWindow))
+	if err != nil {
+		return err
+	}
+
+	for _, event := range hotelEvents {
+		key := eventKey(event)
+		if _, ok := p.seen[key]; ok {
+			continue
+		}
+		p.seen[key] = now
+		if !p.initialized {
+			continue
+		}
+
+		p.log.Infof("Event %s for room %s (extension %s), reservation %s", event.Type, event.RoomID, event.PhoneNumber, event.ReservationID)
+		err = p.dispatcher.Dispatch(ctx, event)
+		if err != nil {
+			p.log.Error(err)
+		}
+	}
+	if !p.initialized {
+		p.log.Debugf("First poll. %d existing events remembered", len(p.seen))
+		p.initialized = true
+	}
+
+	//forget events that are out of the window
+	for key, seenAt := range p.seen {
+		if now.Sub(seenAt) > 2*pollWindow {
+			delete(p.seen, key)
+		}
+	}
+	return nil
+}
+
+func eventKey(event hotel.Event) string {
+	return string(event.Type) + "/" + event.PropertyID + "/" + event.ReservationID + "/" + event.RoomID
+}
@@ -0,0 +1,90 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeEventPoller struct {
+	events []hotel.Event
+	err    error
+	since  []time.Time
+}
+
+func (f *fakeEventPoller) PollEvents(ctx context.Context, since time.Time) ([]hotel.Event, error) {
+	f.since = append(f.since, since)
+	return f.events, f.err
+}
+
+func TestPoller_Poll(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	now := time.Date(2023, 8, 2, 12, 0, 0, 0, time.UTC)
+	oldCheckout := hotel.Event{Type: hotel.EventCheckOut, PropertyID: "297652", RoomID: "544559-0", PhoneNumber: "1001", ReservationID: "1"}
+	newCheckout := hotel.Event{Type: hotel.EventCheckOut, PropertyID: "297652", RoomID: "544559-1", PhoneNumber: "1002", ReservationID: "2"}
+
+	var dispatched []hotel.Event
+	dispatcher := hotel.NewEventDispatcher()
+	dispatcher.Register(hotel.EventCheckOut, hotel.EventHandlerFunc(func(ctx context.Context, event hotel.Event) error {
+		dispatched = append(dispatched, event)
+		return nil
+	}))
+
+	provider := &fakeEventPoller{events: []hotel.Event{oldCheckout}}
+	poller := NewPoller(log, provider, dispatcher, time.Minute)
+	poller.now = func() time.Time { return now }
+
+	// first poll only remembers existing check-outs
+	assert.NoError(t, poller.Poll(context.Background()))
+	assert.Empty(t, dispatched)
+	assert.Equal(t, []time.Time{now.Add(-pollWindow)}, provider.since)
+
+	// only the new check-out is dispatched
+	provider.events = []hotel.Event{oldCheckout, newCheckout}
+	assert.NoError(t, poller.Poll(context.Background()))
+	assert.Equal(t, []hotel.Event{newCheckout}, dispatched)
+
+	// nothing new
+	assert.NoError(t, poller.Poll(context.Background()))
+	assert.Len(t, dispatched, 1)
+
+	// provider error
+	provider.err = errors.New("refresh token error")
+	assert.Error(t, poller.Poll(context.Background()))
+
+	// remembered events are forgotten when they leave the window
+	provider.err = nil
+	provider.events = nil
+	now = now.Add(3 * pollWindow)
+	assert.NoError(t, poller.Poll(context.Background()))
+	assert.Empty(t, poller.seen)
+}
+
+func TestPoller_Run(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	provider := &fakeEventPoller{}
+	poller := NewPoller(log, provider, hotel.NewEventDispatcher(), time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		poller.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("poller didn't stop after ctx was cancelled")
+	}
+	assert.Len(t, provider.since, 1)
+}
@@ -0,0 +1,71 @@
+// Package rules contains automatic actions hotelito performs in reaction to events of a hospitality provider.
+// Rules are hotel.EventHandler and are registered in hotel.EventDispatcher. Events come from webhooks or from Poller.
+package rules
+
+import (
+	"context"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+)
+
+const (
+	// SystemHousekeeperName is the housekeeper name used for updates made by rules
+	SystemHousekeeperName = hotel.SystemHousekeeperName
+	roomConditionDirty    = "dirty"
+)
+
+// DirtyOnCheckout marks the room dirty after the guest checks out
+type DirtyOnCheckout struct {
+	log   *logrus.Logger
+	hotel hotel.HospitalityProvider
+}
+
+func NewDirtyOnCheckout(log *logrus.Logger, hotelProvider hotel.HospitalityProvider) *DirtyOnCheckout {
+	return &DirtyOnCheckout{
+		log:   log,
+		hotel: hotelProvider,
+	}
+}
+
+// HandleEvent updates the room of the check-out event to "dirty". Rooms that are not in the extension map are skipped
+func (r *DirtyOnCheckout) HandleEvent(ctx context.Context, event hotel.Event) error {
+	if event.Type != hotel.EventCheckOut {
+		return nil
+	}
+	if event.PhoneNumber == "" {
+		r.log.Warnf("Room %s of reservation %s has no extension. Skipping dirty on checkout", event.RoomID, event.ReservationID)
+		return nil
+	}
+
+	msg, err := r.hotel.UpdateRoom(ctx, event.PhoneNumber, roomConditionDirty, SystemHousekeeperName)
+	if err != nil {
+		return fmt.Errorf("failed to mark room %s dirty on checkout: %w", event.PhoneNumber, err)
+	}
+	r.log.Infof("Room %s marked dirty on checkout of reservation %s. %s", event.PhoneNumber, event.ReservationID, msg)
+	return nil
+}
+
+// RegisterFromEnv registers rules enabled by env variables in the dispatcher:
+// RULE_DIRTY_ON_CHECKOUT=true - mark the room dirty after check-out
+func RegisterFromEnv(log *logrus.Logger, dispatcher *hotel.EventDispatcher, hotelProvider hotel.HospitalityProvider) {
+	if isEnabled(log, "RULE_DIRTY_ON_CHECKOUT") {
+		log.Infof("Rule enabled: dirty on checkout")
+		dispatcher.Register(hotel.EventCheckOut, NewDirtyOnCheckout(log, hotelProvider))
+	}
+}
+
+func isEnabled(log *logrus.Logger, varName string) bool {
+	value := os.Getenv(varName)
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("%s has invalid value '%s'. Rule is disabled", varName, value)
+		return false
+	}
+	return enabled
+}
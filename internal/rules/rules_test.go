@@ -0,0 +1,141 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"testing"
+)
+
+type MockHospitalityProvider struct {
+	mock.Mock
+}
+
+func (m *MockHospitalityProvider) GetRooms(ctx context.Context) ([]hotel.Room, error) {
+	args := m.Called()
+	return args.Get(0).([]hotel.Room), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
+	args := m.Called(roomNumber, mapFileName)
+	return args.Get(0).(hotel.Room), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	args := m.Called(roomNumber)
+	return args.Get(0).(hotel.Guest), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) UpdateRoom(ctx context.Context, roomNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	args := m.Called(roomNumber, housekeepingStatus, housekeeperName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) UpdateRoomDoNotDisturb(ctx context.Context, roomNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	args := m.Called(roomNumber, doNotDisturb, housekeeperName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) UpdateRoomBlock(ctx context.Context, roomNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	args := m.Called(roomNumber, blocked, reason, staffName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) PostCharge(ctx context.Context, roomNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	args := m.Called(roomNumber, itemCode, quantity, housekeeperName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (m *MockHospitalityProvider) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
+	args := m.Called(state, code)
+	return args.Error(0)
+}
+
+func (m *MockHospitalityProvider) HandleInitialLogin(ctx context.Context) (url string, err error) {
+	args := m.Called()
+	return args.Get(0).(string), args.Error(1)
+}
+
+func TestDirtyOnCheckout_HandleEvent(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	tests := []struct {
+		name          string
+		event         hotel.Event
+		updateError   error
+		expectUpdate  bool
+		expectedError string
+	}{
+		{
+			name:         "Room marked dirty on checkout",
+			event:        hotel.Event{Type: hotel.EventCheckOut, RoomID: "544559-0", PhoneNumber: "1001", ReservationID: "8817735791869"},
+			expectUpdate: true,
+		},
+		{
+			name:          "Update failed",
+			event:         hotel.Event{Type: hotel.EventCheckOut, RoomID: "544559-0", PhoneNumber: "1001", ReservationID: "8817735791869"},
+			updateError:   errors.New("refresh token error"),
+			expectUpdate:  true,
+			expectedError: "failed to mark room 1001 dirty on checkout: refresh token error",
+		},
+		{
+			name:  "Room without extension is skipped",
+			event: hotel.Event{Type: hotel.EventCheckOut, RoomID: "544559-9", ReservationID: "8817735791869"},
+		},
+		{
+			name:  "Check-in is ignored",
+			event: hotel.Event{Type: hotel.EventCheckIn, RoomID: "544559-0", PhoneNumber: "1001"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockProvider := new(MockHospitalityProvider)
+			mockProvider.On("UpdateRoom", "1001", "dirty", SystemHousekeeperName).Return("updated", tt.updateError)
+
+			err := NewDirtyOnCheckout(log, mockProvider).HandleEvent(context.Background(), tt.event)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError, err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.expectUpdate {
+				mockProvider.AssertCalled(t, "UpdateRoom", "1001", "dirty", SystemHousekeeperName)
+			} else {
+				mockProvider.AssertNotCalled(t, "UpdateRoom", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestRegisterFromEnv(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	defer os.Unsetenv("RULE_DIRTY_ON_CHECKOUT")
+
+	tests := []struct {
+		name            string
+		envValue        string
+		expectedEnabled bool
+	}{
+		{name: "Not set", envValue: "", expectedEnabled: false},
+		{name: "Enabled", envValue: "true", expectedEnabled: true},
+		{name: "Disabled", envValue: "false", expectedEnabled: false},
+		{name: "Invalid value", envValue: "yes please", expectedEnabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("RULE_DIRTY_ON_CHECKOUT", tt.envValue)
+			dispatcher := hotel.NewEventDispatcher()
+			RegisterFromEnv(log, dispatcher, new(MockHospitalityProvider))
+			assert.Equal(t, tt.expectedEnabled, dispatcher.HasHandlers(hotel.EventCheckOut))
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Package secretstest provides the secret store test double shared by the tests of hospitality providers
+package secretstest
+
+import (
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockSecretsStore is testify mock of secrets.SecretsStore and secrets.ProviderTokenStore
+type MockSecretsStore struct {
+	mock.Mock
+}
+
+func (m *MockSecretsStore) StoreAccessToken(token string) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockSecretsStore) StoreRefreshToken(token string) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockSecretsStore) RetrieveAccessToken() (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSecretsStore) RetrieveRefreshToken() (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSecretsStore) StoreToken(token secrets.Token) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockSecretsStore) RetrieveToken() (secrets.Token, error) {
+	args := m.Called()
+	return args.Get(0).(secrets.Token), args.Error(1)
+}
+
+func (m *MockSecretsStore) StoreOauthState(state string) error {
+	args := m.Called(state)
+	return args.Error(0)
+}
+
+func (m *MockSecretsStore) RetrieveOauthState(state string) (string, error) {
+	args := m.Called(state)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSecretsStore) RetrieveVar(varName string) (string, error) {
+	args := m.Called(varName)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSecretsStore) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockSecretsStore) StoreProviderToken(provider string, token secrets.Token) error {
+	args := m.Called(provider, token)
+	return args.Error(0)
+}
+
+func (m *MockSecretsStore) RetrieveProviderToken(provider string) (secrets.Token, error) {
+	args := m.Called(provider)
+	return args.Get(0).(secrets.Token), args.Error(1)
+}
@@ -0,0 +1,615 @@
+// Package apaleo represents an implementation of hospitality provider for Apaleo API.
+// Apaleo uses OAuth client credentials flow: the access token is requested with APALEO_CLIENT_ID and APALEO_CLIENT_SECRET,
+// so no interactive login is needed. The token is saved to the secret store under the name apaleo and requested again when Apaleo rejects it.
+// Rooms of the extension map are Apaleo units: hospitality_room_id is the unit ID, hospitality_property_id is the property ID.
+package apaleo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/hotel/oauthclient"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultApiURL   = "https://api.apaleo.com"
+	defaultTokenURL = "https://identity.apaleo.com/connect/token"
+	// apiPageSize is the amount of records requested per page from paginated Apaleo endpoints
+	apiPageSize = 100
+)
+
+const (
+	maintenanceTypeOutOfOrder = "OutOfOrder"
+	// maintenanceDuration is the length of a maintenance created from the phone. The room stays blocked until the unblock code is dialed
+	maintenanceDuration      = 365 * 24 * time.Hour
+	defaultRoomBlockReason   = "Out of order"
+	reservationStatusInHouse = "InHouse"
+)
+
+// unitConditions maps room conditions dialed from the room phone to Apaleo unit conditions
+var unitConditions = hotel.RoomConditionMap{
+	"clean":                 "Clean",
+	"clean_to_be_inspected": "CleanToBeInspected",
+	"dirty":                 "Dirty",
+}
+
+// HTTPClient is needed for mocking http requests in tests. Original http.Client implements this interface
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Apaleo is used to make requests to Apaleo API
+type Apaleo struct {
+	httpClient  HTTPClient
+	storeClient secrets.SecretsStore
+	log         *logrus.Logger
+	configMap   *configuration.ConfigMap
+	apiURL      string
+	tokenConfig *clientcredentials.Config
+	api         *oauthclient.Client
+}
+
+/*
+	Response: {
+	    "units": [
+	        {
+	            "id": "MUC-101",
+	            "name": "101",
+	            "description": "Double room",
+	            "property": {"id": "MUC"},
+	            "status": {
+	                "isOccupied": true,
+	                "condition": "Dirty",
+	                "maintenance": {"id": "MUC-MNT-1", "type": "OutOfOrder"}
+	            }
+	        }
+	    ],
+	    "count": 1
+	}
+*/
+type ResponseGetUnits struct {
+	Units []Unit `json:"units"`
+	Count int    `json:"count"`
+}
+
+type Unit struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Property    struct {
+		ID string `json:"id"`
+	} `json:"property"`
+	Status struct {
+		IsOccupied  bool   `json:"isOccupied"`
+		Condition   string `json:"condition"`
+		Maintenance *struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		} `json:"maintenance"`
+	} `json:"status"`
+}
+
+/*
+	Response: {
+	    "reservations": [
+	        {
+	            "id": "ABCDEF-1",
+	            "status": "InHouse",
+	            "property": {"id": "MUC"},
+	            "unit": {"id": "MUC-101"},
+	            "primaryGuest": {"firstName": "Jane", "lastName": "Smith", "email": "jane.smith@example.com"}
+	        }
+	    ],
+	    "count": 1
+	}
+*/
+type ResponseGetReservations struct {
+	Reservations []Reservation `json:"reservations"`
+	Count        int           `json:"count"`
+}
+
+type Reservation struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Unit   struct {
+		ID string `json:"id"`
+	} `json:"unit"`
+	PrimaryGuest struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+		Email     string `json:"email"`
+	} `json:"primaryGuest"`
+}
+
+type ResponseGetMaintenances struct {
+	Maintenances []Maintenance `json:"maintenances"`
+	Count        int           `json:"count"`
+}
+
+type Maintenance struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Unit struct {
+		ID string `json:"id"`
+	} `json:"unit"`
+}
+
+type ResponseCreateMaintenance struct {
+	ID string `json:"id"`
+}
+
+// ResponseError is returned by Apaleo with 4xx/5xx status code
+type ResponseError struct {
+	Message  string              `json:"message"`
+	Messages map[string][]string `json:"messages"`
+}
+
+func init() {
+	hotel.Register("apaleo", func(log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (hotel.Provider, error) {
+		client, err := New(log, secretStore, configMap)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *configuration.ConfigMap) (*Apaleo, error) {
+	log.Debugf("Creating new Apaleo client")
+
+	apaleoClient := &Apaleo{
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		storeClient: secretStore,
+		log:         log,
+		configMap:   configMapInfo,
+	}
+	getVar := func(varName string) string {
+		return hotel.VarFromStoreOrEnvironment(log, secretStore, varName)
+	}
+
+	clientID := getVar("APALEO_CLIENT_ID")
+	clientSecret := getVar("APALEO_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		errMsg := errors.New("not all required env variables are set. Missed one of: APALEO_CLIENT_ID, APALEO_CLIENT_SECRET")
+		log.Error(errMsg)
+		return nil, errMsg
+	}
+
+	tokenURL := getVar("APALEO_TOKEN_URL")
+	if tokenURL == "" {
+		tokenURL = defaultTokenURL
+	}
+	apaleoClient.tokenConfig = &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     tokenURL,
+	}
+
+	apaleoClient.apiURL = strings.TrimSuffix(getVar("APALEO_API_URL"), "/")
+	if apaleoClient.apiURL == "" {
+		apaleoClient.apiURL = defaultApiURL
+	}
+	apaleoClient.api = apaleoClient.newAPIClient()
+
+	return apaleoClient, nil
+}
+
+// GetRooms returns units of the properties from the extension map (all units of the account if no property is set) with their guests
+func (p *Apaleo) GetRooms(ctx context.Context) (rooms []hotel.Room, err error) {
+	p.log.Debugf("getting rooms")
+
+	propertyIDs := p.propertyIDs()
+	units, err := p.getUnits(ctx, propertyIDs)
+	if err != nil {
+		p.log.Error(err)
+		return rooms, err
+	}
+
+	reservations, err := p.getInHouseReservations(ctx, propertyIDs, nil)
+	if err != nil {
+		p.log.Error(err)
+		return rooms, err
+	}
+
+	for _, unit := range units {
+		var guest *hotel.Guest
+		if reservation, ok := reservations[unit.ID]; ok {
+			guest = toHotelGuest(reservation)
+		}
+		rooms = append(rooms, p.toHotelRoom(unit, guest))
+	}
+
+	if len(rooms) == 0 {
+		errMsg := errors.New("no rooms found")
+		return rooms, &hotel.DetailedError{Msg: errMsg, Details: "success, but no rooms found"}
+	}
+	p.log.Debugf("Amount of rooms: %d", len(rooms))
+	return rooms, nil
+}
+
+// GetRoom returns the unit of the room with extension roomNumber with its condition and in-house guest
+func (p *Apaleo) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
+	p.log.Infof("get info about room %s", roomNumber)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomNumber)
+	if err != nil {
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+
+	unit := Unit{}
+	err = p.do(ctx, http.MethodGet, "/inventory/v1/units/"+url.PathEscape(extension.HospitalityRoomID), nil, nil, &unit)
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Room{PhoneNumber: roomNumber, RoomID: extension.HospitalityRoomID}, err
+	}
+
+	var guest *hotel.Guest
+	if unit.Status.IsOccupied {
+		reservations, err := p.getInHouseReservations(ctx, nil, []string{unit.ID})
+		if err != nil {
+			p.log.Error(err)
+			return hotel.Room{PhoneNumber: roomNumber}, err
+		}
+		if reservation, ok := reservations[unit.ID]; ok {
+			guest = toHotelGuest(reservation)
+		}
+	}
+	return p.toHotelRoom(unit, guest), nil
+}
+
+// GetRoomGuest returns the primary guest of the in-house reservation of the room with extension roomNumber
+func (p *Apaleo) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	p.log.Debugf("get in-house guest for room %s", roomNumber)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomNumber)
+	if err != nil {
+		return hotel.Guest{}, err
+	}
+
+	reservations, err := p.getInHouseReservations(ctx, nil, []string{extension.HospitalityRoomID})
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Guest{}, err
+	}
+	reservation, ok := reservations[extension.HospitalityRoomID]
+	if !ok {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomNumber)
+		p.log.Debugf(errMsg)
+		return hotel.Guest{}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	return *toHotelGuest(reservation), nil
+}
+
+// UpdateRoom sets the condition of the unit. Apaleo doesn't record who has changed the condition, so housekeeperName is only logged
+func (p *Apaleo) UpdateRoom(ctx context.Context, roomExtensionNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoom %s to %s for %s", roomExtensionNumber, housekeepingStatus, housekeeperName)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+
+	roomCondition := strings.ToLower(housekeepingStatus)
+	if !hotel.IsRoomConditionValid(roomCondition, unitConditions) {
+		errMsg := fmt.Sprintf("room condition %s is not valid", housekeepingStatus)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	//unit is patched with JSON Patch (RFC 6902)
+	patch := []map[string]string{
+		{"op": "replace", "path": "/condition", "value": unitConditions[roomCondition]},
+	}
+	err = p.do(ctx, http.MethodPatch, "/inventory/v1/units/"+url.PathEscape(extension.HospitalityRoomID), nil, patch, nil)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomExtensionNumber, housekeepingStatus)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// UpdateRoomDoNotDisturb is not supported: Apaleo has no "Do Not Disturb" status of a unit
+func (p *Apaleo) UpdateRoomDoNotDisturb(ctx context.Context, roomExtensionNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	errMsg := "do not disturb is not supported by apaleo"
+	p.log.Error(errMsg)
+	return "", errors.New(errMsg)
+}
+
+// UpdateRoomBlock creates an out of order maintenance of the unit (blocked=true) or deletes its active out of order maintenances (blocked=false)
+func (p *Apaleo) UpdateRoomBlock(ctx context.Context, roomExtensionNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomBlock %s to %t (%s) for %s", roomExtensionNumber, blocked, reason, staffName)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+	unitID := extension.HospitalityRoomID
+
+	if blocked {
+		if reason == "" {
+			reason = defaultRoomBlockReason
+		}
+		if staffName != "" {
+			reason = fmt.Sprintf("%s (%s)", reason, staffName)
+		}
+		now := time.Now().UTC()
+		request := map[string]string{
+			"unitId":      unitID,
+			"from":        now.Format(time.RFC3339),
+			"to":          now.Add(maintenanceDuration).Format(time.RFC3339),
+			"type":        maintenanceTypeOutOfOrder,
+			"description": reason,
+		}
+		respBody := &ResponseCreateMaintenance{}
+		err = p.do(ctx, http.MethodPost, "/operations/v1/maintenances", nil, request, respBody)
+		if err != nil {
+			p.log.Error(err)
+			return msg, err
+		}
+		msg = fmt.Sprintf("Finish UpdateRoomBlock successfully blocked room %s. Maintenance: %s", roomExtensionNumber, respBody.ID)
+		p.log.Debugf(msg)
+		return msg, nil
+	}
+
+	maintenances, err := p.getActiveMaintenances(ctx, unitID)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+	removed := 0
+	for _, maintenance := range maintenances {
+		if maintenance.Type != maintenanceTypeOutOfOrder || maintenance.Unit.ID != unitID {
+			continue
+		}
+		err = p.do(ctx, http.MethodDelete, "/operations/v1/maintenances/"+url.PathEscape(maintenance.ID), nil, nil, nil)
+		if err != nil {
+			p.log.Error(err)
+			return msg, err
+		}
+		removed++
+	}
+	if removed == 0 {
+		p.log.Infof("no out of order maintenances found for room %s", roomExtensionNumber)
+	}
+	msg = fmt.Sprintf("Finish UpdateRoomBlock successfully unblocked room %s. Removed maintenances: %d", roomExtensionNumber, removed)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// PostCharge is not supported yet: Apaleo charges are posted to folios, which are not mapped to the item catalog
+func (p *Apaleo) PostCharge(ctx context.Context, roomExtensionNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	errMsg := "charges are not supported by apaleo"
+	p.log.Error(errMsg)
+	return "", errors.New(errMsg)
+}
+
+// HandleOAuthCallback does nothing. Apaleo uses client credentials flow
+func (p *Apaleo) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
+	p.log.Debugf("apaleo uses client credentials flow. OAuth callback is ignored")
+	return nil
+}
+
+// HandleInitialLogin does nothing. Apaleo uses client credentials flow, so empty url is returned
+func (p *Apaleo) HandleInitialLogin(ctx context.Context) (url string, err error) {
+	p.log.Debugf("apaleo uses client credentials flow. Login is not required")
+	return "", nil
+}
+
+func (p *Apaleo) Close() error {
+	err := p.storeClient.Close()
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to close secret store: %s", err.Error())
+		p.log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func (p *Apaleo) toHotelRoom(unit Unit, guest *hotel.Guest) hotel.Room {
+	room := hotel.Room{
+		RoomID:          unit.ID,
+		RoomName:        unit.Name,
+		RoomDescription: unit.Description,
+		PropertyID:      unit.Property.ID,
+		RoomCondition:   roomCondition(unit.Status.Condition),
+		RoomBlocked:     unit.Status.Maintenance != nil && unit.Status.Maintenance.Type != "",
+		RoomOccupied:    unit.Status.IsOccupied,
+		Guest:           guest,
+	}
+	if extension, ok := p.configMap.SearchExtensionByRoomID(unit.ID, ""); ok {
+		room.PhoneNumber = extension.RoomExtension
+	}
+	return room
+}
+
+// roomCondition converts Apaleo unit condition to the room condition dialed from the room phone
+func roomCondition(unitCondition string) string {
+	for condition, apaleoCondition := range unitConditions {
+		if apaleoCondition == unitCondition {
+			return condition
+		}
+	}
+	return strings.ToLower(unitCondition)
+}
+
+func toHotelGuest(reservation Reservation) *hotel.Guest {
+	return &hotel.Guest{
+		ReservationID: reservation.ID,
+		FirstName:     reservation.PrimaryGuest.FirstName,
+		LastName:      reservation.PrimaryGuest.LastName,
+		Email:         reservation.PrimaryGuest.Email,
+	}
+}
+
+// propertyIDs returns unique property IDs of the extension map
+func (p *Apaleo) propertyIDs() (propertyIDs []string) {
+	seen := make(map[string]bool)
+	for _, extension := range p.configMap.ExtensionMap {
+		if extension.HospitalityPropertyID == "" || seen[extension.HospitalityPropertyID] {
+			continue
+		}
+		seen[extension.HospitalityPropertyID] = true
+		propertyIDs = append(propertyIDs, extension.HospitalityPropertyID)
+	}
+	return propertyIDs
+}
+
+// getUnits returns units of propertyIDs (all units of the account if propertyIDs is empty) from all pages
+func (p *Apaleo) getUnits(ctx context.Context, propertyIDs []string) (units []Unit, err error) {
+	if len(propertyIDs) == 0 {
+		propertyIDs = []string{""}
+	}
+	for _, propertyID := range propertyIDs {
+		for pageNumber := 1; ; pageNumber++ {
+			query := pageQuery(pageNumber)
+			if propertyID != "" {
+				query.Set("propertyId", propertyID)
+			}
+			respBody := &ResponseGetUnits{}
+			err = p.do(ctx, http.MethodGet, "/inventory/v1/units", query, nil, respBody)
+			if err != nil {
+				return units, err
+			}
+			units = append(units, respBody.Units...)
+			if len(respBody.Units) < apiPageSize {
+				break
+			}
+		}
+	}
+	return units, nil
+}
+
+// getInHouseReservations returns in-house reservations of propertyIDs and unitIDs (no filter if empty).
+// Result is a map unitID -> reservation
+func (p *Apaleo) getInHouseReservations(ctx context.Context, propertyIDs, unitIDs []string) (reservations map[string]Reservation, err error) {
+	reservations = make(map[string]Reservation)
+	for pageNumber := 1; ; pageNumber++ {
+		query := pageQuery(pageNumber)
+		query.Set("status", reservationStatusInHouse)
+		if len(propertyIDs) > 0 {
+			query.Set("propertyIds", strings.Join(propertyIDs, ","))
+		}
+		if len(unitIDs) > 0 {
+			query.Set("unitIds", strings.Join(unitIDs, ","))
+		}
+		respBody := &ResponseGetReservations{}
+		err = p.do(ctx, http.MethodGet, "/booking/v1/reservations", query, nil, respBody)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range respBody.Reservations {
+			if reservation.Unit.ID == "" {
+				continue
+			}
+			//the first reservation wins if a unit has several in-house reservations
+			if _, exists := reservations[reservation.Unit.ID]; !exists {
+				reservations[reservation.Unit.ID] = reservation
+			}
+		}
+		if len(respBody.Reservations) < apiPageSize {
+			return reservations, nil
+		}
+	}
+}
+
+// getActiveMaintenances returns maintenances of the unit that are active now
+func (p *Apaleo) getActiveMaintenances(ctx context.Context, unitID string) (maintenances []Maintenance, err error) {
+	now := time.Now().UTC()
+	query := pageQuery(1)
+	query.Set("unitIds", unitID)
+	query.Set("from", now.Format(time.RFC3339))
+	query.Set("to", now.Add(time.Minute).Format(time.RFC3339))
+	respBody := &ResponseGetMaintenances{}
+	err = p.do(ctx, http.MethodGet, "/operations/v1/maintenances", query, nil, respBody)
+	if err != nil {
+		return maintenances, err
+	}
+	return respBody.Maintenances, nil
+}
+
+func pageQuery(pageNumber int) url.Values {
+	query := url.Values{}
+	query.Set("pageNumber", fmt.Sprintf("%d", pageNumber))
+	query.Set("pageSize", fmt.Sprintf("%d", apiPageSize))
+	return query
+}
+
+// do sends request to Apaleo API path and decodes the reply to response. request and response can be nil.
+// If Apaleo rejects the access token (401), a new token is requested and the request is sent once again
+func (p *Apaleo) do(ctx context.Context, method, path string, query url.Values, request interface{}, response interface{}) error {
+	apiUrl := p.apiURL + path
+	if len(query) > 0 {
+		apiUrl += "?" + query.Encode()
+	}
+
+	var jsonBody []byte
+	if request != nil {
+		var err error
+		jsonBody, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.api.Do(ctx, method+" "+path, func(accessToken string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, apiUrl, bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		req.Header.Add("Accept", "application/json")
+		if request != nil {
+			contentType := "application/json"
+			if method == http.MethodPatch {
+				contentType = "application/json-patch+json"
+			}
+			req.Header.Add("Content-Type", contentType)
+		}
+		return req, nil
+	}, response)
+}
+
+// newAPIClient returns the client of Apaleo API. The access token is requested with the client credentials of tokenConfig
+func (p *Apaleo) newAPIClient() *oauthclient.Client {
+	return &oauthclient.Client{
+		Name:         "apaleo",
+		HTTPClient:   p.httpClient,
+		Store:        p.storeClient,
+		Log:          p.log,
+		RequestToken: p.requestAccessToken,
+		ErrorMessage: errorMessage,
+	}
+}
+
+// requestAccessToken requests a new access token from Apaleo identity with client credentials
+func (p *Apaleo) requestAccessToken(ctx context.Context) (*oauth2.Token, error) {
+	p.log.Debugf("Requesting new access token from %s", p.tokenConfig.TokenURL)
+	if client, ok := p.httpClient.(*http.Client); ok {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	return p.tokenConfig.Token(ctx)
+}
+
+// errorMessage returns the message of ResponseError reply
+func errorMessage(body []byte) string {
+	respError := ResponseError{}
+	if json.Unmarshal(body, &respError) != nil {
+		return ""
+	}
+	return respError.Message
+}
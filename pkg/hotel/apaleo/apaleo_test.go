@@ -0,0 +1,248 @@
+package apaleo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/hoteltest"
+	"github.com/olegromanchuk/hotelito/internal/secretstest"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// newApaleoServer imitates Apaleo identity and API
+func newApaleoServer(t *testing.T, replies map[string]string) *hoteltest.Server {
+	server := hoteltest.NewServer(t, replies)
+	server.TokenPath = "/connect/token"
+	server.AccessToken = "access-token"
+	return server
+}
+
+func newTestApaleo(server *hoteltest.Server, store *secretstest.MockSecretsStore) *Apaleo {
+	client := &Apaleo{
+		httpClient:  server.Client(),
+		storeClient: store,
+		log:         logrus.New(),
+		apiURL:      server.URL,
+		tokenConfig: &clientcredentials.Config{ClientID: "client-id", ClientSecret: "client-secret", TokenURL: server.URL + "/connect/token"},
+		configMap:   &configuration.ConfigMap{ExtensionMap: hoteltest.Extensions("MUC", "MUC-101", "MUC-102")},
+	}
+	client.api = client.newAPIClient()
+	client.api.SetAccessToken("access-token")
+	return client
+}
+
+const (
+	replyUnits        = `{"units":[{"id":"MUC-101","name":"101","property":{"id":"MUC"},"status":{"isOccupied":true,"condition":"CleanToBeInspected"}},{"id":"MUC-102","name":"102","property":{"id":"MUC"},"status":{"isOccupied":false,"condition":"Dirty","maintenance":{"id":"MUC-MNT-1","type":"OutOfOrder"}}}],"count":2}`
+	replyReservations = `{"reservations":[{"id":"ABC-1","status":"InHouse","unit":{"id":"MUC-101"},"primaryGuest":{"firstName":"Jane","lastName":"Smith","email":"jane.smith@example.com"}}],"count":1}`
+)
+
+func TestApaleo_GetRooms(t *testing.T) {
+	server := newApaleoServer(t, map[string]string{
+		"GET /inventory/v1/units":      replyUnits,
+		"GET /booking/v1/reservations": replyReservations,
+	})
+
+	rooms, err := newTestApaleo(server, nil).GetRooms(context.Background())
+	require.NoError(t, err)
+	guest := hoteltest.Guest("ABC-1")
+	assert.Equal(t, []hotel.Room{
+		{
+			RoomID:        "MUC-101",
+			RoomName:      "101",
+			PropertyID:    "MUC",
+			PhoneNumber:   "1001",
+			RoomCondition: "clean_to_be_inspected",
+			RoomOccupied:  true,
+			Guest:         &guest,
+		},
+		{
+			RoomID:        "MUC-102",
+			RoomName:      "102",
+			PropertyID:    "MUC",
+			PhoneNumber:   "1002",
+			RoomCondition: "dirty",
+			RoomBlocked:   true,
+		},
+	}, rooms)
+	require.Len(t, server.Requests, 2)
+	assert.Equal(t, "MUC", server.Requests[0].Query["propertyId"])
+	assert.Equal(t, "InHouse", server.Requests[1].Query["status"])
+	assert.Equal(t, "MUC", server.Requests[1].Query["propertyIds"])
+}
+
+func TestApaleo_GetRoom(t *testing.T) {
+	server := newApaleoServer(t, map[string]string{
+		"GET /inventory/v1/units/MUC-102": `{"id":"MUC-102","name":"102","property":{"id":"MUC"},"status":{"isOccupied":false,"condition":"Clean"}}`,
+	})
+
+	room, err := newTestApaleo(server, nil).GetRoom(context.Background(), "1002", "")
+	require.NoError(t, err)
+	assert.Equal(t, hotel.Room{RoomID: "MUC-102", RoomName: "102", PropertyID: "MUC", PhoneNumber: "1002", RoomCondition: "clean"}, room)
+}
+
+func TestApaleo_GetRoomGuest(t *testing.T) {
+	server := newApaleoServer(t, map[string]string{
+		"GET /booking/v1/reservations": replyReservations,
+	})
+
+	hoteltest.CheckGetRoomGuest(t, newTestApaleo(server, nil), "ABC-1")
+	assert.Equal(t, "MUC-101", server.Requests[0].Query["unitIds"])
+}
+
+func TestApaleo_UpdateRoom(t *testing.T) {
+	server := newApaleoServer(t, map[string]string{
+		"PATCH /inventory/v1/units/MUC-101": ``,
+	})
+	server.ReplyStatus["PATCH /inventory/v1/units/MUC-101"] = http.StatusNoContent
+
+	request := hoteltest.CheckUpdateRoom(t, newTestApaleo(server, nil), server, "clean_to_be_inspected", "inspected")
+	assert.Equal(t, "application/json-patch+json", request.Header.Get("Content-Type"))
+	assert.JSONEq(t, `[{"op":"replace","path":"/condition","value":"CleanToBeInspected"}]`, request.Body)
+}
+
+func TestApaleo_UpdateRoomBlock(t *testing.T) {
+	server := newApaleoServer(t, map[string]string{
+		"POST /operations/v1/maintenances":             `{"id":"MUC-MNT-1"}`,
+		"GET /operations/v1/maintenances":              `{"maintenances":[{"id":"MUC-MNT-1","type":"OutOfOrder","unit":{"id":"MUC-101"}},{"id":"MUC-MNT-2","type":"OutOfService","unit":{"id":"MUC-101"}}],"count":2}`,
+		"DELETE /operations/v1/maintenances/MUC-MNT-1": ``,
+	})
+	server.ReplyStatus["POST /operations/v1/maintenances"] = http.StatusCreated
+	server.ReplyStatus["DELETE /operations/v1/maintenances/MUC-MNT-1"] = http.StatusNoContent
+
+	hoteltest.CheckUpdateRoomBlock(t, newTestApaleo(server, nil),
+		"Finish UpdateRoomBlock successfully blocked room 1001. Maintenance: MUC-MNT-1",
+		"Finish UpdateRoomBlock successfully unblocked room 1001. Removed maintenances: 1")
+	require.Len(t, server.Requests, 3)
+	var maintenance map[string]string
+	require.NoError(t, json.Unmarshal([]byte(server.Requests[0].Body), &maintenance))
+	assert.Equal(t, "MUC-101", maintenance["unitId"])
+	assert.Equal(t, "OutOfOrder", maintenance["type"])
+	assert.Equal(t, "AC repair (Engineer)", maintenance["description"])
+	assert.Equal(t, "MUC-101", server.Requests[1].Query["unitIds"])
+	assert.Equal(t, "/operations/v1/maintenances/MUC-MNT-1", server.Requests[2].Path)
+}
+
+func TestApaleo_AccessToken(t *testing.T) {
+	t.Run("token is requested and saved if store is empty", func(t *testing.T) {
+		server := newApaleoServer(t, map[string]string{
+			"GET /inventory/v1/units/MUC-101": `{"id":"MUC-101","name":"101","status":{"condition":"Clean"}}`,
+		})
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveProviderToken", "apaleo").Return(secrets.Token{}, nil)
+		store.On("StoreProviderToken", "apaleo", mock.MatchedBy(func(token secrets.Token) bool {
+			return token.AccessToken == "access-token" && token.TokenType == "Bearer" && !token.Expiry.IsZero()
+		})).Return(nil)
+		client := newTestApaleo(server, store)
+		client.api.SetAccessToken("")
+
+		_, err := client.GetRoom(context.Background(), "1001", "")
+		require.NoError(t, err)
+		require.Len(t, server.TokenRequests, 1)
+		assert.Equal(t, "client_credentials", server.TokenRequests[0].Form["grant_type"])
+		store.AssertExpectations(t)
+	})
+
+	t.Run("token from store is used", func(t *testing.T) {
+		server := newApaleoServer(t, map[string]string{
+			"GET /inventory/v1/units/MUC-101": `{"id":"MUC-101","name":"101","status":{"condition":"Clean"}}`,
+		})
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveProviderToken", "apaleo").Return(secrets.Token{AccessToken: "access-token"}, nil)
+		client := newTestApaleo(server, store)
+		client.api.SetAccessToken("")
+
+		_, err := client.GetRoom(context.Background(), "1001", "")
+		require.NoError(t, err)
+		assert.Empty(t, server.TokenRequests)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("rejected token is requested again", func(t *testing.T) {
+		server := newApaleoServer(t, map[string]string{
+			"GET /inventory/v1/units/MUC-101": `{"id":"MUC-101","name":"101","status":{"condition":"Clean"}}`,
+		})
+		store := new(secretstest.MockSecretsStore)
+		store.On("StoreProviderToken", "apaleo", mock.Anything).Return(nil)
+		client := newTestApaleo(server, store)
+		client.api.SetAccessToken("expired-token")
+
+		_, err := client.GetRoom(context.Background(), "1001", "")
+		require.NoError(t, err)
+		assert.Len(t, server.TokenRequests, 1)
+		accessToken, err := client.api.AccessToken(context.Background(), false)
+		require.NoError(t, err)
+		assert.Equal(t, "access-token", accessToken)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("token is rejected twice", func(t *testing.T) {
+		server := newApaleoServer(t, map[string]string{})
+		server.AccessToken = "other-token"
+		store := new(secretstest.MockSecretsStore)
+		store.On("StoreProviderToken", "apaleo", mock.Anything).Return(nil)
+
+		_, err := newTestApaleo(server, store).GetRoom(context.Background(), "1001", "")
+		assert.EqualError(t, err, "apaleo GET /inventory/v1/units/MUC-101 failed with status 401: ")
+		var detailedError *hotel.DetailedError
+		assert.True(t, errors.As(err, &detailedError))
+		assert.ErrorIs(t, err, hotel.ErrAuthorization)
+		assert.Len(t, server.TokenRequests, 1)
+	})
+}
+
+func TestApaleo_NotSupported(t *testing.T) {
+	hoteltest.CheckNotSupported(t, &Apaleo{log: logrus.New()}, "do not disturb is not supported by apaleo", "charges are not supported by apaleo")
+}
+
+func TestNew(t *testing.T) {
+	log := logrus.New()
+	configMap := &configuration.ConfigMap{}
+
+	t.Run("credentials from store", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", "APALEO_CLIENT_ID").Return("client-id", nil)
+		store.On("RetrieveVar", "APALEO_CLIENT_SECRET").Return("client-secret", nil)
+		store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		client, err := New(log, store, configMap)
+		require.NoError(t, err)
+		assert.Equal(t, "client-id", client.tokenConfig.ClientID)
+		assert.Equal(t, "client-secret", client.tokenConfig.ClientSecret)
+		assert.Equal(t, defaultTokenURL, client.tokenConfig.TokenURL)
+		assert.Equal(t, defaultApiURL, client.apiURL)
+	})
+
+	t.Run("credentials from environment", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", mock.Anything).Return("", errors.New("not found"))
+		os.Setenv("APALEO_CLIENT_ID", "env-client-id")
+		os.Setenv("APALEO_CLIENT_SECRET", "env-client-secret")
+		os.Setenv("APALEO_API_URL", "https://api.apaleo-test.com/")
+		defer os.Unsetenv("APALEO_CLIENT_ID")
+		defer os.Unsetenv("APALEO_CLIENT_SECRET")
+		defer os.Unsetenv("APALEO_API_URL")
+
+		client, err := New(log, store, configMap)
+		require.NoError(t, err)
+		assert.Equal(t, "env-client-id", client.tokenConfig.ClientID)
+		assert.Equal(t, "https://api.apaleo-test.com", client.apiURL)
+	})
+
+	t.Run("credentials are missing", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		_, err := New(log, store, configMap)
+		assert.EqualError(t, err, "not all required env variables are set. Missed one of: APALEO_CLIENT_ID, APALEO_CLIENT_SECRET")
+	})
+}
@@ -0,0 +1,58 @@
+package cloudbeds
+
+import (
+	"errors"
+	"net/http"
+)
+
+const (
+	// authModeAPIKey in CLOUDBEDS_AUTH_MODE makes the client use the property API key (CLOUDBEDS_API_KEY) instead of OAuth2.
+	// Any other value, including empty, means OAuth2
+	authModeAPIKey = "api_key"
+	// apiKeyHeader is the header Cloudbeds expects the property API key in
+	apiKeyHeader = "x-api-key"
+)
+
+// errAPIKeyRejected is returned instead of the token refresh in API-key mode: there is nothing to refresh
+var errAPIKeyRejected = errors.New("cloudbeds API key is rejected. Check CLOUDBEDS_API_KEY")
+
+// apiKeyTransport adds the property API key to every request
+type apiKeyTransport struct {
+	base   http.RoundTripper
+	apiKey string
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	//RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(apiKeyHeader, t.apiKey)
+	return t.base.RoundTrip(req)
+}
+
+// setAPIKeyConfig switches the client to API-key mode if CLOUDBEDS_AUTH_MODE is api_key. Variables are taken from the secret
+// store or environment, like setOauth2Config does. Returns false if OAuth2 is used
+func (p *Cloudbeds) setAPIKeyConfig() (enabled bool, err error) {
+	if p.getVarFromStoreOrEnvironment("CLOUDBEDS_AUTH_MODE") != authModeAPIKey {
+		return false, nil
+	}
+	apiKey := p.getVarFromStoreOrEnvironment("CLOUDBEDS_API_KEY")
+	if apiKey == "" {
+		err = errors.New("CLOUDBEDS_AUTH_MODE is api_key, but CLOUDBEDS_API_KEY is not set")
+		p.log.Error(err)
+		return false, err
+	}
+
+	p.log.Infof("Using Cloudbeds API key. OAuth2 login is not needed")
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+	p.apiKey = apiKey
+	p.httpClient = &http.Client{Transport: &apiKeyTransport{base: newRetryTransport(p.log, http.DefaultTransport), apiKey: apiKey}}
+	return true, nil
+}
+
+// usesAPIKey reports whether the client authorizes with the property API key instead of OAuth2
+func (p *Cloudbeds) usesAPIKey() bool {
+	p.tokenMu.RLock()
+	defer p.tokenMu.RUnlock()
+	return p.apiKey != ""
+}
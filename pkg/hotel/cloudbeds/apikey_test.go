@@ -0,0 +1,136 @@
+package cloudbeds
+
+import (
+	"context"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestNew_APIKey(t *testing.T) {
+	apiConfFileName := "test_cloudbeds_api_params_apikey.json"
+	createCloudbedsApiConfigFile(apiConfFileName)
+	defer cleanupTestFiles(apiConfFileName)
+	defer cleanUpEnvVars()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	configMap := &configuration.ConfigMap{ApiCfgFileName: apiConfFileName}
+
+	t.Run("api key is sent instead of oauth2 token", func(t *testing.T) {
+		cleanUpEnvVars()
+		os.Setenv("CLOUDBEDS_AUTH_MODE", "api_key")
+		os.Setenv("CLOUDBEDS_API_KEY", "cbat_test_key")
+
+		var receivedKey, receivedAuthorization string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			receivedKey = r.Header.Get(apiKeyHeader)
+			receivedAuthorization = r.Header.Get("Authorization")
+		}))
+		defer server.Close()
+
+		//neither oauth2 variables nor the token are needed
+		mockStoreClient := new(MockSecretsStore)
+		mockStoreClient.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		client, err := New(logger, mockStoreClient, configMap)
+		require.NoError(t, err)
+		mockStoreClient.AssertNotCalled(t, "RetrieveToken")
+
+		resp, err := client.send(context.Background(), "get rooms", func() (*http.Response, error) {
+			return client.get(context.Background(), server.URL)
+		})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "cbat_test_key", receivedKey)
+		assert.Empty(t, receivedAuthorization)
+
+		//login is not required and there is nothing to refresh
+		url, err := client.HandleInitialLogin(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, url)
+		assert.Error(t, client.HandleOAuthCallback(context.Background(), "state", "code"))
+		assert.NoError(t, client.KeepTokenAlive(context.Background()))
+		_, required := client.LoginRequired()
+		assert.False(t, required)
+	})
+
+	t.Run("rejected api key", func(t *testing.T) {
+		cleanUpEnvVars()
+		os.Setenv("CLOUDBEDS_AUTH_MODE", "api_key")
+		os.Setenv("CLOUDBEDS_API_KEY", "cbat_revoked_key")
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		mockStoreClient := new(MockSecretsStore)
+		mockStoreClient.On("RetrieveVar", mock.Anything).Return("", nil)
+		client, err := New(logger, mockStoreClient, configMap)
+		require.NoError(t, err)
+
+		_, err = client.send(context.Background(), "get rooms", func() (*http.Response, error) {
+			return client.get(context.Background(), server.URL)
+		})
+		assert.ErrorIs(t, err, hotel.ErrAuthorization)
+		assert.ErrorContains(t, err, "cloudbeds API key is rejected")
+	})
+
+	t.Run("api key mode without key", func(t *testing.T) {
+		cleanUpEnvVars()
+		mockStoreClient := new(MockSecretsStore)
+		mockStoreClient.On("RetrieveVar", "CLOUDBEDS_AUTH_MODE").Return("api_key", nil)
+		mockStoreClient.On("RetrieveVar", "CLOUDBEDS_API_KEY").Return("", nil)
+
+		client, err := New(logger, mockStoreClient, configMap)
+		assert.Nil(t, client)
+		assert.EqualError(t, err, "CLOUDBEDS_AUTH_MODE is api_key, but CLOUDBEDS_API_KEY is not set")
+	})
+
+	t.Run("api key from secret store in callback client", func(t *testing.T) {
+		cleanUpEnvVars()
+		mockStoreClient := new(MockSecretsStore)
+		mockStoreClient.On("RetrieveVar", "CLOUDBEDS_AUTH_MODE").Return("api_key", nil)
+		mockStoreClient.On("RetrieveVar", "CLOUDBEDS_API_KEY").Return("cbat_test_key", nil)
+
+		client, err := NewClient4CallbackAndInit(logger, mockStoreClient)
+		require.NoError(t, err)
+		url, err := client.HandleInitialLogin(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, url)
+	})
+}
+
+func TestApiKeyTransport(t *testing.T) {
+	var receivedKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedKey = r.Header.Get(apiKeyHeader)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &apiKeyTransport{base: http.DefaultTransport, apiKey: "cbat_test_key"}}
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "cbat_test_key", receivedKey)
+	assert.Empty(t, req.Header.Get(apiKeyHeader), "the request of the caller is not modified")
+}
+
+func TestLoggedValue(t *testing.T) {
+	assert.Equal(t, "************_key", loggedValue("CLOUDBEDS_API_KEY", "cbat_secret_key"))
+	assert.Equal(t, "****", loggedValue("CLOUDBEDS_API_KEY", "key"))
+	assert.Equal(t, "************cret", loggedValue("CLOUDBEDS_CLIENT_SECRET", "client_secret"))
+	assert.Equal(t, "https://hotels.cloudbeds.com/api/v1.1/oauth", loggedValue("CLOUDBEDS_AUTH_URL", "https://hotels.cloudbeds.com/api/v1.1/oauth"))
+}
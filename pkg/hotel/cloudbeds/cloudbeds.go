@@ -22,22 +22,29 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Room struct {
-	RoomID            string `json:"roomID"`
-	RoomName          string `json:"roomName"`
-	RoomDescription   string `json:"roomDescription"`
-	MaxGuests         int32  `json:"maxGuests"`
-	IsPrivate         bool   `json:"isPrivate"`
-	RoomBlocked       bool   `json:"roomBlocked"`
-	RoomTypeID        int32  `json:"roomTypeID"`
-	RoomTypeName      string `json:"roomTypeName"`
-	RoomTypeNameShort string `json:"roomTypeNameShort"`
-	PhoneNumber       string `json:"phoneNumber,omitempty"`
-	RoomCondition     string `json:"RoomCondition,omitempty"`
-	RoomOccupied      bool   `json:"RoomOccupied,omitempty"`
+	RoomID            string       `json:"roomID"`
+	RoomName          string       `json:"roomName"`
+	RoomDescription   string       `json:"roomDescription"`
+	MaxGuests         int32        `json:"maxGuests"`
+	IsPrivate         bool         `json:"isPrivate"`
+	RoomBlocked       bool         `json:"roomBlocked"`
+	RoomTypeID        int32        `json:"roomTypeID"`
+	RoomTypeName      string       `json:"roomTypeName"`
+	RoomTypeNameShort string       `json:"roomTypeNameShort"`
+	PhoneNumber       string       `json:"phoneNumber,omitempty"`
+	RoomCondition     string       `json:"RoomCondition,omitempty"`
+	RoomOccupied      bool         `json:"RoomOccupied,omitempty"`
+	Guest             *hotel.Guest `json:"Guest,omitempty"`
+	PropertyID        string       `json:"propertyID,omitempty"`
+	DoNotDisturb      bool         `json:"DoNotDisturb,omitempty"`
 }
 
 type RoomFromConfig struct {
@@ -46,13 +53,20 @@ type RoomFromConfig struct {
 	HospitalityRoomName string `json:"hospitality_room_name"`
 }
 
-var (
-	loginLoopPreventionCounter = 1
+// apiPageSize is the amount of records requested per page from paginated Cloudbeds endpoints
+const apiPageSize = 100
+
+const (
+	roomBlockType          = "out_of_service"
+	roomBlockDateFormat    = "2006-01-02"
+	defaultRoomBlockReason = "Out of order"
+	// defaultRoomBlockDays is the length of a room block created from the phone if roomBlockDays is not set in the api configuration.
+	// Cloudbeds ends the block on its end date: the room returns to inventory even if the unblock code was not dialed
+	defaultRoomBlockDays = 365
 )
 
 // HTTPClient is needed for mocking http requests in tests. This is the only reason to create this interface. Original http.Client implements this interface
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
 	Do(req *http.Request) (*http.Response, error)
 }
 
@@ -67,6 +81,12 @@ type OauthConfInterface interface {
 // Cloudbeds is used to make requests to Cloudbeds API. httpClient contains pre-authorized http.Client that is set by the package oauth2 during authorization process. In tests we just mock this client to imitate cloudbeds API responses.
 type Cloudbeds struct {
 	httpClient                   HTTPClient
+	token                        *oauth2.Token // token httpClient is authorized with. nil if httpClient is set in tests
+	tokenMu                      sync.RWMutex  // guards httpClient and token replaced by the refresh, and loginRequired
+	refreshMu                    sync.Mutex    // only one refresh runs at a time
+	loginAttempts                int           // prevents login loop if refresh token is stale
+	loginRequired                string        // instructions for the admin if nobody is logged in. Cleared by the oauth callback
+	apiKey                       string        // property API key (CLOUDBEDS_AUTH_MODE=api_key). OAuth2 is not used if set
 	storeClient                  secrets.SecretsStore
 	log                          *logrus.Logger
 	refresher                    TokenRefresher
@@ -74,19 +94,32 @@ type Cloudbeds struct {
 	configMap                    *configuration.ConfigMap
 	apiUrlPostHousekeepingStatus string
 	apiUrlGetRooms               string
-	roomStatuses                 []string
+	apiUrlGetReservations        string
+	apiUrlGetHousekeepingStatus  string
+	apiUrlGetHousekeepers        string
+	apiUrlPostHousekeepingAssign string
+	apiUrlPostRoomBlock          string
+	apiUrlGetRoomBlocks          string
+	apiUrlDeleteRoomBlock        string
+	apiUrlPutRoomBlock           string
+	apiUrlPostCustomItem         string
+	apiUrlGetReservation         string
+	apiUrlGetWebhooks            string
+	apiUrlPostWebhook            string
+	roomStatuses                 hotel.RoomConditionSet
+	roomBlockDays                int
 }
 
 // TokenRefresher is needed for mocking http requests in tests. This is the only reason to create this interface. Cloudbeds implements this interface
 type TokenRefresher interface {
-	refreshToken() error
+	refreshToken(ctx context.Context) error
 }
 
 type UpdateRoomConditionRequest struct {
 	RoomID        string `json:"roomID"`
 	RoomCondition string `json:"roomCondition"`
-	PropertyID    int    `json:"propertyID,omitempty"`
-	DoNotDisturb  bool   `json:"doNotDisturb,omitempty"`
+	PropertyID    string `json:"propertyID,omitempty"`
+	DoNotDisturb  *bool  `json:"doNotDisturb,omitempty"`
 }
 
 /*
@@ -106,6 +139,205 @@ type ResponseGetRooms struct {
 	Message string `json:"message,omitempty"`
 }
 
+/*
+	Response: {
+	    "success": true,
+	    "data": [
+	        {
+	            "propertyID": "297652",
+	            "reservationID": "8817735791869",
+	            "guestID": "51738262",
+	            "guestName": "Jane Smith",
+	            "status": "checked_in",
+	            "startDate": "2023-08-01",
+	            "endDate": "2023-08-04",
+	            "guestList": {
+	                "51738262": {
+	                    "guestID": "51738262",
+	                    "guestFirstName": "Jane",
+	                    "guestLastName": "Smith",
+	                    "guestEmail": "jane.smith@example.com",
+	                    "isMainGuest": true,
+	                    "roomID": "544559-1"
+	                }
+	            }
+	        }
+	    ],
+	    "count": 1,
+	    "total": 1
+	}
+*/
+type ResponseGetReservations struct {
+	Success bool          `json:"success"`
+	Data    []Reservation `json:"data"`
+	Count   int           `json:"count"`
+	Total   int           `json:"total"`
+	Message string        `json:"message,omitempty"`
+}
+
+type Reservation struct {
+	PropertyID    string                      `json:"propertyID"`
+	ReservationID string                      `json:"reservationID"`
+	GuestID       string                      `json:"guestID"`
+	GuestName     string                      `json:"guestName"`
+	Status        string                      `json:"status"`
+	StartDate     string                      `json:"startDate"`
+	EndDate       string                      `json:"endDate"`
+	GuestList     map[string]ReservationGuest `json:"guestList,omitempty"`
+}
+
+type ReservationGuest struct {
+	GuestID        string `json:"guestID"`
+	GuestFirstName string `json:"guestFirstName"`
+	GuestLastName  string `json:"guestLastName"`
+	GuestEmail     string `json:"guestEmail"`
+	IsMainGuest    bool   `json:"isMainGuest"`
+	RoomID         string `json:"roomID"`
+}
+
+/*
+	Response: {
+	    "success": true,
+	    "data": [
+	        {
+	            "date": "2023-08-02",
+	            "roomTypeID": "544559",
+	            "roomTypeName": "Deluxe Queen",
+	            "roomID": "544559-0",
+	            "roomName": "DQ(1)",
+	            "roomCondition": "dirty",
+	            "roomOccupied": true,
+	            "roomBlocked": false,
+	            "housekeeperID": "",
+	            "housekeeper": "",
+	            "doNotDisturb": false,
+	            "frontdeskStatus": "stayover"
+	        }
+	    ],
+	    "count": 1,
+	    "total": 1
+	}
+*/
+type ResponseGetHousekeepingStatus struct {
+	Success bool                 `json:"success"`
+	Data    []HousekeepingStatus `json:"data"`
+	Count   int                  `json:"count"`
+	Total   int                  `json:"total"`
+	Message string               `json:"message,omitempty"`
+}
+
+type HousekeepingStatus struct {
+	Date            string `json:"date"`
+	RoomID          string `json:"roomID"`
+	RoomName        string `json:"roomName"`
+	RoomCondition   string `json:"roomCondition"`
+	RoomOccupied    bool   `json:"roomOccupied"`
+	RoomBlocked     bool   `json:"roomBlocked"`
+	Housekeeper     string `json:"housekeeper"`
+	DoNotDisturb    bool   `json:"doNotDisturb"`
+	FrontdeskStatus string `json:"frontdeskStatus"`
+}
+
+/*
+	Response: {
+	    "success": true,
+	    "data": [
+	        {
+	            "housekeeperID": "1234",
+	            "name": "Michael Jackson"
+	        }
+	    ],
+	    "count": 1,
+	    "total": 1
+	}
+*/
+type ResponseGetHousekeepers struct {
+	Success bool          `json:"success"`
+	Data    []Housekeeper `json:"data"`
+	Count   int           `json:"count"`
+	Total   int           `json:"total"`
+	Message string        `json:"message,omitempty"`
+}
+
+type Housekeeper struct {
+	HousekeeperID string `json:"housekeeperID"`
+	Name          string `json:"name"`
+}
+
+/*
+	Response: {
+	    "success": false,
+	    "message": "Invalid housekeeperID"
+	}
+*/
+type ResponsePostHousekeepingAssignment struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+/*
+	Response: {
+	    "success": true,
+	    "propertyID": "297652",
+	    "roomBlockID": "202308021234",
+	    "roomBlockType": "out_of_service",
+	    "roomBlockReason": "AC repair",
+	    "startDate": "2023-08-02",
+	    "endDate": "2024-08-01",
+	    "rooms": [{"roomID": "544559-0", "roomTypeID": "544559"}]
+	}
+*/
+type ResponsePostRoomBlock struct {
+	Success     bool   `json:"success"`
+	RoomBlockID string `json:"roomBlockID"`
+	Message     string `json:"message,omitempty"`
+}
+
+type ResponseGetRoomBlocks struct {
+	Success bool        `json:"success"`
+	Data    []RoomBlock `json:"data"`
+	Count   int         `json:"count"`
+	Total   int         `json:"total"`
+	Message string      `json:"message,omitempty"`
+}
+
+type RoomBlock struct {
+	RoomBlockID     string `json:"roomBlockID"`
+	RoomBlockType   string `json:"roomBlockType"`
+	RoomBlockReason string `json:"roomBlockReason"`
+	StartDate       string `json:"startDate"`
+	EndDate         string `json:"endDate"`
+	Rooms           []struct {
+		RoomID string `json:"roomID"`
+	} `json:"rooms"`
+}
+
+type ResponseDeleteRoomBlock struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+type ResponsePutRoomBlock struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+/*
+	Response: {
+	    "success": true,
+	    "data": {
+	        "soldProductID": "12345678"
+	    }
+	}
+*/
+type ResponsePostCustomItem struct {
+	Success bool `json:"success"`
+	Data    struct {
+		SoldProductID string `json:"soldProductID"`
+	} `json:"data,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
 type UpdateRoomConditionResponse struct {
 	Success bool `json:"success"`
 	Data    struct {
@@ -131,128 +363,979 @@ func (r Room) ToHotelRoom() hotel.Room {
 		PhoneNumber:       r.PhoneNumber,
 		RoomCondition:     r.RoomCondition,
 		RoomOccupied:      r.RoomOccupied,
+		Guest:             r.Guest,
+		PropertyID:        r.PropertyID,
+		DoNotDisturb:      r.DoNotDisturb,
+	}
+}
+
+// applyState sets the live housekeeping state and the in-house guest (nil if the room is vacant) to the room
+func (r *Room) applyState(status HousekeepingStatus, guest *hotel.Guest) {
+	r.RoomCondition = status.RoomCondition
+	r.RoomOccupied = status.RoomOccupied
+	r.DoNotDisturb = status.DoNotDisturb
+	r.RoomBlocked = r.RoomBlocked || status.RoomBlocked
+	if r.RoomName == "" {
+		r.RoomName = status.RoomName
+	}
+	r.Guest = guest
+}
+
+// GuestInRoom returns the guest of the reservation who stays in the room roomID. The main guest is preferred.
+// If the reservation has no guest details, the guest is built from the reservation guestName.
+func (r Reservation) GuestInRoom(roomID string) hotel.Guest {
+	var found *ReservationGuest
+	for _, guest := range r.GuestList {
+		guest := guest
+		if guest.RoomID != "" && guest.RoomID != roomID {
+			continue
+		}
+		if found == nil || (guest.IsMainGuest && !found.IsMainGuest) || (guest.IsMainGuest == found.IsMainGuest && guest.GuestID < found.GuestID) {
+			found = &guest
+		}
+	}
+
+	if found == nil {
+		firstName, lastName, _ := strings.Cut(strings.TrimSpace(r.GuestName), " ")
+		return hotel.Guest{
+			ReservationID: r.ReservationID,
+			FirstName:     firstName,
+			LastName:      strings.TrimSpace(lastName),
+		}
+	}
+
+	return hotel.Guest{
+		ReservationID: r.ReservationID,
+		FirstName:     found.GuestFirstName,
+		LastName:      found.GuestLastName,
+		Email:         found.GuestEmail,
+	}
+}
+
+// RoomIDs returns IDs of all rooms assigned to guests of the reservation
+func (r Reservation) RoomIDs() (roomIDs []string) {
+	seen := make(map[string]bool)
+	for _, guest := range r.GuestList {
+		if guest.RoomID == "" || seen[guest.RoomID] {
+			continue
+		}
+		seen[guest.RoomID] = true
+		roomIDs = append(roomIDs, guest.RoomID)
+	}
+	sort.Strings(roomIDs)
+	return roomIDs
+}
+
+type ApiConfiguration3CX struct {
+	APIURLs struct {
+		GetRooms               string `json:"getRooms"`
+		PostHousekeepingStatus string `json:"postHousekeepingStatus"`
+		GetReservations        string `json:"getReservations"`
+		GetHousekeepingStatus  string `json:"getHousekeepingStatus"`
+		GetHousekeepers        string `json:"getHousekeepers"`
+		PostHousekeepingAssign string `json:"postHousekeepingAssignment"`
+		PostRoomBlock          string `json:"postRoomBlock"`
+		GetRoomBlocks          string `json:"getRoomBlocks"`
+		DeleteRoomBlock        string `json:"deleteRoomBlock"`
+		PutRoomBlock           string `json:"putRoomBlock"`
+		PostCustomItem         string `json:"postCustomItem"`
+		GetReservation         string `json:"getReservation"`
+		GetWebhooks            string `json:"getWebhooks"`
+		PostWebhook            string `json:"postWebhook"`
+	} `json:"apiURLs"`
+	RoomStatuses []string `json:"roomStatuses"`
+	// RoomBlockDays is the length of a room block created from the phone. Optional, defaultRoomBlockDays if not set
+	RoomBlockDays int `json:"roomBlockDays"`
+}
+
+// GetRooms returns rooms of all properties available to the account. All pages of Cloudbeds getRooms are requested
+func (p *Cloudbeds) GetRooms(ctx context.Context) (rooms []hotel.Room, err error) {
+	p.log.Debugf("getting rooms")
+
+	roomsData, err := fetchAllPages(ctx, url.Values{}, p.getRoomsPage)
+	if err != nil {
+		return rooms, err
+	}
+
+	// check if any room is found
+	if len(roomsData) == 0 {
+		detailedError := &hotel.DetailedError{
+			Msg:     hotel.NewError(hotel.ErrValidation, errors.New("success, but no rooms found")),
+			Details: "success, but no rooms found",
+		}
+		p.log.Debugf("success, but no rooms found")
+		return rooms, detailedError
+	}
+	p.log.Debugf("Amount of rooms: %d", len(roomsData))
+
+	err = p.fillRoomsState(ctx, roomsData)
+	if err != nil {
+		p.log.Error(err)
+		return rooms, err
+	}
+
+	for _, room := range roomsData {
+		rooms = append(rooms, room.ToHotelRoom())
+	}
+
+	return rooms, nil
+}
+
+// getRoomsPage returns rooms of all properties from the page requested by params and the total amount of rooms. Every room is tagged with its property ID
+func (p *Cloudbeds) getRoomsPage(ctx context.Context, params url.Values) (rooms []Room, total int, err error) {
+	apiUrl := p.apiUrlGetRooms
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getRooms" // default value
+	}
+
+	respBody := &ResponseGetRooms{}
+	resp, err := p.send(ctx, "get rooms", func() (*http.Response, error) {
+		return p.get(ctx, apiUrl+"?"+params.Encode())
+	})
+	if err != nil {
+		return rooms, 0, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+		p.log.Debugf("success, but parse return body: %s", err)
+		return rooms, 0, detailedError
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to get rooms: %s", respBody.Message)
+		return rooms, 0, hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to get rooms: %s", respBody.Message))
+	}
+	p.log.Debugf("Response data: %v", respBody.Data)
+	p.log.Debugf("HttpCode: %s", resp.Status)
+
+	for _, property := range respBody.Data {
+		for _, room := range property.Rooms {
+			room.PropertyID = property.PropertyID
+			rooms = append(rooms, room)
+		}
+	}
+
+	return rooms, respBody.Total, nil
+}
+
+// fillRoomsState sets the current condition, occupancy and in-house guest to every room in rooms. State is requested per property
+func (p *Cloudbeds) fillRoomsState(ctx context.Context, rooms []Room) error {
+	var propertyIDs []string
+	roomsByProperty := make(map[string][]int)
+	for i := range rooms {
+		if _, ok := roomsByProperty[rooms[i].PropertyID]; !ok {
+			propertyIDs = append(propertyIDs, rooms[i].PropertyID)
+		}
+		roomsByProperty[rooms[i].PropertyID] = append(roomsByProperty[rooms[i].PropertyID], i)
+	}
+
+	for _, propertyID := range propertyIDs {
+		statuses, err := p.getHousekeepingStatus(ctx, propertyParams(propertyID))
+		if err != nil {
+			return err
+		}
+		statusByRoomID := make(map[string]HousekeepingStatus, len(statuses))
+		for _, status := range statuses {
+			statusByRoomID[status.RoomID] = status
+		}
+
+		guests, err := p.getInHouseGuests(ctx, propertyParams(propertyID))
+		if err != nil {
+			return err
+		}
+
+		for _, i := range roomsByProperty[propertyID] {
+			status, ok := statusByRoomID[rooms[i].RoomID]
+			if !ok {
+				p.log.Debugf("no housekeeping status found for room %s", rooms[i].RoomID)
+				continue
+			}
+			var guest *hotel.Guest
+			if g, ok := guests[rooms[i].RoomID]; ok {
+				guest = &g
+			}
+			rooms[i].applyState(status, guest)
+		}
+	}
+	return nil
+}
+
+//func (p *Cloudbeds) BookRoom(roomID string, date time.Time) (reservations Reservation, err error) {
+//	// Provider1's implementation of BookRoom
+//	return nil
+//}
+
+func (p *Cloudbeds) UpdateRoom(ctx context.Context, roomExtensionNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoom %s to %s for %s", roomExtensionNumber, housekeepingStatus, housekeeperName)
+
+	//get room id
+	room := &Room{}
+	room.PhoneNumber = roomExtensionNumber
+	extension, err := room.SearchExtensionByPhoneNumber(p.log, roomExtensionNumber, p.configMap.ExtensionMap)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+	room.RoomID = extension.HospitalityRoomID
+	room.PropertyID = extension.HospitalityPropertyID
+
+	if !p.checkIfRoomConditionValid(housekeepingStatus) {
+		errMsg := fmt.Sprintf("room condition %s is not valid", housekeepingStatus)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	// Update the room condition
+	err = p.postHousekeepingStatus(ctx, UpdateRoomConditionRequest{
+		RoomID:        room.RoomID,
+		RoomCondition: housekeepingStatus,
+		PropertyID:    room.PropertyID,
+	})
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	// Record who has changed the room. The room status is already updated, so failures here are only logged
+	err = p.assignHousekeeper(ctx, room.RoomID, room.PropertyID, housekeeperName)
+	if err != nil {
+		p.log.Errorf("room %s is updated, but housekeeper %s is not assigned: %s", roomExtensionNumber, housekeeperName, err)
+	}
+	msg = fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomExtensionNumber, housekeepingStatus)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// assignHousekeeper assigns the Cloudbeds housekeeper matching housekeeperName to the room.
+// Updates made by rules (hotel.SystemHousekeeperName) are not assigned
+func (p *Cloudbeds) assignHousekeeper(ctx context.Context, roomID, propertyID, housekeeperName string) error {
+	if housekeeperName == "" {
+		p.log.Debugf("no housekeeper provided for room %s. Skipping assignment", roomID)
+		return nil
+	}
+	if housekeeperName == hotel.SystemHousekeeperName {
+		p.log.Debugf("room %s is updated by hotelito. Skipping housekeeper assignment", roomID)
+		return nil
+	}
+
+	housekeeperID, err := p.searchHousekeeperID(ctx, housekeeperName, propertyID)
+	if err != nil {
+		return err
+	}
+
+	return p.postHousekeepingAssignment(ctx, roomID, propertyID, housekeeperID)
+}
+
+// searchHousekeeperID returns the Cloudbeds housekeeper ID for housekeeperName.
+// The ID set in housekeeper_map has priority. Otherwise, the housekeeper is searched in Cloudbeds by name or by ID.
+func (p *Cloudbeds) searchHousekeeperID(ctx context.Context, housekeeperName, propertyID string) (string, error) {
+	for _, housekeeper := range p.configMap.HousekeeperMap {
+		if housekeeper.HousekeeperName == housekeeperName && housekeeper.HospitalityHousekeeperID != "" {
+			return housekeeper.HospitalityHousekeeperID, nil
+		}
+	}
+
+	housekeepers, err := fetchAllPages(ctx, propertyParams(propertyID), p.getHousekeepersPage)
+	if err != nil {
+		return "", err
+	}
+	for _, housekeeper := range housekeepers {
+		if strings.EqualFold(strings.TrimSpace(housekeeper.Name), strings.TrimSpace(housekeeperName)) || housekeeper.HousekeeperID == housekeeperName {
+			return housekeeper.HousekeeperID, nil
+		}
+	}
+
+	return "", fmt.Errorf("housekeeper %s not found in Cloudbeds", housekeeperName)
+}
+
+// getHousekeepersPage returns one page of housekeepers and the total amount of housekeepers matching params
+func (p *Cloudbeds) getHousekeepersPage(ctx context.Context, params url.Values) (housekeepers []Housekeeper, total int, err error) {
+	apiUrl := p.apiUrlGetHousekeepers
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getHousekeepers" // default value
+	}
+
+	p.log.Debugf("getting housekeepers: %v", params)
+	resp, err := p.send(ctx, "get housekeepers", func() (*http.Response, error) {
+		return p.get(ctx, apiUrl+"?"+params.Encode())
+	})
+	if err != nil {
+		return housekeepers, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody := &ResponseGetHousekeepers{}
+	err = json.NewDecoder(resp.Body).Decode(respBody)
+	if err != nil {
+		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+		p.log.Debugf("success, but parse return body: %s", err)
+		return housekeepers, 0, detailedError
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to get housekeepers: %s", respBody.Message)
+		return housekeepers, 0, hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to get housekeepers: %s", respBody.Message))
+	}
+
+	return respBody.Data, respBody.Total, nil
+}
+
+// postHousekeepingAssignment assigns the housekeeper housekeeperID to the room roomID
+func (p *Cloudbeds) postHousekeepingAssignment(ctx context.Context, roomID, propertyID, housekeeperID string) error {
+	apiUrl := p.apiUrlPostHousekeepingAssign
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/postHousekeepingAssignment" // default value
+	}
+
+	data := propertyParams(propertyID)
+	data.Set("housekeeperID", housekeeperID)
+	data.Set("roomIDs", roomID)
+
+	p.log.Debugf("Sending POST data to %s: %v", apiUrl, data)
+
+	resp, err := p.send(ctx, "assign housekeeper", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", apiUrl, strings.NewReader(data.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return p.client().Do(req)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var respBody ResponsePostHousekeepingAssignment
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to assign housekeeper: %s", respBody.Message)
+		return hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to assign housekeeper: %s", respBody.Message))
+	}
+
+	p.log.Infof("Housekeeper '%s' assigned to room '%s'", housekeeperID, roomID)
+	return nil
+}
+
+// UpdateRoomDoNotDisturb sets or clears "Do Not Disturb" on the room with extension roomExtensionNumber. The room condition is not changed
+func (p *Cloudbeds) UpdateRoomDoNotDisturb(ctx context.Context, roomExtensionNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomDoNotDisturb %s to %t for %s", roomExtensionNumber, doNotDisturb, housekeeperName)
+
+	//get room id
+	room := &Room{}
+	extension, err := room.SearchExtensionByPhoneNumber(p.log, roomExtensionNumber, p.configMap.ExtensionMap)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	err = p.postHousekeepingStatus(ctx, UpdateRoomConditionRequest{
+		RoomID:       extension.HospitalityRoomID,
+		PropertyID:   extension.HospitalityPropertyID,
+		DoNotDisturb: &doNotDisturb,
+	})
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+	msg = fmt.Sprintf("Finish UpdateRoomDoNotDisturb successfully updated room %s. Do not disturb: %t", roomExtensionNumber, doNotDisturb)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// UpdateRoomBlock takes the room with extension roomExtensionNumber out of service (blocked=true) or returns it to inventory (blocked=false)
+func (p *Cloudbeds) UpdateRoomBlock(ctx context.Context, roomExtensionNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomBlock %s to %t (%s) for %s", roomExtensionNumber, blocked, reason, staffName)
+
+	//get room id
+	room := &Room{}
+	extension, err := room.SearchExtensionByPhoneNumber(p.log, roomExtensionNumber, p.configMap.ExtensionMap)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	if blocked {
+		if reason == "" {
+			reason = defaultRoomBlockReason
+		}
+		if staffName != "" {
+			reason = fmt.Sprintf("%s (%s)", reason, staffName)
+		}
+		roomBlockID, err := p.postRoomBlock(ctx, extension.HospitalityRoomID, extension.HospitalityPropertyID, reason)
+		if err != nil {
+			p.log.Error(err)
+			return msg, err
+		}
+		msg = fmt.Sprintf("Finish UpdateRoomBlock successfully blocked room %s. Block: %s", roomExtensionNumber, roomBlockID)
+		p.log.Debugf(msg)
+		return msg, nil
+	}
+
+	amountOfDeletedBlocks, err := p.unblockRoom(ctx, extension.HospitalityRoomID, extension.HospitalityPropertyID)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+	msg = fmt.Sprintf("Finish UpdateRoomBlock successfully unblocked room %s. Removed blocks: %d", roomExtensionNumber, amountOfDeletedBlocks)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// unblockRoom removes the room from its current out of service blocks. Blocks of this room only are deleted,
+// blocks that include other rooms are updated without this room. Returns the amount of blocks the room was removed from
+func (p *Cloudbeds) unblockRoom(ctx context.Context, roomID, propertyID string) (amountOfDeletedBlocks int, err error) {
+	today := time.Now().Format(roomBlockDateFormat)
+	params := propertyParams(propertyID)
+	params.Set("roomID", roomID)
+	params.Set("startDate", today)
+	params.Set("endDate", today)
+	roomBlocks, err := fetchAllPages(ctx, params, p.getRoomBlocksPage)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, roomBlock := range roomBlocks {
+		if roomBlock.RoomBlockType != roomBlockType {
+			continue
+		}
+		otherRoomIDs := make([]string, 0, len(roomBlock.Rooms))
+		for _, room := range roomBlock.Rooms {
+			if room.RoomID != roomID {
+				otherRoomIDs = append(otherRoomIDs, room.RoomID)
+			}
+		}
+		if len(otherRoomIDs) == len(roomBlock.Rooms) {
+			continue
+		}
+		if len(otherRoomIDs) > 0 {
+			err = p.putRoomBlock(ctx, roomBlock, otherRoomIDs, propertyID)
+		} else {
+			err = p.deleteRoomBlock(ctx, roomBlock.RoomBlockID, propertyID)
+		}
+		if err != nil {
+			return amountOfDeletedBlocks, err
+		}
+		amountOfDeletedBlocks++
 	}
+
+	if amountOfDeletedBlocks == 0 {
+		p.log.Infof("no out of service blocks found for room %s", roomID)
+	}
+	return amountOfDeletedBlocks, nil
+}
+
+// postRoomBlock creates an out of service block for the room starting today and ending in roomBlockDays. Returns ID of the created block
+func (p *Cloudbeds) postRoomBlock(ctx context.Context, roomID, propertyID, reason string) (roomBlockID string, err error) {
+	apiUrl := p.apiUrlPostRoomBlock
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/postRoomBlock" // default value
+	}
+
+	roomBlockDays := p.roomBlockDays
+	if roomBlockDays <= 0 {
+		roomBlockDays = defaultRoomBlockDays
+	}
+
+	now := time.Now()
+	data := propertyParams(propertyID)
+	data.Set("roomBlockType", roomBlockType)
+	data.Set("roomBlockReason", reason)
+	data.Set("startDate", now.Format(roomBlockDateFormat))
+	data.Set("endDate", now.AddDate(0, 0, roomBlockDays).Format(roomBlockDateFormat))
+	data.Set("rooms[0][roomID]", roomID)
+
+	p.log.Debugf("Sending POST data to %s: %v", apiUrl, data)
+
+	resp, err := p.send(ctx, "block room", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", apiUrl, strings.NewReader(data.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return p.client().Do(req)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var respBody ResponsePostRoomBlock
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		return "", &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to block room: %s", respBody.Message)
+		return "", hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to block room: %s", respBody.Message))
+	}
+
+	p.log.Infof("Room '%s' blocked. Block: '%s', reason: '%s'", roomID, respBody.RoomBlockID, reason)
+	return respBody.RoomBlockID, nil
 }
 
-type ApiConfiguration3CX struct {
-	APIURLs struct {
-		GetRooms               string `json:"getRooms"`
-		PostHousekeepingStatus string `json:"postHousekeepingStatus"`
-	} `json:"apiURLs"`
-	RoomStatuses []string `json:"roomStatuses"`
+// getRoomBlocksPage returns one page of room blocks and the total amount of room blocks matching params
+func (p *Cloudbeds) getRoomBlocksPage(ctx context.Context, params url.Values) (roomBlocks []RoomBlock, total int, err error) {
+	apiUrl := p.apiUrlGetRoomBlocks
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getRoomBlocks" // default value
+	}
+
+	p.log.Debugf("getting room blocks: %v", params)
+	resp, err := p.send(ctx, "get room blocks", func() (*http.Response, error) {
+		return p.get(ctx, apiUrl+"?"+params.Encode())
+	})
+	if err != nil {
+		return roomBlocks, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody := &ResponseGetRoomBlocks{}
+	err = json.NewDecoder(resp.Body).Decode(respBody)
+	if err != nil {
+		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+		p.log.Debugf("success, but parse return body: %s", err)
+		return roomBlocks, 0, detailedError
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to get room blocks: %s", respBody.Message)
+		return roomBlocks, 0, hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to get room blocks: %s", respBody.Message))
+	}
+
+	return respBody.Data, respBody.Total, nil
 }
 
-func (p *Cloudbeds) GetRooms() (rooms []hotel.Room, err error) {
-	p.log.Debugf("getting rooms")
-	apiUrl := p.apiUrlGetRooms
-	//TODO - move urlConfiguration to configMap and load from separate cloudbeds_api_url.txt config file
+// deleteRoomBlock removes the room block roomBlockID
+func (p *Cloudbeds) deleteRoomBlock(ctx context.Context, roomBlockID, propertyID string) error {
+	apiUrl := p.apiUrlDeleteRoomBlock
 	if apiUrl == "" {
-		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getRooms" // default value
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/deleteRoomBlock" // default value
 	}
 
-	respBody := &ResponseGetRooms{}
-	resp, err := p.httpClient.Get(apiUrl)
+	params := propertyParams(propertyID)
+	params.Set("roomBlockID", roomBlockID)
+
+	p.log.Debugf("Sending DELETE to %s: %v", apiUrl, params)
+
+	resp, err := p.send(ctx, "delete room block", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "DELETE", apiUrl+"?"+params.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return p.client().Do(req)
+	})
 	if err != nil {
-		p.log.Errorf("request failed with: %s", err)
-		return rooms, fmt.Errorf("request failed with: %s", err)
+		return err
 	}
 	defer resp.Body.Close()
 
+	var respBody ResponseDeleteRoomBlock
 	err = json.NewDecoder(resp.Body).Decode(&respBody)
 	if err != nil {
-		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
-		p.log.Debugf("success, but parse return body: %s", err)
-		return rooms, detailedError
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
 	}
 
 	//check for errors
-	if !respBody.Success { //might be access_token expired. Try to refresh it
-		p.log.Debugf("Failed to update room status: %s Might be access_token expired", respBody.Message)
-		err = p.refresher.refreshToken()
+	if !respBody.Success {
+		p.log.Debugf("Failed to delete room block: %s", respBody.Message)
+		return hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to delete room block: %s", respBody.Message))
+	}
+
+	p.log.Infof("Room block '%s' deleted", roomBlockID)
+	return nil
+}
+
+// putRoomBlock updates the room block to include only the rooms roomIDs. Type, reason and dates of the block are not changed
+func (p *Cloudbeds) putRoomBlock(ctx context.Context, roomBlock RoomBlock, roomIDs []string, propertyID string) error {
+	apiUrl := p.apiUrlPutRoomBlock
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/putRoomBlock" // default value
+	}
+
+	data := propertyParams(propertyID)
+	data.Set("roomBlockID", roomBlock.RoomBlockID)
+	data.Set("roomBlockReason", roomBlock.RoomBlockReason)
+	data.Set("startDate", roomBlock.StartDate)
+	data.Set("endDate", roomBlock.EndDate)
+	for i, roomID := range roomIDs {
+		data.Set(fmt.Sprintf("rooms[%d][roomID]", i), roomID)
+	}
+
+	p.log.Debugf("Sending PUT data to %s: %v", apiUrl, data)
+
+	resp, err := p.send(ctx, "update room block", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "PUT", apiUrl, strings.NewReader(data.Encode()))
 		if err != nil {
-			p.log.Debugf("Failed to update room status: %s", respBody.Message)
-			return rooms, err
-		}
-		rooms, err = p.GetRooms() //recursive call. Not testable
-		if err != nil {           //might be access_token expired. Try to refresh it
-			p.log.Debugf("Failed to update room status after token refresh: %s", respBody.Message)
-			return rooms, err
-		} else {
-			return rooms, nil
+			return nil, err
 		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return p.client().Do(req)
+	})
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	// check if respBody.Data is set
-	if len(respBody.Data) == 0 {
-		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but no rooms found: %s", err)}
-		p.log.Debugf("success, but no rooms found: %v", respBody.Data)
-		return rooms, detailedError
+	var respBody ResponsePutRoomBlock
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
 	}
-	p.log.Debugf("Response data: %v", respBody.Data)
-	p.log.Debugf("HttpCode: %s", resp.Status)
-
-	p.log.Debugf("Amount of rooms: %d", len(respBody.Data[0].Rooms))
 
-	for _, room := range respBody.Data[0].Rooms {
-		rooms = append(rooms, room.ToHotelRoom())
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to update room block: %s", respBody.Message)
+		return hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to update room block: %s", respBody.Message))
 	}
 
-	return rooms, nil
+	p.log.Infof("Room block '%s' updated. Rooms: %v", roomBlock.RoomBlockID, roomIDs)
+	return nil
 }
 
-//func (p *Cloudbeds) BookRoom(roomID string, date time.Time) (reservations Reservation, err error) {
-//	// Provider1's implementation of BookRoom
-//	return nil
-//}
+// PostCharge posts quantity of the catalog item itemCode to the folio of the in-house reservation of the room with extension roomExtensionNumber
+func (p *Cloudbeds) PostCharge(ctx context.Context, roomExtensionNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start PostCharge %d x %s to room %s by %s", quantity, itemCode, roomExtensionNumber, housekeeperName)
 
-func (p *Cloudbeds) UpdateRoom(roomExtensionNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
-	p.log.Debugf("Start UpdateRoom %s to %s for %s", roomExtensionNumber, housekeepingStatus, housekeeperName)
+	item, ok := p.configMap.SearchItemByCode(itemCode)
+	if !ok {
+		errMsg := fmt.Sprintf("item %s not found in item catalog", itemCode)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	if quantity <= 0 {
+		errMsg := fmt.Sprintf("quantity %d is not valid", quantity)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
 
 	//get room id
 	room := &Room{}
-	room.PhoneNumber = roomExtensionNumber
-	roomID, err := room.SearchRoomIDByPhoneNumber(p.log, roomExtensionNumber, p.configMap.ExtensionMap)
+	extension, err := room.SearchExtensionByPhoneNumber(p.log, roomExtensionNumber, p.configMap.ExtensionMap)
 	if err != nil {
 		p.log.Error(err)
 		return msg, err
 	}
-	room.RoomID = roomID
 
-	if !p.checkIfRoomConditionValid(housekeepingStatus) {
-		errMsg := fmt.Sprintf("room condition %s is not valid", housekeepingStatus)
+	//charge goes to the folio of the in-house reservation
+	params := propertyParams(extension.HospitalityPropertyID)
+	params.Set("roomID", extension.HospitalityRoomID)
+	guests, err := p.getInHouseGuests(ctx, params)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+	guest, ok := guests[extension.HospitalityRoomID]
+	if !ok {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomExtensionNumber)
 		p.log.Error(errMsg)
-		return "", errors.New(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
 	}
 
-	// Update the room condition
-	err = p.postHousekeepingStatus(room.RoomID, housekeepingStatus)
+	soldProductID, err := p.postCustomItem(ctx, guest.ReservationID, extension.HospitalityRoomID, extension.HospitalityPropertyID, item, quantity, housekeeperName)
 	if err != nil {
 		p.log.Error(err)
 		return msg, err
 	}
-	msg = fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomExtensionNumber, housekeepingStatus)
+	msg = fmt.Sprintf("Finish PostCharge successfully posted %d x %s to room %s. Reservation: %s, sold product: %s", quantity, item.Name, roomExtensionNumber, guest.ReservationID, soldProductID)
 	p.log.Debugf(msg)
 	return msg, nil
 }
 
-func (p *Cloudbeds) GetRoom(roomNumber string, mapFileName string) (hotel.Room, error) {
+// postCustomItem posts quantity of item to the reservation folio. Returns ID of the sold product
+func (p *Cloudbeds) postCustomItem(ctx context.Context, reservationID, roomID, propertyID string, item configuration.Item, quantity int, housekeeperName string) (soldProductID string, err error) {
+	apiUrl := p.apiUrlPostCustomItem
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/postCustomItem" // default value
+	}
+
+	itemID := item.HospitalityItemID
+	if itemID == "" {
+		itemID = item.ItemCode
+	}
+	referenceID := p.generateRandomString(16)
+
+	data := propertyParams(propertyID)
+	data.Set("reservationID", reservationID)
+	data.Set("roomID", roomID)
+	data.Set("referenceID", referenceID) //protects from double posting on Cloudbeds side
+	data.Set("items[0][appItemID]", itemID)
+	data.Set("items[0][itemSKU]", itemID)
+	data.Set("items[0][itemName]", item.Name)
+	data.Set("items[0][itemQuantity]", strconv.Itoa(quantity))
+	data.Set("items[0][itemPrice]", strconv.FormatFloat(item.Price, 'f', 2, 64))
+	if housekeeperName != "" {
+		data.Set("items[0][itemNote]", fmt.Sprintf("Posted by %s", housekeeperName))
+	}
+
+	p.log.Debugf("Sending POST data to %s: %v", apiUrl, data)
+
+	resp, err := p.send(ctx, "post charge", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", apiUrl, strings.NewReader(data.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return p.client().Do(req)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var respBody ResponsePostCustomItem
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		return "", &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to post charge: %s", respBody.Message)
+		return "", hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to post charge: %s", respBody.Message))
+	}
+
+	p.log.Infof("Charge %d x '%s' posted to reservation '%s'", quantity, item.Name, reservationID)
+	return respBody.Data.SoldProductID, nil
+}
+
+func (p *Cloudbeds) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
 	p.log.Infof("get info about room %s", roomNumber)
 
 	//get room id
 	room := &Room{}
 	room.PhoneNumber = roomNumber
-	roomID, err := room.SearchRoomIDByPhoneNumber(p.log, roomNumber, p.configMap.ExtensionMap)
+	extension, err := room.SearchExtensionByPhoneNumber(p.log, roomNumber, p.configMap.ExtensionMap)
 	if err != nil {
 		p.log.Error(err)
 		return room.ToHotelRoom(), err
 	}
+	roomID := extension.HospitalityRoomID
 	room.RoomID = roomID
+	room.PropertyID = extension.HospitalityPropertyID
+
+	//get current housekeeping state. Cloudbeds can't filter housekeeping status by roomID, so we look for the room in the full list
+	statuses, err := p.getHousekeepingStatus(ctx, propertyParams(room.PropertyID))
+	if err != nil {
+		p.log.Error(err)
+		return room.ToHotelRoom(), err
+	}
+	var status *HousekeepingStatus
+	for i := range statuses {
+		if statuses[i].RoomID == roomID {
+			status = &statuses[i]
+			break
+		}
+	}
+	if status == nil {
+		errMsg := fmt.Sprintf("housekeeping status for room %s not found", roomNumber)
+		p.log.Error(errMsg)
+		return room.ToHotelRoom(), hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	var guest *hotel.Guest
+	if status.RoomOccupied {
+		params := propertyParams(room.PropertyID)
+		params.Set("roomID", roomID)
+		guests, err := p.getInHouseGuests(ctx, params)
+		if err != nil {
+			p.log.Error(err)
+			return room.ToHotelRoom(), err
+		}
+		if g, ok := guests[roomID]; ok {
+			guest = &g
+		}
+	}
+	room.applyState(*status, guest)
 
 	return room.ToHotelRoom(), nil
 }
 
+// GetRoomGuest returns the in-house guest of the room with extension roomNumber
+func (p *Cloudbeds) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	p.log.Debugf("get in-house guest for room %s", roomNumber)
+
+	//get room id
+	room := &Room{}
+	extension, err := room.SearchExtensionByPhoneNumber(p.log, roomNumber, p.configMap.ExtensionMap)
+	if err != nil {
+		return hotel.Guest{}, err
+	}
+	roomID := extension.HospitalityRoomID
+
+	params := propertyParams(extension.HospitalityPropertyID)
+	params.Set("roomID", roomID)
+	guests, err := p.getInHouseGuests(ctx, params)
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Guest{}, err
+	}
+
+	guest, ok := guests[roomID]
+	if !ok {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomNumber)
+		p.log.Debugf(errMsg)
+		return hotel.Guest{}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	p.log.Debugf("Found guest %s %s in room %s. Reservation: %s", guest.FirstName, guest.LastName, roomNumber, guest.ReservationID)
+	return guest, nil
+}
+
+// propertyParams returns request parameters limited to the property propertyID. Empty propertyID means the default property of the account
+func propertyParams(propertyID string) url.Values {
+	params := url.Values{}
+	if propertyID != "" {
+		params.Set("propertyID", propertyID)
+	}
+	return params
+}
+
+// getInHouseGuests returns guests of checked-in reservations filtered by params. Result is a map roomID -> guest
+func (p *Cloudbeds) getInHouseGuests(ctx context.Context, params url.Values) (guests map[string]hotel.Guest, err error) {
+	params.Set("status", "checked_in")
+	params.Set("includeGuestsDetails", "true")
+	reservations, err := p.getReservations(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	guests = make(map[string]hotel.Guest)
+	for _, reservation := range reservations {
+		roomIDs := reservation.RoomIDs()
+		//reservation without guest details. Possible only if the request was filtered by roomID
+		if len(roomIDs) == 0 && params.Get("roomID") != "" {
+			roomIDs = []string{params.Get("roomID")}
+		}
+		for _, roomID := range roomIDs {
+			if _, exists := guests[roomID]; exists {
+				continue
+			}
+			guests[roomID] = reservation.GuestInRoom(roomID)
+		}
+	}
+	return guests, nil
+}
+
+// getReservations returns reservations filtered by params from all pages. Check Cloudbeds getReservations API for the list of available filters
+func (p *Cloudbeds) getReservations(ctx context.Context, params url.Values) (reservations []Reservation, err error) {
+	reservations, err = fetchAllPages(ctx, params, p.getReservationsPage)
+	if err != nil {
+		return reservations, err
+	}
+	p.log.Debugf("Amount of reservations: %d", len(reservations))
+	return reservations, nil
+}
+
+// fetchAllPages requests pages from a paginated Cloudbeds endpoint with fetchPage until all records (total) are received or an empty page is returned
+func fetchAllPages[T any](ctx context.Context, params url.Values, fetchPage func(ctx context.Context, params url.Values) (page []T, total int, err error)) (records []T, err error) {
+	for pageNumber := 1; ; pageNumber++ {
+		params.Set("pageNumber", strconv.Itoa(pageNumber))
+		params.Set("pageSize", strconv.Itoa(apiPageSize))
+		page, total, err := fetchPage(ctx, params)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, page...)
+		if len(page) == 0 || len(records) >= total {
+			return records, nil
+		}
+	}
+}
+
+// getReservationsPage returns one page of reservations and the total amount of reservations matching params
+func (p *Cloudbeds) getReservationsPage(ctx context.Context, params url.Values) (reservations []Reservation, total int, err error) {
+	apiUrl := p.apiUrlGetReservations
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getReservations" // default value
+	}
+
+	p.log.Debugf("getting reservations: %v", params)
+	resp, err := p.send(ctx, "get reservations", func() (*http.Response, error) {
+		return p.get(ctx, apiUrl+"?"+params.Encode())
+	})
+	if err != nil {
+		return reservations, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody := &ResponseGetReservations{}
+	err = json.NewDecoder(resp.Body).Decode(respBody)
+	if err != nil {
+		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+		p.log.Debugf("success, but parse return body: %s", err)
+		return reservations, 0, detailedError
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to get reservations: %s", respBody.Message)
+		return reservations, 0, hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to get reservations: %s", respBody.Message))
+	}
+
+	return respBody.Data, respBody.Total, nil
+}
+
+// getHousekeepingStatus returns housekeeping statuses of rooms filtered by params from all pages
+func (p *Cloudbeds) getHousekeepingStatus(ctx context.Context, params url.Values) (statuses []HousekeepingStatus, err error) {
+	statuses, err = fetchAllPages(ctx, params, p.getHousekeepingStatusPage)
+	if err != nil {
+		return statuses, err
+	}
+	p.log.Debugf("Amount of housekeeping statuses: %d", len(statuses))
+	return statuses, nil
+}
+
+func (p *Cloudbeds) getHousekeepingStatusPage(ctx context.Context, params url.Values) (statuses []HousekeepingStatus, total int, err error) {
+	apiUrl := p.apiUrlGetHousekeepingStatus
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getHousekeepingStatus" // default value
+	}
+
+	p.log.Debugf("getting housekeeping status: %v", params)
+	resp, err := p.send(ctx, "get housekeeping status", func() (*http.Response, error) {
+		return p.get(ctx, apiUrl+"?"+params.Encode())
+	})
+	if err != nil {
+		return statuses, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody := &ResponseGetHousekeepingStatus{}
+	err = json.NewDecoder(resp.Body).Decode(respBody)
+	if err != nil {
+		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+		p.log.Debugf("success, but parse return body: %s", err)
+		return statuses, 0, detailedError
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to get housekeeping status: %s", respBody.Message)
+		return statuses, 0, hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to get housekeeping status: %s", respBody.Message))
+	}
+
+	return respBody.Data, respBody.Total, nil
+}
+
 // handleLogin helper function to handle login. Just redirect to oauth2 provider login page
-func (p *Cloudbeds) HandleInitialLogin() (url string, errReturn error) {
+func (p *Cloudbeds) HandleInitialLogin(ctx context.Context) (url string, errReturn error) {
+	if p.usesAPIKey() {
+		return "", nil //login is not required
+	}
 	err := p.setOauth2Config()
 	if err != nil {
 		return "", err
@@ -275,21 +1358,21 @@ func (p *Cloudbeds) HandleInitialLogin() (url string, errReturn error) {
 // login helper function to handle login. Just redirect to oauth2 provider login page
 func (p *Cloudbeds) login(secretStore secrets.SecretsStore) (statusCodeMsg string, msg string, errorInfo error) {
 	p.log.Debugf("Trying to login to Cloudbeds")
-	if loginLoopPreventionCounter > 1 {
-		p.log.Debugf("Running login in a loop %d time", loginLoopPreventionCounter)
+	if p.loginAttempts > 0 {
+		p.log.Debugf("Running login in a loop %d time", p.loginAttempts+1)
 	}
 	oauthStateString := p.generateRandomString(10) // adjust the length as per your needs
 
 	// try to retrieve refresh token from secret store
 	p.log.Debugf("Trying to retrieve refresh token from secret store")
-	refreshToken, err := secretStore.RetrieveRefreshToken()
+	storedToken, err := secretStore.RetrieveToken()
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to retrieve refresh token from secret store: %v", err)
 		p.log.Error(errMsg)
 		return "fatal-error", errMsg, errors.New(errMsg)
 	}
 
-	if refreshToken == "" {
+	if storedToken.RefreshToken == "" {
 		//call oauth2 login
 		err = p.setOauth2Config()
 		if err != nil {
@@ -311,32 +1394,52 @@ func (p *Cloudbeds) login(secretStore secrets.SecretsStore) (statusCodeMsg strin
 	// get new access token via refresh token
 	// Make client request using the obtained token
 	token := &oauth2.Token{
-		RefreshToken: refreshToken,
+		RefreshToken: storedToken.RefreshToken,
 	}
 
-	tokenSource := p.oauthConf.TokenSource(context.Background(), token)
+	tokenSource := p.oauthConf.TokenSource(p.httpContext(context.Background()), token)
 	newToken, err := tokenSource.Token()
 	if err != nil {
 		p.log.Info("failed to get new access token. Looks like refresh token is stale. Clearing it and try to login again")
-		err := secretStore.StoreRefreshToken("")
+		err := secretStore.StoreToken(secrets.Token{})
 		if err != nil {
 			p.log.Fatalf("failed to clear refresh token from secret store: %v", err)
 			return "", "", nil
 		}
-		loginLoopPreventionCounter++
-		if loginLoopPreventionCounter <= 2 {
+		p.loginAttempts++
+		if p.loginAttempts <= 1 {
 			statusRefresh, msgStatus, _ := p.login(secretStore)
 			return statusRefresh, msgStatus, nil
 		}
-		p.log.Debugf("Login loop prevention counter: %d", loginLoopPreventionCounter)
+		p.log.Debugf("Login loop prevention counter: %d", p.loginAttempts)
 		return fmt.Sprintln("failed-to-get-refresh-token"), "failed to get new access token", nil
 	}
+	err = p.saveToken(newToken, storedToken.RefreshToken)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to save new token to secret store: %v", err)
+		p.log.Error(errMsg)
+		return "fatal-error", errMsg, errors.New(errMsg)
+	}
 	p.log.Debugf("Issued new access token with len: %v", len(newToken.AccessToken))
 	return "ok", "", nil
 }
 
-// refreshToken helper function to refresh token and store new access token to secret store
-func (p *Cloudbeds) refreshToken() error {
+// refreshToken helper function to refresh token and store the new token to secret store.
+// Refresh invalidates the previous refresh token, so concurrent refreshes must not happen. Calls waiting for a running
+// refresh reuse its token. If the secret store is shared by several processes (secrets.TokenLocker), the token is refreshed
+// only by the holder of the lock, others reuse the token it saves
+func (p *Cloudbeds) refreshToken(ctx context.Context) error {
+	if p.usesAPIKey() {
+		return errAPIKeyRejected
+	}
+	rejectedToken := p.currentToken()
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	if p.currentToken() != rejectedToken {
+		p.log.Debugf("Token was refreshed while waiting for the refresh. Reusing it")
+		return nil
+	}
+
 	p.log.Debugf("Trying to refresh token")
 
 	//call oauth2 data
@@ -345,19 +1448,43 @@ func (p *Cloudbeds) refreshToken() error {
 		return err
 	}
 
-	refreshToken, err := p.storeClient.RetrieveRefreshToken()
+	storedToken, err := p.storeClient.RetrieveToken()
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to retrieve refresh token from secret store: %v", err)
 		p.log.Error(errMsg)
 		return errors.New(errMsg)
 	}
+	if rejectedToken != nil && storedToken.AccessToken != "" && storedToken.AccessToken != rejectedToken.AccessToken &&
+		!expiresSoon(fromStoredToken(storedToken), time.Now()) {
+		p.log.Debugf("Token was refreshed by another process. Reusing it")
+		p.useToken(fromStoredToken(storedToken))
+		return nil
+	}
+
+	if locker, ok := p.storeClient.(secrets.TokenLocker); ok {
+		owner := p.generateRandomString(10)
+		reused, err := p.lockToken(ctx, locker, owner, storedToken)
+		if err != nil {
+			p.log.Error(err)
+			return err
+		}
+		if reused {
+			return nil
+		}
+		defer func() {
+			err := locker.UnlockToken(owner)
+			if err != nil {
+				p.log.Errorf("failed to unlock token: %s", err)
+			}
+		}()
+	}
 
 	// Make client request using the obtained token
 	token := &oauth2.Token{
-		RefreshToken: refreshToken,
+		RefreshToken: storedToken.RefreshToken,
 	}
 
-	tokenSource := p.oauthConf.TokenSource(context.Background(), token)
+	tokenSource := p.oauthConf.TokenSource(p.httpContext(ctx), token)
 	newToken, err := tokenSource.Token()
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to get new access token. Looks like refresh token is stale. Clearing it and try to login again. Error: %v", err.Error())
@@ -371,18 +1498,27 @@ func (p *Cloudbeds) refreshToken() error {
 		return errors.New(errMsg)
 	}
 
-	p.httpClient = p.oauthConf.Client(context.Background(), token)
-	err = p.storeClient.StoreAccessToken(newToken.AccessToken)
+	err = p.saveToken(newToken, storedToken.RefreshToken)
 	if err != nil {
 		return err
 	}
 
-	p.log.Debugf("Issued new access token with len: %d", len(newToken.AccessToken))
+	p.log.Debugf("Issued new access token with len: %d. Expires at %s", len(newToken.AccessToken), newToken.Expiry)
 	return nil
 }
 
-func (p *Cloudbeds) HandleOAuthCallback(state, code string) (err error) {
+// httpContext returns ctx for oauth2 clients and token sources. Their requests go through retryTransport.
+// The pre-authorized client keeps the context for refreshes during its whole life, so it gets context.Background()
+func (p *Cloudbeds) httpContext(ctx context.Context) context.Context {
+	httpClient := &http.Client{Transport: newRetryTransport(p.log, http.DefaultTransport)}
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+}
+
+func (p *Cloudbeds) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
 	p.log.Debugf("Handling oauth callback in cloudbeds. State: %s, Code: %s", state, code)
+	if p.usesAPIKey() {
+		return errors.New("cloudbeds API key is used. OAuth2 login is not needed")
+	}
 	oauthStateString, err := p.storeClient.RetrieveOauthState(state)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to retrieve oauth state from secret store: %v. Possibly state does not exist or stale. Try to login again", err.Error())
@@ -395,25 +1531,16 @@ func (p *Cloudbeds) HandleOAuthCallback(state, code string) (err error) {
 		return errors.New(errMsg)
 	}
 
-	token, err := p.oauthConf.Exchange(context.Background(), code)
+	token, err := p.oauthConf.Exchange(ctx, code)
 	if err != nil {
 		p.log.Debugf("oauthConf.Exchange() failed with '%s'", err)
 		return fmt.Errorf("oauthConf.Exchange() failed with '%s'\n", err)
 	}
 	p.log.Debugf("Got access token of length: %d", len(token.AccessToken))
 
-	// get pre-authorized client for future requests (doesn't make a lot of sense for aws version)
-	p.httpClient = p.oauthConf.Client(context.Background(), token)
-
-	//save access and refresh token to secret store
-	p.log.Debugf("Saving access token to secret store")
-	err = p.storeClient.StoreAccessToken(token.AccessToken)
-	if err != nil {
-		p.log.Error(err)
-		return err
-	}
-	p.log.Debugf("Saving refresh token to secret store")
-	err = p.storeClient.StoreRefreshToken(token.RefreshToken)
+	//save the token to secret store and get pre-authorized client for future requests (doesn't make a lot of sense for aws version)
+	p.log.Debugf("Saving token to secret store")
+	err = p.saveToken(token, "")
 	if err != nil {
 		p.log.Error(err)
 		return err
@@ -421,6 +1548,16 @@ func (p *Cloudbeds) HandleOAuthCallback(state, code string) (err error) {
 	return nil
 }
 
+func init() {
+	hotel.Register("cloudbeds", func(log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (hotel.Provider, error) {
+		client, err := New(log, secretStore, configMap)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
 func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *configuration.ConfigMap) (*Cloudbeds, error) {
 	log.Debugf("Creating new Cloudbeds client")
 
@@ -441,20 +1578,42 @@ func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *co
 	//get current api parameters for cloudbeds from config file
 	cloudbedsClient.apiUrlPostHousekeepingStatus = apiConfiguration.APIURLs.PostHousekeepingStatus
 	cloudbedsClient.apiUrlGetRooms = apiConfiguration.APIURLs.GetRooms
-	cloudbedsClient.roomStatuses = apiConfiguration.RoomStatuses
+	cloudbedsClient.apiUrlGetReservations = apiConfiguration.APIURLs.GetReservations
+	cloudbedsClient.apiUrlGetHousekeepingStatus = apiConfiguration.APIURLs.GetHousekeepingStatus
+	cloudbedsClient.apiUrlGetHousekeepers = apiConfiguration.APIURLs.GetHousekeepers
+	cloudbedsClient.apiUrlPostHousekeepingAssign = apiConfiguration.APIURLs.PostHousekeepingAssign
+	cloudbedsClient.apiUrlPostRoomBlock = apiConfiguration.APIURLs.PostRoomBlock
+	cloudbedsClient.apiUrlGetRoomBlocks = apiConfiguration.APIURLs.GetRoomBlocks
+	cloudbedsClient.apiUrlDeleteRoomBlock = apiConfiguration.APIURLs.DeleteRoomBlock
+	cloudbedsClient.apiUrlPutRoomBlock = apiConfiguration.APIURLs.PutRoomBlock
+	cloudbedsClient.apiUrlPostCustomItem = apiConfiguration.APIURLs.PostCustomItem
+	cloudbedsClient.apiUrlGetReservation = apiConfiguration.APIURLs.GetReservation
+	cloudbedsClient.apiUrlGetWebhooks = apiConfiguration.APIURLs.GetWebhooks
+	cloudbedsClient.apiUrlPostWebhook = apiConfiguration.APIURLs.PostWebhook
+	cloudbedsClient.roomStatuses = hotel.NewRoomConditionSet(apiConfiguration.RoomStatuses...)
+	cloudbedsClient.roomBlockDays = apiConfiguration.RoomBlockDays
+	cloudbedsClient.refresher = cloudbedsClient //refresher is an interface! This feint with ears is needed to point refreshToken method to itself. Now call p.refresher.refreshToken(ctx) will call refreshToken method of Cloudbeds struct
+	//refresher was created as interface to make the code more testable
+
+	//property API key needs neither OAuth2 config nor token
+	apiKeyEnabled, err := cloudbedsClient.setAPIKeyConfig()
+	if err != nil {
+		return nil, err
+	}
+	if apiKeyEnabled {
+		return cloudbedsClient, nil
+	}
 
 	err = cloudbedsClient.setOauth2Config()
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	cloudbedsClient.refresher = cloudbedsClient //refresher is an interface! This feint with ears is needed to point refreshToken method to itself. Now call p.refresher.refreshToken() will call refreshToken method of Cloudbeds struct
-	//refresher was created as interface to make the code more testable
 
 	//get access_token
 	//check if access_token is valid. If not - get refresh_token and update access_token
-	accessToken, err := cloudbedsClient.storeClient.RetrieveAccessToken()
-	if err != nil || accessToken == "" {
+	storedToken, err := cloudbedsClient.storeClient.RetrieveToken()
+	if err != nil || storedToken.AccessToken == "" {
 		statusCodeMsg, msg, err := cloudbedsClient.login(cloudbedsClient.storeClient)
 		if err != nil {
 			log.Errorln(msg)
@@ -462,14 +1621,14 @@ func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *co
 		}
 		if statusCodeMsg == "no-refresh-token-found" {
 			log.Errorln(msg)
-			//TODO: send communication message to admin
+			cloudbedsClient.setLoginRequired(msg) //admin is alerted by the caller (hotel.LoginChecker)
 		}
 	}
 
-	token := &oauth2.Token{
-		AccessToken: accessToken,
+	//login sets the client if it issued a new token. The token that expires soon is refreshed by the first request
+	if cloudbedsClient.httpClient == nil {
+		cloudbedsClient.useToken(fromStoredToken(storedToken))
 	}
-	cloudbedsClient.httpClient = cloudbedsClient.oauthConf.Client(context.Background(), token)
 
 	return cloudbedsClient, nil
 }
@@ -499,10 +1658,18 @@ func NewClient4CallbackAndInit(log *logrus.Logger, secretStore secrets.SecretsSt
 		log:         log,
 		storeClient: secretStore,
 	}
-	cloudbedsClient.refresher = cloudbedsClient //refresher is an interface! This feint with ears is needed to point refreshToken method to itself. Now call p.refresher.refreshToken() will call refreshToken method of Cloudbeds struct
+	cloudbedsClient.refresher = cloudbedsClient //refresher is an interface! This feint with ears is needed to point refreshToken method to itself. Now call p.refresher.refreshToken(ctx) will call refreshToken method of Cloudbeds struct
 	//refresher was created as interface to make the code more testable
 
-	err := cloudbedsClient.setOauth2Config()
+	apiKeyEnabled, err := cloudbedsClient.setAPIKeyConfig()
+	if err != nil {
+		return nil, err
+	}
+	if apiKeyEnabled {
+		return cloudbedsClient, nil
+	}
+
+	err = cloudbedsClient.setOauth2Config()
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -520,38 +1687,41 @@ func (p *Cloudbeds) Close() error {
 	return nil
 }
 
-func (p *Cloudbeds) postHousekeepingStatus(roomID string, roomCondition string) (errorStatusCodeMsg error) {
+func (p *Cloudbeds) postHousekeepingStatus(ctx context.Context, reqBody UpdateRoomConditionRequest) (errorStatusCodeMsg error) {
 	apiUrl := p.apiUrlPostHousekeepingStatus
 	//TODO - move urlConfiguration to configMap and load from separate cloudbeds_api_url.txt config file
 	if apiUrl == "" {
 		apiUrl = "https://hotels.cloudbeds.com/api/v1.1/postHousekeepingStatus" // default value
 	}
 
-	p.log.Infof("Posting housekeeping assignment for room %s with condition: %s", roomID, roomCondition)
-
-	reqBody := UpdateRoomConditionRequest{
-		RoomID:        roomID,
-		RoomCondition: roomCondition,
-	}
+	p.log.Infof("Posting housekeeping assignment for room %s with condition: %s", reqBody.RoomID, reqBody.RoomCondition)
 
 	data := url.Values{
-		"roomID":        {reqBody.RoomID},
-		"roomCondition": {reqBody.RoomCondition},
+		"roomID": {reqBody.RoomID},
+	}
+	if reqBody.RoomCondition != "" {
+		data.Set("roomCondition", reqBody.RoomCondition)
+	}
+	if reqBody.PropertyID != "" {
+		data.Set("propertyID", reqBody.PropertyID)
+	}
+	if reqBody.DoNotDisturb != nil {
+		data.Set("doNotDisturb", strconv.FormatBool(*reqBody.DoNotDisturb))
 	}
 
 	p.log.Debugf("Sending POST data to %s: %v", apiUrl, data)
 
 	// Use the encoded form data to create the request body. client.Post() does not work, so we create a separate request and run client.Do(req)
-	req, err := http.NewRequest("POST", apiUrl, strings.NewReader(data.Encode()))
-	if err != nil {
-		p.log.Fatal(err)
-	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.send(ctx, "update room status", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", apiUrl, strings.NewReader(data.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return p.client().Do(req)
+	})
 	if err != nil {
-		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("update room status failed with: %s", err)}
-		p.log.Debugf("update room status failed with: %s", err)
-		return detailedError
+		return err
 	}
 	defer resp.Body.Close()
 
@@ -564,32 +1734,22 @@ func (p *Cloudbeds) postHousekeepingStatus(roomID string, roomCondition string)
 	}
 
 	//check for errors
-	if !respBody.Success { //might be access_token expired. Try to refresh it
-		p.log.Debugf("Failed to update room status: %s Might be access_token expired", respBody.Message)
-		err = p.refresher.refreshToken()
-		if err != nil {
-			errMsg := fmt.Sprintf("Failed to update room status: %s", err.Error())
-			p.log.Debugf(errMsg)
-			return errors.New(errMsg)
-		}
-		err = p.postHousekeepingStatus(roomID, roomCondition)
-		if err != nil { //might be access_token expired. Try to refresh it
-			errMsg := fmt.Sprintf("Failed to update room status after token refresh: %s", err.Error())
-			p.log.Debugf(errMsg)
-			return errors.New(errMsg)
-		} else {
-			return nil
-		}
+	if !respBody.Success {
+		p.log.Debugf("Failed to update room status: %s", respBody.Message)
+		return hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to update room status: %s", respBody.Message))
 	}
 
 	// check if respBody.Data is set
 	if respBody.Data.RoomID == "" {
-		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but return body is empty: %s", err)}
+		detailedError := &hotel.DetailedError{
+			Msg:     errors.New("success, but return body is empty"),
+			Details: fmt.Sprintf("success, but return body Data.RoomID is empty: %v", respBody.Data),
+		}
 		p.log.Debugf("but return body Data.RoomID is empty: %v", respBody.Data)
 		return detailedError
 	}
 
-	p.log.Infof("Room '%s' status successfully updated to '%s'.", respBody.Data.RoomID, respBody.Data.RoomCondition)
+	p.log.Infof("Room '%s' status successfully updated to '%s'. Do not disturb: %t", respBody.Data.RoomID, respBody.Data.RoomCondition, respBody.Data.DoNotDisturb)
 	p.log.Debugf("HttpCode: %s. Response data: %v", resp.Status, respBody.Data)
 
 	return nil
@@ -597,17 +1757,24 @@ func (p *Cloudbeds) postHousekeepingStatus(roomID string, roomCondition string)
 
 func (p *Cloudbeds) checkIfRoomConditionValid(roomCondition string) bool {
 	p.log.Debugf("Checking if room condition %s is valid", roomCondition)
-	for _, status := range p.roomStatuses {
-		if status == roomCondition {
-			p.log.Debugf("Room condition %s is valid", roomCondition)
-			return true
-		}
+	if hotel.IsRoomConditionValid(roomCondition, p.roomStatuses) {
+		p.log.Debugf("Room condition %s is valid", roomCondition)
+		return true
 	}
 	p.log.Debugf("Room condition %s is not valid", roomCondition)
 	return false
 }
 
 func (r *Room) SearchRoomIDByPhoneNumber(log *logrus.Logger, phoneNumber string, extensionsInfo []configuration.Extension) (string, error) {
+	extension, err := r.SearchExtensionByPhoneNumber(log, phoneNumber, extensionsInfo)
+	if err != nil {
+		return "", err
+	}
+	return extension.HospitalityRoomID, nil
+}
+
+// SearchExtensionByPhoneNumber returns the extension mapping (room ID, room name and property ID) for phoneNumber
+func (r *Room) SearchExtensionByPhoneNumber(log *logrus.Logger, phoneNumber string, extensionsInfo []configuration.Extension) (configuration.Extension, error) {
 
 	// create map for easy search
 	roomMap := make(map[string]configuration.Extension)
@@ -621,11 +1788,11 @@ func (r *Room) SearchRoomIDByPhoneNumber(log *logrus.Logger, phoneNumber string,
 	if !ok {
 		errMsg := fmt.Sprintf("phone number %s not found", phoneNumber)
 		log.Error(errMsg)
-		return "", errors.New(errMsg)
+		return configuration.Extension{}, errors.New(errMsg)
 	}
-	log.Tracef("Found room name: %s, ID: %s for phone number: %s", room.HospitalityRoomName, room.HospitalityRoomID, phoneNumber)
+	log.Tracef("Found room name: %s, ID: %s, property ID: %s for phone number: %s", room.HospitalityRoomName, room.HospitalityRoomID, room.HospitalityPropertyID, phoneNumber)
 
-	return room.HospitalityRoomID, nil
+	return room, nil
 }
 
 // setOauth2Config sets oauth2 config from env variables
@@ -667,22 +1834,24 @@ func (p *Cloudbeds) getVarFromStoreOrEnvironment(varName string) (result string)
 		if err != nil {
 			p.log.Errorf("Got error while trying to get variable '%s' from store: %s", varName, err)
 		}
-		//obfuscate secret CLOUDBEDS_CLIENT_SECRET
-		if varName == "CLOUDBEDS_CLIENT_SECRET" {
-			//print last 4 symbols of CLOUDBEDS_CLIENT_SECRET
-			if len(result) > 4 {
-				result = "************" + result[len(result)-4:]
-			} else {
-				result = "****"
-			}
-		}
-		p.log.Debugf("The store is empty. Got variable '%s' from environment. Result: '%s'", varName, result)
+		p.log.Debugf("The store is empty. Got variable '%s' from environment. Result: '%s'", varName, loggedValue(varName, result))
 		return
 	}
-	p.log.Debugf("Got variable '%s' from store. Result: '%s'", varName, result)
+	p.log.Debugf("Got variable '%s' from store. Result: '%s'", varName, loggedValue(varName, result))
 	return
 }
 
+// loggedValue hides the API key and the client secret in the log. Only last 4 symbols are shown
+func loggedValue(varName, value string) string {
+	if varName != "CLOUDBEDS_API_KEY" && varName != "CLOUDBEDS_CLIENT_SECRET" {
+		return value
+	}
+	if len(value) > 4 {
+		return "************" + value[len(value)-4:]
+	}
+	return "****"
+}
+
 func (p *Cloudbeds) generateRandomString(length int) string {
 	bytes := make([]byte, length)
 	p.log.Debugf("Generating random string of length %d", length)
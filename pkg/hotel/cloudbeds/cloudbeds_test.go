@@ -19,9 +19,11 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // GoMockHTTPClient is a mock of HTTPClient interface.
@@ -101,9 +103,9 @@ func (m *GoMockTokenRefresher) EXPECT() *GoMockTokenRefresherGoMockRecorder {
 }
 
 // refreshToken mocks base method.
-func (m *GoMockTokenRefresher) refreshToken() error {
+func (m *GoMockTokenRefresher) refreshToken(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "refreshToken")
+	ret := m.ctrl.Call(m, "refreshToken", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
@@ -140,6 +142,16 @@ func (m *MockSecretsStore) RetrieveRefreshToken() (string, error) {
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockSecretsStore) StoreToken(token secrets.Token) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockSecretsStore) RetrieveToken() (secrets.Token, error) {
+	args := m.Called()
+	return args.Get(0).(secrets.Token), args.Error(1)
+}
+
 func (m *MockSecretsStore) StoreOauthState(state string) error {
 	args := m.Called(state)
 	return args.Error(0)
@@ -160,7 +172,7 @@ func (m *MockSecretsStore) Close() error {
 	return args.Error(0)
 }
 
-func (m *MockTokenRefresher) refreshToken() error {
+func (m *MockTokenRefresher) refreshToken(ctx context.Context) error {
 	return fmt.Errorf("refresh token error")
 }
 
@@ -296,10 +308,11 @@ func TestCloudbeds_Room_ToHotelRoom(t *testing.T) {
 	}
 }
 
-// Get implements the HTTPClient interface
-func (m *MockHTTPClient) Get(url string) (*http.Response, error) {
-	args := m.Called(url)
-	return args.Get(0).(*http.Response), args.Error(1)
+// getRequestTo matches GET request to url in MockHTTPClient.On("Do", ...)
+func getRequestTo(url string) interface{} {
+	return mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == http.MethodGet && req.URL.String() == url
+	})
 }
 
 func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
@@ -321,6 +334,8 @@ func cleanUpEnvVars() {
 		"CLOUDBEDS_AUTH_URL",
 		"CLOUDBEDS_TOKEN_URL",
 		"CLOUDBEDS_SCOPES",
+		"CLOUDBEDS_AUTH_MODE",
+		"CLOUDBEDS_API_KEY",
 	}
 	for _, key := range keys {
 		os.Unsetenv(key)
@@ -348,16 +363,21 @@ func TestCloudbeds_GetRooms(t *testing.T) {
 	}
 
 	generalMockUrl := "https://hotels.cloudbeds.com/api/v1.1/getRooms"
+	housekeepingMockUrl := "https://hotels.cloudbeds.com/api/v1.2/getHousekeepingStatus?pageNumber=1&pageSize=100&propertyID=297652"
+	reservationsMockUrl := "https://hotels.cloudbeds.com/api/v1.2/getReservations?includeGuestsDetails=true&pageNumber=1&pageSize=100&propertyID=297652&status=checked_in"
 
 	testCases := []struct {
-		desc             string
-		mockResp         string
-		mockStatus       int
-		mockError        error
-		mockUrl          string
-		expectedResponse []hotel.Room
-		expectedError    error
-		expectError      bool
+		desc                 string
+		mockResp             string
+		mockStatus           int
+		mockError            error
+		mockUrl              string
+		mockHousekeepingResp string
+		mockReservationsResp string
+		expectedResponse     []hotel.Room
+		expectedError        error
+		expectedErrorKind    error // hotel.ErrValidation, hotel.ErrAuthorization or hotel.ErrTransient. Only the message of expectedError is compared
+		expectError          bool
 	}{
 		{
 			desc: "Successful Response",
@@ -365,12 +385,54 @@ func TestCloudbeds_GetRooms(t *testing.T) {
 				"success": true,
 				"data": [{"propertyID": "297652", "rooms": [{"roomID": "544559-0"}]}]
 			}`,
+			mockStatus:           http.StatusOK,
+			mockError:            nil,
+			mockUrl:              generalMockUrl,
+			mockHousekeepingResp: `{"success": true, "data": [], "count": 0, "total": 0}`,
+			mockReservationsResp: `{"success": true, "data": [], "count": 0, "total": 0}`,
+			expectedResponse: []hotel.Room{
+				{
+					RoomID:     "544559-0",
+					PropertyID: "297652",
+				},
+			},
+			expectedError: nil,
+			expectError:   false,
+		},
+		{
+			desc: "Successful Response with room state",
+			mockResp: `{
+				"success": true,
+				"data": [{"propertyID": "297652", "rooms": [{"roomID": "544559-0", "roomName": "DQ(1)"}, {"roomID": "544559-1", "roomName": "DQ(2)"}]}]
+			}`,
 			mockStatus: http.StatusOK,
 			mockError:  nil,
 			mockUrl:    generalMockUrl,
+			mockHousekeepingResp: `{"success": true, "data": [
+				{"roomID": "544559-0", "roomName": "DQ(1)", "roomCondition": "dirty", "roomOccupied": true},
+				{"roomID": "544559-1", "roomName": "DQ(2)", "roomCondition": "clean", "roomOccupied": false}
+			], "count": 2, "total": 2}`,
+			mockReservationsResp: `{"success": true, "data": [
+				{"reservationID": "9876543210", "guestName": "Jane Smith", "status": "checked_in", "guestList": {"1": {"guestID": "1", "guestFirstName": "Jane", "guestLastName": "Smith", "isMainGuest": true, "roomID": "544559-0"}}}
+			], "count": 1, "total": 1}`,
 			expectedResponse: []hotel.Room{
 				{
-					RoomID: "544559-0",
+					RoomID:        "544559-0",
+					RoomName:      "DQ(1)",
+					RoomCondition: "dirty",
+					RoomOccupied:  true,
+					Guest: &hotel.Guest{
+						ReservationID: "9876543210",
+						FirstName:     "Jane",
+						LastName:      "Smith",
+					},
+					PropertyID: "297652",
+				},
+				{
+					RoomID:        "544559-1",
+					RoomName:      "DQ(2)",
+					RoomCondition: "clean",
+					PropertyID:    "297652",
 				},
 			},
 			expectedError: nil,
@@ -382,12 +444,36 @@ func TestCloudbeds_GetRooms(t *testing.T) {
 				"success": false,
 				"message": "Something went wrong"
 			}`,
-			mockStatus:       http.StatusOK,
-			mockError:        nil,
-			mockUrl:          generalMockUrl,
-			expectedResponse: []hotel.Room{},
-			expectedError:    errors.New("refresh token error"),
-			expectError:      true,
+			mockStatus:        http.StatusOK,
+			mockError:         nil,
+			mockUrl:           generalMockUrl,
+			expectedResponse:  []hotel.Room{},
+			expectedError:     errors.New("failed to get rooms: Something went wrong"),
+			expectedErrorKind: hotel.ErrValidation,
+			expectError:       true,
+		},
+		{
+			desc:              "No rooms",
+			mockResp:          `{"success": true, "data": [], "count": 0, "total": 0}`,
+			mockStatus:        http.StatusOK,
+			mockUrl:           generalMockUrl,
+			expectedError:     errors.New("success, but no rooms found"),
+			expectedErrorKind: hotel.ErrValidation,
+			expectError:       true,
+		},
+		{
+			desc: "Expired access token",
+			mockResp: `{
+				"success": false,
+				"message": "Access token expired"
+			}`,
+			mockStatus:        http.StatusUnauthorized,
+			mockError:         nil,
+			mockUrl:           generalMockUrl,
+			expectedResponse:  []hotel.Room{},
+			expectedError:     errors.New("failed to get rooms: refresh token error"),
+			expectedErrorKind: hotel.ErrAuthorization,
+			expectError:       true,
 		},
 		{
 			desc: "Bad JSON Format",
@@ -420,8 +506,9 @@ func TestCloudbeds_GetRooms(t *testing.T) {
 					RoomID: "544559-0",
 				},
 			},
-			expectedError: errors.New("request failed with: Not found"),
-			expectError:   true,
+			expectedError:     errors.New("request failed with: Not found"),
+			expectedErrorKind: hotel.ErrTransient,
+			expectError:       true,
 		},
 	}
 
@@ -441,11 +528,26 @@ func TestCloudbeds_GetRooms(t *testing.T) {
 				StatusCode: tc.mockStatus,
 				Body:       io.NopCloser(bytes.NewBufferString(tc.mockResp)),
 			}
-			mockClient.On("Get", cb.apiUrlGetRooms).Return(resp, tc.mockError)
+			mockClient.On("Do", getRequestTo(cb.apiUrlGetRooms+"?pageNumber=1&pageSize=100")).Return(resp, tc.mockError)
+			if tc.mockHousekeepingResp != "" {
+				mockClient.On("Do", getRequestTo(housekeepingMockUrl)).Return(&http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(tc.mockHousekeepingResp)),
+				}, nil)
+			}
+			if tc.mockReservationsResp != "" {
+				mockClient.On("Do", getRequestTo(reservationsMockUrl)).Return(&http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(tc.mockReservationsResp)),
+				}, nil)
+			}
 
-			testResult, err := cb.GetRooms()
+			testResult, err := cb.GetRooms(context.Background())
 
-			if tc.expectError {
+			if tc.expectError && tc.expectedErrorKind != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+				assert.ErrorIs(t, err, tc.expectedErrorKind)
+			} else if tc.expectError {
 				assert.NotNil(t, err, "Expected error but got none")
 				assert.Equal(t, tc.expectedError, err)
 			} else {
@@ -459,6 +561,58 @@ func TestCloudbeds_GetRooms(t *testing.T) {
 
 }
 
+func TestCloudbeds_GetRooms_PaginationAndProperties(t *testing.T) {
+	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "100", r.URL.Query().Get("pageSize"))
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/api/v1.2/getRooms":
+			switch r.URL.Query().Get("pageNumber") {
+			case "1":
+				_, _ = w.Write([]byte(`{"success":true,"data":[{"propertyID":"1001","rooms":[{"roomID":"1-0"},{"roomID":"1-1"}]},{"propertyID":"2002","rooms":[{"roomID":"2-0"}]}],"count":3,"total":5}`))
+			case "2":
+				_, _ = w.Write([]byte(`{"success":true,"data":[{"propertyID":"2002","rooms":[{"roomID":"2-1"},{"roomID":"2-2"}]}],"count":2,"total":5}`))
+			default:
+				t.Errorf("unexpected page %s", r.URL.Query().Get("pageNumber"))
+			}
+		case "/api/v1.2/getHousekeepingStatus":
+			switch r.URL.Query().Get("propertyID") {
+			case "1001":
+				_, _ = w.Write([]byte(`{"success":true,"data":[{"roomID":"1-0","roomCondition":"clean"},{"roomID":"1-1","roomCondition":"dirty"}],"count":2,"total":2}`))
+			case "2002":
+				_, _ = w.Write([]byte(`{"success":true,"data":[{"roomID":"2-0","roomCondition":"clean"},{"roomID":"2-1","roomCondition":"clean"},{"roomID":"2-2","roomCondition":"dirty"}],"count":3,"total":3}`))
+			default:
+				t.Errorf("unexpected property %s", r.URL.Query().Get("propertyID"))
+			}
+		case "/api/v1.2/getReservations":
+			_, _ = w.Write([]byte(`{"success":true,"data":[],"count":0,"total":0}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	cb := &Cloudbeds{
+		httpClient:                  mockServer.Client(),
+		log:                         logrus.New(),
+		apiUrlGetRooms:              mockServer.URL + "/api/v1.2/getRooms",
+		apiUrlGetHousekeepingStatus: mockServer.URL + "/api/v1.2/getHousekeepingStatus",
+		apiUrlGetReservations:       mockServer.URL + "/api/v1.2/getReservations",
+	}
+
+	rooms, err := cb.GetRooms(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []hotel.Room{
+		{RoomID: "1-0", RoomCondition: "clean", PropertyID: "1001"},
+		{RoomID: "1-1", RoomCondition: "dirty", PropertyID: "1001"},
+		{RoomID: "2-0", RoomCondition: "clean", PropertyID: "2002"},
+		{RoomID: "2-1", RoomCondition: "clean", PropertyID: "2002"},
+		{RoomID: "2-2", RoomCondition: "dirty", PropertyID: "2002"},
+	}, rooms)
+}
+
 func TestCloudbeds_Room_SearchRoomIDByPhoneNumber(t *testing.T) {
 	cleanUpEnvVars()
 	log := logrus.New()
@@ -579,6 +733,62 @@ func TestCloudbeds_Room_SearchRoomIDByPhoneNumber(t *testing.T) {
 	}
 }
 
+func TestCloudbeds_Room_SearchExtensionByPhoneNumber(t *testing.T) {
+	cleanUpEnvVars()
+	log := logrus.New()
+
+	extensionsInfo := []configuration.Extension{
+		{
+			RoomExtension:         "100",
+			HospitalityRoomID:     "544559-0",
+			HospitalityRoomName:   "DQ(1)",
+			HospitalityPropertyID: "297652",
+		},
+		{
+			RoomExtension:       "101",
+			HospitalityRoomID:   "544559-1",
+			HospitalityRoomName: "DQ(2)",
+		},
+	}
+
+	tests := []struct {
+		name        string
+		phoneNumber string
+		want        configuration.Extension
+		wantErr     assert.ErrorAssertionFunc
+	}{
+		{
+			name:        "with property",
+			phoneNumber: "100",
+			want:        extensionsInfo[0],
+			wantErr:     assert.NoError,
+		},
+		{
+			name:        "without property",
+			phoneNumber: "101",
+			want:        extensionsInfo[1],
+			wantErr:     assert.NoError,
+		},
+		{
+			name:        "not found",
+			phoneNumber: "987654321",
+			want:        configuration.Extension{},
+			wantErr:     assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Room{}
+			got, err := r.SearchExtensionByPhoneNumber(log, tt.phoneNumber, extensionsInfo)
+			if !tt.wantErr(t, err, fmt.Sprintf("SearchExtensionByPhoneNumber(%v)", tt.phoneNumber)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "SearchExtensionByPhoneNumber(%v)", tt.phoneNumber)
+		})
+	}
+}
+
 func TestCloudbeds_postHousekeepingStatus(t *testing.T) {
 	cleanUpEnvVars()
 	mockRefresher := new(MockTokenRefresher)
@@ -633,7 +843,50 @@ func TestCloudbeds_postHousekeepingStatus(t *testing.T) {
 				roomCondition: "clean",
 			},
 			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
-				return assert.EqualError(t, err, "Failed to update room status: refresh token error")
+				return assert.EqualError(t, err, "failed to update room status: Invalid roomID") && assert.ErrorIs(t, err, hotel.ErrValidation)
+			},
+		},
+		{
+			name: "expired access token",
+			fields: fields{
+				responseJSON: `{"success":false,"message":"Access token expired"}`,
+				httpStatus:   http.StatusUnauthorized,
+			},
+			args: args{
+				roomID:        "1",
+				roomCondition: "clean",
+			},
+			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				return assert.EqualError(t, err, "failed to update room status: refresh token error") && assert.ErrorIs(t, err, hotel.ErrAuthorization)
+			},
+		},
+		{
+			name: "success without room in the reply",
+			fields: fields{
+				responseJSON: `{"success":true,"data":{}}`,
+				httpStatus:   http.StatusOK,
+			},
+			args: args{
+				roomID:        "1",
+				roomCondition: "clean",
+			},
+			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				var detailedError *hotel.DetailedError
+				return assert.EqualError(t, err, "success, but return body is empty") && assert.ErrorAs(t, err, &detailedError)
+			},
+		},
+		{
+			name: "server error is not treated as expired token",
+			fields: fields{
+				responseJSON: `<html>Bad Gateway</html>`,
+				httpStatus:   http.StatusBadGateway,
+			},
+			args: args{
+				roomID:        "1",
+				roomCondition: "clean",
+			},
+			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				return assert.EqualError(t, err, "cloudbeds request failed with status 502") && assert.ErrorIs(t, err, hotel.ErrTransient)
 			},
 		},
 	}
@@ -655,7 +908,7 @@ func TestCloudbeds_postHousekeepingStatus(t *testing.T) {
 				Body:       io.NopCloser(bytes.NewBufferString(tt.fields.responseJSON)),
 			}
 			mockClient.On("Do", mock.AnythingOfType("*http.Request")).Return(mockResponse, nil)
-			err := cb.postHousekeepingStatus(tt.args.roomID, tt.args.roomCondition)
+			err := cb.postHousekeepingStatus(context.Background(), UpdateRoomConditionRequest{RoomID: tt.args.roomID, RoomCondition: tt.args.roomCondition})
 			tt.wantErr(t, err)
 		})
 	}
@@ -666,18 +919,34 @@ func TestCloudbeds_UpdateRoom(t *testing.T) {
 	cleanUpEnvVars()
 	// Create a mock HTTP server
 	postURL := "/api/v1.1/updateRoomCondition"
+	var assignments []string
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// housekeeper assignment follows every successful status update
+		switch r.URL.Path {
+		case "/api/v1.2/getHousekeepers":
+			_, _ = w.Write([]byte(`{"success":true,"data":[{"housekeeperID":"77","name":"John Doe"}],"count":1,"total":1}`))
+			return
+		case "/api/v1.2/postHousekeepingAssignment":
+			bodyBytes, _ := io.ReadAll(r.Body)
+			assignments = append(assignments, string(bodyBytes))
+			_, _ = w.Write([]byte(`{"success":true}`))
+			return
+		}
+
 		// Check that the request is a POST request
 		assert.Equal(t, http.MethodPost, r.Method)
 
 		// Check that the request URL matches the expected URL
 		assert.Equal(t, postURL, r.URL.Path)
 
-		// Check that the request body contains the expected JSON payload
-		expectedBody := "roomCondition=clean&roomID=544559-0"
+		// Check that the request body contains the expected JSON payload. propertyID is sent only for rooms mapped to a property
+		expectedBodies := []string{
+			"roomCondition=clean&roomID=544559-0",
+			"propertyID=297652&roomCondition=clean&roomID=544560-0",
+		}
 		bodyBytes, err := io.ReadAll(r.Body)
 		assert.NoError(t, err)
-		assert.Equal(t, expectedBody, string(bodyBytes))
+		assert.Contains(t, expectedBodies, string(bodyBytes))
 
 		// Return a successful response
 		w.WriteHeader(http.StatusOK)
@@ -702,6 +971,14 @@ func TestCloudbeds_UpdateRoom(t *testing.T) {
 			false,
 			"",
 		},
+		{
+			"Valid Room 2 with property",
+			"124",
+			"clean",
+			"Finish UpdateRoom successfully updated room 124 to clean",
+			false,
+			"",
+		},
 		{
 			"Invalid Room 1",
 			"invalid",
@@ -727,7 +1004,9 @@ func TestCloudbeds_UpdateRoom(t *testing.T) {
 				httpClient:                   mockServer.Client(),
 				log:                          logrus.New(),
 				apiUrlPostHousekeepingStatus: mockServer.URL + "/api/v1.1/updateRoomCondition",
-				roomStatuses:                 []string{"clean", "dirty"},
+				apiUrlGetHousekeepers:        mockServer.URL + "/api/v1.2/getHousekeepers",
+				apiUrlPostHousekeepingAssign: mockServer.URL + "/api/v1.2/postHousekeepingAssignment",
+				roomStatuses:                 hotel.NewRoomConditionSet("clean", "dirty"),
 				configMap: &configuration.ConfigMap{
 					ExtensionMap: []configuration.Extension{
 						{
@@ -735,12 +1014,18 @@ func TestCloudbeds_UpdateRoom(t *testing.T) {
 							HospitalityRoomID:   "544559-0",
 							HospitalityRoomName: "DQ(1)",
 						},
+						{
+							RoomExtension:         "124",
+							HospitalityRoomID:     "544560-0",
+							HospitalityRoomName:   "DK(1)",
+							HospitalityPropertyID: "297652",
+						},
 					},
 				},
 			}
 
 			// Call the UpdateRoom function with test data
-			msg, err := cb.UpdateRoom(tt.roomExtension, tt.condition, "John Doe")
+			msg, err := cb.UpdateRoom(context.Background(), tt.roomExtension, tt.condition, "John Doe")
 
 			// Check the function's output
 			if tt.expectErr {
@@ -753,6 +1038,400 @@ func TestCloudbeds_UpdateRoom(t *testing.T) {
 		})
 	}
 
+	// both successful updates record the housekeeper
+	assert.Equal(t, []string{
+		"housekeeperID=77&roomIDs=544559-0",
+		"housekeeperID=77&propertyID=297652&roomIDs=544560-0",
+	}, assignments)
+
+	// invalid room condition is not a failure of Cloudbeds
+	cb := &Cloudbeds{
+		log:          logrus.New(),
+		roomStatuses: hotel.NewRoomConditionSet("clean", "dirty"),
+		configMap:    &configuration.ConfigMap{ExtensionMap: []configuration.Extension{{RoomExtension: "123", HospitalityRoomID: "544559-0"}}},
+	}
+	_, err := cb.UpdateRoom(context.Background(), "123", "dristed", "John Doe")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+}
+
+func TestCloudbeds_searchHousekeeperID(t *testing.T) {
+	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/api/v1.2/getHousekeepers", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"success":true,"data":[{"housekeeperID":"77","name":"John Doe"},{"housekeeperID":"78","name":"Madonna"}],"count":2,"total":2}`))
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name            string
+		housekeeperName string
+		want            string
+		wantErr         string
+	}{
+		{name: "ID from housekeeper_map", housekeeperName: "Michael Jackson", want: "99"},
+		{name: "Name from Cloudbeds, case insensitive", housekeeperName: "john doe", want: "77"},
+		{name: "ID from Cloudbeds", housekeeperName: "78", want: "78"},
+		{name: "Not found", housekeeperName: "Elvis", wantErr: "housekeeper Elvis not found in Cloudbeds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &Cloudbeds{
+				httpClient:            mockServer.Client(),
+				log:                   logrus.New(),
+				apiUrlGetHousekeepers: mockServer.URL + "/api/v1.2/getHousekeepers",
+				configMap: &configuration.ConfigMap{
+					HousekeeperMap: []configuration.Housekeeper{
+						{
+							RoomStatusPhoneNumber:    "2222222221",
+							HousekeeperName:          "Michael Jackson",
+							NumberType:               "dirty",
+							HospitalityHousekeeperID: "99",
+						},
+					},
+				},
+			}
+
+			got, err := cb.searchHousekeeperID(context.Background(), tt.housekeeperName, "")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCloudbeds_UpdateRoomDoNotDisturb(t *testing.T) {
+	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+
+		// roomCondition must not be sent, so the current condition of the room is kept
+		bodyBytes, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		values, err := url.ParseQuery(string(bodyBytes))
+		assert.NoError(t, err)
+		assert.Equal(t, "544559-0", values.Get("roomID"))
+		assert.False(t, values.Has("roomCondition"))
+
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte(fmt.Sprintf(`{"success":true,"data":{"date":"2022-01-01","roomID":"544559-0","roomCondition":"dirty","doNotDisturb":%s}}`, values.Get("doNotDisturb"))))
+		assert.NoError(t, err)
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name          string
+		roomExtension string
+		doNotDisturb  bool
+		expectedMsg   string
+		errMsg        string
+	}{
+		{
+			name:          "Do not disturb on",
+			roomExtension: "123",
+			doNotDisturb:  true,
+			expectedMsg:   "Finish UpdateRoomDoNotDisturb successfully updated room 123. Do not disturb: true",
+		},
+		{
+			name:          "Do not disturb off",
+			roomExtension: "123",
+			doNotDisturb:  false,
+			expectedMsg:   "Finish UpdateRoomDoNotDisturb successfully updated room 123. Do not disturb: false",
+		},
+		{
+			name:          "Invalid room",
+			roomExtension: "invalid",
+			doNotDisturb:  true,
+			errMsg:        "phone number invalid not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &Cloudbeds{
+				httpClient:                   mockServer.Client(),
+				log:                          logrus.New(),
+				apiUrlPostHousekeepingStatus: mockServer.URL + "/api/v1.2/postHousekeepingStatus",
+				configMap: &configuration.ConfigMap{
+					ExtensionMap: []configuration.Extension{
+						{
+							RoomExtension:       "123",
+							HospitalityRoomID:   "544559-0",
+							HospitalityRoomName: "DQ(1)",
+						},
+					},
+				},
+			}
+
+			msg, err := cb.UpdateRoomDoNotDisturb(context.Background(), tt.roomExtension, tt.doNotDisturb, "John Doe")
+			if tt.errMsg != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errMsg, err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedMsg, msg)
+			}
+		})
+	}
+}
+
+// rules update rooms as hotel.SystemHousekeeperName. Cloudbeds has no such housekeeper: it is not searched and not assigned
+func TestCloudbeds_UpdateRoom_SystemHousekeeper(t *testing.T) {
+	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1.1/updateRoomCondition":
+			_, _ = w.Write([]byte(`{"success":true,"data":{"roomID":"544559-0","roomCondition":"dirty"}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	cb := &Cloudbeds{
+		httpClient:                   mockServer.Client(),
+		log:                          logrus.New(),
+		apiUrlPostHousekeepingStatus: mockServer.URL + "/api/v1.1/updateRoomCondition",
+		apiUrlGetHousekeepers:        mockServer.URL + "/api/v1.2/getHousekeepers",
+		apiUrlPostHousekeepingAssign: mockServer.URL + "/api/v1.2/postHousekeepingAssignment",
+		roomStatuses:                 hotel.NewRoomConditionSet("clean", "dirty"),
+		configMap: &configuration.ConfigMap{
+			ExtensionMap: []configuration.Extension{{RoomExtension: "123", HospitalityRoomID: "544559-0"}},
+		},
+	}
+
+	msg, err := cb.UpdateRoom(context.Background(), "123", "dirty", hotel.SystemHousekeeperName)
+	assert.NoError(t, err)
+	assert.Equal(t, "Finish UpdateRoom successfully updated room 123 to dirty", msg)
+}
+
+func TestCloudbeds_UpdateRoomBlock(t *testing.T) {
+	cleanUpEnvVars()
+	today := time.Now().Format("2006-01-02")
+	var deletedBlocks, updatedBlocks []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/api/v1.2/postRoomBlock":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "out_of_service", r.PostForm.Get("roomBlockType"))
+			assert.Equal(t, "AC repair (Engineer)", r.PostForm.Get("roomBlockReason"))
+			assert.Equal(t, today, r.PostForm.Get("startDate"))
+			assert.Equal(t, time.Now().AddDate(0, 0, 30).Format("2006-01-02"), r.PostForm.Get("endDate"))
+			assert.Equal(t, "544559-0", r.PostForm.Get("rooms[0][roomID]"))
+			assert.Equal(t, "297652", r.PostForm.Get("propertyID"))
+			_, _ = w.Write([]byte(`{"success":true,"roomBlockID":"1001","roomBlockType":"out_of_service"}`))
+		case "/api/v1.2/getRoomBlocks":
+			assert.Equal(t, http.MethodGet, r.Method)
+			assert.Equal(t, "544559-0", r.URL.Query().Get("roomID"))
+			assert.Equal(t, today, r.URL.Query().Get("startDate"))
+			_, _ = w.Write([]byte(`{"success":true,"data":[
+				{"roomBlockID":"1001","roomBlockType":"out_of_service","rooms":[{"roomID":"544559-0"}]},
+				{"roomBlockID":"1002","roomBlockType":"courtesy_hold","rooms":[{"roomID":"544559-0"}]},
+				{"roomBlockID":"1003","roomBlockType":"out_of_service","roomBlockReason":"Renovation","startDate":"2023-08-01","endDate":"2023-09-01","rooms":[{"roomID":"544559-0"},{"roomID":"544559-1"}]},
+				{"roomBlockID":"1004","roomBlockType":"out_of_service","rooms":[{"roomID":"544559-2"}]}
+			],"count":4,"total":4}`))
+		case "/api/v1.2/putRoomBlock":
+			assert.Equal(t, http.MethodPut, r.Method)
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "297652", r.PostForm.Get("propertyID"))
+			assert.Equal(t, "Renovation", r.PostForm.Get("roomBlockReason"))
+			assert.Equal(t, "2023-08-01", r.PostForm.Get("startDate"))
+			assert.Equal(t, "2023-09-01", r.PostForm.Get("endDate"))
+			assert.Equal(t, "544559-1", r.PostForm.Get("rooms[0][roomID]"))
+			assert.Empty(t, r.PostForm.Get("rooms[1][roomID]"))
+			updatedBlocks = append(updatedBlocks, r.PostForm.Get("roomBlockID"))
+			_, _ = w.Write([]byte(`{"success":true}`))
+		case "/api/v1.2/deleteRoomBlock":
+			assert.Equal(t, http.MethodDelete, r.Method)
+			deletedBlocks = append(deletedBlocks, r.URL.Query().Get("roomBlockID"))
+			_, _ = w.Write([]byte(`{"success":true}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name          string
+		roomExtension string
+		blocked       bool
+		expectedMsg   string
+		errMsg        string
+	}{
+		{
+			name:          "Block room",
+			roomExtension: "123",
+			blocked:       true,
+			expectedMsg:   "Finish UpdateRoomBlock successfully blocked room 123. Block: 1001",
+		},
+		{
+			name:          "Unblock room",
+			roomExtension: "123",
+			blocked:       false,
+			expectedMsg:   "Finish UpdateRoomBlock successfully unblocked room 123. Removed blocks: 2",
+		},
+		{
+			name:          "Invalid room",
+			roomExtension: "invalid",
+			blocked:       true,
+			errMsg:        "phone number invalid not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &Cloudbeds{
+				httpClient:            mockServer.Client(),
+				log:                   logrus.New(),
+				apiUrlPostRoomBlock:   mockServer.URL + "/api/v1.2/postRoomBlock",
+				apiUrlGetRoomBlocks:   mockServer.URL + "/api/v1.2/getRoomBlocks",
+				apiUrlDeleteRoomBlock: mockServer.URL + "/api/v1.2/deleteRoomBlock",
+				apiUrlPutRoomBlock:    mockServer.URL + "/api/v1.2/putRoomBlock",
+				roomBlockDays:         30,
+				configMap: &configuration.ConfigMap{
+					ExtensionMap: []configuration.Extension{
+						{
+							RoomExtension:         "123",
+							HospitalityRoomID:     "544559-0",
+							HospitalityRoomName:   "DQ(1)",
+							HospitalityPropertyID: "297652",
+						},
+					},
+				},
+			}
+
+			msg, err := cb.UpdateRoomBlock(context.Background(), tt.roomExtension, tt.blocked, "AC repair", "Engineer")
+			if tt.errMsg != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errMsg, err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedMsg, msg)
+			}
+		})
+	}
+
+	// the out of service block of this room is removed, the room is removed from the block of several rooms
+	assert.Equal(t, []string{"1001"}, deletedBlocks)
+	assert.Equal(t, []string{"1003"}, updatedBlocks)
+}
+
+func TestCloudbeds_PostCharge(t *testing.T) {
+	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/api/v1.2/getReservations":
+			if r.URL.Query().Get("roomID") == "544559-0" {
+				_, _ = w.Write([]byte(`{"success":true,"data":[{"reservationID":"9876543210","guestName":"Jane Smith","status":"checked_in"}],"count":1,"total":1}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"success":true,"data":[],"count":0,"total":0}`))
+		case "/api/v1.2/postCustomItem":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "9876543210", r.PostForm.Get("reservationID"))
+			assert.Equal(t, "544559-0", r.PostForm.Get("roomID"))
+			assert.NotEmpty(t, r.PostForm.Get("referenceID"))
+			assert.Equal(t, "SKU-BEER", r.PostForm.Get("items[0][appItemID]"))
+			assert.Equal(t, "Beer", r.PostForm.Get("items[0][itemName]"))
+			assert.Equal(t, "2", r.PostForm.Get("items[0][itemQuantity]"))
+			assert.Equal(t, "5.50", r.PostForm.Get("items[0][itemPrice]"))
+			assert.Equal(t, "Posted by John Doe", r.PostForm.Get("items[0][itemNote]"))
+			_, _ = w.Write([]byte(`{"success":true,"data":{"soldProductID":"555"}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name          string
+		roomExtension string
+		itemCode      string
+		quantity      int
+		expectedMsg   string
+		errMsg        string
+		validation    bool
+	}{
+		{
+			name:          "Charge posted",
+			roomExtension: "123",
+			itemCode:      "12",
+			quantity:      2,
+			expectedMsg:   "Finish PostCharge successfully posted 2 x Beer to room 123. Reservation: 9876543210, sold product: 555",
+		},
+		{
+			name:          "Unknown item",
+			roomExtension: "123",
+			itemCode:      "99",
+			quantity:      1,
+			errMsg:        "item 99 not found in item catalog",
+			validation:    true,
+		},
+		{
+			name:          "Vacant room",
+			roomExtension: "124",
+			itemCode:      "12",
+			quantity:      1,
+			errMsg:        "no in-house reservation found for room 124",
+			validation:    true,
+		},
+		{
+			name:          "Invalid quantity",
+			roomExtension: "123",
+			itemCode:      "12",
+			quantity:      0,
+			errMsg:        "quantity 0 is not valid",
+			validation:    true,
+		},
+		{
+			name:          "Invalid room",
+			roomExtension: "invalid",
+			itemCode:      "12",
+			quantity:      1,
+			errMsg:        "phone number invalid not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &Cloudbeds{
+				httpClient:            mockServer.Client(),
+				log:                   logrus.New(),
+				apiUrlGetReservations: mockServer.URL + "/api/v1.2/getReservations",
+				apiUrlPostCustomItem:  mockServer.URL + "/api/v1.2/postCustomItem",
+				configMap: &configuration.ConfigMap{
+					ExtensionMap: []configuration.Extension{
+						{RoomExtension: "123", HospitalityRoomID: "544559-0", HospitalityRoomName: "DQ(1)"},
+						{RoomExtension: "124", HospitalityRoomID: "544559-1", HospitalityRoomName: "DQ(2)"},
+					},
+					ItemCatalog: []configuration.Item{
+						{ItemCode: "12", Name: "Beer", Price: 5.5, HospitalityItemID: "SKU-BEER"},
+					},
+				},
+			}
+
+			msg, err := cb.PostCharge(context.Background(), tt.roomExtension, tt.itemCode, tt.quantity, "John Doe")
+			if tt.errMsg != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errMsg, err.Error())
+				if tt.validation {
+					assert.ErrorIs(t, err, hotel.ErrValidation)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedMsg, msg)
+			}
+		})
+	}
 }
 
 // Add your test cases
@@ -838,28 +1517,82 @@ func TestCloudbeds_setOauth2Config(t *testing.T) {
 
 func TestCloudbeds_GetRoom(t *testing.T) {
 	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/api/v1.2/getHousekeepingStatus":
+			_, _ = w.Write([]byte(`{"success":true,"data":[{"roomID":"544559-0","roomName":"DQ(1)","roomCondition":"dirty","roomOccupied":true},{"roomID":"544559-1","roomName":"DQ(2)","roomCondition":"clean","roomOccupied":false}],"count":2,"total":2}`))
+		case "/api/v1.2/getReservations":
+			assert.Equal(t, "544559-0", r.URL.Query().Get("roomID"))
+			_, _ = w.Write([]byte(`{"success":true,"data":[{"reservationID":"9876543210","guestName":"Jane Smith","status":"checked_in","guestList":{"1":{"guestID":"1","guestFirstName":"Jane","guestLastName":"Smith","isMainGuest":true,"roomID":"544559-0"}}}],"count":1,"total":1}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	extensionMap := []configuration.Extension{
+		{
+			RoomExtension:       "123",
+			HospitalityRoomID:   "544559-0",
+			HospitalityRoomName: "DQ(1)",
+		},
+		{
+			RoomExtension:       "124",
+			HospitalityRoomID:   "544559-1",
+			HospitalityRoomName: "DQ(2)",
+		},
+		{
+			RoomExtension:       "125",
+			HospitalityRoomID:   "544559-2",
+			HospitalityRoomName: "DQ(3)",
+		},
+	}
+
 	tests := []struct {
 		name           string
 		roomNumber     string
 		extensionMap   []configuration.Extension
 		expectedRoom   hotel.Room
 		expectedErrMsg string
+		validation     bool
 	}{
 		{
-			name:       "Valid room number",
-			roomNumber: "123",
-			extensionMap: []configuration.Extension{
-				{
-					RoomExtension:       "123",
-					HospitalityRoomID:   "544559-0",
-					HospitalityRoomName: "DQ(1)",
+			name:         "Occupied room",
+			roomNumber:   "123",
+			extensionMap: extensionMap,
+			expectedRoom: hotel.Room{
+				RoomID:        "544559-0",
+				RoomName:      "DQ(1)",
+				PhoneNumber:   "123",
+				RoomCondition: "dirty",
+				RoomOccupied:  true,
+				Guest: &hotel.Guest{
+					ReservationID: "9876543210",
+					FirstName:     "Jane",
+					LastName:      "Smith",
 				},
 			},
+		},
+		{
+			name:         "Vacant room",
+			roomNumber:   "124",
+			extensionMap: extensionMap,
 			expectedRoom: hotel.Room{
-				RoomID:      "544559-0",
-				PhoneNumber: "123",
+				RoomID:        "544559-1",
+				RoomName:      "DQ(2)",
+				PhoneNumber:   "124",
+				RoomCondition: "clean",
 			},
 		},
+		{
+			name:           "Room without housekeeping status",
+			roomNumber:     "125",
+			extensionMap:   extensionMap,
+			expectedErrMsg: "housekeeping status for room 125 not found",
+			validation:     true,
+		},
 		{
 			name:           "Invalid room number",
 			roomNumber:     "invalid",
@@ -873,15 +1606,9 @@ func TestCloudbeds_GetRoom(t *testing.T) {
 			expectedErrMsg: "phone number  not found",
 		},
 		{
-			name:       "No matching room extension",
-			roomNumber: "999",
-			extensionMap: []configuration.Extension{
-				{
-					RoomExtension:       "123",
-					HospitalityRoomID:   "544559-0",
-					HospitalityRoomName: "DQ(1)",
-				},
-			},
+			name:           "No matching room extension",
+			roomNumber:     "999",
+			extensionMap:   extensionMap,
 			expectedErrMsg: "phone number 999 not found",
 		},
 	}
@@ -889,16 +1616,22 @@ func TestCloudbeds_GetRoom(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cb := &Cloudbeds{
-				log: logrus.New(),
+				httpClient:                  mockServer.Client(),
+				log:                         logrus.New(),
+				apiUrlGetHousekeepingStatus: mockServer.URL + "/api/v1.2/getHousekeepingStatus",
+				apiUrlGetReservations:       mockServer.URL + "/api/v1.2/getReservations",
 				configMap: &configuration.ConfigMap{
 					ExtensionMap: tt.extensionMap,
 				},
 			}
 
-			actualRoom, err := cb.GetRoom(tt.roomNumber, "map.json")
+			actualRoom, err := cb.GetRoom(context.Background(), tt.roomNumber, "map.json")
 			if tt.expectedErrMsg != "" {
 				assert.Error(t, err)
 				assert.Equal(t, tt.expectedErrMsg, err.Error())
+				if tt.validation {
+					assert.ErrorIs(t, err, hotel.ErrValidation)
+				}
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedRoom, actualRoom)
@@ -907,6 +1640,90 @@ func TestCloudbeds_GetRoom(t *testing.T) {
 	}
 }
 
+func TestCloudbeds_GetRoomGuest(t *testing.T) {
+	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/api/v1.2/getReservations", r.URL.Path)
+		assert.Equal(t, "checked_in", r.URL.Query().Get("status"))
+		assert.Equal(t, "true", r.URL.Query().Get("includeGuestsDetails"))
+
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Query().Get("roomID") {
+		case "544559-0":
+			_, _ = w.Write([]byte(`{"success":true,"data":[{"propertyID":"297652","reservationID":"9876543210","guestID":"2","guestName":"Jane Smith","status":"checked_in","guestList":{"1":{"guestID":"1","guestFirstName":"John","guestLastName":"Smith","isMainGuest":false,"roomID":"544559-0"},"2":{"guestID":"2","guestFirstName":"Jane","guestLastName":"Smith","guestEmail":"jane.smith@example.com","isMainGuest":true,"roomID":"544559-0"}}}],"count":1,"total":1}`))
+		case "544559-1":
+			_, _ = w.Write([]byte(`{"success":true,"data":[{"propertyID":"297652","reservationID":"1234567890","guestName":"Mary Ann Jones","status":"checked_in"}],"count":1,"total":1}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":true,"data":[],"count":0,"total":0}`))
+		}
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name           string
+		roomNumber     string
+		expectedGuest  hotel.Guest
+		expectedErrMsg string
+	}{
+		{
+			name:       "Main guest from guest list",
+			roomNumber: "1001",
+			expectedGuest: hotel.Guest{
+				ReservationID: "9876543210",
+				FirstName:     "Jane",
+				LastName:      "Smith",
+				Email:         "jane.smith@example.com",
+			},
+		},
+		{
+			name:       "Guest from reservation guestName",
+			roomNumber: "1002",
+			expectedGuest: hotel.Guest{
+				ReservationID: "1234567890",
+				FirstName:     "Mary",
+				LastName:      "Ann Jones",
+			},
+		},
+		{
+			name:           "Room without in-house reservation",
+			roomNumber:     "1003",
+			expectedErrMsg: "no in-house reservation found for room 1003",
+		},
+		{
+			name:           "Unknown room extension",
+			roomNumber:     "999",
+			expectedErrMsg: "phone number 999 not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &Cloudbeds{
+				httpClient:            mockServer.Client(),
+				log:                   logrus.New(),
+				apiUrlGetReservations: mockServer.URL + "/api/v1.2/getReservations",
+				configMap: &configuration.ConfigMap{
+					ExtensionMap: []configuration.Extension{
+						{RoomExtension: "1001", HospitalityRoomID: "544559-0", HospitalityRoomName: "DQ(1)"},
+						{RoomExtension: "1002", HospitalityRoomID: "544559-1", HospitalityRoomName: "DQ(2)"},
+						{RoomExtension: "1003", HospitalityRoomID: "544559-2", HospitalityRoomName: "DQ(3)"},
+					},
+				},
+			}
+
+			guest, err := cb.GetRoomGuest(context.Background(), tt.roomNumber)
+			if tt.expectedErrMsg != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErrMsg, err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedGuest, guest)
+			}
+		})
+	}
+}
+
 func TestCloudbeds_GenerateRandomString(t *testing.T) {
 	cleanUpEnvVars()
 	tests := []struct {
@@ -947,6 +1764,7 @@ func TestCloudbeds_GetVarFromStoreOrEnvironment(t *testing.T) {
 	cleanUpEnvVars()
 	tests := []struct {
 		name           string
+		varName        string
 		storeValue     string
 		storeErr       error
 		envValue       string
@@ -973,6 +1791,14 @@ func TestCloudbeds_GetVarFromStoreOrEnvironment(t *testing.T) {
 			envValue:       "env_value",
 			expectedResult: "env_value",
 		},
+		{
+			name:           "client secret from environment is not masked",
+			varName:        "CLOUDBEDS_CLIENT_SECRET",
+			storeValue:     "",
+			storeErr:       nil,
+			envValue:       "client_secret",
+			expectedResult: "client_secret",
+		},
 	}
 
 	for _, tt := range tests {
@@ -986,12 +1812,17 @@ func TestCloudbeds_GetVarFromStoreOrEnvironment(t *testing.T) {
 				log:         logrus.New(),
 			}
 
+			varName := tt.varName
+			if varName == "" {
+				varName = "TEST_VAR"
+			}
+
 			// Set environment variable
-			os.Setenv("TEST_VAR", tt.envValue)
-			defer os.Unsetenv("TEST_VAR")
+			os.Setenv(varName, tt.envValue)
+			defer os.Unsetenv(varName)
 
 			// Execute
-			result := cloudbeds.getVarFromStoreOrEnvironment("TEST_VAR")
+			result := cloudbeds.getVarFromStoreOrEnvironment(varName)
 
 			// Verify
 			assert.Equal(t, tt.expectedResult, result)
@@ -1010,6 +1841,18 @@ func TestCloudbeds_LoadApiConfiguration(t *testing.T) {
 	type APIURLs struct {
 		GetRooms               string `json:"getRooms"`
 		PostHousekeepingStatus string `json:"postHousekeepingStatus"`
+		GetReservations        string `json:"getReservations"`
+		GetHousekeepingStatus  string `json:"getHousekeepingStatus"`
+		GetHousekeepers        string `json:"getHousekeepers"`
+		PostHousekeepingAssign string `json:"postHousekeepingAssignment"`
+		PostRoomBlock          string `json:"postRoomBlock"`
+		GetRoomBlocks          string `json:"getRoomBlocks"`
+		DeleteRoomBlock        string `json:"deleteRoomBlock"`
+		PutRoomBlock           string `json:"putRoomBlock"`
+		PostCustomItem         string `json:"postCustomItem"`
+		GetReservation         string `json:"getReservation"`
+		GetWebhooks            string `json:"getWebhooks"`
+		PostWebhook            string `json:"postWebhook"`
 	}
 
 	tests := []struct {
@@ -1039,7 +1882,8 @@ func TestCloudbeds_LoadApiConfiguration(t *testing.T) {
 			"getRooms": "https://hotels.cloudbeds.com/api/v1.2/getRooms",
 			"postHousekeepingStatus": "https://hotels.cloudbeds.com/api/v1.2/postHousekeepingStatus"
 		},
-		"roomStatuses": ["clean", "dirty"]
+		"roomStatuses": ["clean", "dirty"],
+		"roomBlockDays": 30
 	}`,
 			apiConfigPath: "testdata/cloudbeds_api_params.json",
 			expectedResult: &ApiConfiguration3CX{
@@ -1047,7 +1891,8 @@ func TestCloudbeds_LoadApiConfiguration(t *testing.T) {
 					GetRooms:               "https://hotels.cloudbeds.com/api/v1.2/getRooms",
 					PostHousekeepingStatus: "https://hotels.cloudbeds.com/api/v1.2/postHousekeepingStatus",
 				},
-				RoomStatuses: []string{"clean", "dirty"},
+				RoomStatuses:  []string{"clean", "dirty"},
+				RoomBlockDays: 30,
 			},
 			wantError: assert.NoError,
 		},
@@ -1159,7 +2004,7 @@ func TestHandleInitialLogin(t *testing.T) {
 				mockSecretStore.On("StoreOauthState", mock.Anything).Return(tt.mockStoreErr)
 			}
 
-			url, err := cloudbeds.HandleInitialLogin()
+			url, err := cloudbeds.HandleInitialLogin(context.Background())
 
 			if tt.expectErr {
 				assert.NotNil(t, err)
@@ -1229,10 +2074,12 @@ func TestLogin(t *testing.T) {
 				oauthConf:   mockOauthConf,
 			}
 
-			mockSecretStore.On("RetrieveRefreshToken").Return(tt.mockRetrieveRefreshToken, tt.mockRetrieveRefreshErr)
+			mockSecretStore.On("RetrieveToken").Return(secrets.Token{RefreshToken: tt.mockRetrieveRefreshToken}, tt.mockRetrieveRefreshErr)
 			mockSecretStore.On("StoreOauthState", mock.Anything).Return(tt.mockStoreOauthStateErr)
+			mockSecretStore.On("StoreToken", savedToken(secrets.Token{AccessToken: "some-access-token", RefreshToken: tt.mockRetrieveRefreshToken})).Return(nil)
 			mockOauthConf.On("AuthCodeURL", mock.Anything, mock.Anything).Return("someAuthCodeURL")
 			mockOauthConf.On("TokenSource", mock.Anything, mock.AnythingOfType("*oauth2.Token")).Return(tokenSource)
+			mockOauthConf.On("Client", mock.Anything, mock.AnythingOfType("*oauth2.Token")).Return(&http.Client{})
 
 			statusCodeMsg, msg, err := cloudbeds.login(mockSecretStore)
 
@@ -1243,6 +2090,9 @@ func TestLogin(t *testing.T) {
 			}
 			assert.Equal(t, tt.expectedStatusCodeMsg, statusCodeMsg)
 			assert.Equal(t, tt.expectedMsg, msg)
+			if statusCodeMsg == "ok" {
+				mockSecretStore.AssertCalled(t, "StoreToken", savedToken(secrets.Token{AccessToken: "some-access-token", RefreshToken: tt.mockRetrieveRefreshToken}))
+			}
 
 		})
 	}
@@ -1270,7 +2120,7 @@ func TestCloudbeds_RefreshToken(t *testing.T) {
 			retrieveVarString: "",
 		},
 		{
-			name:              "RetrieveRefreshToken error",
+			name:              "RetrieveToken error",
 			setOauth2Config:   nil,
 			expectedError:     errors.New("failed to retrieve refresh token from secret store: someError"),
 			refreshTokenErr:   errors.New("someError"),
@@ -1312,13 +2162,13 @@ func TestCloudbeds_RefreshToken(t *testing.T) {
 				oauthConf:   mockOauthConf,
 			}
 
-			mockStoreClient.On("RetrieveRefreshToken").Return("someRefreshToken", tt.refreshTokenErr)
+			mockStoreClient.On("RetrieveToken").Return(secrets.Token{RefreshToken: "someRefreshToken"}, tt.refreshTokenErr)
 			mockOauthConf.On("TokenSource", mock.Anything, mock.Anything).Return(mock.Anything)
 			mockOauthConf.On("Client", mock.Anything, mock.Anything).Return(&http.Client{})
-			mockStoreClient.On("StoreAccessToken", mock.Anything).Return(tt.storeAccessToken)
+			mockStoreClient.On("StoreToken", mock.Anything).Return(tt.storeAccessToken)
 			mockStoreClient.On("RetrieveVar", mock.Anything).Return(tt.retrieveVarString, nil)
 
-			err := p.refreshToken()
+			err := p.refreshToken(context.Background())
 
 			assert.Contains(t, err.Error(), tt.expectedError.Error())
 		})
@@ -1335,8 +2185,7 @@ func TestHandleOAuthCallback(t *testing.T) {
 		mockStateErr    error
 		mockToken       *oauth2.Token
 		mockTokenErr    error
-		mockStoreAT     error
-		mockStoreRT     error
+		mockStoreToken  error
 		mockHttpClient  *http.Client
 		exchangeOptions []oauth2.AuthCodeOption
 		expectedError   string
@@ -1371,33 +2220,22 @@ func TestHandleOAuthCallback(t *testing.T) {
 			expectedError:   "oauthConf.Exchange() failed",
 		},
 		{
-			name:            "StoreAccessToken fails",
-			state:           "someState",
-			code:            "someCode",
-			mockState:       "someState",
-			mockToken:       &oauth2.Token{AccessToken: "some_token"},
-			mockStoreAT:     errors.New("store access token error"),
-			mockHttpClient:  &http.Client{},
-			exchangeOptions: []oauth2.AuthCodeOption{},
-			expectedError:   "store access token error",
-		},
-		{
-			name:            "StoreRefreshToken fails",
+			name:            "StoreToken fails",
 			state:           "someState",
 			code:            "someCode",
 			mockState:       "someState",
 			mockToken:       &oauth2.Token{AccessToken: "some_token"},
-			mockStoreRT:     errors.New("store refresh token error"),
+			mockStoreToken:  errors.New("store token error"),
 			mockHttpClient:  &http.Client{},
 			exchangeOptions: []oauth2.AuthCodeOption{},
-			expectedError:   "store refresh token error",
+			expectedError:   "store token error",
 		},
 		{
 			name:            "success",
 			state:           "someState",
 			code:            "someCode",
 			mockState:       "someState",
-			mockToken:       &oauth2.Token{AccessToken: "some_token"},
+			mockToken:       &oauth2.Token{AccessToken: "some_token", RefreshToken: "some_refresh_token", TokenType: "Bearer", Expiry: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)},
 			mockHttpClient:  &http.Client{},
 			exchangeOptions: []oauth2.AuthCodeOption{},
 		},
@@ -1418,14 +2256,16 @@ func TestHandleOAuthCallback(t *testing.T) {
 
 			mockStore.On("RetrieveOauthState", tt.state).Return(tt.mockState, tt.mockStateErr)
 			mockOauth.On("Exchange", context.Background(), tt.code, []oauth2.AuthCodeOption(nil)).Return(tt.mockToken, tt.mockTokenErr)
-			mockStore.On("StoreAccessToken", mock.Anything).Return(tt.mockStoreAT)
-			mockStore.On("StoreRefreshToken", mock.Anything).Return(tt.mockStoreRT)
-			mockOauth.On("Client", context.Background(), mock.AnythingOfType("*oauth2.Token")).Return(tt.mockHttpClient)
+			mockStore.On("StoreToken", mock.Anything).Return(tt.mockStoreToken)
+			mockOauth.On("Client", mock.Anything, mock.AnythingOfType("*oauth2.Token")).Return(tt.mockHttpClient)
 
-			err := p.HandleOAuthCallback(tt.state, tt.code)
+			err := p.HandleOAuthCallback(context.Background(), tt.state, tt.code)
 
 			if tt.expectedError == "" {
 				assert.NoError(t, err)
+				//the whole token is saved, including expiry
+				mockStore.AssertCalled(t, "StoreToken", savedToken(toStoredToken(tt.mockToken)))
+				assert.Equal(t, tt.mockHttpClient, p.httpClient)
 			} else {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), tt.expectedError)
@@ -1440,17 +2280,16 @@ func TestNew(t *testing.T) {
 	createCloudbedsApiConfigFile(apiConfFileName)
 
 	tests := []struct {
-		name                    string
-		loadConfigErr           error
-		retrieveAccessTokenErr  error
-		retrieveRefreshTokenErr error
-		retrieveVarValue        string
-		retrieveVarErr          error
-		loginMsg                string
-		loginStatusCode         string
-		apiConfFileName         string
-		setEnvVars              bool
-		expectedErr             string
+		name                   string
+		loadConfigErr          error
+		retrieveAccessTokenErr error
+		retrieveVarValue       string
+		retrieveVarErr         error
+		loginMsg               string
+		loginStatusCode        string
+		apiConfFileName        string
+		setEnvVars             bool
+		expectedErr            string
 	}{
 		{
 			name:            "loadApiConfiguration error",
@@ -1491,8 +2330,7 @@ func TestNew(t *testing.T) {
 			mockStoreClient := new(MockSecretsStore)
 			mockConfig := &configuration.ConfigMap{ApiCfgFileName: tt.apiConfFileName}
 
-			mockStoreClient.On("RetrieveAccessToken").Return("some_token", tt.retrieveAccessTokenErr)
-			mockStoreClient.On("RetrieveRefreshToken").Return("some_token", tt.retrieveRefreshTokenErr)
+			mockStoreClient.On("RetrieveToken").Return(secrets.Token{AccessToken: "some_token", RefreshToken: "some_token"}, tt.retrieveAccessTokenErr)
 			mockStoreClient.On("RetrieveVar", mock.Anything).Return(tt.retrieveVarValue, tt.retrieveVarErr) // Replace "some_value" and nil with whatever you want the function to return
 
 			client, err := New(logger, mockStoreClient, mockConfig)
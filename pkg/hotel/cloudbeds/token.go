@@ -0,0 +1,165 @@
+package cloudbeds
+
+import (
+	"context"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"golang.org/x/oauth2"
+	"time"
+)
+
+const (
+	// tokenRefreshMargin is how long before the expiry the access token is refreshed. Cloudbeds access tokens live one hour
+	tokenRefreshMargin = 5 * time.Minute
+	// tokenLockTTL is the time the token lock is held at most. It expires if the lambda holding it crashed
+	tokenLockTTL = 15 * time.Second
+	// tokenLockWait is how long the token refreshed by another process is awaited
+	tokenLockWait = 10 * time.Second
+	// tokenLockPollInterval is the pause between checks whether another process released the lock or saved the new token
+	tokenLockPollInterval = 200 * time.Millisecond
+)
+
+func toStoredToken(token *oauth2.Token) secrets.Token {
+	return secrets.Token{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	}
+}
+
+func fromStoredToken(token secrets.Token) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	}
+}
+
+// saveToken saves the token issued by Cloudbeds to the secret store and authorizes next requests with it.
+// Cloudbeds may rotate the refresh token: the new one is always saved. If the response has no refresh token, previousRefreshToken is kept.
+// The time of the save is recorded as RefreshedAt
+func (p *Cloudbeds) saveToken(token *oauth2.Token, previousRefreshToken string) error {
+	if token.RefreshToken == "" {
+		token.RefreshToken = previousRefreshToken
+	}
+	storedToken := toStoredToken(token)
+	storedToken.RefreshedAt = time.Now()
+	err := p.storeClient.StoreToken(storedToken)
+	if err != nil {
+		return err
+	}
+	p.useToken(token)
+	p.setLoginRequired("")
+	return nil
+}
+
+// LoginRequired returns the login link for the admin if no refresh token was found on start and nobody logged in since
+func (p *Cloudbeds) LoginRequired() (msg string, required bool) {
+	p.tokenMu.RLock()
+	defer p.tokenMu.RUnlock()
+	return p.loginRequired, p.loginRequired != ""
+}
+
+func (p *Cloudbeds) setLoginRequired(msg string) {
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+	p.loginRequired = msg
+}
+
+// KeepTokenAlive refreshes the token even if the access token is still valid: the refresh token is used and stays fresh.
+// The property API key doesn't expire: nothing is done in API-key mode
+func (p *Cloudbeds) KeepTokenAlive(ctx context.Context) error {
+	if p.usesAPIKey() {
+		p.log.Debugf("Cloudbeds API key is used. There is no token to refresh")
+		return nil
+	}
+	err := p.refresher.refreshToken(ctx)
+	if err != nil {
+		return hotel.NewError(hotel.ErrAuthorization, fmt.Errorf("failed to keep token alive: %w", err))
+	}
+	return nil
+}
+
+// useToken creates pre-authorized http client for token. The client gets only the access token, so package oauth2 never
+// refreshes it on its own: the refreshed token would not be saved and the rotated refresh token would be lost.
+// The token is refreshed by send instead: ahead of the expiry and on 401
+func (p *Cloudbeds) useToken(token *oauth2.Token) {
+	clientToken := &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+	}
+	httpClient := p.oauthConf.Client(p.httpContext(context.Background()), clientToken)
+
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+	p.token = token
+	p.httpClient = httpClient
+}
+
+// client returns pre-authorized http client. It is replaced by the refresh while other requests are running
+func (p *Cloudbeds) client() HTTPClient {
+	p.tokenMu.RLock()
+	defer p.tokenMu.RUnlock()
+	return p.httpClient
+}
+
+// currentToken returns the token the client is authorized with. The refreshed token is always a new pointer
+func (p *Cloudbeds) currentToken() *oauth2.Token {
+	p.tokenMu.RLock()
+	defer p.tokenMu.RUnlock()
+	return p.token
+}
+
+// expiresSoon reports whether token expires within tokenRefreshMargin. Tokens without expiry never do
+func expiresSoon(token *oauth2.Token, now time.Time) bool {
+	if token == nil || token.Expiry.IsZero() {
+		return false
+	}
+	return token.Expiry.Sub(now) < tokenRefreshMargin
+}
+
+// lockToken takes the token lock shared by all processes using the secret store. If the lock is held by another process,
+// it waits up to tokenLockWait for the token refreshed by that process. reused is true if that token is now used, and the lock
+// is not taken then. storedToken is the token in the secret store before the refresh
+func (p *Cloudbeds) lockToken(ctx context.Context, locker secrets.TokenLocker, owner string, storedToken secrets.Token) (reused bool, err error) {
+	deadline := time.Now().Add(tokenLockWait)
+	for {
+		acquired, err := locker.LockToken(owner, tokenLockTTL)
+		if err != nil {
+			return false, fmt.Errorf("failed to lock token: %w", err)
+		}
+
+		//the previous holder might have saved the new token just before releasing the lock
+		currentToken, err := p.storeClient.RetrieveToken()
+		if err != nil {
+			if acquired {
+				_ = locker.UnlockToken(owner)
+			}
+			return false, fmt.Errorf("failed to retrieve token from secret store: %w", err)
+		}
+		if currentToken.AccessToken != "" && currentToken.AccessToken != storedToken.AccessToken {
+			if acquired {
+				_ = locker.UnlockToken(owner)
+			}
+			p.log.Debugf("Token was refreshed by another process. Reusing it")
+			p.useToken(fromStoredToken(currentToken))
+			return true, nil
+		}
+		if acquired {
+			return false, nil
+		}
+
+		if time.Now().After(deadline) {
+			return false, fmt.Errorf("token is being refreshed by another process for more than %s", tokenLockWait)
+		}
+		p.log.Debugf("Token is being refreshed by another process. Waiting")
+		select {
+		case <-time.After(tokenLockPollInterval):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
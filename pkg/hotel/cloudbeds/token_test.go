@@ -0,0 +1,389 @@
+package cloudbeds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCloudbeds_RefreshToken_SavesRotatedToken(t *testing.T) {
+	cleanUpEnvVars()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "old_refresh", r.PostForm.Get("refresh_token"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"new_access","refresh_token":"new_refresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	mockStoreClient := new(MockSecretsStore)
+	p := &Cloudbeds{
+		log:         logger,
+		storeClient: mockStoreClient,
+	}
+
+	mockStoreClient.On("RetrieveVar", mock.Anything).Return(server.URL, nil)
+	mockStoreClient.On("RetrieveToken").Return(secrets.Token{AccessToken: "old_access", RefreshToken: "old_refresh"}, nil)
+	mockStoreClient.On("StoreToken", mock.Anything).Return(nil)
+
+	before := time.Now()
+	err := p.refreshToken(context.Background())
+	require.NoError(t, err)
+
+	mockStoreClient.AssertCalled(t, "StoreToken", mock.MatchedBy(func(token secrets.Token) bool {
+		return token.AccessToken == "new_access" &&
+			token.RefreshToken == "new_refresh" &&
+			token.TokenType == "Bearer" &&
+			token.Expiry.After(before.Add(59*time.Minute)) &&
+			!token.RefreshedAt.Before(before)
+	}))
+	assert.Equal(t, "new_access", p.token.AccessToken)
+	assert.False(t, expiresSoon(p.currentToken(), time.Now()))
+	cleanUpEnvVars()
+}
+
+func TestCloudbeds_KeepTokenAlive(t *testing.T) {
+	refresher := &countingTokenRefresher{}
+	p := &Cloudbeds{log: logrus.New(), refresher: refresher}
+	assert.NoError(t, p.KeepTokenAlive(context.Background()))
+	assert.Equal(t, 1, refresher.calls)
+
+	p.refresher = &MockTokenRefresher{}
+	err := p.KeepTokenAlive(context.Background())
+	assert.EqualError(t, err, "failed to keep token alive: refresh token error")
+	assert.ErrorIs(t, err, hotel.ErrAuthorization)
+}
+
+func TestCloudbeds_saveToken(t *testing.T) {
+	tests := []struct {
+		name                 string
+		token                *oauth2.Token
+		previousRefreshToken string
+		storeErr             error
+		expectedStored       secrets.Token
+	}{
+		{
+			name:                 "rotated refresh token is saved",
+			token:                &oauth2.Token{AccessToken: "access", RefreshToken: "new_refresh", TokenType: "Bearer"},
+			previousRefreshToken: "old_refresh",
+			expectedStored:       secrets.Token{AccessToken: "access", RefreshToken: "new_refresh", TokenType: "Bearer"},
+		},
+		{
+			name:                 "previous refresh token is kept",
+			token:                &oauth2.Token{AccessToken: "access", TokenType: "Bearer"},
+			previousRefreshToken: "old_refresh",
+			expectedStored:       secrets.Token{AccessToken: "access", RefreshToken: "old_refresh", TokenType: "Bearer"},
+		},
+		{
+			name:                 "store fails",
+			token:                &oauth2.Token{AccessToken: "access", RefreshToken: "new_refresh"},
+			previousRefreshToken: "old_refresh",
+			storeErr:             assert.AnError,
+			expectedStored:       secrets.Token{AccessToken: "access", RefreshToken: "new_refresh"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStoreClient := new(MockSecretsStore)
+			mockOauthConf := new(MockOauthConfInterface)
+			p := &Cloudbeds{
+				log:         logrus.New(),
+				storeClient: mockStoreClient,
+				oauthConf:   mockOauthConf,
+			}
+			p.setLoginRequired("No refresh token found")
+			client := &http.Client{}
+			mockStoreClient.On("StoreToken", savedToken(tt.expectedStored)).Return(tt.storeErr)
+			//the client gets only the access token: package oauth2 must not refresh it on its own
+			mockOauthConf.On("Client", mock.Anything, &oauth2.Token{AccessToken: tt.token.AccessToken, TokenType: tt.token.TokenType}).Return(client)
+
+			err := p.saveToken(tt.token, tt.previousRefreshToken)
+
+			mockStoreClient.AssertExpectations(t)
+			if tt.storeErr != nil {
+				assert.ErrorIs(t, err, tt.storeErr)
+				assert.Nil(t, p.httpClient)
+				_, required := p.LoginRequired()
+				assert.True(t, required)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, client, p.httpClient)
+			assert.Equal(t, tt.token, p.token)
+			//saved token means somebody logged in
+			_, required := p.LoginRequired()
+			assert.False(t, required)
+		})
+	}
+}
+
+func TestExpiresSoon(t *testing.T) {
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		token    *oauth2.Token
+		expected bool
+	}{
+		{"no token", nil, false},
+		{"no expiry", &oauth2.Token{AccessToken: "access"}, false},
+		{"expires later", &oauth2.Token{AccessToken: "access", Expiry: now.Add(time.Hour)}, false},
+		{"expires within margin", &oauth2.Token{AccessToken: "access", Expiry: now.Add(tokenRefreshMargin - time.Second)}, true},
+		{"expired", &oauth2.Token{AccessToken: "access", Expiry: now.Add(-time.Minute)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, expiresSoon(tt.token, now))
+		})
+	}
+}
+
+func TestCloudbeds_send_RefreshesAheadOfExpiry(t *testing.T) {
+	newResponse := func() *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{"success":true}`))}
+	}
+
+	tests := []struct {
+		name              string
+		expiry            time.Time
+		expectedRefreshes int
+	}{
+		{"token expiring soon is refreshed before the request", time.Now().Add(time.Minute), 1},
+		{"valid token is not refreshed", time.Now().Add(time.Hour), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refresher := &countingTokenRefresher{}
+			p := &Cloudbeds{
+				log:       logrus.New(),
+				refresher: refresher,
+				token:     &oauth2.Token{AccessToken: "access", Expiry: tt.expiry},
+			}
+			attempts := 0
+			resp, err := p.send(context.Background(), "get rooms", func() (*http.Response, error) {
+				attempts++
+				return newResponse(), nil
+			})
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, 1, attempts)
+			assert.Equal(t, tt.expectedRefreshes, refresher.calls)
+		})
+	}
+}
+
+// savedToken matches the token saved by saveToken. RefreshedAt is the time of the save, so it is only checked to be set
+func savedToken(expected secrets.Token) interface{} {
+	return mock.MatchedBy(func(token secrets.Token) bool {
+		if token.RefreshedAt.IsZero() {
+			return false
+		}
+		token.RefreshedAt = time.Time{}
+		return token == expected
+	})
+}
+
+// memoryTokenStore keeps the token in memory like the real secret store. Other methods are mocked
+type memoryTokenStore struct {
+	*MockSecretsStore
+	mu     sync.Mutex
+	token  secrets.Token
+	stored int
+}
+
+func (s *memoryTokenStore) StoreToken(token secrets.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	s.stored++
+	return nil
+}
+
+func (s *memoryTokenStore) RetrieveToken() (secrets.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, nil
+}
+
+// lockingTokenStore is shared by several processes. lockToken is called on every attempt to take the lock
+type lockingTokenStore struct {
+	memoryTokenStore
+	lockOwner string
+	unlocked  []string
+	lockToken func(store *lockingTokenStore, owner string) bool
+}
+
+func (s *lockingTokenStore) LockToken(owner string, ttl time.Duration) (bool, error) {
+	if s.lockToken != nil {
+		return s.lockToken(s, owner), nil
+	}
+	s.lockOwner = owner
+	return true, nil
+}
+
+func (s *lockingTokenStore) UnlockToken(owner string) error {
+	s.unlocked = append(s.unlocked, owner)
+	return nil
+}
+
+// newRefreshTestCloudbeds returns Cloudbeds authorized with old_access token. The token endpoint is served by server
+func newRefreshTestCloudbeds(store secrets.SecretsStore, tokenRequests *int32) (*Cloudbeds, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(tokenRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"new_access_%d","refresh_token":"new_refresh_%d","token_type":"Bearer","expires_in":3600}`, n, n)
+	}))
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	mockStore := new(MockSecretsStore)
+	mockStore.On("RetrieveVar", mock.Anything).Return(server.URL, nil)
+	switch s := store.(type) {
+	case *memoryTokenStore:
+		s.MockSecretsStore = mockStore
+	case *lockingTokenStore:
+		s.MockSecretsStore = mockStore
+	}
+
+	p := &Cloudbeds{
+		log:         logger,
+		storeClient: store,
+		oauthConf:   &oauth2.Config{},
+	}
+	p.useToken(&oauth2.Token{AccessToken: "old_access", RefreshToken: "old_refresh"})
+	return p, server
+}
+
+func TestCloudbeds_RefreshToken_SingleFlight(t *testing.T) {
+	cleanUpEnvVars()
+	var tokenRequests int32
+	store := &memoryTokenStore{token: secrets.Token{AccessToken: "old_access", RefreshToken: "old_refresh"}}
+	p, server := newRefreshTestCloudbeds(store, &tokenRequests)
+	defer server.Close()
+
+	//all requests got 401 with the same token and wait for the refresh
+	p.refreshMu.Lock()
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.refreshToken(context.Background())
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	p.refreshMu.Unlock()
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests))
+	assert.Equal(t, 1, store.stored)
+	assert.Equal(t, "new_access_1", p.currentToken().AccessToken)
+	assert.Equal(t, "new_refresh_1", store.token.RefreshToken)
+}
+
+func TestCloudbeds_RefreshToken_SharedStore(t *testing.T) {
+	refreshedToken := secrets.Token{AccessToken: "other_access", RefreshToken: "other_refresh", Expiry: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name                  string
+		storedToken           secrets.Token
+		lockToken             func(store *lockingTokenStore, owner string) bool
+		ctxCanceled           bool
+		expectedTokenRequests int32
+		expectedAccessToken   string
+		expectedErr           error
+		expectUnlock          bool
+	}{
+		{
+			name:                  "lock holder refreshes the token",
+			storedToken:           secrets.Token{AccessToken: "old_access", RefreshToken: "old_refresh"},
+			expectedTokenRequests: 1,
+			expectedAccessToken:   "new_access_1",
+			expectUnlock:          true,
+		},
+		{
+			name:        "token refreshed by another process is reused",
+			storedToken: refreshedToken,
+			lockToken: func(store *lockingTokenStore, owner string) bool {
+				t.Error("lock is not needed")
+				return false
+			},
+			expectedAccessToken: "other_access",
+		},
+		{
+			name:        "token of the lock holder is awaited",
+			storedToken: secrets.Token{AccessToken: "old_access", RefreshToken: "old_refresh"},
+			lockToken: func(store *lockingTokenStore, owner string) bool {
+				//another process saves the new token while we wait
+				store.token = refreshedToken
+				return false
+			},
+			expectedAccessToken: "other_access",
+		},
+		{
+			name:        "waiting is canceled",
+			storedToken: secrets.Token{AccessToken: "old_access", RefreshToken: "old_refresh"},
+			lockToken: func(store *lockingTokenStore, owner string) bool {
+				return false
+			},
+			ctxCanceled:         true,
+			expectedAccessToken: "old_access",
+			expectedErr:         context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleanUpEnvVars()
+			var tokenRequests int32
+			store := &lockingTokenStore{lockToken: tt.lockToken}
+			store.token = tt.storedToken
+			p, server := newRefreshTestCloudbeds(store, &tokenRequests)
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.ctxCanceled {
+				cancel()
+			}
+			defer cancel()
+
+			err := p.refreshToken(ctx)
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedTokenRequests, atomic.LoadInt32(&tokenRequests))
+			assert.Equal(t, tt.expectedAccessToken, p.currentToken().AccessToken)
+			if tt.expectUnlock {
+				assert.Equal(t, []string{store.lockOwner}, store.unlocked)
+			} else {
+				assert.Empty(t, store.unlocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package cloudbeds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxRetries is the amount of repeated requests after Cloudbeds answered with 429 or 5xx
+	maxRetries = 3
+	// retryBaseDelay is the backoff before the first retry. It is doubled for every next retry
+	retryBaseDelay = 500 * time.Millisecond
+	// maxRetryDelay caps the backoff. If Retry-After asks to wait longer, the response is returned without retry:
+	// the housekeeper is on the phone and lambda would time out anyway
+	maxRetryDelay = 10 * time.Second
+	// maxAuthAttempts is the amount of tries of a request rejected with 401: the first one and the one after token refresh
+	maxAuthAttempts = 2
+)
+
+// errAccessTokenExpired is returned by checkResponseStatus on 401. Access token is refreshed only on this error
+var errAccessTokenExpired = errors.New("access token expired")
+
+// retryTransport repeats requests rejected by Cloudbeds rate limit (429) or failed on the server side (5xx).
+// It waits as long as Retry-After says, otherwise uses exponential backoff with jitter, so the phones of many housekeepers
+// dialing at once do not hit the API in the same moment again.
+// 429 means the request was not processed and is retried for any method. 5xx are retried only for idempotent methods:
+// the request might have been processed, and a repeated POST might e.g. create the room block or post the charge twice.
+// Every attempt sends a copy of the request: RoundTripper must not modify the request of the caller
+type retryTransport struct {
+	base       http.RoundTripper
+	log        *logrus.Logger
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	sleep      func(req *http.Request, delay time.Duration) error // waits delay. Replaced in tests
+	jitter     func(delay time.Duration) time.Duration            // random part of the backoff. Replaced in tests
+}
+
+func newRetryTransport(log *logrus.Logger, base http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		base:       base,
+		log:        log,
+		maxRetries: maxRetries,
+		baseDelay:  retryBaseDelay,
+		maxDelay:   maxRetryDelay,
+		sleep:      sleepWithContext,
+		jitter: func(delay time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(delay) + 1))
+		},
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil || attempt >= t.maxRetries || !t.shouldRetry(req, resp) {
+			return resp, err
+		}
+
+		delay, ok := t.retryDelay(resp, attempt)
+		if !ok {
+			t.log.Warnf("Cloudbeds asked to retry %s %s after %s. Giving up", req.Method, req.URL.Path, resp.Header.Get("Retry-After"))
+			return resp, nil
+		}
+
+		nextReq, ok := cloneForRetry(req)
+		if !ok {
+			return resp, nil
+		}
+		attemptReq = nextReq
+
+		t.log.Warnf("Cloudbeds returned %d for %s %s. Retry %d/%d in %s", resp.StatusCode, req.Method, req.URL.Path, attempt+1, t.maxRetries, delay)
+		_, _ = io.Copy(io.Discard, resp.Body) //read the body to reuse the connection
+		resp.Body.Close()
+
+		err = t.sleep(req, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// cloneForRetry copies req for the next attempt with the rewound body. Requests with body, but without GetBody can't be repeated
+func cloneForRetry(req *http.Request) (*http.Request, bool) {
+	clone := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		clone.Body = body
+	}
+	return clone, true
+}
+
+// shouldRetry reports whether resp is worth repeating the request
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return hotel.IsIdempotent(req.Method)
+	default:
+		return false
+	}
+}
+
+// retryDelay returns the time to wait before retry number attempt+1. ok is false if Retry-After is longer than maxDelay
+func (t *retryTransport) retryDelay(resp *http.Response, attempt int) (delay time.Duration, ok bool) {
+	if retryAfter, found := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); found {
+		return retryAfter, retryAfter <= t.maxDelay
+	}
+
+	//exponential backoff: half of the delay is fixed, another half is random
+	backoff := t.baseDelay << attempt
+	if backoff > t.maxDelay || backoff <= 0 {
+		backoff = t.maxDelay
+	}
+	return backoff/2 + t.jitter(backoff/2), true
+}
+
+// parseRetryAfter parses Retry-After header: delay in seconds or HTTP date
+func parseRetryAfter(value string, now time.Time) (delay time.Duration, found bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// sleepWithContext waits delay or until the request is canceled
+func sleepWithContext(req *http.Request, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// send sends the request made by request and checks the status of the response. The access token that expires within
+// tokenRefreshMargin is refreshed before the request. On 401 the access token is refreshed and the request is repeated,
+// at most maxAuthAttempts tries in total. 429 and 5xx are already retried by retryTransport.
+// Errors are classified with hotel.ErrAuthorization, hotel.ErrValidation and hotel.ErrTransient.
+// The body of the returned response must be closed by the caller
+func (p *Cloudbeds) send(ctx context.Context, action string, request func() (*http.Response, error)) (*http.Response, error) {
+	//the token is read once: it is replaced by the refresh of concurrent requests
+	if token := p.currentToken(); expiresSoon(token, time.Now()) {
+		p.log.Debugf("Access token expires at %s. Refreshing it before trying to %s", token.Expiry, action)
+		err := p.refresher.refreshToken(ctx)
+		if err != nil {
+			//the current token may still be valid. If not, the request gets 401 and the refresh is repeated
+			p.log.Warnf("Failed to refresh access token ahead of expiry: %s", err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := request()
+		if err != nil {
+			p.log.Errorf("request failed with: %s", err)
+			return nil, hotel.NewError(hotel.ErrTransient, fmt.Errorf("request failed with: %w", err))
+		}
+
+		err = checkResponseStatus(resp)
+		if err == nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if err != errAccessTokenExpired {
+			p.log.Debugf("Failed to %s: %s", action, err)
+			return nil, err
+		}
+		if attempt >= maxAuthAttempts {
+			p.log.Debugf("Failed to %s: access_token is rejected after refresh", action)
+			return nil, hotel.NewError(hotel.ErrAuthorization, fmt.Errorf("failed to %s: access token is rejected after refresh", action))
+		}
+
+		p.log.Debugf("Failed to %s: access_token expired. Refreshing it", action)
+		err = p.refresher.refreshToken(ctx)
+		if err != nil {
+			return nil, hotel.NewError(hotel.ErrAuthorization, fmt.Errorf("failed to %s: %s", action, err))
+		}
+	}
+}
+
+// get sends GET request to apiUrl. The request is cancelled with ctx
+func (p *Cloudbeds) get(ctx context.Context, apiUrl string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.client().Do(req)
+}
+
+// checkResponseStatus checks the HTTP status of Cloudbeds response. It returns errAccessTokenExpired on 401.
+// 429 and 5xx get here only after retryTransport gave up. 5xx of non-idempotent requests are not transient: the outcome is unknown
+func checkResponseStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errAccessTokenExpired
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	detailedError := &hotel.DetailedError{
+		Msg:               fmt.Errorf("cloudbeds request failed with status %d", resp.StatusCode),
+		StatusCodeMessage: http.StatusText(resp.StatusCode),
+		Details:           string(body),
+	}
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError && resp.Request != nil && !hotel.IsIdempotent(resp.Request.Method):
+		//the request might have been processed: repeating it (e.g. webhook resent by Cloudbeds) might duplicate the change
+		return detailedError
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= http.StatusInternalServerError:
+		return hotel.NewError(hotel.ErrTransient, detailedError)
+	case resp.StatusCode == http.StatusForbidden:
+		return hotel.NewError(hotel.ErrAuthorization, detailedError)
+	default:
+		return hotel.NewError(hotel.ErrValidation, detailedError)
+	}
+}
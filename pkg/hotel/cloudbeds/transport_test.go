@@ -0,0 +1,260 @@
+package cloudbeds
+
+import (
+	"bytes"
+	"context"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRetryTransport returns retryTransport that records delays instead of sleeping. Jitter is always zero
+func newTestRetryTransport(delays *[]time.Duration) *retryTransport {
+	transport := newRetryTransport(logrus.New(), http.DefaultTransport)
+	transport.sleep = func(req *http.Request, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		return nil
+	}
+	transport.jitter = func(delay time.Duration) time.Duration { return 0 }
+	return transport
+}
+
+func TestRetryTransport_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		statuses       []int // status of every next response. The last one is repeated
+		retryAfter     string
+		expectedStatus int
+		expectedDelays []time.Duration
+	}{
+		{
+			name:           "success is not retried",
+			method:         http.MethodGet,
+			statuses:       []int{http.StatusOK},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "429 with Retry-After",
+			method:         http.MethodPost,
+			statuses:       []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:     "2",
+			expectedStatus: http.StatusOK,
+			expectedDelays: []time.Duration{2 * time.Second},
+		},
+		{
+			name:           "503 with backoff until retries are exhausted",
+			method:         http.MethodGet,
+			statuses:       []int{http.StatusServiceUnavailable},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedDelays: []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second},
+		},
+		{
+			name:           "500 is retried for GET",
+			method:         http.MethodGet,
+			statuses:       []int{http.StatusInternalServerError, http.StatusOK},
+			expectedStatus: http.StatusOK,
+			expectedDelays: []time.Duration{250 * time.Millisecond},
+		},
+		{
+			name:           "500 is not retried for POST",
+			method:         http.MethodPost,
+			statuses:       []int{http.StatusInternalServerError, http.StatusOK},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "503 is not retried for POST",
+			method:         http.MethodPost,
+			statuses:       []int{http.StatusServiceUnavailable, http.StatusOK},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Retry-After longer than max delay",
+			method:         http.MethodGet,
+			statuses:       []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:     "120",
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:           "401 is not retried",
+			method:         http.MethodGet,
+			statuses:       []int{http.StatusUnauthorized, http.StatusOK},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				status := tt.statuses[len(tt.statuses)-1]
+				if len(bodies) <= len(tt.statuses) {
+					status = tt.statuses[len(bodies)-1]
+				}
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+			}))
+			defer mockServer.Close()
+
+			var delays []time.Duration
+			transport := newTestRetryTransport(&delays)
+
+			req, err := http.NewRequest(tt.method, mockServer.URL, strings.NewReader("roomID=1"))
+			require.NoError(t, err)
+			body := req.Body
+			resp, err := transport.RoundTrip(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, body, req.Body, "the request of the caller is not modified")
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tt.expectedDelays, delays)
+			assert.Len(t, bodies, len(tt.expectedDelays)+1)
+			for _, body := range bodies {
+				assert.Equal(t, "roomID=1", body) //body is sent again on every retry
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 8, 1, 10, 0, 0, 0, time.UTC)
+
+	delay, found := parseRetryAfter("3", now)
+	assert.True(t, found)
+	assert.Equal(t, 3*time.Second, delay)
+
+	delay, found = parseRetryAfter("Tue, 01 Aug 2023 10:00:05 GMT", now)
+	assert.True(t, found)
+	assert.Equal(t, 5*time.Second, delay)
+
+	delay, found = parseRetryAfter("Tue, 01 Aug 2023 09:00:00 GMT", now)
+	assert.True(t, found)
+	assert.Equal(t, time.Duration(0), delay)
+
+	_, found = parseRetryAfter("", now)
+	assert.False(t, found)
+	_, found = parseRetryAfter("soon", now)
+	assert.False(t, found)
+}
+
+func TestCheckResponseStatus(t *testing.T) {
+	newResponse := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(body))}
+	}
+
+	assert.NoError(t, checkResponseStatus(newResponse(http.StatusOK, "")))
+	assert.Equal(t, errAccessTokenExpired, checkResponseStatus(newResponse(http.StatusUnauthorized, "")))
+
+	err := checkResponseStatus(newResponse(http.StatusTooManyRequests, `{"success":false,"message":"Too many requests"}`))
+	assert.EqualError(t, err, "cloudbeds request failed with status 429")
+	assert.ErrorIs(t, err, hotel.ErrTransient)
+	var detailedError *hotel.DetailedError
+	require.ErrorAs(t, err, &detailedError)
+	assert.Equal(t, `{"success":false,"message":"Too many requests"}`, detailedError.Details)
+
+	//the outcome of failed POST is unknown: it must not be repeated as transient failure
+	postResponse := newResponse(http.StatusInternalServerError, "")
+	postResponse.Request = httptest.NewRequest(http.MethodPost, "/api/v1.2/postRoomBlock", nil)
+	err = checkResponseStatus(postResponse)
+	assert.NotErrorIs(t, err, hotel.ErrTransient)
+	require.ErrorAs(t, err, &detailedError)
+	getResponse := newResponse(http.StatusInternalServerError, "")
+	getResponse.Request = httptest.NewRequest(http.MethodGet, "/api/v1.2/getRooms", nil)
+	assert.ErrorIs(t, checkResponseStatus(getResponse), hotel.ErrTransient)
+
+	assert.ErrorIs(t, checkResponseStatus(newResponse(http.StatusForbidden, "")), hotel.ErrAuthorization)
+	assert.ErrorIs(t, checkResponseStatus(newResponse(http.StatusBadRequest, "")), hotel.ErrValidation)
+}
+
+func TestCloudbeds_send(t *testing.T) {
+	newResponse := func(status int) *http.Response {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(`{"success":true}`))}
+	}
+
+	t.Run("token is refreshed once and the request is repeated", func(t *testing.T) {
+		refresher := &countingTokenRefresher{}
+		p := &Cloudbeds{log: logrus.New(), refresher: refresher}
+		statuses := []int{http.StatusUnauthorized, http.StatusOK}
+		attempts := 0
+		resp, err := p.send(context.Background(), "get rooms", func() (*http.Response, error) {
+			attempts++
+			return newResponse(statuses[attempts-1]), nil
+		})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, 1, refresher.calls)
+	})
+
+	t.Run("attempts are bounded if token is still rejected", func(t *testing.T) {
+		refresher := &countingTokenRefresher{}
+		p := &Cloudbeds{log: logrus.New(), refresher: refresher}
+		attempts := 0
+		_, err := p.send(context.Background(), "get rooms", func() (*http.Response, error) {
+			attempts++
+			return newResponse(http.StatusUnauthorized), nil
+		})
+		assert.EqualError(t, err, "failed to get rooms: access token is rejected after refresh")
+		assert.ErrorIs(t, err, hotel.ErrAuthorization)
+		assert.Equal(t, maxAuthAttempts, attempts)
+		assert.Equal(t, maxAuthAttempts-1, refresher.calls)
+	})
+
+	t.Run("validation failure is not repeated", func(t *testing.T) {
+		refresher := &countingTokenRefresher{}
+		p := &Cloudbeds{log: logrus.New(), refresher: refresher}
+		attempts := 0
+		_, err := p.send(context.Background(), "get rooms", func() (*http.Response, error) {
+			attempts++
+			return newResponse(http.StatusBadRequest), nil
+		})
+		assert.ErrorIs(t, err, hotel.ErrValidation)
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, 0, refresher.calls)
+	})
+}
+
+// countingTokenRefresher counts refreshes. Refresh always succeeds
+type countingTokenRefresher struct {
+	calls int
+}
+
+func (r *countingTokenRefresher) refreshToken(ctx context.Context) error {
+	r.calls++
+	return nil
+}
+
+func TestCloudbeds_GetRooms_ContextCanceled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"success":true,"data":[],"total":0}`))
+	}))
+	defer server.Close()
+
+	p := &Cloudbeds{
+		log:            logrus.New(),
+		httpClient:     server.Client(),
+		refresher:      &countingTokenRefresher{},
+		apiUrlGetRooms: server.URL,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := p.GetRooms(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, requests)
+}
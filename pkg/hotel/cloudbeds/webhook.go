@@ -0,0 +1,451 @@
+package cloudbeds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cloudbeds reservation statuses that are converted to hotel events
+const (
+	reservationStatusCheckedIn  = "checked_in"
+	reservationStatusCheckedOut = "checked_out"
+	reservationDateFormat       = "2006-01-02"
+)
+
+// webhookSubscription is a Cloudbeds webhook (object/action) hotelito subscribes to
+type webhookSubscription struct {
+	Object string
+	Action string
+}
+
+// webhookSubscriptions are the Cloudbeds webhooks required by hotelito: check-in/check-out (reservation status) and room condition changes
+var webhookSubscriptions = []webhookSubscription{
+	{Object: "reservation", Action: "status_changed"},
+	{Object: "housekeeping", Action: "room_condition_changed"},
+}
+
+/*
+	Webhook: {
+	    "version": "1.0",
+	    "event": "reservation/status_changed",
+	    "timestamp": 1691012345.123,
+	    "propertyID": 297652,
+	    "propertyID_str": "297652",
+	    "reservationID": "8817735791869",
+	    "status": "checked_out"
+	}
+
+	Webhook: {
+	    "version": "1.0",
+	    "event": "housekeeping/room_condition_changed",
+	    "timestamp": 1691012345.123,
+	    "propertyID": 297652,
+	    "propertyID_str": "297652",
+	    "roomID": "544559-0",
+	    "roomCondition": "dirty"
+	}
+*/
+type WebhookEvent struct {
+	Version       string      `json:"version"`
+	Event         string      `json:"event"`
+	Timestamp     float64     `json:"timestamp"`
+	PropertyID    json.Number `json:"propertyID"`
+	PropertyIDStr string      `json:"propertyID_str"`
+	ReservationID string      `json:"reservationID,omitempty"`
+	Status        string      `json:"status,omitempty"`
+	RoomID        string      `json:"roomID,omitempty"`
+	RoomCondition string      `json:"roomCondition,omitempty"`
+}
+
+/*
+	Response: {
+	    "success": true,
+	    "data": {
+	        "propertyID": "297652",
+	        "reservationID": "8817735791869",
+	        "status": "checked_out",
+	        "guestList": {...},
+	        "assigned": [
+	            {
+	                "roomTypeName": "Deluxe Queen",
+	                "roomID": "544559-1",
+	                "roomName": "DQ(2)"
+	            }
+	        ]
+	    }
+	}
+*/
+type ResponseGetReservation struct {
+	Success bool               `json:"success"`
+	Data    ReservationDetails `json:"data"`
+	Message string             `json:"message,omitempty"`
+}
+
+type ReservationDetails struct {
+	Reservation
+	Assigned []struct {
+		RoomID   string `json:"roomID"`
+		RoomName string `json:"roomName"`
+	} `json:"assigned"`
+}
+
+/*
+	Response: {
+	    "success": true,
+	    "data": [
+	        {
+	            "id": "5f1e9a8b",
+	            "event": {
+	                "entity": "reservation",
+	                "action": "status_changed"
+	            },
+	            "subscriptionType": "endpoint",
+	            "subscriptionData": {
+	                "endpoint": "https://mypublic.api.address/api/v1/cloudbeds/webhook"
+	            }
+	        }
+	    ]
+	}
+*/
+type ResponseGetWebhooks struct {
+	Success bool      `json:"success"`
+	Data    []Webhook `json:"data"`
+	Message string    `json:"message,omitempty"`
+}
+
+type Webhook struct {
+	ID    string `json:"id"`
+	Event struct {
+		Entity string `json:"entity"`
+		Action string `json:"action"`
+	} `json:"event"`
+	SubscriptionType string `json:"subscriptionType"`
+	SubscriptionData struct {
+		Endpoint string `json:"endpoint"`
+	} `json:"subscriptionData"`
+}
+
+type ResponsePostWebhook struct {
+	Success bool `json:"success"`
+	Data    struct {
+		SubscriptionID string `json:"subscriptionID"`
+	} `json:"data,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// AssignedRoomIDs returns IDs of the rooms assigned to the reservation
+func (r ReservationDetails) AssignedRoomIDs() (roomIDs []string) {
+	seen := make(map[string]bool)
+	for _, assigned := range r.Assigned {
+		if assigned.RoomID == "" || seen[assigned.RoomID] {
+			continue
+		}
+		seen[assigned.RoomID] = true
+		roomIDs = append(roomIDs, assigned.RoomID)
+	}
+	for _, roomID := range r.RoomIDs() {
+		if seen[roomID] {
+			continue
+		}
+		seen[roomID] = true
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Strings(roomIDs)
+	return roomIDs
+}
+
+// propertyID returns the property ID of the webhook. Cloudbeds sends it as a number, propertyID_str is preferred if present
+func (e WebhookEvent) propertyID() string {
+	if e.PropertyIDStr != "" {
+		return e.PropertyIDStr
+	}
+	return e.PropertyID.String()
+}
+
+func (e WebhookEvent) occurredAt() time.Time {
+	if e.Timestamp == 0 {
+		return time.Now()
+	}
+	seconds, fraction := math.Modf(e.Timestamp)
+	return time.Unix(int64(seconds), int64(fraction*float64(time.Second)))
+}
+
+// ParseWebhook converts the Cloudbeds webhook body to hotel events. Reservation status changes other than check-in and check-out are ignored
+func (p *Cloudbeds) ParseWebhook(ctx context.Context, body []byte) (hotelEvents []hotel.Event, err error) {
+	var webhookEvent WebhookEvent
+	err = json.Unmarshal(body, &webhookEvent)
+	if err != nil {
+		return nil, &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("failed to parse webhook: %s", err)}
+	}
+	p.log.Debugf("Received webhook %s: %+v", webhookEvent.Event, webhookEvent)
+
+	propertyID := webhookEvent.propertyID()
+	switch webhookEvent.Event {
+	case "reservation/status_changed":
+		var eventType hotel.EventType
+		switch webhookEvent.Status {
+		case reservationStatusCheckedIn:
+			eventType = hotel.EventCheckIn
+		case reservationStatusCheckedOut:
+			eventType = hotel.EventCheckOut
+		default:
+			p.log.Debugf("Ignoring reservation %s status %s", webhookEvent.ReservationID, webhookEvent.Status)
+			return nil, nil
+		}
+		if webhookEvent.ReservationID == "" {
+			return nil, hotel.NewError(hotel.ErrValidation, fmt.Errorf("webhook %s has no reservationID", webhookEvent.Event))
+		}
+
+		//webhook doesn't contain rooms of the reservation
+		reservation, err := p.getReservation(ctx, webhookEvent.ReservationID, propertyID)
+		if err != nil {
+			return nil, err
+		}
+		roomIDs := reservation.AssignedRoomIDs()
+		if len(roomIDs) == 0 {
+			p.log.Infof("Reservation %s has no assigned rooms. Ignoring %s", webhookEvent.ReservationID, eventType)
+			return nil, nil
+		}
+		for _, roomID := range roomIDs {
+			hotelEvents = append(hotelEvents, p.newEvent(eventType, propertyID, roomID, webhookEvent.ReservationID, "", webhookEvent.occurredAt()))
+		}
+	case "housekeeping/room_condition_changed":
+		if webhookEvent.RoomID == "" {
+			return nil, hotel.NewError(hotel.ErrValidation, fmt.Errorf("webhook %s has no roomID", webhookEvent.Event))
+		}
+		hotelEvents = append(hotelEvents, p.newEvent(hotel.EventRoomConditionChanged, propertyID, webhookEvent.RoomID, "", webhookEvent.RoomCondition, webhookEvent.occurredAt()))
+	default:
+		p.log.Debugf("Ignoring unsupported webhook %s", webhookEvent.Event)
+		return nil, nil
+	}
+
+	return hotelEvents, nil
+}
+
+// newEvent creates hotel event for the room. Room extension is resolved through the extension map
+func (p *Cloudbeds) newEvent(eventType hotel.EventType, propertyID, roomID, reservationID, roomCondition string, eventTime time.Time) hotel.Event {
+	event := hotel.Event{
+		Type:          eventType,
+		PropertyID:    propertyID,
+		RoomID:        roomID,
+		ReservationID: reservationID,
+		RoomCondition: roomCondition,
+		Time:          eventTime,
+	}
+	if p.configMap != nil {
+		if extension, ok := p.configMap.SearchExtensionByRoomID(roomID, propertyID); ok {
+			event.PhoneNumber = extension.RoomExtension
+		}
+	}
+	if event.PhoneNumber == "" {
+		p.log.Debugf("room %s of property %s not found in extension map", roomID, propertyID)
+	}
+	return event
+}
+
+// getReservation returns the reservation reservationID with assigned rooms
+func (p *Cloudbeds) getReservation(ctx context.Context, reservationID, propertyID string) (reservation ReservationDetails, err error) {
+	apiUrl := p.apiUrlGetReservation
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getReservation" // default value
+	}
+
+	params := propertyParams(propertyID)
+	params.Set("reservationID", reservationID)
+
+	p.log.Debugf("getting reservation: %v", params)
+	resp, err := p.send(ctx, "get reservation", func() (*http.Response, error) {
+		return p.get(ctx, apiUrl+"?"+params.Encode())
+	})
+	if err != nil {
+		return reservation, err
+	}
+	defer resp.Body.Close()
+
+	respBody := &ResponseGetReservation{}
+	err = json.NewDecoder(resp.Body).Decode(respBody)
+	if err != nil {
+		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+		p.log.Debugf("success, but parse return body: %s", err)
+		return reservation, detailedError
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to get reservation: %s", respBody.Message)
+		return reservation, hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to get reservation: %s", respBody.Message))
+	}
+
+	return respBody.Data, nil
+}
+
+// SubscribeWebhooks subscribes endpointURL to check-in/check-out and room condition webhooks of every property of the extension map.
+// Subscriptions that already exist are not created again, so it is safe to call it on every start
+func (p *Cloudbeds) SubscribeWebhooks(ctx context.Context, endpointURL string) error {
+	if endpointURL == "" {
+		return fmt.Errorf("webhook endpoint url is empty")
+	}
+
+	for _, propertyID := range p.propertyIDs() {
+		webhooks, err := p.getWebhooks(ctx, propertyID)
+		if err != nil {
+			p.log.Error(err)
+			return err
+		}
+
+		for _, subscription := range webhookSubscriptions {
+			if webhookExists(webhooks, subscription, endpointURL) {
+				p.log.Debugf("webhook %s/%s for property '%s' already exists", subscription.Object, subscription.Action, propertyID)
+				continue
+			}
+			subscriptionID, err := p.postWebhook(ctx, propertyID, subscription, endpointURL)
+			if err != nil {
+				p.log.Error(err)
+				return err
+			}
+			p.log.Infof("Subscribed to webhook %s/%s for property '%s'. Subscription: %s", subscription.Object, subscription.Action, propertyID, subscriptionID)
+		}
+	}
+	return nil
+}
+
+// propertyIDs returns distinct property IDs of the extension map. Empty property ID means the default property of the account
+func (p *Cloudbeds) propertyIDs() (propertyIDs []string) {
+	seen := make(map[string]bool)
+	if p.configMap != nil {
+		for _, extension := range p.configMap.ExtensionMap {
+			if seen[extension.HospitalityPropertyID] {
+				continue
+			}
+			seen[extension.HospitalityPropertyID] = true
+			propertyIDs = append(propertyIDs, extension.HospitalityPropertyID)
+		}
+	}
+	if len(propertyIDs) == 0 {
+		propertyIDs = append(propertyIDs, "")
+	}
+	sort.Strings(propertyIDs)
+	return propertyIDs
+}
+
+func webhookExists(webhooks []Webhook, subscription webhookSubscription, endpointURL string) bool {
+	for _, webhook := range webhooks {
+		if webhook.Event.Entity == subscription.Object && webhook.Event.Action == subscription.Action &&
+			strings.EqualFold(webhook.SubscriptionData.Endpoint, endpointURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// getWebhooks returns webhooks subscribed for the property
+func (p *Cloudbeds) getWebhooks(ctx context.Context, propertyID string) (webhooks []Webhook, err error) {
+	apiUrl := p.apiUrlGetWebhooks
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/getWebhooks" // default value
+	}
+
+	params := propertyParams(propertyID)
+	p.log.Debugf("getting webhooks: %v", params)
+	resp, err := p.send(ctx, "get webhooks", func() (*http.Response, error) {
+		return p.get(ctx, apiUrl+"?"+params.Encode())
+	})
+	if err != nil {
+		return webhooks, err
+	}
+	defer resp.Body.Close()
+
+	respBody := &ResponseGetWebhooks{}
+	err = json.NewDecoder(resp.Body).Decode(respBody)
+	if err != nil {
+		detailedError := &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+		p.log.Debugf("success, but parse return body: %s", err)
+		return webhooks, detailedError
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to get webhooks: %s", respBody.Message)
+		return webhooks, hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to get webhooks: %s", respBody.Message))
+	}
+
+	return respBody.Data, nil
+}
+
+// postWebhook subscribes endpointURL to the webhook. Returns ID of the subscription
+func (p *Cloudbeds) postWebhook(ctx context.Context, propertyID string, subscription webhookSubscription, endpointURL string) (subscriptionID string, err error) {
+	apiUrl := p.apiUrlPostWebhook
+	if apiUrl == "" {
+		apiUrl = "https://hotels.cloudbeds.com/api/v1.2/postWebhook" // default value
+	}
+
+	data := propertyParams(propertyID)
+	data.Set("object", subscription.Object)
+	data.Set("action", subscription.Action)
+	data.Set("endpointUrl", endpointURL)
+
+	p.log.Debugf("Sending POST data to %s: %v", apiUrl, data)
+
+	resp, err := p.send(ctx, "subscribe to webhook", func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", apiUrl, strings.NewReader(data.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return p.client().Do(req)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var respBody ResponsePostWebhook
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		return "", &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+	}
+
+	//check for errors
+	if !respBody.Success {
+		p.log.Debugf("Failed to subscribe to webhook: %s", respBody.Message)
+		return "", hotel.NewError(hotel.ErrValidation, fmt.Errorf("failed to subscribe to webhook: %s", respBody.Message))
+	}
+
+	return respBody.Data.SubscriptionID, nil
+}
+
+// PollEvents returns check-out events of reservations checked out since "since" in every property of the extension map.
+// It is an alternative to webhooks for deployments without a public webhook URL
+func (p *Cloudbeds) PollEvents(ctx context.Context, since time.Time) (hotelEvents []hotel.Event, err error) {
+	now := time.Now()
+	for _, propertyID := range p.propertyIDs() {
+		params := propertyParams(propertyID)
+		params.Set("status", reservationStatusCheckedOut)
+		params.Set("includeGuestsDetails", "true")
+		params.Set("checkOutFrom", since.Format(reservationDateFormat))
+		params.Set("checkOutTo", now.Format(reservationDateFormat))
+		reservations, err := p.getReservations(ctx, params)
+		if err != nil {
+			p.log.Error(err)
+			return hotelEvents, err
+		}
+
+		for _, reservation := range reservations {
+			reservationPropertyID := reservation.PropertyID
+			if reservationPropertyID == "" {
+				reservationPropertyID = propertyID
+			}
+			for _, roomID := range reservation.RoomIDs() {
+				hotelEvents = append(hotelEvents, p.newEvent(hotel.EventCheckOut, reservationPropertyID, roomID, reservation.ReservationID, "", now))
+			}
+		}
+	}
+	return hotelEvents, nil
+}
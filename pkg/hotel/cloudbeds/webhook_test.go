@@ -0,0 +1,227 @@
+package cloudbeds
+
+import (
+	"context"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloudbeds_ParseWebhook(t *testing.T) {
+	cleanUpEnvVars()
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/api/v1.2/getReservation":
+			assert.Equal(t, "297652", r.URL.Query().Get("propertyID"))
+			switch r.URL.Query().Get("reservationID") {
+			case "8817735791869":
+				_, _ = w.Write([]byte(`{"success":true,"data":{"reservationID":"8817735791869","status":"checked_out","guestList":{"51738262":{"guestID":"51738262","roomID":"544559-1"}},"assigned":[{"roomID":"544559-0","roomName":"DQ(1)"}]}}`))
+			case "1111111111111":
+				_, _ = w.Write([]byte(`{"success":true,"data":{"reservationID":"1111111111111","status":"checked_in","assigned":[]}}`))
+			default:
+				_, _ = w.Write([]byte(`{"success":false,"message":"Reservation not found"}`))
+			}
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	eventTime := time.Unix(1691005197, 0)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedEvents []hotel.Event
+		errMsg         string
+		errKind        error
+	}{
+		{
+			name: "Check-out of reservation with two rooms",
+			body: `{"version":"1.0","event":"reservation/status_changed","timestamp":1691005197,"propertyID":297652,"propertyID_str":"297652","reservationID":"8817735791869","status":"checked_out"}`,
+			expectedEvents: []hotel.Event{
+				{Type: hotel.EventCheckOut, PropertyID: "297652", RoomID: "544559-0", PhoneNumber: "1001", ReservationID: "8817735791869", Time: eventTime},
+				{Type: hotel.EventCheckOut, PropertyID: "297652", RoomID: "544559-1", ReservationID: "8817735791869", Time: eventTime},
+			},
+		},
+		{
+			name:           "Check-in without assigned rooms",
+			body:           `{"version":"1.0","event":"reservation/status_changed","timestamp":1691005197,"propertyID":297652,"reservationID":"1111111111111","status":"checked_in"}`,
+			expectedEvents: nil,
+		},
+		{
+			name:           "Other reservation status is ignored",
+			body:           `{"version":"1.0","event":"reservation/status_changed","timestamp":1691005197,"propertyID":297652,"reservationID":"8817735791869","status":"confirmed"}`,
+			expectedEvents: nil,
+		},
+		{
+			name: "Room condition changed",
+			body: `{"version":"1.0","event":"housekeeping/room_condition_changed","timestamp":1691005197,"propertyID":297652,"roomID":"544559-0","roomCondition":"dirty"}`,
+			expectedEvents: []hotel.Event{
+				{Type: hotel.EventRoomConditionChanged, PropertyID: "297652", RoomID: "544559-0", PhoneNumber: "1001", RoomCondition: "dirty", Time: eventTime},
+			},
+		},
+		{
+			name:           "Unsupported webhook is ignored",
+			body:           `{"version":"1.0","event":"guest/created","timestamp":1691005197,"propertyID":297652}`,
+			expectedEvents: nil,
+		},
+		{
+			name:    "Room condition changed without room",
+			body:    `{"version":"1.0","event":"housekeeping/room_condition_changed","propertyID":297652}`,
+			errMsg:  "webhook housekeeping/room_condition_changed has no roomID",
+			errKind: hotel.ErrValidation,
+		},
+		{
+			name:    "Check-out without reservation",
+			body:    `{"version":"1.0","event":"reservation/status_changed","propertyID":297652,"status":"checked_out"}`,
+			errMsg:  "webhook reservation/status_changed has no reservationID",
+			errKind: hotel.ErrValidation,
+		},
+		{
+			name:   "Unknown reservation",
+			body:   `{"version":"1.0","event":"reservation/status_changed","propertyID":297652,"reservationID":"0","status":"checked_out"}`,
+			errMsg: "failed to get reservation: Reservation not found",
+		},
+		{
+			name:   "Bad JSON",
+			body:   `{"event":`,
+			errMsg: "unexpected end of JSON input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &Cloudbeds{
+				httpClient:           mockServer.Client(),
+				log:                  logrus.New(),
+				refresher:            &MockTokenRefresher{},
+				apiUrlGetReservation: mockServer.URL + "/api/v1.2/getReservation",
+				configMap: &configuration.ConfigMap{
+					ExtensionMap: []configuration.Extension{
+						{RoomExtension: "1001", HospitalityRoomID: "544559-0", HospitalityPropertyID: "297652"},
+						{RoomExtension: "2002", HospitalityRoomID: "544559-1", HospitalityPropertyID: "311111"},
+					},
+				},
+			}
+
+			hotelEvents, err := cb.ParseWebhook(context.Background(), []byte(tt.body))
+			if tt.errMsg != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errMsg, err.Error())
+				if tt.errKind != nil {
+					assert.ErrorIs(t, err, tt.errKind)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedEvents, hotelEvents)
+		})
+	}
+}
+
+func TestCloudbeds_SubscribeWebhooks(t *testing.T) {
+	cleanUpEnvVars()
+	endpointURL := "https://mypublic.api.address/api/v1/cloudbeds/webhook"
+	var subscribed []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/api/v1.2/getWebhooks":
+			if r.URL.Query().Get("propertyID") == "297652" {
+				//reservation webhook already exists for the first property
+				_, _ = w.Write([]byte(`{"success":true,"data":[{"id":"1","event":{"entity":"reservation","action":"status_changed"},"subscriptionType":"endpoint","subscriptionData":{"endpoint":"` + endpointURL + `"}}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"success":true,"data":[]}`))
+		case "/api/v1.2/postWebhook":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, endpointURL, r.PostForm.Get("endpointUrl"))
+			subscribed = append(subscribed, r.PostForm.Get("propertyID")+":"+r.PostForm.Get("object")+"/"+r.PostForm.Get("action"))
+			_, _ = w.Write([]byte(`{"success":true,"data":{"subscriptionID":"42"}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	cb := &Cloudbeds{
+		httpClient:        mockServer.Client(),
+		log:               logrus.New(),
+		refresher:         &MockTokenRefresher{},
+		apiUrlGetWebhooks: mockServer.URL + "/api/v1.2/getWebhooks",
+		apiUrlPostWebhook: mockServer.URL + "/api/v1.2/postWebhook",
+		configMap: &configuration.ConfigMap{
+			ExtensionMap: []configuration.Extension{
+				{RoomExtension: "1001", HospitalityRoomID: "544559-0", HospitalityPropertyID: "297652"},
+				{RoomExtension: "1002", HospitalityRoomID: "544559-1", HospitalityPropertyID: "297652"},
+				{RoomExtension: "2001", HospitalityRoomID: "544559-0", HospitalityPropertyID: "311111"},
+			},
+		},
+	}
+
+	err := cb.SubscribeWebhooks(context.Background(), endpointURL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"297652:housekeeping/room_condition_changed",
+		"311111:reservation/status_changed",
+		"311111:housekeeping/room_condition_changed",
+	}, subscribed)
+
+	err = cb.SubscribeWebhooks(context.Background(), "")
+	assert.Error(t, err)
+	assert.Equal(t, "webhook endpoint url is empty", err.Error())
+}
+
+func TestCloudbeds_PollEvents(t *testing.T) {
+	cleanUpEnvVars()
+	since := time.Date(2023, 8, 1, 10, 0, 0, 0, time.Local)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/api/v1.2/getReservations":
+			query := r.URL.Query()
+			assert.Equal(t, "checked_out", query.Get("status"))
+			assert.Equal(t, "2023-08-01", query.Get("checkOutFrom"))
+			assert.Equal(t, time.Now().Format("2006-01-02"), query.Get("checkOutTo"))
+			if query.Get("propertyID") == "297652" {
+				_, _ = w.Write([]byte(`{"success":true,"data":[{"propertyID":"297652","reservationID":"8817735791869","status":"checked_out","guestList":{"51738262":{"guestID":"51738262","roomID":"544559-0"}}}],"count":1,"total":1}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"success":true,"data":[],"count":0,"total":0}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer mockServer.Close()
+
+	cb := &Cloudbeds{
+		httpClient:            mockServer.Client(),
+		log:                   logrus.New(),
+		refresher:             &MockTokenRefresher{},
+		apiUrlGetReservations: mockServer.URL + "/api/v1.2/getReservations",
+		configMap: &configuration.ConfigMap{
+			ExtensionMap: []configuration.Extension{
+				{RoomExtension: "1001", HospitalityRoomID: "544559-0", HospitalityPropertyID: "297652"},
+				{RoomExtension: "2001", HospitalityRoomID: "544559-0", HospitalityPropertyID: "311111"},
+			},
+		},
+	}
+
+	hotelEvents, err := cb.PollEvents(context.Background(), since)
+	assert.NoError(t, err)
+	if assert.Len(t, hotelEvents, 1) {
+		assert.Equal(t, hotel.EventCheckOut, hotelEvents[0].Type)
+		assert.Equal(t, "297652", hotelEvents[0].PropertyID)
+		assert.Equal(t, "544559-0", hotelEvents[0].RoomID)
+		assert.Equal(t, "1001", hotelEvents[0].PhoneNumber)
+		assert.Equal(t, "8817735791869", hotelEvents[0].ReservationID)
+	}
+}
@@ -0,0 +1,35 @@
+package hotel
+
+import "sort"
+
+// RoomConditionMap maps room conditions dialed from the room phone (number_type of housekeeper_map) to room conditions of a hospitality provider API
+type RoomConditionMap map[string]string
+
+// RoomConditionSet is the set of room conditions that can be dialed, e.g. the conditions listed in the API configuration
+type RoomConditionSet map[string]struct{}
+
+// NewRoomConditionSet returns the set of conditions
+func NewRoomConditionSet(conditions ...string) RoomConditionSet {
+	set := make(RoomConditionSet, len(conditions))
+	for _, condition := range conditions {
+		set[condition] = struct{}{}
+	}
+	return set
+}
+
+// Conditions returns sorted room conditions that can be dialed
+func (m RoomConditionMap) Conditions() []string {
+	conditions := make([]string, 0, len(m))
+	for condition := range m {
+		conditions = append(conditions, condition)
+	}
+	sort.Strings(conditions)
+	return conditions
+}
+
+// IsRoomConditionValid returns true if roomCondition is one of the conditions supported by a hospitality provider.
+// validConditions is RoomConditionMap or RoomConditionSet
+func IsRoomConditionValid[V any](roomCondition string, validConditions map[string]V) bool {
+	_, ok := validConditions[roomCondition]
+	return ok
+}
@@ -0,0 +1,25 @@
+package hotel
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIsRoomConditionValid(t *testing.T) {
+	validConditions := NewRoomConditionSet("clean", "dirty")
+	assert.True(t, IsRoomConditionValid("clean", validConditions))
+	assert.True(t, IsRoomConditionValid("dirty", validConditions))
+	assert.False(t, IsRoomConditionValid("Clean", validConditions))
+	assert.False(t, IsRoomConditionValid("", validConditions))
+	assert.False(t, IsRoomConditionValid("clean", RoomConditionSet(nil)))
+
+	conditionMap := RoomConditionMap{"clean": "Clean", "ooo": "OutOfOrder"}
+	assert.True(t, IsRoomConditionValid("ooo", conditionMap))
+	assert.False(t, IsRoomConditionValid("OutOfOrder", conditionMap))
+}
+
+func TestRoomConditionMap_Conditions(t *testing.T) {
+	conditions := RoomConditionMap{"dirty": "Dirty", "clean": "Clean", "inspected": "Inspected"}
+	assert.Equal(t, []string{"clean", "dirty", "inspected"}, conditions.Conditions())
+	assert.Empty(t, RoomConditionMap{}.Conditions())
+}
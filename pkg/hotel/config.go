@@ -0,0 +1,33 @@
+package hotel
+
+import (
+	"fmt"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+// VarFromStoreOrEnvironment returns variable varName from the secret store or from environment if the store doesn't have it.
+// Store errors are logged: the environment is used then
+func VarFromStoreOrEnvironment(log *logrus.Logger, store secrets.SecretsStore, varName string) string {
+	result, err := store.RetrieveVar(varName)
+	if err != nil || result == "" {
+		if err != nil {
+			log.Debugf("Got error while trying to get variable '%s' from store: %s", varName, err)
+		}
+		return os.Getenv(varName)
+	}
+	return result
+}
+
+// SearchExtension returns the extension with roomExtensionNumber from the extension map of configMap
+func SearchExtension(log *logrus.Logger, configMap *configuration.ConfigMap, roomExtensionNumber string) (configuration.Extension, error) {
+	extension, ok := configMap.SearchExtensionByPhoneNumber(roomExtensionNumber)
+	if !ok {
+		err := fmt.Errorf("phone number %s not found", roomExtensionNumber)
+		log.Error(err)
+		return extension, err
+	}
+	return extension, nil
+}
@@ -0,0 +1,50 @@
+package hotel
+
+import (
+	"errors"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/secretstest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestVarFromStoreOrEnvironment(t *testing.T) {
+	tests := []struct {
+		name           string
+		storeValue     string
+		storeErr       error
+		expectedResult string
+	}{
+		{name: "from store", storeValue: "store_value", expectedResult: "store_value"},
+		{name: "from environment", storeValue: "", expectedResult: "env_value"},
+		{name: "store error", storeErr: errors.New("some error"), expectedResult: "env_value"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			store := new(secretstest.MockSecretsStore)
+			store.On("RetrieveVar", "TEST_VAR").Return(tt.storeValue, tt.storeErr)
+			os.Setenv("TEST_VAR", "env_value")
+			defer os.Unsetenv("TEST_VAR")
+
+			assert.Equal(t, tt.expectedResult, VarFromStoreOrEnvironment(logrus.New(), store, "TEST_VAR"))
+			store.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSearchExtension(t *testing.T) {
+	configMap := &configuration.ConfigMap{
+		ExtensionMap: []configuration.Extension{{RoomExtension: "1001", HospitalityRoomID: "UNIT-1"}},
+	}
+
+	extension, err := SearchExtension(logrus.New(), configMap, "1001")
+	assert.NoError(t, err)
+	assert.Equal(t, "UNIT-1", extension.HospitalityRoomID)
+
+	_, err = SearchExtension(logrus.New(), configMap, "999")
+	assert.EqualError(t, err, "phone number 999 not found")
+}
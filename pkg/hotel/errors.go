@@ -0,0 +1,49 @@
+package hotel
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Kinds of hospitality provider failures. Callers check them with errors.Is to choose the reaction:
+// ask the admin to login again, report the bad request back or retry later
+var (
+	// ErrAuthorization means the provider rejected the credentials or the access token and refreshing it did not help. Login is needed
+	ErrAuthorization = errors.New("authorization failed")
+	// ErrValidation means the provider rejected the request itself, e.g. "Parameter roomID is required". Repeating it won't help
+	ErrValidation = errors.New("validation failed")
+	// ErrTransient means the provider is unreachable, overloaded (429) or failed (5xx). The request may succeed later
+	ErrTransient = errors.New("transient failure")
+)
+
+// classifiedError marks err as one of the kinds above without changing its message
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+// NewError marks err as kind (ErrAuthorization, ErrValidation or ErrTransient). The message of err is kept,
+// errors.Is(err, kind) reports true and errors.As still finds the errors wrapped by err (e.g. *DetailedError)
+func NewError(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{kind: kind, err: err}
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() error { return e.err }
+
+func (e *classifiedError) Is(target error) bool { return target == e.kind }
+
+// IsIdempotent reports whether the request with HTTP method can be sent again without repeating its effect.
+// 5xx replies to other requests are not ErrTransient: the request might have been processed and repeating it might duplicate the change
+func IsIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
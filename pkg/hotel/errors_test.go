@@ -0,0 +1,34 @@
+package hotel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewError(t *testing.T) {
+	detailedError := &DetailedError{Msg: errors.New("cloudbeds request failed with status 400"), Details: `{"success":false}`}
+	err := fmt.Errorf("update room: %w", NewError(ErrValidation, detailedError))
+
+	assert.EqualError(t, err, "update room: cloudbeds request failed with status 400")
+	assert.ErrorIs(t, err, ErrValidation)
+	assert.False(t, errors.Is(err, ErrTransient))
+	assert.False(t, errors.Is(err, ErrAuthorization))
+
+	var target *DetailedError
+	assert.True(t, errors.As(err, &target))
+	assert.Equal(t, detailedError, target)
+
+	assert.Nil(t, NewError(ErrTransient, nil))
+}
+
+func TestIsIdempotent(t *testing.T) {
+	assert.True(t, IsIdempotent(http.MethodGet))
+	assert.True(t, IsIdempotent(http.MethodPut))
+	assert.True(t, IsIdempotent(http.MethodDelete))
+	assert.False(t, IsIdempotent(http.MethodPost))
+	assert.False(t, IsIdempotent(http.MethodPatch))
+}
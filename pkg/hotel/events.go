@@ -0,0 +1,103 @@
+package hotel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventType is a type of change made in a hospitality provider
+type EventType string
+
+const (
+	EventCheckIn              EventType = "check_in"
+	EventCheckOut             EventType = "check_out"
+	EventRoomConditionChanged EventType = "room_condition_changed"
+)
+
+// Event is a change made in a hospitality provider (check-in, check-out, room condition changed...) that hotelito reacts to
+type Event struct {
+	Type          EventType `json:"type"`
+	PropertyID    string    `json:"propertyID,omitempty"`
+	RoomID        string    `json:"roomID,omitempty"`
+	PhoneNumber   string    `json:"phoneNumber,omitempty"` // room extension. Empty if the room is not in the extension map
+	ReservationID string    `json:"reservationID,omitempty"`
+	RoomCondition string    `json:"roomCondition,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// EventHandler reacts to events of a hospitality provider
+type EventHandler interface {
+	HandleEvent(ctx context.Context, event Event) error
+}
+
+// EventHandlerFunc is an adapter to use ordinary functions as EventHandler
+type EventHandlerFunc func(ctx context.Context, event Event) error
+
+// HandleEvent calls f(ctx, event)
+func (f EventHandlerFunc) HandleEvent(ctx context.Context, event Event) error { return f(ctx, event) }
+
+// WebhookProvider is implemented by hospitality providers that can push their events to hotelito
+type WebhookProvider interface {
+	// SubscribeWebhooks subscribes endpointURL to events of the provider. Already existing subscriptions are kept
+	SubscribeWebhooks(ctx context.Context, endpointURL string) error
+	// ParseWebhook converts the body of a webhook request to events. Unsupported webhooks result in no events
+	ParseWebhook(ctx context.Context, body []byte) ([]Event, error)
+}
+
+// EventPoller is implemented by hospitality providers that can report their events on request. It is used when webhooks are not available
+type EventPoller interface {
+	// PollEvents returns check-out events of reservations checked out since "since". The same event can be returned by subsequent calls
+	PollEvents(ctx context.Context, since time.Time) ([]Event, error)
+}
+
+// EventDispatcher delivers events to the handlers registered for the event type
+type EventDispatcher struct {
+	mu       sync.RWMutex
+	handlers map[EventType][]EventHandler
+}
+
+func NewEventDispatcher() *EventDispatcher {
+	return &EventDispatcher{
+		handlers: make(map[EventType][]EventHandler),
+	}
+}
+
+// Register adds handler for events of eventType. Handlers are called in the order of registration
+func (d *EventDispatcher) Register(eventType EventType, handler EventHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[eventType] = append(d.handlers[eventType], handler)
+}
+
+// HasHandlers returns true if at least one handler is registered for eventType
+func (d *EventDispatcher) HasHandlers(eventType EventType) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.handlers[eventType]) > 0
+}
+
+// Dispatch calls all handlers registered for the event type. A failed handler doesn't stop the others. The first error is returned
+func (d *EventDispatcher) Dispatch(ctx context.Context, event Event) error {
+	d.mu.RLock()
+	handlers := d.handlers[event.Type]
+	d.mu.RUnlock()
+
+	var firstErr error
+	amountOfFailed := 0
+	for _, handler := range handlers {
+		err := handler.HandleEvent(ctx, event)
+		if err != nil {
+			amountOfFailed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d handlers failed for event %s of room %s: %w", amountOfFailed, len(handlers), event.Type, event.RoomID, firstErr)
+	}
+	return nil
+}
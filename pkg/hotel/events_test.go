@@ -0,0 +1,36 @@
+package hotel
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEventDispatcher_Dispatch(t *testing.T) {
+	dispatcher := NewEventDispatcher()
+
+	var calls []string
+	dispatcher.Register(EventCheckOut, EventHandlerFunc(func(ctx context.Context, event Event) error {
+		calls = append(calls, "first:"+event.RoomID)
+		return errors.New("first handler failed")
+	}))
+	dispatcher.Register(EventCheckOut, EventHandlerFunc(func(ctx context.Context, event Event) error {
+		calls = append(calls, "second:"+event.RoomID)
+		return nil
+	}))
+
+	assert.True(t, dispatcher.HasHandlers(EventCheckOut))
+	assert.False(t, dispatcher.HasHandlers(EventCheckIn))
+
+	// failed handler doesn't stop the next one
+	err := dispatcher.Dispatch(context.Background(), Event{Type: EventCheckOut, RoomID: "544559-0"})
+	assert.Error(t, err)
+	assert.Equal(t, "1 of 2 handlers failed for event check_out of room 544559-0: first handler failed", err.Error())
+	assert.Equal(t, []string{"first:544559-0", "second:544559-0"}, calls)
+
+	// no handlers registered
+	err = dispatcher.Dispatch(context.Background(), Event{Type: EventCheckIn, RoomID: "544559-0"})
+	assert.NoError(t, err)
+	assert.Len(t, calls, 2)
+}
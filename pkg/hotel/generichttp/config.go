@@ -0,0 +1,196 @@
+package generichttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+/*
+ApiConfiguration describes the API of a hospitality provider. It is loaded from HOSPITALITY_API_CONF_FILENAME.
+URL, header and body values are Go templates (text/template). Available in URL and body templates:
+.RoomExtension, .RoomID, .RoomName, .PropertyID, .RoomCondition (value of roomConditions), .HousekeeperName,
+.DoNotDisturb, .Blocked, .Reason, .ReservationID, .ItemCode, .ItemName, .HospitalityItemID, .Price, .Quantity, .Amount.
+Functions: {{json .X}} writes .X as JSON value, {{var "NAME"}} reads variable from secret store or environment
+(the only data available in header templates), {{urlquery .X}} escapes .X for URL.
+
+	{
+	    "authHeader": {"name": "Authorization", "value": "Bearer {{var \"GENERIC_HTTP_API_TOKEN\"}}"},
+	    "roomConditions": {"clean": "CLEAN", "dirty": "DIRTY"},
+	    "endpoints": {
+	        "getRooms": {"method": "GET", "url": "https://pms.example.com/api/rooms", "roomsPath": "$.data.rooms"},
+	        "updateRoom": {"method": "PUT", "url": "https://pms.example.com/api/rooms/{{.RoomID}}/status", "body": "{\"status\": {{json .RoomCondition}}}"}
+	    },
+	    "roomFields": {"roomID": "id", "roomName": "name", "roomCondition": "housekeeping.status"}
+	}
+*/
+type ApiConfiguration struct {
+	AuthHeader     Header            `json:"authHeader"`
+	Headers        map[string]string `json:"headers"`
+	RoomConditions map[string]string `json:"roomConditions"`
+	Endpoints      struct {
+		GetRooms               *Endpoint `json:"getRooms"`
+		GetRoom                *Endpoint `json:"getRoom"`
+		UpdateRoom             *Endpoint `json:"updateRoom"`
+		UpdateRoomDoNotDisturb *Endpoint `json:"updateRoomDoNotDisturb"`
+		UpdateRoomBlock        *Endpoint `json:"updateRoomBlock"`
+		PostCharge             *Endpoint `json:"postCharge"`
+	} `json:"endpoints"`
+	RoomFields RoomFields `json:"roomFields"`
+}
+
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Endpoint is a request to the hospitality provider
+type Endpoint struct {
+	Method      string `json:"method"` // GET by default, POST by default if body is set
+	URL         string `json:"url"`
+	Body        string `json:"body"`
+	ContentType string `json:"contentType"` // application/json by default
+	// PerProperty sends the request once for every hospitality_property_id of the extension map (getRooms only)
+	PerProperty bool `json:"perProperty"`
+	// RoomsPath is JSONPath of the rooms array in the reply of getRooms. "$" (root) by default
+	RoomsPath string `json:"roomsPath"`
+	// RoomPath is JSONPath of the room object in the reply of getRoom. "$" (root) by default
+	RoomPath string `json:"roomPath"`
+
+	urlTemplate  *template.Template
+	bodyTemplate *template.Template
+}
+
+// RoomFields are JSONPaths of room attributes relative to a room object of getRooms/getRoom reply. Empty paths are not read
+type RoomFields struct {
+	RoomID         string `json:"roomID"`
+	RoomName       string `json:"roomName"`
+	RoomType       string `json:"roomType"`
+	PropertyID     string `json:"propertyID"`
+	RoomCondition  string `json:"roomCondition"`
+	RoomBlocked    string `json:"roomBlocked"`
+	RoomOccupied   string `json:"roomOccupied"`
+	ReservationID  string `json:"reservationID"`
+	GuestFirstName string `json:"guestFirstName"`
+	GuestLastName  string `json:"guestLastName"`
+	GuestEmail     string `json:"guestEmail"`
+}
+
+func loadApiConfiguration(log *logrus.Logger, apiConfigurationFileName string) (apiConfiguration *ApiConfiguration, err error) {
+	apiConfiguration = &ApiConfiguration{}
+	file, err := os.Open(apiConfigurationFileName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error opening config file: %s", err.Error())
+		log.Errorf(errMsg)
+		return apiConfiguration, errors.New(errMsg)
+	}
+	defer file.Close()
+	byteValue, _ := io.ReadAll(file)
+	err = json.Unmarshal(byteValue, apiConfiguration)
+	if err != nil {
+		errMsg := fmt.Errorf("error unmarshalling config file %s: %s", apiConfigurationFileName, err.Error())
+		log.Errorf(errMsg.Error())
+		return apiConfiguration, errMsg
+	}
+	return apiConfiguration, nil
+}
+
+// validate checks required settings and parses templates of the endpoints with funcs
+func (c *ApiConfiguration) validate(funcs template.FuncMap) error {
+	if c.Endpoints.GetRooms == nil {
+		return errors.New("endpoint getRooms is required")
+	}
+	if c.RoomFields.RoomID == "" {
+		return errors.New("roomFields.roomID is required")
+	}
+
+	endpoints := map[string]*Endpoint{
+		"getRooms":               c.Endpoints.GetRooms,
+		"getRoom":                c.Endpoints.GetRoom,
+		"updateRoom":             c.Endpoints.UpdateRoom,
+		"updateRoomDoNotDisturb": c.Endpoints.UpdateRoomDoNotDisturb,
+		"updateRoomBlock":        c.Endpoints.UpdateRoomBlock,
+		"postCharge":             c.Endpoints.PostCharge,
+	}
+	for name, endpoint := range endpoints {
+		if endpoint == nil {
+			continue
+		}
+		err := endpoint.parse(name, funcs)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range []string{c.Endpoints.GetRooms.RoomsPath, c.RoomFields.RoomID, c.RoomFields.RoomName, c.RoomFields.RoomType,
+		c.RoomFields.PropertyID, c.RoomFields.RoomCondition, c.RoomFields.RoomBlocked, c.RoomFields.RoomOccupied,
+		c.RoomFields.ReservationID, c.RoomFields.GuestFirstName, c.RoomFields.GuestLastName, c.RoomFields.GuestEmail} {
+		if _, err := parseJSONPath(path); err != nil {
+			return err
+		}
+	}
+	if c.Endpoints.GetRoom != nil {
+		if _, err := parseJSONPath(c.Endpoints.GetRoom.RoomPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Endpoint) parse(name string, funcs template.FuncMap) (err error) {
+	if e.URL == "" {
+		return fmt.Errorf("endpoint %s: url is required", name)
+	}
+	if e.Method == "" {
+		e.Method = "GET"
+		if e.Body != "" {
+			e.Method = "POST"
+		}
+	}
+	e.Method = strings.ToUpper(e.Method)
+	if e.ContentType == "" {
+		e.ContentType = "application/json"
+	}
+
+	e.urlTemplate, err = template.New(name + " url").Funcs(funcs).Parse(e.URL)
+	if err != nil {
+		return fmt.Errorf("endpoint %s: %w", name, err)
+	}
+	if e.Body != "" {
+		e.bodyTemplate, err = template.New(name + " body").Funcs(funcs).Parse(e.Body)
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// newTemplateFuncs returns functions available in all templates. getVar reads variable from secret store or environment
+func newTemplateFuncs(getVar func(varName string) string) template.FuncMap {
+	return template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			jsonValue, err := json.Marshal(value)
+			return string(jsonValue), err
+		},
+		"var": getVar,
+	}
+}
+
+// renderTemplate executes template text with data. Used for header values
+func renderTemplate(name, text string, funcs template.FuncMap, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	result := &strings.Builder{}
+	err = tmpl.Execute(result, data)
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
@@ -0,0 +1,527 @@
+// Package generichttp represents a hospitality provider configured declaratively for PMSes without a dedicated provider.
+// Requests (URL templates, method, auth header, body templates) and the way rooms are read from replies (JSONPath) are described
+// in the API configuration file (HOSPITALITY_API_CONF_FILENAME), see ApiConfiguration.
+// Rooms of the extension map are matched by hospitality_room_id against the roomID field of the configured rooms list.
+package generichttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HTTPClient is needed for mocking http requests in tests. Original http.Client implements this interface
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// GenericHTTP is used to make requests to a hospitality provider described by ApiConfiguration
+type GenericHTTP struct {
+	httpClient     HTTPClient
+	storeClient    secrets.SecretsStore
+	log            *logrus.Logger
+	configMap      *configuration.ConfigMap
+	apiConfig      *ApiConfiguration
+	headers        map[string]string // rendered auth header and additional headers
+	roomConditions hotel.RoomConditionMap
+}
+
+// requestData is passed to URL and body templates of the endpoints
+type requestData struct {
+	RoomExtension     string
+	RoomID            string
+	RoomName          string
+	PropertyID        string
+	RoomCondition     string
+	HousekeeperName   string
+	DoNotDisturb      bool
+	Blocked           bool
+	Reason            string
+	ReservationID     string
+	ItemCode          string
+	ItemName          string
+	HospitalityItemID string
+	Price             float64
+	Quantity          int
+	Amount            float64
+}
+
+func init() {
+	hotel.Register("generichttp", func(log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (hotel.Provider, error) {
+		client, err := New(log, secretStore, configMap)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *configuration.ConfigMap) (*GenericHTTP, error) {
+	log.Debugf("Creating new generic http client")
+
+	apiConfiguration, err := loadApiConfiguration(log, configMapInfo.ApiCfgFileName)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	client := &GenericHTTP{
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		storeClient: secretStore,
+		log:         log,
+		configMap:   configMapInfo,
+	}
+	err = client.setApiConfiguration(apiConfiguration)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// setApiConfiguration validates apiConfiguration and renders headers
+func (p *GenericHTTP) setApiConfiguration(apiConfiguration *ApiConfiguration) error {
+	funcs := newTemplateFuncs(func(varName string) string {
+		return hotel.VarFromStoreOrEnvironment(p.log, p.storeClient, varName)
+	})
+	err := apiConfiguration.validate(funcs)
+	if err != nil {
+		return fmt.Errorf("api configuration %s is not valid: %w", p.configMap.ApiCfgFileName, err)
+	}
+
+	headers := make(map[string]string)
+	for name, value := range apiConfiguration.Headers {
+		headers[name] = value
+	}
+	if apiConfiguration.AuthHeader.Name != "" {
+		headers[apiConfiguration.AuthHeader.Name] = apiConfiguration.AuthHeader.Value
+	}
+	for name, value := range headers {
+		headers[name], err = renderTemplate(name, value, funcs, nil)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", name, err)
+		}
+	}
+
+	p.apiConfig = apiConfiguration
+	p.headers = headers
+	p.roomConditions = apiConfiguration.RoomConditions
+	return nil
+}
+
+// GetRooms returns the rooms list of getRooms endpoint. The request is sent for every property if perProperty is set
+func (p *GenericHTTP) GetRooms(ctx context.Context) (rooms []hotel.Room, err error) {
+	p.log.Debugf("getting rooms")
+
+	endpoint := p.apiConfig.Endpoints.GetRooms
+	propertyIDs := []string{""}
+	if endpoint.PerProperty {
+		propertyIDs = p.propertyIDs()
+	}
+
+	for _, propertyID := range propertyIDs {
+		var document interface{}
+		err = p.send(ctx, "getRooms", endpoint, requestData{PropertyID: propertyID}, &document)
+		if err != nil {
+			p.log.Error(err)
+			return rooms, err
+		}
+		roomsValue, ok, err := lookupJSONPath(document, endpoint.RoomsPath)
+		if err != nil {
+			return rooms, err
+		}
+		roomObjects, isArray := roomsValue.([]interface{})
+		if !ok || !isArray {
+			errMsg := fmt.Errorf("rooms list %s not found in getRooms reply", endpoint.RoomsPath)
+			p.log.Error(errMsg)
+			return rooms, &hotel.DetailedError{Msg: errMsg, Details: "success, but parse return body"}
+		}
+		for _, roomObject := range roomObjects {
+			room := p.toHotelRoom(roomObject)
+			if room.PropertyID == "" {
+				room.PropertyID = propertyID
+			}
+			rooms = append(rooms, room)
+		}
+	}
+
+	if len(rooms) == 0 {
+		errMsg := errors.New("no rooms found")
+		return rooms, &hotel.DetailedError{Msg: errMsg, Details: "success, but no rooms found"}
+	}
+	p.log.Debugf("Amount of rooms: %d", len(rooms))
+	return rooms, nil
+}
+
+// GetRoom returns the room with extension roomNumber from getRoom endpoint or, if it is not configured, from the rooms list
+func (p *GenericHTTP) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
+	p.log.Infof("get info about room %s", roomNumber)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomNumber)
+	if err != nil {
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+
+	endpoint := p.apiConfig.Endpoints.GetRoom
+	if endpoint == nil {
+		rooms, err := p.GetRooms(ctx)
+		if err != nil {
+			return hotel.Room{PhoneNumber: roomNumber}, err
+		}
+		for _, room := range rooms {
+			if room.RoomID == extension.HospitalityRoomID {
+				return room, nil
+			}
+		}
+		errMsg := fmt.Sprintf("room %s not found", extension.HospitalityRoomID)
+		p.log.Error(errMsg)
+		return hotel.Room{PhoneNumber: roomNumber, RoomID: extension.HospitalityRoomID}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	var document interface{}
+	err = p.send(ctx, "getRoom", endpoint, p.extensionData(extension), &document)
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Room{PhoneNumber: roomNumber, RoomID: extension.HospitalityRoomID}, err
+	}
+	roomObject, ok, err := lookupJSONPath(document, endpoint.RoomPath)
+	if err != nil {
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+	if !ok {
+		errMsg := fmt.Errorf("room %s not found in getRoom reply", endpoint.RoomPath)
+		p.log.Error(errMsg)
+		return hotel.Room{PhoneNumber: roomNumber, RoomID: extension.HospitalityRoomID}, &hotel.DetailedError{Msg: errMsg, Details: "success, but parse return body"}
+	}
+	room := p.toHotelRoom(roomObject)
+	room.PhoneNumber = roomNumber
+	if room.RoomID == "" {
+		room.RoomID = extension.HospitalityRoomID
+	}
+	return room, nil
+}
+
+// GetRoomGuest returns the guest of the room with extension roomNumber. Guest fields of roomFields must be configured
+func (p *GenericHTTP) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	p.log.Debugf("get in-house guest for room %s", roomNumber)
+
+	room, err := p.GetRoom(ctx, roomNumber, "")
+	if err != nil {
+		return hotel.Guest{}, err
+	}
+	if room.Guest == nil {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomNumber)
+		p.log.Debugf(errMsg)
+		return hotel.Guest{}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	return *room.Guest, nil
+}
+
+// UpdateRoom sends updateRoom request with the condition mapped by roomConditions
+func (p *GenericHTTP) UpdateRoom(ctx context.Context, roomExtensionNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoom %s to %s for %s", roomExtensionNumber, housekeepingStatus, housekeeperName)
+
+	endpoint, err := p.endpoint("updateRoom", p.apiConfig.Endpoints.UpdateRoom)
+	if err != nil {
+		return msg, err
+	}
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+	if !hotel.IsRoomConditionValid(housekeepingStatus, p.roomConditions) {
+		errMsg := fmt.Sprintf("room condition %s is not valid", housekeepingStatus)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	data := p.extensionData(extension)
+	data.RoomCondition = p.roomConditions[housekeepingStatus]
+	data.HousekeeperName = housekeeperName
+	err = p.send(ctx, "updateRoom", endpoint, data, nil)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomExtensionNumber, housekeepingStatus)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// UpdateRoomDoNotDisturb sends updateRoomDoNotDisturb request. Template data: .DoNotDisturb
+func (p *GenericHTTP) UpdateRoomDoNotDisturb(ctx context.Context, roomExtensionNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomDoNotDisturb %s to %t for %s", roomExtensionNumber, doNotDisturb, housekeeperName)
+
+	endpoint, err := p.endpoint("updateRoomDoNotDisturb", p.apiConfig.Endpoints.UpdateRoomDoNotDisturb)
+	if err != nil {
+		return msg, err
+	}
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+
+	data := p.extensionData(extension)
+	data.DoNotDisturb = doNotDisturb
+	data.HousekeeperName = housekeeperName
+	err = p.send(ctx, "updateRoomDoNotDisturb", endpoint, data, nil)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish UpdateRoomDoNotDisturb successfully updated room %s to %t", roomExtensionNumber, doNotDisturb)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// UpdateRoomBlock sends updateRoomBlock request. Template data: .Blocked, .Reason
+func (p *GenericHTTP) UpdateRoomBlock(ctx context.Context, roomExtensionNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomBlock %s to %t (%s) for %s", roomExtensionNumber, blocked, reason, staffName)
+
+	endpoint, err := p.endpoint("updateRoomBlock", p.apiConfig.Endpoints.UpdateRoomBlock)
+	if err != nil {
+		return msg, err
+	}
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+
+	data := p.extensionData(extension)
+	data.Blocked = blocked
+	data.Reason = reason
+	data.HousekeeperName = staffName
+	err = p.send(ctx, "updateRoomBlock", endpoint, data, nil)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	action := "unblocked"
+	if blocked {
+		action = "blocked"
+	}
+	msg = fmt.Sprintf("Finish UpdateRoomBlock successfully %s room %s", action, roomExtensionNumber)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// PostCharge sends postCharge request for the item of the catalog. The reservation of the in-house guest is passed as .ReservationID
+func (p *GenericHTTP) PostCharge(ctx context.Context, roomExtensionNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start PostCharge %d x %s to room %s by %s", quantity, itemCode, roomExtensionNumber, housekeeperName)
+
+	endpoint, err := p.endpoint("postCharge", p.apiConfig.Endpoints.PostCharge)
+	if err != nil {
+		return msg, err
+	}
+	item, ok := p.configMap.SearchItemByCode(itemCode)
+	if !ok {
+		errMsg := fmt.Sprintf("item %s not found in item catalog", itemCode)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	if quantity <= 0 {
+		errMsg := fmt.Sprintf("quantity %d is not valid", quantity)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+	guest, err := p.GetRoomGuest(ctx, roomExtensionNumber)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	data := p.extensionData(extension)
+	data.HousekeeperName = housekeeperName
+	data.ReservationID = guest.ReservationID
+	data.ItemCode = item.ItemCode
+	data.ItemName = item.Name
+	data.HospitalityItemID = item.HospitalityItemID
+	data.Price = item.Price
+	data.Quantity = quantity
+	data.Amount = item.Price * float64(quantity)
+	err = p.send(ctx, "postCharge", endpoint, data, nil)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish PostCharge successfully posted %d x %s to room %s. Reservation: %s", quantity, item.Name, roomExtensionNumber, guest.ReservationID)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// HandleOAuthCallback does nothing. Authentication is done with the static auth header
+func (p *GenericHTTP) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
+	p.log.Debugf("generic http provider uses static auth header. OAuth callback is ignored")
+	return nil
+}
+
+// HandleInitialLogin does nothing. Authentication is done with the static auth header, so empty url is returned
+func (p *GenericHTTP) HandleInitialLogin(ctx context.Context) (url string, err error) {
+	p.log.Debugf("generic http provider uses static auth header. Login is not required")
+	return "", nil
+}
+
+func (p *GenericHTTP) Close() error {
+	err := p.storeClient.Close()
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to close secret store: %s", err.Error())
+		p.log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// endpoint returns error if the optional endpoint is not configured
+func (p *GenericHTTP) endpoint(name string, endpoint *Endpoint) (*Endpoint, error) {
+	if endpoint == nil {
+		errMsg := fmt.Sprintf("endpoint %s is not configured in %s", name, p.configMap.ApiCfgFileName)
+		p.log.Error(errMsg)
+		return nil, errors.New(errMsg)
+	}
+	return endpoint, nil
+}
+
+func (p *GenericHTTP) toHotelRoom(roomObject interface{}) hotel.Room {
+	fields := p.apiConfig.RoomFields
+	room := hotel.Room{
+		RoomID:        p.field(roomObject, fields.RoomID),
+		RoomName:      p.field(roomObject, fields.RoomName),
+		RoomTypeName:  p.field(roomObject, fields.RoomType),
+		PropertyID:    p.field(roomObject, fields.PropertyID),
+		RoomCondition: p.roomCondition(p.field(roomObject, fields.RoomCondition)),
+		RoomBlocked:   jsonBool(p.value(roomObject, fields.RoomBlocked)),
+		RoomOccupied:  jsonBool(p.value(roomObject, fields.RoomOccupied)),
+	}
+	guest := hotel.Guest{
+		ReservationID: p.field(roomObject, fields.ReservationID),
+		FirstName:     p.field(roomObject, fields.GuestFirstName),
+		LastName:      p.field(roomObject, fields.GuestLastName),
+		Email:         p.field(roomObject, fields.GuestEmail),
+	}
+	if guest != (hotel.Guest{}) {
+		room.Guest = &guest
+		room.RoomOccupied = true
+	}
+	if extension, ok := p.configMap.SearchExtensionByRoomID(room.RoomID, ""); ok {
+		room.PhoneNumber = extension.RoomExtension
+	}
+	return room
+}
+
+// roomCondition converts condition of the provider to the room condition dialed from the room phone
+func (p *GenericHTTP) roomCondition(providerCondition string) string {
+	for _, condition := range p.roomConditions.Conditions() {
+		if p.roomConditions[condition] == providerCondition {
+			return condition
+		}
+	}
+	return strings.ToLower(providerCondition)
+}
+
+// value returns the value at path of roomObject. nil if path is empty or not found
+func (p *GenericHTTP) value(roomObject interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	value, _, err := lookupJSONPath(roomObject, path)
+	if err != nil {
+		p.log.Debugf("room field %s: %s", path, err)
+	}
+	return value
+}
+
+func (p *GenericHTTP) field(roomObject interface{}, path string) string {
+	return jsonString(p.value(roomObject, path))
+}
+
+func (p *GenericHTTP) extensionData(extension configuration.Extension) requestData {
+	return requestData{
+		RoomExtension: extension.RoomExtension,
+		RoomID:        extension.HospitalityRoomID,
+		RoomName:      extension.HospitalityRoomName,
+		PropertyID:    extension.HospitalityPropertyID,
+	}
+}
+
+// propertyIDs returns unique property IDs of the extension map
+func (p *GenericHTTP) propertyIDs() (propertyIDs []string) {
+	seen := make(map[string]bool)
+	for _, extension := range p.configMap.ExtensionMap {
+		if extension.HospitalityPropertyID == "" || seen[extension.HospitalityPropertyID] {
+			continue
+		}
+		seen[extension.HospitalityPropertyID] = true
+		propertyIDs = append(propertyIDs, extension.HospitalityPropertyID)
+	}
+	sort.Strings(propertyIDs)
+	return propertyIDs
+}
+
+// send renders the endpoint with data, sends the request and decodes JSON reply to response. response can be nil
+func (p *GenericHTTP) send(ctx context.Context, name string, endpoint *Endpoint, data requestData, response interface{}) error {
+	apiUrl := &strings.Builder{}
+	err := endpoint.urlTemplate.Execute(apiUrl, data)
+	if err != nil {
+		return fmt.Errorf("endpoint %s: %w", name, err)
+	}
+	var body io.Reader
+	if endpoint.bodyTemplate != nil {
+		bodyBuffer := &bytes.Buffer{}
+		err = endpoint.bodyTemplate.Execute(bodyBuffer, data)
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", name, err)
+		}
+		body = bodyBuffer
+	}
+
+	p.log.Debugf("Sending %s to %s", endpoint.Method, apiUrl.String())
+	req, err := http.NewRequestWithContext(ctx, endpoint.Method, apiUrl.String(), body)
+	if err != nil {
+		return err
+	}
+	for headerName, value := range p.headers {
+		req.Header.Set(headerName, value)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", endpoint.ContentType)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("%s failed with: %s", name, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("%s failed with status %d: %s", name, resp.StatusCode, string(respBody))
+		return &hotel.DetailedError{Msg: err, StatusCodeMessage: resp.Status, Details: string(respBody)}
+	}
+
+	if response == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package generichttp
+
+import (
+	"context"
+	"errors"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/hoteltest"
+	"github.com/olegromanchuk/hotelito/internal/secretstest"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newPmsServer imitates a PMS API. Replies are looked up by "METHOD uri"
+func newPmsServer(t *testing.T, replies map[string]string) *hoteltest.Server {
+	server := hoteltest.NewServer(t, replies)
+	server.AccessToken = "pms-token"
+	server.Key = func(r *http.Request) string {
+		return r.Method + " " + r.URL.RequestURI()
+	}
+	server.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		assert.Equal(t, "application/json", r.Header.Get("Accept"))
+		return false
+	}
+	return server
+}
+
+// newTestGenericHTTP creates provider from generichttp_api_params.json of the repository with URLs redirected to server
+func newTestGenericHTTP(t *testing.T, server *hoteltest.Server, changeConfig func(config string) string) *GenericHTTP {
+	config, err := os.ReadFile("../../../generichttp_api_params.json")
+	require.NoError(t, err)
+	configText := strings.ReplaceAll(string(config), "https://pms.example.com", server.URL)
+	if changeConfig != nil {
+		configText = changeConfig(configText)
+	}
+	configFileName := filepath.Join(t.TempDir(), "generichttp_api_params.json")
+	require.NoError(t, os.WriteFile(configFileName, []byte(configText), 0600))
+
+	store := new(secretstest.MockSecretsStore)
+	store.On("RetrieveVar", "GENERIC_HTTP_API_TOKEN").Return("pms-token", nil)
+	store.On("Close").Return(nil)
+	client, err := New(logrus.New(), store, &configuration.ConfigMap{
+		ApiCfgFileName: configFileName,
+		ExtensionMap:   hoteltest.Extensions("P1", "101", "102"),
+		ItemCatalog: []configuration.Item{
+			{ItemCode: "12", Name: "Beer", Price: 5.5, HospitalityItemID: "beer"},
+		},
+	})
+	require.NoError(t, err)
+	client.httpClient = server.Client()
+	return client
+}
+
+const (
+	replyRoom101 = `{"id":101,"name":"101","type":{"name":"Double"},"housekeeping":{"status":"DIRTY"},"blocked":false,"occupied":true,"reservation":{"id":"R-1","guest":{"firstName":"Jane","lastName":"Smith","email":"jane.smith@example.com"}}}`
+	replyRoom102 = `{"id":"102","name":"102","housekeeping":{"status":"CLEAN"},"blocked":"yes","occupied":false}`
+	replyRooms   = `{"data":{"rooms":[` + replyRoom101 + `,` + replyRoom102 + `]}}`
+)
+
+func TestGenericHTTP_GetRooms(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"GET /api/v1/rooms": replyRooms,
+	})
+
+	rooms, err := newTestGenericHTTP(t, server, nil).GetRooms(context.Background())
+	require.NoError(t, err)
+	guest := hoteltest.Guest("R-1")
+	assert.Equal(t, []hotel.Room{
+		{
+			RoomID:        "101",
+			RoomName:      "101",
+			RoomTypeName:  "Double",
+			PhoneNumber:   "1001",
+			RoomCondition: "dirty",
+			RoomOccupied:  true,
+			Guest:         &guest,
+		},
+		{
+			RoomID:        "102",
+			RoomName:      "102",
+			PhoneNumber:   "1002",
+			RoomCondition: "clean",
+			RoomBlocked:   true,
+		},
+	}, rooms)
+}
+
+func TestGenericHTTP_GetRooms_PerProperty(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"GET /api/v1/properties/P1/rooms": replyRooms,
+	})
+	client := newTestGenericHTTP(t, server, func(config string) string {
+		return strings.Replace(config, `"url": "`+server.URL+`/api/v1/rooms",`, `"url": "`+server.URL+`/api/v1/properties/{{.PropertyID}}/rooms", "perProperty": true,`, 1)
+	})
+
+	rooms, err := client.GetRooms(context.Background())
+	require.NoError(t, err)
+	require.Len(t, rooms, 2)
+	assert.Equal(t, "P1", rooms[0].PropertyID)
+	assert.Len(t, server.Requests, 1)
+}
+
+func TestGenericHTTP_GetRooms_BadRoomsPath(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"GET /api/v1/rooms": `{"data":{"rooms":{}}}`,
+	})
+
+	_, err := newTestGenericHTTP(t, server, nil).GetRooms(context.Background())
+	assert.EqualError(t, err, "rooms list $.data.rooms not found in getRooms reply")
+	var detailedError *hotel.DetailedError
+	assert.True(t, errors.As(err, &detailedError))
+}
+
+func TestGenericHTTP_GetRoomGuest(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"GET /api/v1/rooms/101": `{"data":` + replyRoom101 + `}`,
+		"GET /api/v1/rooms/102": `{"data":` + replyRoom102 + `}`,
+	})
+
+	hoteltest.CheckGetRoomGuest(t, newTestGenericHTTP(t, server, nil), "R-1")
+}
+
+func TestGenericHTTP_GetRoom_FromRoomsList(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"GET /api/v1/rooms": replyRooms,
+	})
+	client := newTestGenericHTTP(t, server, nil)
+	client.apiConfig.Endpoints.GetRoom = nil
+
+	room, err := client.GetRoom(context.Background(), "1002", "")
+	require.NoError(t, err)
+	assert.Equal(t, "clean", room.RoomCondition)
+}
+
+func TestGenericHTTP_UpdateRoom(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"PUT /api/v1/rooms/101/housekeeping": `{}`,
+	})
+
+	request := hoteltest.CheckUpdateRoom(t, newTestGenericHTTP(t, server, nil), server, "clean", "inspected")
+	assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"status":"CLEAN","updatedBy":"John \"JD\" Doe"}`, request.Body)
+}
+
+func TestGenericHTTP_UpdateRoomBlock(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"POST /api/v1/rooms/101/block": `{}`,
+	})
+
+	hoteltest.CheckUpdateRoomBlock(t, newTestGenericHTTP(t, server, nil),
+		"Finish UpdateRoomBlock successfully blocked room 1001",
+		"Finish UpdateRoomBlock successfully unblocked room 1001")
+	require.Len(t, server.Requests, 2)
+	assert.JSONEq(t, `{"blocked":true,"reason":"AC repair"}`, server.Requests[0].Body)
+	assert.JSONEq(t, `{"blocked":false,"reason":""}`, server.Requests[1].Body)
+}
+
+func TestGenericHTTP_PostCharge(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"GET /api/v1/rooms/101": `{"data":` + replyRoom101 + `}`,
+		"POST /api/v1/charges":  `{}`,
+	})
+	client := newTestGenericHTTP(t, server, func(config string) string {
+		return strings.Replace(config, `"endpoints": {`, `"endpoints": {
+    "postCharge": {"url": "`+server.URL+`/api/v1/charges", "body": "{\"reservation\": {{json .ReservationID}}, \"item\": {{json .HospitalityItemID}}, \"quantity\": {{.Quantity}}, \"amount\": {{.Amount}}}"},`, 1)
+	})
+
+	msg, err := client.PostCharge(context.Background(), "1001", "12", 2, "John Doe")
+	require.NoError(t, err)
+	assert.Equal(t, "Finish PostCharge successfully posted 2 x Beer to room 1001. Reservation: R-1", msg)
+	require.Len(t, server.Requests, 2)
+	assert.Equal(t, "POST", server.Requests[1].Method)
+	assert.JSONEq(t, `{"reservation":"R-1","item":"beer","quantity":2,"amount":11}`, server.Requests[1].Body)
+
+	_, err = client.PostCharge(context.Background(), "1001", "99", 1, "John Doe")
+	assert.EqualError(t, err, "item 99 not found in item catalog")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+}
+
+func TestGenericHTTP_NotConfigured(t *testing.T) {
+	server := newPmsServer(t, map[string]string{})
+	client := newTestGenericHTTP(t, server, nil)
+
+	hoteltest.CheckNotSupported(t, client,
+		"endpoint updateRoomDoNotDisturb is not configured in "+client.configMap.ApiCfgFileName,
+		"endpoint postCharge is not configured in "+client.configMap.ApiCfgFileName)
+	assert.Empty(t, server.Requests)
+	assert.NoError(t, client.Close())
+}
+
+func TestGenericHTTP_ApiError(t *testing.T) {
+	server := newPmsServer(t, map[string]string{
+		"PUT /api/v1/rooms/101/housekeeping": `{"error":"forbidden"}`,
+	})
+	server.ReplyStatus["PUT /api/v1/rooms/101/housekeeping"] = http.StatusForbidden
+
+	hoteltest.CheckApiError(t, newTestGenericHTTP(t, server, nil), `updateRoom failed with status 403: {"error":"forbidden"}`)
+}
+
+func TestNew(t *testing.T) {
+	writeConfig := func(t *testing.T, config string) *configuration.ConfigMap {
+		configFileName := filepath.Join(t.TempDir(), "api.json")
+		require.NoError(t, os.WriteFile(configFileName, []byte(config), 0600))
+		return &configuration.ConfigMap{ApiCfgFileName: configFileName}
+	}
+
+	tests := []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{
+			name:          "getRooms is missing",
+			config:        `{"roomFields":{"roomID":"id"}}`,
+			expectedError: "endpoint getRooms is required",
+		},
+		{
+			name:          "roomID is missing",
+			config:        `{"endpoints":{"getRooms":{"url":"https://pms.example.com/rooms"}}}`,
+			expectedError: "roomFields.roomID is required",
+		},
+		{
+			name:          "bad template",
+			config:        `{"endpoints":{"getRooms":{"url":"https://pms.example.com/rooms"},"updateRoom":{"url":"https://pms.example.com/{{.RoomID"}},"roomFields":{"roomID":"id"}}`,
+			expectedError: "endpoint updateRoom: template: updateRoom url:1: unclosed action",
+		},
+		{
+			name:          "bad jsonpath",
+			config:        `{"endpoints":{"getRooms":{"url":"https://pms.example.com/rooms","roomsPath":"$.rooms[x]"}},"roomFields":{"roomID":"id"}}`,
+			expectedError: "jsonpath $.rooms[x]: index x is not valid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configMap := writeConfig(t, tt.config)
+			store := new(secretstest.MockSecretsStore)
+			_, err := New(logrus.New(), store, configMap)
+			assert.EqualError(t, err, "api configuration "+configMap.ApiCfgFileName+" is not valid: "+tt.expectedError)
+		})
+	}
+
+	t.Run("defaults and headers from environment", func(t *testing.T) {
+		configMap := writeConfig(t, `{"authHeader":{"name":"X-Api-Key","value":"{{var \"GENERIC_HTTP_TEST_KEY\"}}"},"endpoints":{"getRooms":{"url":"https://pms.example.com/rooms"},"updateRoom":{"url":"https://pms.example.com/rooms/{{.RoomID}}","body":"{}"}},"roomFields":{"roomID":"id"}}`)
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", "GENERIC_HTTP_TEST_KEY").Return("", errors.New("not found"))
+		os.Setenv("GENERIC_HTTP_TEST_KEY", "env-key")
+		defer os.Unsetenv("GENERIC_HTTP_TEST_KEY")
+
+		client, err := New(logrus.New(), store, configMap)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"X-Api-Key": "env-key"}, client.headers)
+		assert.Equal(t, "GET", client.apiConfig.Endpoints.GetRooms.Method)
+		assert.Equal(t, "POST", client.apiConfig.Endpoints.UpdateRoom.Method)
+		assert.Equal(t, "application/json", client.apiConfig.Endpoints.UpdateRoom.ContentType)
+	})
+
+	t.Run("config file is missing", func(t *testing.T) {
+		_, err := New(logrus.New(), new(secretstest.MockSecretsStore), &configuration.ConfigMap{ApiCfgFileName: "not-existing.json"})
+		assert.ErrorContains(t, err, "error opening config file")
+	})
+}
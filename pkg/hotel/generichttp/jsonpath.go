@@ -0,0 +1,113 @@
+package generichttp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is one step of a parsed JSONPath: object key or array index
+type jsonPathStep struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// parseJSONPath parses the subset of JSONPath supported by the provider: "$" (root), ".key" and "[index]" steps.
+// "$" can be omitted: "data.rooms" is the same as "$.data.rooms"
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	rest := strings.TrimSpace(path)
+	rest = strings.TrimPrefix(rest, "$")
+	var steps []jsonPathStep
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("jsonpath %s: empty key", path)
+			}
+			steps = append(steps, jsonPathStep{key: rest[:end]})
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("jsonpath %s: missing ]", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("jsonpath %s: index %s is not valid", path, rest[1:end])
+			}
+			steps = append(steps, jsonPathStep{index: index, isIdx: true})
+			rest = rest[end+1:]
+		case len(steps) == 0:
+			//first key without leading dot
+			rest = "." + rest
+		default:
+			return nil, fmt.Errorf("jsonpath %s: unexpected %q", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+// lookupJSONPath returns the value of the decoded JSON document at path. ok is false if the path doesn't exist in the document
+func lookupJSONPath(document interface{}, path string) (value interface{}, ok bool, err error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	value = document
+	for _, step := range steps {
+		if step.isIdx {
+			array, isArray := value.([]interface{})
+			if !isArray || step.index >= len(array) {
+				return nil, false, nil
+			}
+			value = array[step.index]
+			continue
+		}
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return nil, false, nil
+		}
+		value, ok = object[step.key]
+		if !ok {
+			return nil, false, nil
+		}
+	}
+	return value, true, nil
+}
+
+// jsonString converts a decoded JSON scalar to string. Numbers are formatted without exponent, null is empty
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// jsonBool converts a decoded JSON scalar to bool: true, non-zero number, "true", "yes" and "1" are true
+func jsonBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "1":
+			return true
+		}
+	}
+	return false
+}
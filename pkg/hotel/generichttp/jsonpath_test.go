@@ -0,0 +1,63 @@
+package generichttp
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	var document interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"data":{"rooms":[{"id":101,"name":"A"},{"id":"102","tags":["x","y"]}],"count":2},"ok":true}`), &document))
+
+	tests := []struct {
+		name          string
+		path          string
+		expectedValue interface{}
+		expectedOk    bool
+		expectedError string
+	}{
+		{name: "root", path: "$", expectedValue: document, expectedOk: true},
+		{name: "empty path is root", path: "", expectedValue: document, expectedOk: true},
+		{name: "nested key", path: "$.data.count", expectedValue: float64(2), expectedOk: true},
+		{name: "without $", path: "data.count", expectedValue: float64(2), expectedOk: true},
+		{name: "array index", path: "$.data.rooms[1].id", expectedValue: "102", expectedOk: true},
+		{name: "nested array index", path: "data.rooms[1].tags[0]", expectedValue: "x", expectedOk: true},
+		{name: "missing key", path: "$.data.missing", expectedOk: false},
+		{name: "index out of range", path: "$.data.rooms[5]", expectedOk: false},
+		{name: "key of array", path: "$.data.rooms.id", expectedOk: false},
+		{name: "bad index", path: "$.data.rooms[a]", expectedError: "jsonpath $.data.rooms[a]: index a is not valid"},
+		{name: "missing bracket", path: "$.data.rooms[1", expectedError: "jsonpath $.data.rooms[1: missing ]"},
+		{name: "empty key", path: "$.data..count", expectedError: "jsonpath $.data..count: empty key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, ok, err := lookupJSONPath(document, tt.path)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOk, ok)
+			if tt.expectedOk {
+				assert.Equal(t, tt.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestJSONScalars(t *testing.T) {
+	assert.Equal(t, "", jsonString(nil))
+	assert.Equal(t, "101", jsonString(float64(101)))
+	assert.Equal(t, "1.5", jsonString(1.5))
+	assert.Equal(t, "true", jsonString(true))
+	assert.Equal(t, "abc", jsonString("abc"))
+
+	assert.True(t, jsonBool(true))
+	assert.True(t, jsonBool(float64(1)))
+	assert.True(t, jsonBool("Yes"))
+	assert.False(t, jsonBool("no"))
+	assert.False(t, jsonBool(float64(0)))
+	assert.False(t, jsonBool(nil))
+}
@@ -2,6 +2,8 @@
 // It is a common interface for different hospitality providers
 package hotel
 
+import "context"
+
 /*
 	Response: {
 	    "success": true,
@@ -76,15 +78,49 @@ type Room struct {
 	PhoneNumber       string `json:"phoneNumber,omitempty"`
 	RoomCondition     string `json:"RoomCondition,omitempty"`
 	RoomOccupied      bool   `json:"RoomOccupied,omitempty"`
+	Guest             *Guest `json:"Guest,omitempty"` // in-house guest. nil if the room is vacant or the guest is unknown
+	PropertyID        string `json:"propertyID,omitempty"`
+	DoNotDisturb      bool   `json:"DoNotDisturb,omitempty"`
+}
+
+// Guest is a struct that represents an in-house guest of a room
+type Guest struct {
+	ReservationID string `json:"reservationID"`
+	FirstName     string `json:"firstName"`
+	LastName      string `json:"lastName"`
+	Email         string `json:"email,omitempty"`
 }
 
-// HospitalityProvider is an interface that represents a hospitality provider
+// SystemHousekeeperName is the housekeeper name of updates made by hotelito itself (rules), not by a person.
+// Providers don't assign a housekeeper to the room for it
+const SystemHousekeeperName = "system"
+
+// HospitalityProvider is an interface that represents a hospitality provider.
+// ctx of every method cancels the requests to the provider: client disconnect, Lambda deadline or server shutdown
 type HospitalityProvider interface {
-	GetRooms() ([]Room, error)
-	GetRoom(roomNumber string, mapFileName string) (Room, error)
-	UpdateRoom(roomNumber, housekeepingStatus, housekeeperName string) (msg string, err error)
-	HandleOAuthCallback(state, code string) (err error)
-	HandleInitialLogin() (url string, err error)
+	GetRooms(ctx context.Context) ([]Room, error)
+	GetRoom(ctx context.Context, roomNumber string, mapFileName string) (Room, error)
+	GetRoomGuest(ctx context.Context, roomNumber string) (Guest, error)
+	UpdateRoom(ctx context.Context, roomNumber, housekeepingStatus, housekeeperName string) (msg string, err error)
+	UpdateRoomDoNotDisturb(ctx context.Context, roomNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error)
+	UpdateRoomBlock(ctx context.Context, roomNumber string, blocked bool, reason, staffName string) (msg string, err error)
+	PostCharge(ctx context.Context, roomNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error)
+	HandleOAuthCallback(ctx context.Context, state, code string) (err error)
+	HandleInitialLogin(ctx context.Context) (url string, err error)
+}
+
+// TokenKeeper is implemented by hospitality providers whose refresh token goes stale if it's not used for a long time,
+// e.g. off season nobody dials from the rooms. It is called on schedule, so the next real call doesn't need a manual login
+type TokenKeeper interface {
+	// KeepTokenAlive refreshes the token and saves it to the secret store
+	KeepTokenAlive(ctx context.Context) error
+}
+
+// LoginChecker is implemented by hospitality providers that need an interactive login by the admin (OAuth2).
+// It is checked on start, so admins are alerted before the first room update fails
+type LoginChecker interface {
+	// LoginRequired returns the instructions for the admin if nobody is logged in or the saved token went stale
+	LoginRequired() (msg string, required bool)
 }
 
 // DetailedError is a struct that represents an error with a status code and details
@@ -96,3 +132,6 @@ type DetailedError struct {
 
 // Error returns the error message
 func (e *DetailedError) Error() string { return e.Msg.Error() }
+
+// Unwrap returns Msg, so errors.Is and errors.As see the errors wrapped by DetailedError
+func (e *DetailedError) Unwrap() error { return e.Msg }
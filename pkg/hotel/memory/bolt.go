@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+)
+
+// roomsBucketName is the bucket of the secret store database the room states are saved to
+const roomsBucketName = "memory_provider_rooms"
+
+// boltPersistence saves room states to bolt database as JSON. The key is the room extension
+type boltPersistence struct {
+	db *bolt.DB
+}
+
+func newBoltPersistence(db *bolt.DB) *boltPersistence {
+	return &boltPersistence{db: db}
+}
+
+func (b *boltPersistence) save(state *RoomState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(roomsBucketName))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(state.RoomExtension), value)
+	})
+}
+
+func (b *boltPersistence) load() (states []*RoomState, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(roomsBucketName))
+		if bucket == nil {
+			return nil //nothing is saved yet
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			state := &RoomState{}
+			err := json.Unmarshal(value, state)
+			if err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+	})
+	return states, err
+}
@@ -0,0 +1,378 @@
+// Package memory represents a demo hospitality provider that keeps room states in memory.
+// Rooms are seeded from the extension map of config.json, so the full server can run without credentials of a real PMS
+// (sales demos, 3CX dial plan testing). Changes made from the room phone are reflected by GetRooms/GetRoom.
+// With MEMORY_PROVIDER_PERSIST=true the states are saved to the bolt database of the secret store and survive restarts.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/olegromanchuk/hotelito/pkg/secrets/boltstore"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	initialRoomCondition   = "clean"
+	defaultRoomBlockReason = "Out of order"
+)
+
+// defaultRoomConditions are accepted in addition to the room conditions of the housekeeper mapping
+var defaultRoomConditions = []string{"clean", "dirty", "inspected"}
+
+// RoomState is the state of a room kept by the provider
+type RoomState struct {
+	RoomExtension string       `json:"roomExtension"`
+	RoomCondition string       `json:"roomCondition"`
+	DoNotDisturb  bool         `json:"doNotDisturb"`
+	Blocked       bool         `json:"blocked"`
+	BlockReason   string       `json:"blockReason,omitempty"`
+	Guest         *hotel.Guest `json:"guest,omitempty"`
+	Charges       []Charge     `json:"charges,omitempty"`
+	UpdatedBy     string       `json:"updatedBy,omitempty"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+}
+
+// Charge is an item posted to the room
+type Charge struct {
+	ItemCode string    `json:"itemCode"`
+	Name     string    `json:"name"`
+	Quantity int       `json:"quantity"`
+	Amount   float64   `json:"amount"`
+	PostedBy string    `json:"postedBy"`
+	PostedAt time.Time `json:"postedAt"`
+}
+
+// Memory keeps room states of the extension map
+type Memory struct {
+	mu             sync.RWMutex
+	log            *logrus.Logger
+	storeClient    secrets.SecretsStore
+	configMap      *configuration.ConfigMap
+	rooms          map[string]*RoomState // room extension -> state
+	roomConditions hotel.RoomConditionSet
+	persistence    *boltPersistence // nil if states are not persisted
+	now            func() time.Time
+}
+
+func init() {
+	hotel.Register("memory", func(log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (hotel.Provider, error) {
+		client, err := New(log, secretStore, configMap)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *configuration.ConfigMap) (*Memory, error) {
+	log.Debugf("Creating new in-memory hospitality provider")
+
+	memoryClient := &Memory{
+		log:            log,
+		storeClient:    secretStore,
+		configMap:      configMapInfo,
+		rooms:          make(map[string]*RoomState),
+		roomConditions: hotel.NewRoomConditionSet(defaultRoomConditions...),
+		now:            time.Now,
+	}
+	for _, condition := range configMapInfo.RoomConditions() {
+		memoryClient.roomConditions[condition] = struct{}{}
+	}
+	memoryClient.seed(memoryClient.getBoolVar("MEMORY_PROVIDER_DEMO_GUESTS"))
+
+	if memoryClient.getBoolVar("MEMORY_PROVIDER_PERSIST") {
+		boltStore, ok := secretStore.(*boltstore.BoltDBStore)
+		if !ok {
+			errMsg := errors.New("MEMORY_PROVIDER_PERSIST requires bolt secret store (standalone version)")
+			log.Error(errMsg)
+			return nil, errMsg
+		}
+		memoryClient.persistence = newBoltPersistence(boltStore.Db)
+		err := memoryClient.load()
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	log.Infof("in-memory hospitality provider is ready. Rooms: %d, persisted: %t", len(memoryClient.rooms), memoryClient.persistence != nil)
+	return memoryClient, nil
+}
+
+// seed creates clean rooms for all extensions. With demoGuests every second room has an in-house demo guest
+func (p *Memory) seed(demoGuests bool) {
+	for i, extension := range p.configMap.ExtensionMap {
+		state := &RoomState{
+			RoomExtension: extension.RoomExtension,
+			RoomCondition: initialRoomCondition,
+			UpdatedAt:     p.now(),
+		}
+		if demoGuests && i%2 == 0 {
+			state.Guest = &hotel.Guest{
+				ReservationID: "DEMO-" + extension.RoomExtension,
+				FirstName:     "Demo",
+				LastName:      "Guest " + extension.HospitalityRoomName,
+			}
+		}
+		p.rooms[extension.RoomExtension] = state
+	}
+}
+
+// load replaces seeded states with persisted states of the extensions that are still in the extension map
+func (p *Memory) load() error {
+	states, err := p.persistence.load()
+	if err != nil {
+		return fmt.Errorf("failed to load room states: %w", err)
+	}
+	for _, state := range states {
+		if _, ok := p.rooms[state.RoomExtension]; !ok {
+			p.log.Debugf("room %s is not in the extension map. Persisted state is ignored", state.RoomExtension)
+			continue
+		}
+		p.rooms[state.RoomExtension] = state
+	}
+	return nil
+}
+
+// GetRooms returns all rooms of the extension map in the order of the map
+func (p *Memory) GetRooms(ctx context.Context) (rooms []hotel.Room, err error) {
+	p.log.Debugf("getting rooms")
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, extension := range p.configMap.ExtensionMap {
+		rooms = append(rooms, p.toHotelRoom(extension, p.rooms[extension.RoomExtension]))
+	}
+
+	if len(rooms) == 0 {
+		errMsg := errors.New("no rooms found")
+		return rooms, &hotel.DetailedError{Msg: errMsg, Details: "extension map is empty"}
+	}
+	return rooms, nil
+}
+
+// GetRoom returns the room with extension roomNumber
+func (p *Memory) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
+	p.log.Infof("get info about room %s", roomNumber)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomNumber)
+	if err != nil {
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.toHotelRoom(extension, p.rooms[roomNumber]), nil
+}
+
+// GetRoomGuest returns the in-house guest of the room with extension roomNumber
+func (p *Memory) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	p.log.Debugf("get in-house guest for room %s", roomNumber)
+
+	room, err := p.GetRoom(ctx, roomNumber, "")
+	if err != nil {
+		return hotel.Guest{}, err
+	}
+	if room.Guest == nil {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomNumber)
+		p.log.Debugf(errMsg)
+		return hotel.Guest{}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	return *room.Guest, nil
+}
+
+// UpdateRoom sets the room condition. Valid conditions are clean, dirty, inspected and the room conditions of the housekeeper mapping
+func (p *Memory) UpdateRoom(ctx context.Context, roomExtensionNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoom %s to %s for %s", roomExtensionNumber, housekeepingStatus, housekeeperName)
+
+	if !hotel.IsRoomConditionValid(housekeepingStatus, p.roomConditions) {
+		errMsg := fmt.Sprintf("room condition %s is not valid", housekeepingStatus)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	err = p.update(roomExtensionNumber, housekeeperName, func(state *RoomState) {
+		state.RoomCondition = housekeepingStatus
+	})
+	if err != nil {
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomExtensionNumber, housekeepingStatus)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+func (p *Memory) UpdateRoomDoNotDisturb(ctx context.Context, roomExtensionNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomDoNotDisturb %s to %t for %s", roomExtensionNumber, doNotDisturb, housekeeperName)
+
+	err = p.update(roomExtensionNumber, housekeeperName, func(state *RoomState) {
+		state.DoNotDisturb = doNotDisturb
+	})
+	if err != nil {
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish UpdateRoomDoNotDisturb successfully updated room %s to %t", roomExtensionNumber, doNotDisturb)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+func (p *Memory) UpdateRoomBlock(ctx context.Context, roomExtensionNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomBlock %s to %t (%s) for %s", roomExtensionNumber, blocked, reason, staffName)
+
+	if blocked && reason == "" {
+		reason = defaultRoomBlockReason
+	}
+	err = p.update(roomExtensionNumber, staffName, func(state *RoomState) {
+		state.Blocked = blocked
+		state.BlockReason = ""
+		if blocked {
+			state.BlockReason = reason
+		}
+	})
+	if err != nil {
+		return msg, err
+	}
+
+	action := "unblocked"
+	if blocked {
+		action = "blocked"
+	}
+	msg = fmt.Sprintf("Finish UpdateRoomBlock successfully %s room %s", action, roomExtensionNumber)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// PostCharge records the catalog item on the room. The room must have an in-house guest
+func (p *Memory) PostCharge(ctx context.Context, roomExtensionNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start PostCharge %d x %s to room %s by %s", quantity, itemCode, roomExtensionNumber, housekeeperName)
+
+	item, ok := p.configMap.SearchItemByCode(itemCode)
+	if !ok {
+		errMsg := fmt.Sprintf("item %s not found in item catalog", itemCode)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	if quantity <= 0 {
+		errMsg := fmt.Sprintf("quantity %d is not valid", quantity)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	var reservationID string
+	err = p.update(roomExtensionNumber, housekeeperName, func(state *RoomState) {
+		if state.Guest == nil {
+			return
+		}
+		reservationID = state.Guest.ReservationID
+		state.Charges = append(state.Charges, Charge{
+			ItemCode: item.ItemCode,
+			Name:     item.Name,
+			Quantity: quantity,
+			Amount:   item.Price * float64(quantity),
+			PostedBy: housekeeperName,
+			PostedAt: p.now(),
+		})
+	})
+	if err != nil {
+		return msg, err
+	}
+	if reservationID == "" {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomExtensionNumber)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	msg = fmt.Sprintf("Finish PostCharge successfully posted %d x %s to room %s. Reservation: %s", quantity, item.Name, roomExtensionNumber, reservationID)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// HandleOAuthCallback does nothing. No authentication is needed
+func (p *Memory) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
+	p.log.Debugf("in-memory provider doesn't need authentication. OAuth callback is ignored")
+	return nil
+}
+
+// HandleInitialLogin does nothing. No authentication is needed, so empty url is returned
+func (p *Memory) HandleInitialLogin(ctx context.Context) (url string, err error) {
+	p.log.Debugf("in-memory provider doesn't need authentication. Login is not required")
+	return "", nil
+}
+
+func (p *Memory) Close() error {
+	err := p.storeClient.Close()
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to close secret store: %s", err.Error())
+		p.log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// update changes the state of the room with extension roomExtensionNumber and persists it
+func (p *Memory) update(roomExtensionNumber, updatedBy string, change func(state *RoomState)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.rooms[roomExtensionNumber]
+	if !ok {
+		err := fmt.Errorf("phone number %s not found", roomExtensionNumber)
+		p.log.Error(err)
+		return err
+	}
+
+	//the state is changed on a copy, so a failed save doesn't leave the room half updated
+	updated := *state
+	change(&updated)
+	updated.UpdatedBy = updatedBy
+	updated.UpdatedAt = p.now()
+	if p.persistence != nil {
+		err := p.persistence.save(&updated)
+		if err != nil {
+			errMsg := fmt.Errorf("failed to save state of room %s: %w", roomExtensionNumber, err)
+			p.log.Error(errMsg)
+			return errMsg
+		}
+	}
+	p.rooms[roomExtensionNumber] = &updated
+	return nil
+}
+
+func (p *Memory) toHotelRoom(extension configuration.Extension, state *RoomState) hotel.Room {
+	roomID := extension.HospitalityRoomID
+	if roomID == "" {
+		roomID = extension.RoomExtension
+	}
+	room := hotel.Room{
+		RoomID:        roomID,
+		RoomName:      extension.HospitalityRoomName,
+		PropertyID:    extension.HospitalityPropertyID,
+		PhoneNumber:   extension.RoomExtension,
+		RoomCondition: state.RoomCondition,
+		RoomBlocked:   state.Blocked,
+		RoomOccupied:  state.Guest != nil,
+	}
+	if state.Guest != nil {
+		guest := *state.Guest
+		room.Guest = &guest
+	}
+	return room
+}
+
+// getBoolVar returns variable from secret store or environment as bool. Not set or invalid values are false
+func (p *Memory) getBoolVar(varName string) bool {
+	value := hotel.VarFromStoreOrEnvironment(p.log, p.storeClient, varName)
+	result, err := strconv.ParseBool(value)
+	if err != nil && value != "" {
+		p.log.Warnf("%s=%s is not a valid bool. Treated as false", varName, value)
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+package memory
+
+import (
+	"context"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/secretstest"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets/boltstore"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testConfigMap() *configuration.ConfigMap {
+	return &configuration.ConfigMap{
+		ExtensionMap: []configuration.Extension{
+			{RoomExtension: "1001", HospitalityRoomID: "544559-0", HospitalityRoomName: "DQ(1)"},
+			{RoomExtension: "1002", HospitalityRoomID: "544559-1", HospitalityRoomName: "DQ(2)"},
+			{RoomExtension: "1003", HospitalityRoomName: "DQ(3)"},
+		},
+		HousekeeperMap: []configuration.Housekeeper{
+			{RoomStatusPhoneNumber: "5551", NumberType: "dirty"},
+			{RoomStatusPhoneNumber: "5552", NumberType: "pickup"},
+			{RoomStatusPhoneNumber: "5553", NumberType: configuration.NumberTypeRoomBlock},
+		},
+		ItemCatalog: []configuration.Item{
+			{ItemCode: "12", Name: "Beer", Price: 5.5},
+		},
+	}
+}
+
+func newTestMemory(t *testing.T, vars map[string]string) *Memory {
+	store := new(secretstest.MockSecretsStore)
+	for name, value := range vars {
+		store.On("RetrieveVar", name).Return(value, nil)
+	}
+	store.On("RetrieveVar", mock.Anything).Return("", nil)
+	client, err := New(logrus.New(), store, testConfigMap())
+	require.NoError(t, err)
+	return client
+}
+
+func TestMemory_GetRooms(t *testing.T) {
+	client := newTestMemory(t, map[string]string{"MEMORY_PROVIDER_DEMO_GUESTS": "true"})
+
+	rooms, err := client.GetRooms(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []hotel.Room{
+		{
+			RoomID:        "544559-0",
+			RoomName:      "DQ(1)",
+			PhoneNumber:   "1001",
+			RoomCondition: "clean",
+			RoomOccupied:  true,
+			Guest:         &hotel.Guest{ReservationID: "DEMO-1001", FirstName: "Demo", LastName: "Guest DQ(1)"},
+		},
+		{RoomID: "544559-1", RoomName: "DQ(2)", PhoneNumber: "1002", RoomCondition: "clean"},
+		{
+			RoomID:        "1003",
+			RoomName:      "DQ(3)",
+			PhoneNumber:   "1003",
+			RoomCondition: "clean",
+			RoomOccupied:  true,
+			Guest:         &hotel.Guest{ReservationID: "DEMO-1003", FirstName: "Demo", LastName: "Guest DQ(3)"},
+		},
+	}, rooms)
+
+	_, err = (&Memory{log: logrus.New(), configMap: &configuration.ConfigMap{}}).GetRooms(context.Background())
+	assert.EqualError(t, err, "no rooms found")
+}
+
+func TestMemory_UpdateRoom(t *testing.T) {
+	client := newTestMemory(t, nil)
+
+	msg, err := client.UpdateRoom(context.Background(), "1002", "dirty", "John Doe")
+	require.NoError(t, err)
+	assert.Equal(t, "Finish UpdateRoom successfully updated room 1002 to dirty", msg)
+	room, err := client.GetRoom(context.Background(), "1002", "")
+	require.NoError(t, err)
+	assert.Equal(t, "dirty", room.RoomCondition)
+	assert.Equal(t, "John Doe", client.rooms["1002"].UpdatedBy)
+
+	// room conditions of the housekeeper mapping are accepted
+	_, err = client.UpdateRoom(context.Background(), "1002", "pickup", "John Doe")
+	require.NoError(t, err)
+
+	_, err = client.UpdateRoom(context.Background(), "1002", "sparkling", "John Doe")
+	assert.EqualError(t, err, "room condition sparkling is not valid")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+	_, err = client.UpdateRoom(context.Background(), "9999", "dirty", "John Doe")
+	assert.EqualError(t, err, "phone number 9999 not found")
+	_, err = client.GetRoom(context.Background(), "9999", "")
+	assert.EqualError(t, err, "phone number 9999 not found")
+}
+
+func TestMemory_UpdateRoomDoNotDisturbAndBlock(t *testing.T) {
+	client := newTestMemory(t, nil)
+
+	_, err := client.UpdateRoomDoNotDisturb(context.Background(), "1001", true, "John Doe")
+	require.NoError(t, err)
+	assert.True(t, client.rooms["1001"].DoNotDisturb)
+
+	msg, err := client.UpdateRoomBlock(context.Background(), "1001", true, "", "Engineer")
+	require.NoError(t, err)
+	assert.Equal(t, "Finish UpdateRoomBlock successfully blocked room 1001", msg)
+	room, _ := client.GetRoom(context.Background(), "1001", "")
+	assert.True(t, room.RoomBlocked)
+	assert.Equal(t, "Out of order", client.rooms["1001"].BlockReason)
+
+	_, err = client.UpdateRoomBlock(context.Background(), "1001", false, "", "Engineer")
+	require.NoError(t, err)
+	room, _ = client.GetRoom(context.Background(), "1001", "")
+	assert.False(t, room.RoomBlocked)
+	assert.Empty(t, client.rooms["1001"].BlockReason)
+}
+
+func TestMemory_PostChargeAndGuest(t *testing.T) {
+	client := newTestMemory(t, map[string]string{"MEMORY_PROVIDER_DEMO_GUESTS": "true"})
+	postedAt := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return postedAt }
+
+	guest, err := client.GetRoomGuest(context.Background(), "1001")
+	require.NoError(t, err)
+	assert.Equal(t, "DEMO-1001", guest.ReservationID)
+	_, err = client.GetRoomGuest(context.Background(), "1002")
+	assert.EqualError(t, err, "no in-house reservation found for room 1002")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+
+	msg, err := client.PostCharge(context.Background(), "1001", "12", 2, "John Doe")
+	require.NoError(t, err)
+	assert.Equal(t, "Finish PostCharge successfully posted 2 x Beer to room 1001. Reservation: DEMO-1001", msg)
+	assert.Equal(t, []Charge{{ItemCode: "12", Name: "Beer", Quantity: 2, Amount: 11, PostedBy: "John Doe", PostedAt: postedAt}}, client.rooms["1001"].Charges)
+
+	_, err = client.PostCharge(context.Background(), "1002", "12", 1, "John Doe")
+	assert.EqualError(t, err, "no in-house reservation found for room 1002")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+	assert.Empty(t, client.rooms["1002"].Charges)
+	_, err = client.PostCharge(context.Background(), "1001", "99", 1, "John Doe")
+	assert.EqualError(t, err, "item 99 not found in item catalog")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+	_, err = client.PostCharge(context.Background(), "1001", "12", 0, "John Doe")
+	assert.EqualError(t, err, "quantity 0 is not valid")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+}
+
+func TestMemory_Persistence(t *testing.T) {
+	dbFileName := filepath.Join(t.TempDir(), "memory.db")
+	os.Setenv("MEMORY_PROVIDER_PERSIST", "true")
+	defer os.Unsetenv("MEMORY_PROVIDER_PERSIST")
+
+	store, err := boltstore.Initialize(dbFileName, "test_bucket")
+	require.NoError(t, err)
+	client, err := New(logrus.New(), store, testConfigMap())
+	require.NoError(t, err)
+	_, err = client.UpdateRoom(context.Background(), "1002", "dirty", "John Doe")
+	require.NoError(t, err)
+	_, err = client.UpdateRoomBlock(context.Background(), "1003", true, "AC repair", "Engineer")
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+
+	// states survive restart. Rooms removed from the extension map are ignored
+	store, err = boltstore.Initialize(dbFileName, "test_bucket")
+	require.NoError(t, err)
+	configMap := testConfigMap()
+	configMap.ExtensionMap = configMap.ExtensionMap[1:2]
+	client, err = New(logrus.New(), store, configMap)
+	require.NoError(t, err)
+	defer client.Close()
+
+	rooms, err := client.GetRooms(context.Background())
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	assert.Equal(t, "dirty", rooms[0].RoomCondition)
+	assert.Equal(t, "John Doe", client.rooms["1002"].UpdatedBy)
+}
+
+func TestNew_PersistRequiresBolt(t *testing.T) {
+	store := new(secretstest.MockSecretsStore)
+	store.On("RetrieveVar", "MEMORY_PROVIDER_PERSIST").Return("true", nil)
+	store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+	_, err := New(logrus.New(), store, testConfigMap())
+	assert.EqualError(t, err, "MEMORY_PROVIDER_PERSIST requires bolt secret store (standalone version)")
+}
+
+func TestMemory_NoOpAuthorization(t *testing.T) {
+	client := newTestMemory(t, nil)
+	url, err := client.HandleInitialLogin(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, url)
+	assert.NoError(t, client.HandleOAuthCallback(context.Background(), "state", "code"))
+}
@@ -0,0 +1,718 @@
+// Package mews represents an implementation of hospitality provider for Mews Connector API.
+// Mews uses token based authentication: ClientToken identifies the integration, AccessToken identifies the property (enterprise).
+// Both tokens are taken from the secret store or environment, so no interactive login is needed.
+// Rooms of the extension map are Mews resources (spaces): hospitality_room_id is the resource ID.
+package mews
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultApiURL     = "https://api.mews.com"
+	defaultClientName = "hotelito 1.0"
+	defaultCurrency   = "USD"
+	// apiPageSize is the amount of records requested per page from paginated Mews endpoints
+	apiPageSize = 100
+)
+
+const (
+	resourceBlockType = "OutOfOrder"
+	// resourceBlockDuration is the length of a block created from the phone. The room stays blocked until the unblock code is dialed
+	resourceBlockDuration  = 365 * 24 * time.Hour
+	defaultRoomBlockReason = "Out of order"
+	reservationStateActive = "Started" // checked in
+)
+
+// resourceStates maps room conditions dialed from the room phone to Mews resource states
+var resourceStates = hotel.RoomConditionMap{
+	"clean":     "Clean",
+	"dirty":     "Dirty",
+	"inspected": "Inspected",
+}
+
+// HTTPClient is needed for mocking http requests in tests. Original http.Client implements this interface
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Mews is used to make requests to Mews Connector API
+type Mews struct {
+	httpClient  HTTPClient
+	storeClient secrets.SecretsStore
+	log         *logrus.Logger
+	configMap   *configuration.ConfigMap
+	apiURL      string
+	clientToken string
+	accessToken string
+	clientName  string
+	serviceID   string // service the charges are posted to. Optional: charges are not supported without it
+	currency    string
+}
+
+/*
+	Response: {
+	    "Resources": [
+	        {
+	            "Id": "5ee074b1-6c86-48e8-915f-c7aa4702086f",
+	            "IsActive": true,
+	            "Name": "101",
+	            "ParentResourceId": null,
+	            "State": "Dirty",
+	            "Data": {
+	                "Discriminator": "Space",
+	                "Value": {
+	                    "FloorNumber": "1"
+	                }
+	            }
+	        }
+	    ],
+	    "Cursor": "5ee074b1-6c86-48e8-915f-c7aa4702086f"
+	}
+*/
+type ResponseGetResources struct {
+	Resources []Resource `json:"Resources"`
+	Cursor    string     `json:"Cursor"`
+}
+
+type Resource struct {
+	ID       string `json:"Id"`
+	IsActive bool   `json:"IsActive"`
+	Name     string `json:"Name"`
+	State    string `json:"State"`
+	Data     struct {
+		Discriminator string `json:"Discriminator"`
+	} `json:"Data"`
+}
+
+/*
+	Response: {
+	    "Reservations": [
+	        {
+	            "Id": "bfee2c44-1f84-4326-a862-5289598f6e2d",
+	            "ServiceId": "bd26d8db-86da-4f96-9efc-e5a4654a4a94",
+	            "AccountId": "407a26f8-dcfc-4e29-b978-ab440117a153",
+	            "AccountType": "Customer",
+	            "AssignedResourceId": "5ee074b1-6c86-48e8-915f-c7aa4702086f",
+	            "State": "Started"
+	        }
+	    ],
+	    "Cursor": "bfee2c44-1f84-4326-a862-5289598f6e2d"
+	}
+*/
+type ResponseGetReservations struct {
+	Reservations []Reservation `json:"Reservations"`
+	Cursor       string        `json:"Cursor"`
+}
+
+type Reservation struct {
+	ID                 string `json:"Id"`
+	ServiceID          string `json:"ServiceId"`
+	AccountID          string `json:"AccountId"`
+	AssignedResourceID string `json:"AssignedResourceId"`
+	State              string `json:"State"`
+}
+
+type ResponseGetCustomers struct {
+	Customers []Customer `json:"Customers"`
+	Cursor    string     `json:"Cursor"`
+}
+
+type Customer struct {
+	ID        string `json:"Id"`
+	FirstName string `json:"FirstName"`
+	LastName  string `json:"LastName"`
+	Email     string `json:"Email"`
+}
+
+type ResponseResourceBlocks struct {
+	ResourceBlocks []ResourceBlock `json:"ResourceBlocks"`
+	Cursor         string          `json:"Cursor"`
+}
+
+type ResourceBlock struct {
+	ID                 string `json:"Id"`
+	AssignedResourceID string `json:"AssignedResourceId"`
+	Type               string `json:"Type"`
+	Name               string `json:"Name"`
+}
+
+type ResponseAddOrder struct {
+	OrderID string `json:"OrderId"`
+}
+
+// ResponseError is returned by Mews with non 200 status code
+type ResponseError struct {
+	Message string `json:"Message"`
+}
+
+// inHouseReservation is a started (checked-in) reservation with its guest
+type inHouseReservation struct {
+	reservation Reservation
+	guest       hotel.Guest
+}
+
+func init() {
+	hotel.Register("mews", func(log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (hotel.Provider, error) {
+		client, err := New(log, secretStore, configMap)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *configuration.ConfigMap) (*Mews, error) {
+	log.Debugf("Creating new Mews client")
+
+	mewsClient := &Mews{
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		storeClient: secretStore,
+		log:         log,
+		configMap:   configMapInfo,
+	}
+	getVar := func(varName string) string {
+		return hotel.VarFromStoreOrEnvironment(log, secretStore, varName)
+	}
+
+	mewsClient.clientToken = getVar("MEWS_CLIENT_TOKEN")
+	mewsClient.accessToken = getVar("MEWS_ACCESS_TOKEN")
+	if mewsClient.clientToken == "" || mewsClient.accessToken == "" {
+		errMsg := errors.New("not all required env variables are set. Missed one of: MEWS_CLIENT_TOKEN, MEWS_ACCESS_TOKEN")
+		log.Error(errMsg)
+		return nil, errMsg
+	}
+
+	mewsClient.apiURL = strings.TrimSuffix(getVar("MEWS_API_URL"), "/")
+	if mewsClient.apiURL == "" {
+		mewsClient.apiURL = defaultApiURL
+	}
+	mewsClient.clientName = getVar("MEWS_CLIENT_NAME")
+	if mewsClient.clientName == "" {
+		mewsClient.clientName = defaultClientName
+	}
+	mewsClient.serviceID = getVar("MEWS_SERVICE_ID")
+	mewsClient.currency = getVar("MEWS_CURRENCY")
+	if mewsClient.currency == "" {
+		mewsClient.currency = defaultCurrency
+	}
+
+	return mewsClient, nil
+}
+
+// GetRooms returns active spaces of the enterprise with their state and in-house guests
+func (p *Mews) GetRooms(ctx context.Context) (rooms []hotel.Room, err error) {
+	p.log.Debugf("getting rooms")
+
+	resources, err := p.getResources(ctx, nil)
+	if err != nil {
+		p.log.Error(err)
+		return rooms, err
+	}
+
+	reservations, err := p.getInHouseReservations(ctx, nil)
+	if err != nil {
+		p.log.Error(err)
+		return rooms, err
+	}
+
+	for _, resource := range resources {
+		if !resource.IsActive {
+			continue
+		}
+		var guest *hotel.Guest
+		if reservation, ok := reservations[resource.ID]; ok {
+			guest = &reservation.guest
+		}
+		rooms = append(rooms, p.toHotelRoom(resource, guest))
+	}
+
+	if len(rooms) == 0 {
+		errMsg := errors.New("no rooms found")
+		return rooms, &hotel.DetailedError{Msg: errMsg, Details: "success, but no rooms found"}
+	}
+	p.log.Debugf("Amount of rooms: %d", len(rooms))
+	return rooms, nil
+}
+
+// GetRoom returns the room with extension roomNumber with its state and in-house guest
+func (p *Mews) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
+	p.log.Infof("get info about room %s", roomNumber)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomNumber)
+	if err != nil {
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+
+	resources, err := p.getResources(ctx, []string{extension.HospitalityRoomID})
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+	if len(resources) == 0 {
+		errMsg := fmt.Sprintf("resource for room %s not found", roomNumber)
+		p.log.Error(errMsg)
+		return hotel.Room{PhoneNumber: roomNumber, RoomID: extension.HospitalityRoomID}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	reservations, err := p.getInHouseReservations(ctx, []string{extension.HospitalityRoomID})
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+	var guest *hotel.Guest
+	if reservation, ok := reservations[extension.HospitalityRoomID]; ok {
+		guest = &reservation.guest
+	}
+	return p.toHotelRoom(resources[0], guest), nil
+}
+
+// GetRoomGuest returns the in-house guest of the room with extension roomNumber
+func (p *Mews) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	p.log.Debugf("get in-house guest for room %s", roomNumber)
+
+	reservation, err := p.getRoomReservation(ctx, roomNumber)
+	if err != nil {
+		return hotel.Guest{}, err
+	}
+	return reservation.guest, nil
+}
+
+// UpdateRoom sets the state of the resource. Mews doesn't record who has changed the state, so housekeeperName is only logged
+func (p *Mews) UpdateRoom(ctx context.Context, roomExtensionNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoom %s to %s for %s", roomExtensionNumber, housekeepingStatus, housekeeperName)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+
+	roomCondition := strings.ToLower(housekeepingStatus)
+	if !hotel.IsRoomConditionValid(roomCondition, resourceStates) {
+		errMsg := fmt.Sprintf("room condition %s is not valid", housekeepingStatus)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	request := map[string]interface{}{
+		"ResourceUpdates": []map[string]interface{}{
+			{
+				"ResourceId": extension.HospitalityRoomID,
+				"State":      map[string]string{"Value": resourceStates[roomCondition]},
+			},
+		},
+	}
+	err = p.post(ctx, "resources/update", request, nil)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomExtensionNumber, housekeepingStatus)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// UpdateRoomDoNotDisturb is not supported: Mews has no "Do Not Disturb" state of a resource
+func (p *Mews) UpdateRoomDoNotDisturb(ctx context.Context, roomExtensionNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	errMsg := "do not disturb is not supported by mews"
+	p.log.Error(errMsg)
+	return "", errors.New(errMsg)
+}
+
+// UpdateRoomBlock takes the room out of order (blocked=true) or removes its out of order blocks (blocked=false)
+func (p *Mews) UpdateRoomBlock(ctx context.Context, roomExtensionNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomBlock %s to %t (%s) for %s", roomExtensionNumber, blocked, reason, staffName)
+
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return msg, err
+	}
+	resourceID := extension.HospitalityRoomID
+
+	if blocked {
+		if reason == "" {
+			reason = defaultRoomBlockReason
+		}
+		if staffName != "" {
+			reason = fmt.Sprintf("%s (%s)", reason, staffName)
+		}
+		now := time.Now().UTC()
+		request := map[string]interface{}{
+			"ResourceBlocks": []map[string]interface{}{
+				{
+					"ResourceId": resourceID,
+					"Name":       reason,
+					"Type":       resourceBlockType,
+					"StartUtc":   now.Format(time.RFC3339),
+					"EndUtc":     now.Add(resourceBlockDuration).Format(time.RFC3339),
+				},
+			},
+		}
+		respBody := &ResponseResourceBlocks{}
+		err = p.post(ctx, "resourceBlocks/add", request, respBody)
+		if err != nil {
+			p.log.Error(err)
+			return msg, err
+		}
+		blockID := ""
+		if len(respBody.ResourceBlocks) > 0 {
+			blockID = respBody.ResourceBlocks[0].ID
+		}
+		msg = fmt.Sprintf("Finish UpdateRoomBlock successfully blocked room %s. Block: %s", roomExtensionNumber, blockID)
+		p.log.Debugf(msg)
+		return msg, nil
+	}
+
+	blocks, err := p.getActiveResourceBlocks(ctx, resourceID)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+	var blockIDs []string
+	for _, block := range blocks {
+		if block.Type == resourceBlockType && block.AssignedResourceID == resourceID {
+			blockIDs = append(blockIDs, block.ID)
+		}
+	}
+	if len(blockIDs) > 0 {
+		err = p.post(ctx, "resourceBlocks/delete", map[string]interface{}{"ResourceBlockIds": blockIDs}, nil)
+		if err != nil {
+			p.log.Error(err)
+			return msg, err
+		}
+	} else {
+		p.log.Infof("no out of order blocks found for room %s", roomExtensionNumber)
+	}
+	msg = fmt.Sprintf("Finish UpdateRoomBlock successfully unblocked room %s. Removed blocks: %d", roomExtensionNumber, len(blockIDs))
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// PostCharge adds an order with the catalog item to the in-house reservation. Requires MEWS_SERVICE_ID.
+// If the item has hospitality_item_id it is posted as Mews product, otherwise as a custom item with the catalog price
+func (p *Mews) PostCharge(ctx context.Context, roomExtensionNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start PostCharge %d x %s to room %s by %s", quantity, itemCode, roomExtensionNumber, housekeeperName)
+
+	if p.serviceID == "" {
+		errMsg := "MEWS_SERVICE_ID is not set. Charges are not supported"
+		p.log.Error(errMsg)
+		return "", errors.New(errMsg)
+	}
+	item, ok := p.configMap.SearchItemByCode(itemCode)
+	if !ok {
+		errMsg := fmt.Sprintf("item %s not found in item catalog", itemCode)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	if quantity <= 0 {
+		errMsg := fmt.Sprintf("quantity %d is not valid", quantity)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	reservation, err := p.getRoomReservation(ctx, roomExtensionNumber)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	request := map[string]interface{}{
+		"AccountId":           reservation.reservation.AccountID,
+		"ServiceId":           p.serviceID,
+		"LinkedReservationId": reservation.reservation.ID,
+		"Notes":               fmt.Sprintf("Posted by %s", housekeeperName),
+	}
+	if item.HospitalityItemID != "" {
+		request["ProductOrders"] = []map[string]interface{}{
+			{"ProductId": item.HospitalityItemID, "Count": quantity},
+		}
+	} else {
+		request["Items"] = []map[string]interface{}{
+			{
+				"Name":      item.Name,
+				"UnitCount": quantity,
+				"UnitAmount": map[string]interface{}{
+					"Currency":   p.currency,
+					"GrossValue": item.Price,
+					"TaxCodes":   []string{},
+				},
+			},
+		}
+	}
+	respBody := &ResponseAddOrder{}
+	err = p.post(ctx, "orders/add", request, respBody)
+	if err != nil {
+		p.log.Error(err)
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish PostCharge successfully posted %d x %s to room %s. Reservation: %s, order: %s", quantity, item.Name, roomExtensionNumber, reservation.reservation.ID, respBody.OrderID)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// HandleOAuthCallback does nothing. Mews uses token based authentication
+func (p *Mews) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
+	p.log.Debugf("mews uses token based authentication. OAuth callback is ignored")
+	return nil
+}
+
+// HandleInitialLogin does nothing. Mews uses token based authentication, so empty url is returned
+func (p *Mews) HandleInitialLogin(ctx context.Context) (url string, err error) {
+	p.log.Debugf("mews uses token based authentication. Login is not required")
+	return "", nil
+}
+
+func (p *Mews) Close() error {
+	err := p.storeClient.Close()
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to close secret store: %s", err.Error())
+		p.log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func (p *Mews) toHotelRoom(resource Resource, guest *hotel.Guest) hotel.Room {
+	room := hotel.Room{
+		RoomID:        resource.ID,
+		RoomName:      resource.Name,
+		RoomCondition: strings.ToLower(resource.State),
+		RoomBlocked:   resource.State == "OutOfOrder" || resource.State == "OutOfService",
+		RoomOccupied:  guest != nil,
+		Guest:         guest,
+	}
+	if extension, ok := p.configMap.SearchExtensionByRoomID(resource.ID, ""); ok {
+		room.PhoneNumber = extension.RoomExtension
+	}
+	return room
+}
+
+// getRoomReservation returns the in-house reservation of the room with extension roomExtensionNumber
+func (p *Mews) getRoomReservation(ctx context.Context, roomExtensionNumber string) (inHouseReservation, error) {
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return inHouseReservation{}, err
+	}
+
+	reservations, err := p.getInHouseReservations(ctx, []string{extension.HospitalityRoomID})
+	if err != nil {
+		p.log.Error(err)
+		return inHouseReservation{}, err
+	}
+	reservation, ok := reservations[extension.HospitalityRoomID]
+	if !ok {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomExtensionNumber)
+		p.log.Debugf(errMsg)
+		return inHouseReservation{}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	return reservation, nil
+}
+
+// getResources returns resources with resourceIDs (all resources if resourceIDs is empty) from all pages
+func (p *Mews) getResources(ctx context.Context, resourceIDs []string) (resources []Resource, err error) {
+	request := map[string]interface{}{
+		"Extent": map[string]bool{"Resources": true},
+	}
+	if len(resourceIDs) > 0 {
+		request["ResourceIds"] = resourceIDs
+	}
+
+	cursor := ""
+	for {
+		request["Limitation"] = limitation(cursor)
+		respBody := &ResponseGetResources{}
+		err = p.post(ctx, "resources/getAll", request, respBody)
+		if err != nil {
+			return resources, err
+		}
+		resources = append(resources, respBody.Resources...)
+		if respBody.Cursor == "" || len(respBody.Resources) < apiPageSize {
+			return resources, nil
+		}
+		cursor = respBody.Cursor
+	}
+}
+
+// getInHouseReservations returns started (checked-in) reservations of resourceIDs (all resources if empty) with their guests.
+// Result is a map resourceID -> reservation
+func (p *Mews) getInHouseReservations(ctx context.Context, resourceIDs []string) (reservations map[string]inHouseReservation, err error) {
+	now := time.Now().UTC()
+	request := map[string]interface{}{
+		"States": []string{reservationStateActive},
+		"CollidingUtc": map[string]string{
+			"StartUtc": now.Format(time.RFC3339),
+			"EndUtc":   now.Add(time.Minute).Format(time.RFC3339),
+		},
+	}
+	if len(resourceIDs) > 0 {
+		request["AssignedResourceIds"] = resourceIDs
+	}
+
+	var started []Reservation
+	cursor := ""
+	for {
+		request["Limitation"] = limitation(cursor)
+		respBody := &ResponseGetReservations{}
+		err = p.post(ctx, "reservations/getAll/2023-06-06", request, respBody)
+		if err != nil {
+			return nil, err
+		}
+		started = append(started, respBody.Reservations...)
+		if respBody.Cursor == "" || len(respBody.Reservations) < apiPageSize {
+			break
+		}
+		cursor = respBody.Cursor
+	}
+
+	var customerIDs []string
+	for _, reservation := range started {
+		if reservation.AccountID != "" {
+			customerIDs = append(customerIDs, reservation.AccountID)
+		}
+	}
+	customers, err := p.getCustomers(ctx, customerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	//the first reservation (by ID) wins if a resource has several started reservations
+	sort.Slice(started, func(i, j int) bool { return started[i].ID < started[j].ID })
+	reservations = make(map[string]inHouseReservation)
+	for _, reservation := range started {
+		if reservation.AssignedResourceID == "" {
+			continue
+		}
+		if _, exists := reservations[reservation.AssignedResourceID]; exists {
+			continue
+		}
+		customer := customers[reservation.AccountID]
+		reservations[reservation.AssignedResourceID] = inHouseReservation{
+			reservation: reservation,
+			guest: hotel.Guest{
+				ReservationID: reservation.ID,
+				FirstName:     customer.FirstName,
+				LastName:      customer.LastName,
+				Email:         customer.Email,
+			},
+		}
+	}
+	return reservations, nil
+}
+
+// getCustomers returns customers with customerIDs. Result is a map customerID -> customer
+func (p *Mews) getCustomers(ctx context.Context, customerIDs []string) (customers map[string]Customer, err error) {
+	customers = make(map[string]Customer)
+	for start := 0; start < len(customerIDs); start += apiPageSize {
+		end := start + apiPageSize
+		if end > len(customerIDs) {
+			end = len(customerIDs)
+		}
+		request := map[string]interface{}{
+			"CustomerIds": customerIDs[start:end],
+			"Extent":      map[string]bool{"Customers": true},
+			"Limitation":  limitation(""),
+		}
+		respBody := &ResponseGetCustomers{}
+		err = p.post(ctx, "customers/getAll", request, respBody)
+		if err != nil {
+			return customers, err
+		}
+		for _, customer := range respBody.Customers {
+			customers[customer.ID] = customer
+		}
+	}
+	return customers, nil
+}
+
+// getActiveResourceBlocks returns blocks of the resource that are active now
+func (p *Mews) getActiveResourceBlocks(ctx context.Context, resourceID string) (blocks []ResourceBlock, err error) {
+	now := time.Now().UTC()
+	request := map[string]interface{}{
+		"AssignedResourceIds": []string{resourceID},
+		"CollidingUtc": map[string]string{
+			"StartUtc": now.Format(time.RFC3339),
+			"EndUtc":   now.Add(time.Minute).Format(time.RFC3339),
+		},
+		"Extent":     map[string]bool{"Inactive": false},
+		"Limitation": limitation(""),
+	}
+	respBody := &ResponseResourceBlocks{}
+	err = p.post(ctx, "resourceBlocks/getAll", request, respBody)
+	if err != nil {
+		return blocks, err
+	}
+	return respBody.ResourceBlocks, nil
+}
+
+func limitation(cursor string) map[string]interface{} {
+	result := map[string]interface{}{"Count": apiPageSize}
+	if cursor != "" {
+		result["Cursor"] = cursor
+	}
+	return result
+}
+
+// post sends request with authentication tokens to the Connector API operation (e.g. "resources/getAll") and decodes the reply to response.
+// response can be nil if the reply is not needed
+func (p *Mews) post(ctx context.Context, operation string, request map[string]interface{}, response interface{}) error {
+	apiUrl := fmt.Sprintf("%s/api/connector/v1/%s", p.apiURL, operation)
+
+	body := map[string]interface{}{
+		"ClientToken": p.clientToken,
+		"AccessToken": p.accessToken,
+		"Client":      p.clientName,
+	}
+	for key, value := range request {
+		body[key] = value
+	}
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	p.log.Debugf("Sending POST to %s", apiUrl)
+	req, err := http.NewRequestWithContext(ctx, "POST", apiUrl, bytes.NewReader(jsonBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("%s failed with: %s", operation, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		respError := &ResponseError{}
+		if json.Unmarshal(respBody, respError) != nil || respError.Message == "" {
+			respError.Message = string(respBody)
+		}
+		err = fmt.Errorf("mews %s failed with status %d: %s", operation, resp.StatusCode, respError.Message)
+		return &hotel.DetailedError{Msg: err, StatusCodeMessage: resp.Status, Details: respError.Message}
+	}
+
+	if response == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+	}
+	return nil
+}
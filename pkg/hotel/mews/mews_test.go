@@ -0,0 +1,249 @@
+package mews
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/hoteltest"
+	"github.com/olegromanchuk/hotelito/internal/secretstest"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+const operationPrefix = "/api/connector/v1/"
+
+// newMewsServer imitates Mews Connector API. Replies are looked up by the operation, e.g. "resources/getAll"
+func newMewsServer(t *testing.T, replies map[string]string) *hoteltest.Server {
+	server := hoteltest.NewServer(t, replies)
+	server.Key = func(r *http.Request) string {
+		return strings.TrimPrefix(r.URL.Path, operationPrefix)
+	}
+	return server
+}
+
+// mewsRequests returns the decoded bodies of operation requests received by server. Every request must have the tokens of the test client
+func mewsRequests(t *testing.T, server *hoteltest.Server, operation string) []map[string]interface{} {
+	var requests []map[string]interface{}
+	for _, request := range server.Requests {
+		if request.Path != operationPrefix+operation {
+			continue
+		}
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(request.Body), &body))
+		assert.Equal(t, "client-token", body["ClientToken"])
+		assert.Equal(t, "access-token", body["AccessToken"])
+		assert.Equal(t, "hotelito test", body["Client"])
+		requests = append(requests, body)
+	}
+	return requests
+}
+
+func newTestMews(server *hoteltest.Server) *Mews {
+	return &Mews{
+		httpClient:  server.Client(),
+		log:         logrus.New(),
+		apiURL:      server.URL,
+		clientToken: "client-token",
+		accessToken: "access-token",
+		clientName:  "hotelito test",
+		currency:    "EUR",
+		configMap: &configuration.ConfigMap{
+			ExtensionMap: hoteltest.Extensions("", "res-101", "res-102"),
+			ItemCatalog: []configuration.Item{
+				{ItemCode: "12", Name: "Beer", Price: 5.5},
+				{ItemCode: "13", Name: "Water", Price: 2, HospitalityItemID: "product-water"},
+			},
+		},
+	}
+}
+
+const (
+	replyResources    = `{"Resources":[{"Id":"res-101","IsActive":true,"Name":"101","State":"Dirty"},{"Id":"res-102","IsActive":true,"Name":"102","State":"OutOfOrder"},{"Id":"res-old","IsActive":false,"Name":"Old","State":"Clean"}],"Cursor":null}`
+	replyReservations = `{"Reservations":[{"Id":"rsv-1","AccountId":"cust-1","AssignedResourceId":"res-101","State":"Started"}],"Cursor":null}`
+	replyCustomers    = `{"Customers":[{"Id":"cust-1","FirstName":"Jane","LastName":"Smith","Email":"jane.smith@example.com"}],"Cursor":null}`
+)
+
+func TestMews_GetRooms(t *testing.T) {
+	server := newMewsServer(t, map[string]string{
+		"resources/getAll":               replyResources,
+		"reservations/getAll/2023-06-06": replyReservations,
+		"customers/getAll":               replyCustomers,
+	})
+
+	rooms, err := newTestMews(server).GetRooms(context.Background())
+	require.NoError(t, err)
+	guest := hoteltest.Guest("rsv-1")
+	assert.Equal(t, []hotel.Room{
+		{
+			RoomID:        "res-101",
+			RoomName:      "101",
+			PhoneNumber:   "1001",
+			RoomCondition: "dirty",
+			RoomOccupied:  true,
+			Guest:         &guest,
+		},
+		{
+			RoomID:        "res-102",
+			RoomName:      "102",
+			PhoneNumber:   "1002",
+			RoomCondition: "outoforder",
+			RoomBlocked:   true,
+		},
+	}, rooms)
+}
+
+func TestMews_GetRoomGuest(t *testing.T) {
+	server := newMewsServer(t, map[string]string{
+		"reservations/getAll/2023-06-06": replyReservations,
+		"customers/getAll":               replyCustomers,
+	})
+
+	hoteltest.CheckGetRoomGuest(t, newTestMews(server), "rsv-1")
+	assert.Equal(t, []interface{}{"res-101"}, mewsRequests(t, server, "reservations/getAll/2023-06-06")[0]["AssignedResourceIds"])
+	assert.Equal(t, []interface{}{"cust-1"}, mewsRequests(t, server, "customers/getAll")[0]["CustomerIds"])
+}
+
+func TestMews_GetRoom(t *testing.T) {
+	server := newMewsServer(t, map[string]string{
+		"resources/getAll":               `{"Resources":[{"Id":"res-101","IsActive":true,"Name":"101","State":"Inspected"}]}`,
+		"reservations/getAll/2023-06-06": `{"Reservations":[]}`,
+		"customers/getAll":               `{"Customers":[]}`,
+	})
+
+	room, err := newTestMews(server).GetRoom(context.Background(), "1001", "")
+	require.NoError(t, err)
+	assert.Equal(t, hotel.Room{RoomID: "res-101", RoomName: "101", PhoneNumber: "1001", RoomCondition: "inspected"}, room)
+}
+
+func TestMews_UpdateRoom(t *testing.T) {
+	server := newMewsServer(t, map[string]string{
+		"resources/update": `{}`,
+	})
+
+	hoteltest.CheckUpdateRoom(t, newTestMews(server), server, "clean", "sparkling")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"ResourceId": "res-101", "State": map[string]interface{}{"Value": "Clean"}},
+	}, mewsRequests(t, server, "resources/update")[0]["ResourceUpdates"])
+}
+
+func TestMews_UpdateRoomBlock(t *testing.T) {
+	server := newMewsServer(t, map[string]string{
+		"resourceBlocks/add":    `{"ResourceBlocks":[{"Id":"block-1","AssignedResourceId":"res-101","Type":"OutOfOrder"}]}`,
+		"resourceBlocks/getAll": `{"ResourceBlocks":[{"Id":"block-1","AssignedResourceId":"res-101","Type":"OutOfOrder"},{"Id":"block-2","AssignedResourceId":"res-101","Type":"InternalUse"}]}`,
+		"resourceBlocks/delete": `{}`,
+	})
+
+	hoteltest.CheckUpdateRoomBlock(t, newTestMews(server),
+		"Finish UpdateRoomBlock successfully blocked room 1001. Block: block-1",
+		"Finish UpdateRoomBlock successfully unblocked room 1001. Removed blocks: 1")
+	block := mewsRequests(t, server, "resourceBlocks/add")[0]["ResourceBlocks"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "res-101", block["ResourceId"])
+	assert.Equal(t, "AC repair (Engineer)", block["Name"])
+	assert.Equal(t, "OutOfOrder", block["Type"])
+	assert.Equal(t, []interface{}{"block-1"}, mewsRequests(t, server, "resourceBlocks/delete")[0]["ResourceBlockIds"])
+}
+
+func TestMews_PostCharge(t *testing.T) {
+	server := newMewsServer(t, map[string]string{
+		"reservations/getAll/2023-06-06": replyReservations,
+		"customers/getAll":               replyCustomers,
+		"orders/add":                     `{"OrderId":"order-1"}`,
+	})
+	client := newTestMews(server)
+
+	_, err := client.PostCharge(context.Background(), "1001", "12", 2, "John Doe")
+	assert.EqualError(t, err, "MEWS_SERVICE_ID is not set. Charges are not supported")
+
+	client.serviceID = "service-bar"
+	msg, err := client.PostCharge(context.Background(), "1001", "12", 2, "John Doe")
+	require.NoError(t, err)
+	assert.Equal(t, "Finish PostCharge successfully posted 2 x Beer to room 1001. Reservation: rsv-1, order: order-1", msg)
+	order := mewsRequests(t, server, "orders/add")[0]
+	assert.Equal(t, "cust-1", order["AccountId"])
+	assert.Equal(t, "service-bar", order["ServiceId"])
+	assert.Equal(t, "rsv-1", order["LinkedReservationId"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"Name":       "Beer",
+			"UnitCount":  float64(2),
+			"UnitAmount": map[string]interface{}{"Currency": "EUR", "GrossValue": 5.5, "TaxCodes": []interface{}{}},
+		},
+	}, order["Items"])
+
+	// catalog item with hospitality_item_id is posted as a Mews product
+	_, err = client.PostCharge(context.Background(), "1001", "13", 1, "John Doe")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"ProductId": "product-water", "Count": float64(1)},
+	}, mewsRequests(t, server, "orders/add")[1]["ProductOrders"])
+
+	_, err = client.PostCharge(context.Background(), "1001", "99", 1, "John Doe")
+	assert.EqualError(t, err, "item 99 not found in item catalog")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+}
+
+func TestMews_ApiError(t *testing.T) {
+	server := newMewsServer(t, map[string]string{
+		"resources/update": `{"Message":"Invalid AccessToken."}`,
+	})
+	server.ReplyStatus["resources/update"] = http.StatusUnauthorized
+
+	hoteltest.CheckApiError(t, newTestMews(server), "mews resources/update failed with status 401: Invalid AccessToken.")
+}
+
+func TestMews_NotSupported(t *testing.T) {
+	hoteltest.CheckNotSupported(t, &Mews{log: logrus.New()}, "do not disturb is not supported by mews", "")
+}
+
+func TestNew(t *testing.T) {
+	log := logrus.New()
+	configMap := &configuration.ConfigMap{}
+
+	t.Run("tokens from store", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", "MEWS_CLIENT_TOKEN").Return("client-token", nil)
+		store.On("RetrieveVar", "MEWS_ACCESS_TOKEN").Return("access-token", nil)
+		store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		client, err := New(log, store, configMap)
+		require.NoError(t, err)
+		assert.Equal(t, "client-token", client.clientToken)
+		assert.Equal(t, "access-token", client.accessToken)
+		assert.Equal(t, defaultApiURL, client.apiURL)
+		assert.Equal(t, defaultClientName, client.clientName)
+		assert.Equal(t, defaultCurrency, client.currency)
+	})
+
+	t.Run("tokens from environment", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", mock.Anything).Return("", errors.New("not found"))
+		os.Setenv("MEWS_CLIENT_TOKEN", "env-client-token")
+		os.Setenv("MEWS_ACCESS_TOKEN", "env-access-token")
+		os.Setenv("MEWS_API_URL", "https://api.mews-demo.com/")
+		defer os.Unsetenv("MEWS_CLIENT_TOKEN")
+		defer os.Unsetenv("MEWS_ACCESS_TOKEN")
+		defer os.Unsetenv("MEWS_API_URL")
+
+		client, err := New(log, store, configMap)
+		require.NoError(t, err)
+		assert.Equal(t, "env-client-token", client.clientToken)
+		assert.Equal(t, "env-access-token", client.accessToken)
+		assert.Equal(t, "https://api.mews-demo.com", client.apiURL)
+	})
+
+	t.Run("tokens are missing", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		_, err := New(log, store, configMap)
+		assert.EqualError(t, err, "not all required env variables are set. Missed one of: MEWS_CLIENT_TOKEN, MEWS_ACCESS_TOKEN")
+	})
+}
@@ -0,0 +1,179 @@
+// Package oauthclient sends requests to the API of hospitality providers that request the access token themselves,
+// without the interactive login: OAuth client credentials or password grant (Apaleo, OPERA Cloud).
+// The access token is kept in memory and in the secret store under the name of the provider. It is requested again when the API rejects it.
+// Errors are classified with hotel.ErrAuthorization, hotel.ErrValidation and hotel.ErrTransient
+package oauthclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// HTTPClient is needed for mocking http requests in tests. Original http.Client implements this interface
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client sends requests with the access token of the provider Name
+type Client struct {
+	// Name of the provider. It starts error messages and is the name of the token in the secret store
+	Name       string
+	HTTPClient HTTPClient
+	// Store keeps the token between restarts if it implements secrets.ProviderTokenStore. Otherwise the token is kept only in memory
+	Store secrets.SecretsStore
+	Log   *logrus.Logger
+	// RequestToken requests a new access token from the identity provider. Error replies are returned as *oauth2.RetrieveError
+	RequestToken func(ctx context.Context) (*oauth2.Token, error)
+	// ErrorMessage returns the message of the API error reply. Optional: the whole reply is used if it is nil or returns empty string
+	ErrorMessage func(body []byte) string
+
+	tokenMu     sync.Mutex
+	accessToken string
+}
+
+// SetAccessToken replaces the access token kept in memory. The secret store is not changed
+func (c *Client) SetAccessToken(accessToken string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.accessToken = accessToken
+}
+
+// Do sends the request made by newRequest with accessToken and decodes JSON reply to response. response can be nil.
+// operation names the request in errors, e.g. "GET /inventory/v1/units".
+// If the API rejects the access token (401), a new token is requested and the request is sent once again
+func (c *Client) Do(ctx context.Context, operation string, newRequest func(accessToken string) (*http.Request, error), response interface{}) error {
+	for attempt := 0; ; attempt++ {
+		accessToken, err := c.AccessToken(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+
+		req, err := newRequest(accessToken)
+		if err != nil {
+			return err
+		}
+		c.Log.Debugf("Sending %s to %s", req.Method, req.URL)
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return hotel.NewError(hotel.ErrTransient, &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("%s failed with: %s", operation, err)})
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			c.Log.Infof("%s rejected access token. Requesting a new one", c.Name)
+			continue
+		}
+		err = c.decodeResponse(operation, resp, response)
+		resp.Body.Close()
+		return err
+	}
+}
+
+// decodeResponse checks the status of resp and decodes JSON reply to response. response can be nil.
+// 204 is returned by list endpoints when nothing is found: response is not changed
+func (c *Client) decodeResponse(operation string, resp *http.Response, response interface{}) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		message := ""
+		if c.ErrorMessage != nil {
+			message = c.ErrorMessage(respBody)
+		}
+		if message == "" {
+			message = string(respBody)
+		}
+		err := fmt.Errorf("%s %s failed with status %d: %s", c.Name, operation, resp.StatusCode, message)
+		return classifyStatus(resp, &hotel.DetailedError{Msg: err, StatusCodeMessage: resp.Status, Details: message})
+	}
+
+	if response == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	err := json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return &hotel.DetailedError{Msg: err, Details: fmt.Sprintf("success, but parse return body: %s", err)}
+	}
+	return nil
+}
+
+// AccessToken returns the access token from memory or secret store. A new token is requested with RequestToken
+// if there is no token yet or forceNew is set (the token was rejected). The new token is saved to the secret store.
+// Concurrent callers wait for the token requested by one of them
+func (c *Client) AccessToken(ctx context.Context, forceNew bool) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if !forceNew {
+		if c.accessToken != "" {
+			return c.accessToken, nil
+		}
+		if store, ok := c.Store.(secrets.ProviderTokenStore); ok {
+			token, err := store.RetrieveProviderToken(c.Name)
+			if err != nil {
+				c.Log.Debugf("Got error while trying to get %s access token from store: %s", c.Name, err)
+			}
+			if token.AccessToken != "" {
+				c.accessToken = token.AccessToken
+				return c.accessToken, nil
+			}
+		}
+	}
+
+	token, err := c.RequestToken(ctx)
+	if err != nil {
+		errMsg := fmt.Errorf("failed to get %s access token: %w", c.Name, err)
+		c.Log.Error(errMsg)
+		return "", classifyTokenError(&hotel.DetailedError{Msg: errMsg, Details: err.Error()})
+	}
+	if token.AccessToken == "" {
+		errMsg := fmt.Errorf("%s token request returned empty access token", c.Name)
+		c.Log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrAuthorization, &hotel.DetailedError{Msg: errMsg, Details: "success, but no access token"})
+	}
+	c.accessToken = token.AccessToken
+
+	if store, ok := c.Store.(secrets.ProviderTokenStore); ok {
+		err = store.StoreProviderToken(c.Name, secrets.Token{AccessToken: token.AccessToken, TokenType: token.TokenType, Expiry: token.Expiry})
+		if err != nil {
+			//the token is still usable. It will be requested again after restart
+			c.Log.Errorf("failed to save %s access token: %s", c.Name, err)
+		}
+	}
+	return c.accessToken, nil
+}
+
+// classifyStatus marks the error reply of the API by its status. 5xx of non-idempotent requests are not transient:
+// the request might have been processed and repeating it might duplicate the change
+func classifyStatus(resp *http.Response, err *hotel.DetailedError) error {
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError && resp.Request != nil && !hotel.IsIdempotent(resp.Request.Method):
+		return err
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= http.StatusInternalServerError:
+		return hotel.NewError(hotel.ErrTransient, err)
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return hotel.NewError(hotel.ErrAuthorization, err)
+	default:
+		return hotel.NewError(hotel.ErrValidation, err)
+	}
+}
+
+// classifyTokenError marks the failed token request. The identity provider that replied 4xx (*oauth2.RetrieveError) rejected
+// the credentials: login is needed. Unreachable or failed (429, 5xx) identity provider is a transient failure
+func classifyTokenError(err error) error {
+	var retrieveError *oauth2.RetrieveError
+	if errors.As(err, &retrieveError) && retrieveError.Response != nil {
+		statusCode := retrieveError.Response.StatusCode
+		if statusCode != http.StatusTooManyRequests && statusCode < http.StatusInternalServerError {
+			return hotel.NewError(hotel.ErrAuthorization, err)
+		}
+	}
+	return hotel.NewError(hotel.ErrTransient, err)
+}
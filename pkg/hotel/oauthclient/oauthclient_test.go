@@ -0,0 +1,208 @@
+package oauthclient
+
+import (
+	"context"
+	"errors"
+	"github.com/olegromanchuk/hotelito/internal/secretstest"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// plainStore is a secret store without provider tokens
+type plainStore struct {
+	secrets.SecretsStore
+}
+
+func newTestClient(server *httptest.Server, store secrets.SecretsStore, requestToken func(ctx context.Context) (*oauth2.Token, error)) *Client {
+	return &Client{
+		Name:         "test",
+		HTTPClient:   server.Client(),
+		Store:        store,
+		Log:          logrus.New(),
+		RequestToken: requestToken,
+	}
+}
+
+func newRequest(method, url string) func(accessToken string) (*http.Request, error) {
+	return func(accessToken string) (*http.Request, error) {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		return req, nil
+	}
+}
+
+func TestClient_AccessToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	t.Run("token is requested once by concurrent callers", func(t *testing.T) {
+		var tokenCalls int32
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveProviderToken", "test").Return(secrets.Token{}, nil)
+		store.On("StoreProviderToken", "test", secrets.Token{AccessToken: "access-token"}).Return(nil).Once()
+		client := newTestClient(server, store, func(ctx context.Context) (*oauth2.Token, error) {
+			atomic.AddInt32(&tokenCalls, 1)
+			return &oauth2.Token{AccessToken: "access-token"}, nil
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				accessToken, err := client.AccessToken(context.Background(), false)
+				assert.NoError(t, err)
+				assert.Equal(t, "access-token", accessToken)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), tokenCalls)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("store without provider tokens", func(t *testing.T) {
+		client := newTestClient(server, plainStore{}, func(ctx context.Context) (*oauth2.Token, error) {
+			return &oauth2.Token{AccessToken: "access-token"}, nil
+		})
+		accessToken, err := client.AccessToken(context.Background(), false)
+		assert.NoError(t, err)
+		assert.Equal(t, "access-token", accessToken)
+	})
+
+	tests := []struct {
+		name         string
+		requestToken func(ctx context.Context) (*oauth2.Token, error)
+		expectedKind error
+	}{
+		{
+			name: "credentials are rejected",
+			requestToken: func(ctx context.Context) (*oauth2.Token, error) {
+				return nil, &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusUnauthorized}, Body: []byte(`{"error":"invalid_client"}`)}
+			},
+			expectedKind: hotel.ErrAuthorization,
+		},
+		{
+			name: "identity provider is unavailable",
+			requestToken: func(ctx context.Context) (*oauth2.Token, error) {
+				return nil, &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}
+			},
+			expectedKind: hotel.ErrTransient,
+		},
+		{
+			name: "identity provider is unreachable",
+			requestToken: func(ctx context.Context) (*oauth2.Token, error) {
+				return nil, errors.New("connection refused")
+			},
+			expectedKind: hotel.ErrTransient,
+		},
+		{
+			name: "empty access token",
+			requestToken: func(ctx context.Context) (*oauth2.Token, error) {
+				return &oauth2.Token{}, nil
+			},
+			expectedKind: hotel.ErrAuthorization,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestClient(server, plainStore{}, tt.requestToken).AccessToken(context.Background(), true)
+			assert.ErrorIs(t, err, tt.expectedKind)
+			var detailedError *hotel.DetailedError
+			assert.True(t, errors.As(err, &detailedError))
+		})
+	}
+}
+
+func TestClient_Do(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		status        int
+		expectedKind  error
+		notTransient  bool
+		expectedError string
+	}{
+		{name: "bad request", method: http.MethodPatch, status: http.StatusBadRequest, expectedKind: hotel.ErrValidation, expectedError: "test PATCH /units failed with status 400: failed"},
+		{name: "forbidden", method: http.MethodGet, status: http.StatusForbidden, expectedKind: hotel.ErrAuthorization},
+		{name: "too many requests", method: http.MethodPost, status: http.StatusTooManyRequests, expectedKind: hotel.ErrTransient},
+		{name: "5xx of GET", method: http.MethodGet, status: http.StatusServiceUnavailable, expectedKind: hotel.ErrTransient},
+		{name: "5xx of POST might have been processed", method: http.MethodPost, status: http.StatusInternalServerError, notTransient: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("failed"))
+			}))
+			defer server.Close()
+			client := newTestClient(server, plainStore{}, nil)
+			client.SetAccessToken("access-token")
+
+			err := client.Do(context.Background(), tt.method+" /units", newRequest(tt.method, server.URL+"/units"), nil)
+			require.Error(t, err)
+			var detailedError *hotel.DetailedError
+			assert.True(t, errors.As(err, &detailedError))
+			if tt.expectedKind != nil {
+				assert.ErrorIs(t, err, tt.expectedKind)
+			}
+			if tt.notTransient {
+				assert.NotErrorIs(t, err, hotel.ErrTransient)
+			}
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestClient_Do_RejectedToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name":"101"}`))
+	}))
+	defer server.Close()
+
+	store := new(secretstest.MockSecretsStore)
+	store.On("StoreProviderToken", "test", mock.Anything).Return(nil)
+	client := newTestClient(server, store, func(ctx context.Context) (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "new-token"}, nil
+	})
+	client.SetAccessToken("expired-token")
+
+	var response struct {
+		Name string `json:"name"`
+	}
+	err := client.Do(context.Background(), "GET /units", newRequest(http.MethodGet, server.URL+"/units"), &response)
+	require.NoError(t, err)
+	assert.Equal(t, "101", response.Name)
+	store.AssertCalled(t, "StoreProviderToken", "test", secrets.Token{AccessToken: "new-token"})
+
+	//the new token is rejected as well
+	client.RequestToken = func(ctx context.Context) (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "other-token"}, nil
+	}
+	client.SetAccessToken("expired-token")
+	err = client.Do(context.Background(), "GET /units", newRequest(http.MethodGet, server.URL+"/units"), &response)
+	assert.EqualError(t, err, "test GET /units failed with status 401: ")
+	assert.ErrorIs(t, err, hotel.ErrAuthorization)
+}
@@ -0,0 +1,676 @@
+// Package opera represents an implementation of hospitality provider for Oracle OPERA Cloud over OHIP (Oracle Hospitality Integration Platform) REST API.
+// Every request carries the application key (x-app-key) of the OHIP integration and an OAuth access token.
+// The token is requested from the OHIP gateway with integration user credentials (password grant) or with client credentials
+// if no integration user is set, saved to the secret store under the name opera and requested again when OHIP rejects it.
+// Rooms of the extension map are OPERA rooms: hospitality_room_id is the room number, hospitality_property_id is the hotel code (OPERA_HOTEL_ID by default).
+package opera
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/hotel/oauthclient"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// apiPageSize is the amount of records requested per page from paginated OHIP endpoints
+	apiPageSize              = 100
+	defaultClientScope       = "urn:opc:hgbu:ws:__myscopes__"
+	reservationStatusInHouse = "InHouse"
+	// roomStatusAfterBlock is set when the room is returned to inventory. The room has to be cleaned after maintenance
+	roomStatusAfterBlock   = "Dirty"
+	defaultRoomBlockReason = "Out of order"
+)
+
+const (
+	roomStatusOutOfOrder   = "OutOfOrder"
+	roomStatusOutOfService = "OutOfService"
+)
+
+// roomStatuses maps room conditions dialed from the room phone to OPERA housekeeping room statuses
+var roomStatuses = hotel.RoomConditionMap{
+	"clean":     "Clean",
+	"dirty":     "Dirty",
+	"inspected": "Inspected",
+	"pickup":    "Pickup",
+	"ooo":       roomStatusOutOfOrder,
+}
+
+// HTTPClient is needed for mocking http requests in tests. Original http.Client implements this interface
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Opera is used to make requests to OHIP REST API
+type Opera struct {
+	httpClient   HTTPClient
+	storeClient  secrets.SecretsStore
+	log          *logrus.Logger
+	configMap    *configuration.ConfigMap
+	gatewayURL   string
+	appKey       string
+	hotelID      string // default hotel code for extensions without hospitality_property_id
+	clientID     string
+	clientSecret string
+	username     string // integration user. Client credentials are used if empty
+	password     string
+	enterpriseID string // required by client credentials grant
+	scope        string
+	api          *oauthclient.Client
+}
+
+/*
+	Response: {
+	    "access_token": "eyJ4NXQjUzI1NiI6...",
+	    "token_type": "Bearer",
+	    "expires_in": 3600
+	}
+*/
+type ResponseToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+/*
+	Response: {
+	    "housekeepingRoomInfo": {
+	        "housekeepingRooms": {
+	            "room": [
+	                {
+	                    "roomId": "101",
+	                    "roomType": {"roomType": "KNG"},
+	                    "description": "King room",
+	                    "housekeeping": {
+	                        "housekeepingRoomStatus": {"housekeepingRoomStatus": "Dirty"},
+	                        "frontOfficeStatus": "Occupied"
+	                    }
+	                }
+	            ]
+	        },
+	        "hasMore": false
+	    }
+	}
+*/
+type ResponseHousekeepingOverview struct {
+	HousekeepingRoomInfo struct {
+		HousekeepingRooms struct {
+			Room []HousekeepingRoom `json:"room"`
+		} `json:"housekeepingRooms"`
+		HasMore bool `json:"hasMore"`
+	} `json:"housekeepingRoomInfo"`
+}
+
+type HousekeepingRoom struct {
+	RoomID   string `json:"roomId"`
+	RoomType struct {
+		RoomType string `json:"roomType"`
+	} `json:"roomType"`
+	Description  string `json:"description"`
+	Housekeeping struct {
+		HousekeepingRoomStatus struct {
+			HousekeepingRoomStatus string `json:"housekeepingRoomStatus"`
+		} `json:"housekeepingRoomStatus"`
+		FrontOfficeStatus string `json:"frontOfficeStatus"`
+	} `json:"housekeeping"`
+}
+
+/*
+	Response: {
+	    "reservations": {
+	        "reservationInfo": [
+	            {
+	                "reservationIdList": [{"id": "123456", "type": "Reservation"}],
+	                "roomStay": {"roomId": "101"},
+	                "reservationGuest": {"givenName": "Jane", "surname": "Smith", "email": "jane.smith@example.com"},
+	                "reservationStatus": "InHouse"
+	            }
+	        ],
+	        "hasMore": false
+	    }
+	}
+*/
+type ResponseGetReservations struct {
+	Reservations struct {
+		ReservationInfo []ReservationInfo `json:"reservationInfo"`
+		HasMore         bool              `json:"hasMore"`
+	} `json:"reservations"`
+}
+
+type ReservationInfo struct {
+	ReservationIDList []struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"reservationIdList"`
+	RoomStay struct {
+		RoomID string `json:"roomId"`
+	} `json:"roomStay"`
+	ReservationGuest struct {
+		GivenName string `json:"givenName"`
+		Surname   string `json:"surname"`
+		Email     string `json:"email"`
+	} `json:"reservationGuest"`
+	ReservationStatus string `json:"reservationStatus"`
+}
+
+// reservationID returns the OPERA reservation ID (not the confirmation number)
+func (r ReservationInfo) reservationID() string {
+	for _, id := range r.ReservationIDList {
+		if id.Type == "Reservation" {
+			return id.ID
+		}
+	}
+	return ""
+}
+
+// ResponseError is returned by OHIP with 4xx/5xx status code
+type ResponseError struct {
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+func (e ResponseError) message() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return e.Title
+}
+
+func init() {
+	hotel.Register("opera", func(log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (hotel.Provider, error) {
+		client, err := New(log, secretStore, configMap)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+func New(log *logrus.Logger, secretStore secrets.SecretsStore, configMapInfo *configuration.ConfigMap) (*Opera, error) {
+	log.Debugf("Creating new Opera client")
+
+	operaClient := &Opera{
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		storeClient: secretStore,
+		log:         log,
+		configMap:   configMapInfo,
+	}
+	getVar := func(varName string) string {
+		return hotel.VarFromStoreOrEnvironment(log, secretStore, varName)
+	}
+
+	operaClient.gatewayURL = strings.TrimSuffix(getVar("OPERA_GATEWAY_URL"), "/")
+	operaClient.appKey = getVar("OPERA_APP_KEY")
+	operaClient.clientID = getVar("OPERA_CLIENT_ID")
+	operaClient.clientSecret = getVar("OPERA_CLIENT_SECRET")
+	operaClient.hotelID = getVar("OPERA_HOTEL_ID")
+	if operaClient.gatewayURL == "" || operaClient.appKey == "" || operaClient.clientID == "" || operaClient.clientSecret == "" || operaClient.hotelID == "" {
+		errMsg := errors.New("not all required env variables are set. Missed one of: OPERA_GATEWAY_URL, OPERA_APP_KEY, OPERA_CLIENT_ID, OPERA_CLIENT_SECRET, OPERA_HOTEL_ID")
+		log.Error(errMsg)
+		return nil, errMsg
+	}
+
+	operaClient.username = getVar("OPERA_USERNAME")
+	operaClient.password = getVar("OPERA_PASSWORD")
+	operaClient.enterpriseID = getVar("OPERA_ENTERPRISE_ID")
+	if operaClient.username == "" && operaClient.enterpriseID == "" {
+		errMsg := errors.New("OPERA_USERNAME/OPERA_PASSWORD (integration user) or OPERA_ENTERPRISE_ID (client credentials) must be set")
+		log.Error(errMsg)
+		return nil, errMsg
+	}
+	operaClient.scope = getVar("OPERA_SCOPE")
+	if operaClient.scope == "" {
+		operaClient.scope = defaultClientScope
+	}
+	operaClient.api = operaClient.newAPIClient()
+
+	return operaClient, nil
+}
+
+// GetRooms returns housekeeping status of the rooms of all hotels from the extension map with their in-house guests
+func (p *Opera) GetRooms(ctx context.Context) (rooms []hotel.Room, err error) {
+	p.log.Debugf("getting rooms")
+
+	for _, hotelID := range p.hotelIDs() {
+		hotelRooms, err := p.getHousekeepingRooms(ctx, hotelID, "")
+		if err != nil {
+			p.log.Error(err)
+			return rooms, err
+		}
+		reservations, err := p.getInHouseReservations(ctx, hotelID, "")
+		if err != nil {
+			p.log.Error(err)
+			return rooms, err
+		}
+		for _, hotelRoom := range hotelRooms {
+			var guest *hotel.Guest
+			if reservation, ok := reservations[hotelRoom.RoomID]; ok {
+				guest = toHotelGuest(reservation)
+			}
+			rooms = append(rooms, p.toHotelRoom(hotelID, hotelRoom, guest))
+		}
+	}
+
+	if len(rooms) == 0 {
+		errMsg := errors.New("no rooms found")
+		return rooms, &hotel.DetailedError{Msg: errMsg, Details: "success, but no rooms found"}
+	}
+	p.log.Debugf("Amount of rooms: %d", len(rooms))
+	return rooms, nil
+}
+
+// GetRoom returns housekeeping status of the room with extension roomNumber with its in-house guest
+func (p *Opera) GetRoom(ctx context.Context, roomNumber string, mapFileName string) (hotel.Room, error) {
+	p.log.Infof("get info about room %s", roomNumber)
+
+	extension, hotelID, err := p.searchExtension(roomNumber)
+	if err != nil {
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+
+	hotelRooms, err := p.getHousekeepingRooms(ctx, hotelID, extension.HospitalityRoomID)
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+	var hotelRoom *HousekeepingRoom
+	for i := range hotelRooms {
+		if hotelRooms[i].RoomID == extension.HospitalityRoomID {
+			hotelRoom = &hotelRooms[i]
+			break
+		}
+	}
+	if hotelRoom == nil {
+		errMsg := fmt.Sprintf("room %s not found in hotel %s", extension.HospitalityRoomID, hotelID)
+		p.log.Error(errMsg)
+		return hotel.Room{PhoneNumber: roomNumber, RoomID: extension.HospitalityRoomID}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	reservations, err := p.getInHouseReservations(ctx, hotelID, extension.HospitalityRoomID)
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Room{PhoneNumber: roomNumber}, err
+	}
+	var guest *hotel.Guest
+	if reservation, ok := reservations[extension.HospitalityRoomID]; ok {
+		guest = toHotelGuest(reservation)
+	}
+	return p.toHotelRoom(hotelID, *hotelRoom, guest), nil
+}
+
+// GetRoomGuest returns the guest of the in-house reservation of the room with extension roomNumber
+func (p *Opera) GetRoomGuest(ctx context.Context, roomNumber string) (hotel.Guest, error) {
+	p.log.Debugf("get in-house guest for room %s", roomNumber)
+
+	extension, hotelID, err := p.searchExtension(roomNumber)
+	if err != nil {
+		return hotel.Guest{}, err
+	}
+
+	reservations, err := p.getInHouseReservations(ctx, hotelID, extension.HospitalityRoomID)
+	if err != nil {
+		p.log.Error(err)
+		return hotel.Guest{}, err
+	}
+	reservation, ok := reservations[extension.HospitalityRoomID]
+	if !ok {
+		errMsg := fmt.Sprintf("no in-house reservation found for room %s", roomNumber)
+		p.log.Debugf(errMsg)
+		return hotel.Guest{}, hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+	return *toHotelGuest(reservation), nil
+}
+
+// UpdateRoom sets the housekeeping status of the room: clean, dirty, inspected, pickup or ooo (out of order)
+func (p *Opera) UpdateRoom(ctx context.Context, roomExtensionNumber, housekeepingStatus, housekeeperName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoom %s to %s for %s", roomExtensionNumber, housekeepingStatus, housekeeperName)
+
+	roomCondition := strings.ToLower(housekeepingStatus)
+	if !hotel.IsRoomConditionValid(roomCondition, roomStatuses) {
+		errMsg := fmt.Sprintf("room condition %s is not valid", housekeepingStatus)
+		p.log.Error(errMsg)
+		return "", hotel.NewError(hotel.ErrValidation, errors.New(errMsg))
+	}
+
+	reason := ""
+	if roomStatuses[roomCondition] == roomStatusOutOfOrder {
+		reason = fmt.Sprintf("%s (%s)", defaultRoomBlockReason, housekeeperName)
+	}
+	err = p.setRoomStatus(ctx, roomExtensionNumber, roomStatuses[roomCondition], reason)
+	if err != nil {
+		return msg, err
+	}
+
+	msg = fmt.Sprintf("Finish UpdateRoom successfully updated room %s to %s", roomExtensionNumber, housekeepingStatus)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// UpdateRoomDoNotDisturb is not supported: OHIP has no "Do Not Disturb" housekeeping status
+func (p *Opera) UpdateRoomDoNotDisturb(ctx context.Context, roomExtensionNumber string, doNotDisturb bool, housekeeperName string) (msg string, err error) {
+	errMsg := "do not disturb is not supported by opera"
+	p.log.Error(errMsg)
+	return "", errors.New(errMsg)
+}
+
+// UpdateRoomBlock sets the room out of order (blocked=true) or returns it to inventory as dirty (blocked=false)
+func (p *Opera) UpdateRoomBlock(ctx context.Context, roomExtensionNumber string, blocked bool, reason, staffName string) (msg string, err error) {
+	p.log.Debugf("Start UpdateRoomBlock %s to %t (%s) for %s", roomExtensionNumber, blocked, reason, staffName)
+
+	status := roomStatusAfterBlock
+	if blocked {
+		status = roomStatusOutOfOrder
+		if reason == "" {
+			reason = defaultRoomBlockReason
+		}
+		if staffName != "" {
+			reason = fmt.Sprintf("%s (%s)", reason, staffName)
+		}
+	}
+	err = p.setRoomStatus(ctx, roomExtensionNumber, status, reason)
+	if err != nil {
+		return msg, err
+	}
+
+	action := "unblocked"
+	if blocked {
+		action = "blocked"
+	}
+	msg = fmt.Sprintf("Finish UpdateRoomBlock successfully %s room %s", action, roomExtensionNumber)
+	p.log.Debugf(msg)
+	return msg, nil
+}
+
+// PostCharge is not supported yet: OPERA charges require transaction codes, which are not mapped to the item catalog
+func (p *Opera) PostCharge(ctx context.Context, roomExtensionNumber, itemCode string, quantity int, housekeeperName string) (msg string, err error) {
+	errMsg := "charges are not supported by opera"
+	p.log.Error(errMsg)
+	return "", errors.New(errMsg)
+}
+
+// HandleOAuthCallback does nothing. OHIP tokens are requested with integration user or client credentials
+func (p *Opera) HandleOAuthCallback(ctx context.Context, state, code string) (err error) {
+	p.log.Debugf("opera doesn't use interactive login. OAuth callback is ignored")
+	return nil
+}
+
+// HandleInitialLogin does nothing. OHIP tokens are requested with integration user or client credentials, so empty url is returned
+func (p *Opera) HandleInitialLogin(ctx context.Context) (url string, err error) {
+	p.log.Debugf("opera doesn't use interactive login. Login is not required")
+	return "", nil
+}
+
+func (p *Opera) Close() error {
+	err := p.storeClient.Close()
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to close secret store: %s", err.Error())
+		p.log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// setRoomStatus sets OPERA housekeeping status of the room with extension roomExtensionNumber. reason is required by OPERA for out of order status
+func (p *Opera) setRoomStatus(ctx context.Context, roomExtensionNumber, status, reason string) error {
+	extension, hotelID, err := p.searchExtension(roomExtensionNumber)
+	if err != nil {
+		return err
+	}
+
+	roomStatus := map[string]interface{}{
+		"housekeepingRoomStatus": status,
+	}
+	if status == roomStatusOutOfOrder || status == roomStatusOutOfService {
+		roomStatus["returnStatus"] = roomStatusAfterBlock
+		roomStatus["reasonDescription"] = reason
+	}
+	request := map[string]interface{}{
+		"housekeepingRoomStatus": roomStatus,
+	}
+	path := fmt.Sprintf("/hsk/v1/hotels/%s/rooms/%s/housekeepingStatus", url.PathEscape(hotelID), url.PathEscape(extension.HospitalityRoomID))
+	err = p.do(ctx, http.MethodPut, path, hotelID, nil, request, nil)
+	if err != nil {
+		p.log.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (p *Opera) toHotelRoom(hotelID string, hotelRoom HousekeepingRoom, guest *hotel.Guest) hotel.Room {
+	status := hotelRoom.Housekeeping.HousekeepingRoomStatus.HousekeepingRoomStatus
+	room := hotel.Room{
+		RoomID:          hotelRoom.RoomID,
+		RoomName:        hotelRoom.RoomID,
+		RoomDescription: hotelRoom.Description,
+		RoomTypeName:    hotelRoom.RoomType.RoomType,
+		PropertyID:      hotelID,
+		RoomCondition:   roomCondition(status),
+		RoomBlocked:     status == roomStatusOutOfOrder || status == roomStatusOutOfService,
+		RoomOccupied:    hotelRoom.Housekeeping.FrontOfficeStatus == "Occupied" || guest != nil,
+		Guest:           guest,
+	}
+	if extension, ok := p.configMap.SearchExtensionByRoomID(hotelRoom.RoomID, ""); ok {
+		room.PhoneNumber = extension.RoomExtension
+	}
+	return room
+}
+
+// roomCondition converts OPERA housekeeping room status to the room condition dialed from the room phone
+func roomCondition(status string) string {
+	for condition, operaStatus := range roomStatuses {
+		if operaStatus == status {
+			return condition
+		}
+	}
+	return strings.ToLower(status)
+}
+
+func toHotelGuest(reservation ReservationInfo) *hotel.Guest {
+	return &hotel.Guest{
+		ReservationID: reservation.reservationID(),
+		FirstName:     reservation.ReservationGuest.GivenName,
+		LastName:      reservation.ReservationGuest.Surname,
+		Email:         reservation.ReservationGuest.Email,
+	}
+}
+
+// searchExtension returns the extension with roomExtensionNumber and its hotel code
+func (p *Opera) searchExtension(roomExtensionNumber string) (configuration.Extension, string, error) {
+	extension, err := hotel.SearchExtension(p.log, p.configMap, roomExtensionNumber)
+	if err != nil {
+		return extension, "", err
+	}
+	return extension, p.extensionHotelID(extension), nil
+}
+
+func (p *Opera) extensionHotelID(extension configuration.Extension) string {
+	if extension.HospitalityPropertyID != "" {
+		return extension.HospitalityPropertyID
+	}
+	return p.hotelID
+}
+
+// hotelIDs returns unique hotel codes of the extension map. OPERA_HOTEL_ID is used if the map is empty
+func (p *Opera) hotelIDs() (hotelIDs []string) {
+	seen := make(map[string]bool)
+	for _, extension := range p.configMap.ExtensionMap {
+		hotelID := p.extensionHotelID(extension)
+		if seen[hotelID] {
+			continue
+		}
+		seen[hotelID] = true
+		hotelIDs = append(hotelIDs, hotelID)
+	}
+	if len(hotelIDs) == 0 {
+		hotelIDs = append(hotelIDs, p.hotelID)
+	}
+	return hotelIDs
+}
+
+// getHousekeepingRooms returns housekeeping overview of the room roomID (all rooms of the hotel if roomID is empty) from all pages
+func (p *Opera) getHousekeepingRooms(ctx context.Context, hotelID, roomID string) (rooms []HousekeepingRoom, err error) {
+	for offset := 0; ; offset += apiPageSize {
+		query := pageQuery(offset)
+		if roomID != "" {
+			query.Set("roomId", roomID)
+		}
+		respBody := &ResponseHousekeepingOverview{}
+		err = p.do(ctx, http.MethodGet, fmt.Sprintf("/hsk/v1/hotels/%s/housekeepingOverview", url.PathEscape(hotelID)), hotelID, query, nil, respBody)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, respBody.HousekeepingRoomInfo.HousekeepingRooms.Room...)
+		if !respBody.HousekeepingRoomInfo.HasMore {
+			return rooms, nil
+		}
+	}
+}
+
+// getInHouseReservations returns in-house reservations of the room roomID (all rooms of the hotel if roomID is empty).
+// Result is a map roomID -> reservation
+func (p *Opera) getInHouseReservations(ctx context.Context, hotelID, roomID string) (reservations map[string]ReservationInfo, err error) {
+	reservations = make(map[string]ReservationInfo)
+	for offset := 0; ; offset += apiPageSize {
+		query := pageQuery(offset)
+		query.Set("reservationStatuses", reservationStatusInHouse)
+		if roomID != "" {
+			query.Set("roomId", roomID)
+		}
+		respBody := &ResponseGetReservations{}
+		err = p.do(ctx, http.MethodGet, fmt.Sprintf("/rsv/v1/hotels/%s/reservations", url.PathEscape(hotelID)), hotelID, query, nil, respBody)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range respBody.Reservations.ReservationInfo {
+			if reservation.RoomStay.RoomID == "" {
+				continue
+			}
+			//the first reservation wins if a room has several in-house reservations (shares)
+			if _, exists := reservations[reservation.RoomStay.RoomID]; !exists {
+				reservations[reservation.RoomStay.RoomID] = reservation
+			}
+		}
+		if !respBody.Reservations.HasMore {
+			return reservations, nil
+		}
+	}
+}
+
+func pageQuery(offset int) url.Values {
+	query := url.Values{}
+	query.Set("limit", fmt.Sprintf("%d", apiPageSize))
+	query.Set("offset", fmt.Sprintf("%d", offset))
+	return query
+}
+
+// do sends request to OHIP path of hotelID and decodes the reply to response. request and response can be nil.
+// If OHIP rejects the access token (401), a new token is requested and the request is sent once again
+func (p *Opera) do(ctx context.Context, method, path, hotelID string, query url.Values, request interface{}, response interface{}) error {
+	apiUrl := p.gatewayURL + path
+	if len(query) > 0 {
+		apiUrl += "?" + query.Encode()
+	}
+
+	var jsonBody []byte
+	if request != nil {
+		var err error
+		jsonBody, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.api.Do(ctx, method+" "+path, func(accessToken string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, apiUrl, bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		req.Header.Add("x-app-key", p.appKey)
+		req.Header.Add("x-hotelid", hotelID)
+		req.Header.Add("Accept", "application/json")
+		if request != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		return req, nil
+	}, response)
+}
+
+// newAPIClient returns the client of OHIP REST API. The access token is requested from the OHIP gateway
+func (p *Opera) newAPIClient() *oauthclient.Client {
+	return &oauthclient.Client{
+		Name:         "opera",
+		HTTPClient:   p.httpClient,
+		Store:        p.storeClient,
+		Log:          p.log,
+		RequestToken: p.requestAccessToken,
+		ErrorMessage: errorMessage,
+	}
+}
+
+// requestAccessToken requests a new token from the OHIP gateway: password grant for the integration user or client credentials grant
+func (p *Opera) requestAccessToken(ctx context.Context) (*oauth2.Token, error) {
+	form := url.Values{}
+	if p.username != "" {
+		form.Set("grant_type", "password")
+		form.Set("username", p.username)
+		form.Set("password", p.password)
+	} else {
+		form.Set("grant_type", "client_credentials")
+		form.Set("scope", p.scope)
+	}
+
+	tokenUrl := p.gatewayURL + "/oauth/v1/tokens"
+	p.log.Debugf("Requesting new access token from %s", tokenUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.clientID, p.clientSecret)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("x-app-key", p.appKey)
+	if p.enterpriseID != "" {
+		req.Header.Add("enterpriseId", p.enterpriseID)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &oauth2.RetrieveError{Response: resp, Body: body}
+	}
+	respBody := &ResponseToken{}
+	err = json.NewDecoder(resp.Body).Decode(respBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token reply: %w", err)
+	}
+	token := &oauth2.Token{AccessToken: respBody.AccessToken, TokenType: respBody.TokenType}
+	if respBody.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(respBody.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// errorMessage returns the message of ResponseError reply
+func errorMessage(body []byte) string {
+	respError := ResponseError{}
+	if json.Unmarshal(body, &respError) != nil {
+		return ""
+	}
+	return respError.message()
+}
@@ -0,0 +1,302 @@
+package opera
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/hoteltest"
+	"github.com/olegromanchuk/hotelito/internal/secretstest"
+	"github.com/olegromanchuk/hotelito/pkg/hotel"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// newOhipServer imitates OHIP gateway: token endpoint and REST API. Every request must have the application key
+func newOhipServer(t *testing.T, replies map[string]string) *hoteltest.Server {
+	server := hoteltest.NewServer(t, replies)
+	server.TokenPath = "/oauth/v1/tokens"
+	server.AccessToken = "access-token"
+	server.UnauthorizedReply = `{"title":"Unauthorized","detail":"Invalid token"}`
+	server.Intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		assert.Equal(t, "app-key", r.Header.Get("x-app-key"))
+		return false
+	}
+	return server
+}
+
+func newTestOpera(server *hoteltest.Server, store *secretstest.MockSecretsStore) *Opera {
+	client := &Opera{
+		httpClient:   server.Client(),
+		storeClient:  store,
+		log:          logrus.New(),
+		gatewayURL:   server.URL,
+		appKey:       "app-key",
+		hotelID:      "HOTEL1",
+		clientID:     "client-id",
+		clientSecret: "client-secret",
+		username:     "integration-user",
+		password:     "secret",
+		configMap:    &configuration.ConfigMap{ExtensionMap: hoteltest.Extensions("", "101", "102")},
+	}
+	client.api = client.newAPIClient()
+	client.api.SetAccessToken("access-token")
+	return client
+}
+
+const (
+	replyHousekeepingOverview = `{"housekeepingRoomInfo":{"housekeepingRooms":{"room":[` +
+		`{"roomId":"101","roomType":{"roomType":"KNG"},"description":"King room","housekeeping":{"housekeepingRoomStatus":{"housekeepingRoomStatus":"Inspected"},"frontOfficeStatus":"Occupied"}},` +
+		`{"roomId":"102","roomType":{"roomType":"QN"},"housekeeping":{"housekeepingRoomStatus":{"housekeepingRoomStatus":"OutOfOrder"},"frontOfficeStatus":"Vacant"}}` +
+		`]},"hasMore":false}}`
+	replyReservations = `{"reservations":{"reservationInfo":[{"reservationIdList":[{"id":"CONF-1","type":"Confirmation"},{"id":"123456","type":"Reservation"}],"roomStay":{"roomId":"101"},"reservationGuest":{"givenName":"Jane","surname":"Smith","email":"jane.smith@example.com"},"reservationStatus":"InHouse"}],"hasMore":false}}`
+)
+
+func TestOpera_GetRooms(t *testing.T) {
+	server := newOhipServer(t, map[string]string{
+		"GET /hsk/v1/hotels/HOTEL1/housekeepingOverview": replyHousekeepingOverview,
+		"GET /rsv/v1/hotels/HOTEL1/reservations":         replyReservations,
+	})
+
+	rooms, err := newTestOpera(server, nil).GetRooms(context.Background())
+	require.NoError(t, err)
+	guest := hoteltest.Guest("123456")
+	assert.Equal(t, []hotel.Room{
+		{
+			RoomID:          "101",
+			RoomName:        "101",
+			RoomDescription: "King room",
+			RoomTypeName:    "KNG",
+			PropertyID:      "HOTEL1",
+			PhoneNumber:     "1001",
+			RoomCondition:   "inspected",
+			RoomOccupied:    true,
+			Guest:           &guest,
+		},
+		{
+			RoomID:        "102",
+			RoomName:      "102",
+			RoomTypeName:  "QN",
+			PropertyID:    "HOTEL1",
+			PhoneNumber:   "1002",
+			RoomCondition: "ooo",
+			RoomBlocked:   true,
+		},
+	}, rooms)
+	require.Len(t, server.Requests, 2)
+	assert.Equal(t, "HOTEL1", server.Requests[0].Header.Get("x-hotelid"))
+	assert.Equal(t, "InHouse", server.Requests[1].Query["reservationStatuses"])
+}
+
+func TestOpera_GetRoom(t *testing.T) {
+	server := newOhipServer(t, map[string]string{
+		"GET /hsk/v1/hotels/HOTEL1/housekeepingOverview": `{"housekeepingRoomInfo":{"housekeepingRooms":{"room":[{"roomId":"102","housekeeping":{"housekeepingRoomStatus":{"housekeepingRoomStatus":"Pickup"},"frontOfficeStatus":"Vacant"}}]},"hasMore":false}}`,
+		"GET /rsv/v1/hotels/HOTEL1/reservations":         `{"reservations":{"reservationInfo":[],"hasMore":false}}`,
+	})
+	client := newTestOpera(server, nil)
+
+	room, err := client.GetRoom(context.Background(), "1002", "")
+	require.NoError(t, err)
+	assert.Equal(t, hotel.Room{RoomID: "102", RoomName: "102", PropertyID: "HOTEL1", PhoneNumber: "1002", RoomCondition: "pickup"}, room)
+	assert.Equal(t, "102", server.Requests[0].Query["roomId"])
+	assert.Equal(t, "102", server.Requests[1].Query["roomId"])
+
+	_, err = client.GetRoom(context.Background(), "1001", "")
+	assert.EqualError(t, err, "room 101 not found in hotel HOTEL1")
+	assert.ErrorIs(t, err, hotel.ErrValidation)
+}
+
+func TestOpera_GetRoomGuest(t *testing.T) {
+	server := newOhipServer(t, map[string]string{
+		"GET /rsv/v1/hotels/HOTEL1/reservations": replyReservations,
+	})
+
+	hoteltest.CheckGetRoomGuest(t, newTestOpera(server, nil), "123456")
+}
+
+func TestOpera_UpdateRoom(t *testing.T) {
+	tests := []struct {
+		name           string
+		roomCondition  string
+		expectedStatus string
+	}{
+		{"clean", "clean", "Clean"},
+		{"dirty", "dirty", "Dirty"},
+		{"inspected", "inspected", "Inspected"},
+		{"pickup", "Pickup", "Pickup"},
+		{"out of order", "ooo", "OutOfOrder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newOhipServer(t, map[string]string{
+				"PUT /hsk/v1/hotels/HOTEL1/rooms/101/housekeepingStatus": `{}`,
+			})
+
+			request := hoteltest.CheckUpdateRoom(t, newTestOpera(server, nil), server, tt.roomCondition, "sparkling")
+			var body map[string]map[string]string
+			require.NoError(t, json.Unmarshal([]byte(request.Body), &body))
+			assert.Equal(t, tt.expectedStatus, body["housekeepingRoomStatus"]["housekeepingRoomStatus"])
+			assert.Equal(t, "HOTEL1", request.Header.Get("x-hotelid"))
+		})
+	}
+}
+
+func TestOpera_UpdateRoomBlock(t *testing.T) {
+	server := newOhipServer(t, map[string]string{
+		"PUT /hsk/v1/hotels/HOTEL1/rooms/101/housekeepingStatus": `{}`,
+	})
+
+	hoteltest.CheckUpdateRoomBlock(t, newTestOpera(server, nil),
+		"Finish UpdateRoomBlock successfully blocked room 1001",
+		"Finish UpdateRoomBlock successfully unblocked room 1001")
+	require.Len(t, server.Requests, 2)
+	assert.JSONEq(t, `{"housekeepingRoomStatus":{"housekeepingRoomStatus":"OutOfOrder","returnStatus":"Dirty","reasonDescription":"AC repair (Engineer)"}}`, server.Requests[0].Body)
+	assert.JSONEq(t, `{"housekeepingRoomStatus":{"housekeepingRoomStatus":"Dirty"}}`, server.Requests[1].Body)
+}
+
+func TestOpera_AccessToken(t *testing.T) {
+	const replyRoom = `{"housekeepingRoomInfo":{"housekeepingRooms":{"room":[{"roomId":"101"}]},"hasMore":false}}`
+	const replyNoReservations = `{"reservations":{"reservationInfo":[],"hasMore":false}}`
+
+	t.Run("integration user token is requested and saved if store is empty", func(t *testing.T) {
+		server := newOhipServer(t, map[string]string{
+			"GET /hsk/v1/hotels/HOTEL1/housekeepingOverview": replyRoom,
+			"GET /rsv/v1/hotels/HOTEL1/reservations":         replyNoReservations,
+		})
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveProviderToken", "opera").Return(secrets.Token{}, errors.New("not found"))
+		store.On("StoreProviderToken", "opera", mock.MatchedBy(func(token secrets.Token) bool {
+			return token.AccessToken == "access-token" && token.TokenType == "Bearer" && !token.Expiry.IsZero()
+		})).Return(nil)
+		client := newTestOpera(server, store)
+		client.api.SetAccessToken("")
+
+		_, err := client.GetRoom(context.Background(), "1001", "")
+		require.NoError(t, err)
+		require.Len(t, server.TokenRequests, 1)
+		tokenRequest := server.TokenRequests[0]
+		clientID, clientSecret, ok := (&http.Request{Header: tokenRequest.Header}).BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "client-id", clientID)
+		assert.Equal(t, "client-secret", clientSecret)
+		assert.Equal(t, "password", tokenRequest.Form["grant_type"])
+		assert.Equal(t, "integration-user", tokenRequest.Form["username"])
+		assert.Equal(t, "secret", tokenRequest.Form["password"])
+		store.AssertExpectations(t)
+	})
+
+	t.Run("client credentials are used without integration user", func(t *testing.T) {
+		server := newOhipServer(t, map[string]string{
+			"GET /hsk/v1/hotels/HOTEL1/housekeepingOverview": replyRoom,
+			"GET /rsv/v1/hotels/HOTEL1/reservations":         replyNoReservations,
+		})
+		store := new(secretstest.MockSecretsStore)
+		store.On("StoreProviderToken", "opera", mock.Anything).Return(nil)
+		client := newTestOpera(server, store)
+		client.api.SetAccessToken("expired-token")
+		client.username = ""
+		client.enterpriseID = "ENTERPRISE"
+		client.scope = defaultClientScope
+
+		_, err := client.GetRoom(context.Background(), "1001", "")
+		require.NoError(t, err)
+		require.Len(t, server.TokenRequests, 1)
+		tokenRequest := server.TokenRequests[0]
+		assert.Equal(t, "client_credentials", tokenRequest.Form["grant_type"])
+		assert.Equal(t, defaultClientScope, tokenRequest.Form["scope"])
+		assert.Equal(t, "ENTERPRISE", tokenRequest.Header.Get("enterpriseId"))
+		accessToken, err := client.api.AccessToken(context.Background(), false)
+		require.NoError(t, err)
+		assert.Equal(t, "access-token", accessToken)
+	})
+
+	t.Run("token is rejected twice", func(t *testing.T) {
+		server := newOhipServer(t, map[string]string{})
+		server.AccessToken = "other-token"
+		store := new(secretstest.MockSecretsStore)
+		store.On("StoreProviderToken", "opera", mock.Anything).Return(nil)
+
+		_, err := newTestOpera(server, store).GetRoomGuest(context.Background(), "1001")
+		assert.EqualError(t, err, "opera GET /rsv/v1/hotels/HOTEL1/reservations failed with status 401: Invalid token")
+		var detailedError *hotel.DetailedError
+		assert.True(t, errors.As(err, &detailedError))
+		assert.ErrorIs(t, err, hotel.ErrAuthorization)
+		assert.Len(t, server.TokenRequests, 1)
+	})
+}
+
+func TestOpera_NotSupported(t *testing.T) {
+	hoteltest.CheckNotSupported(t, &Opera{log: logrus.New()}, "do not disturb is not supported by opera", "charges are not supported by opera")
+}
+
+func TestNew(t *testing.T) {
+	log := logrus.New()
+	configMap := &configuration.ConfigMap{}
+
+	t.Run("settings from store", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", "OPERA_GATEWAY_URL").Return("https://gateway.example.com/", nil)
+		store.On("RetrieveVar", "OPERA_APP_KEY").Return("app-key", nil)
+		store.On("RetrieveVar", "OPERA_CLIENT_ID").Return("client-id", nil)
+		store.On("RetrieveVar", "OPERA_CLIENT_SECRET").Return("client-secret", nil)
+		store.On("RetrieveVar", "OPERA_HOTEL_ID").Return("HOTEL1", nil)
+		store.On("RetrieveVar", "OPERA_ENTERPRISE_ID").Return("ENTERPRISE", nil)
+		store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		client, err := New(log, store, configMap)
+		require.NoError(t, err)
+		assert.Equal(t, "https://gateway.example.com", client.gatewayURL)
+		assert.Equal(t, "app-key", client.appKey)
+		assert.Equal(t, "HOTEL1", client.hotelID)
+		assert.Equal(t, "ENTERPRISE", client.enterpriseID)
+		assert.Equal(t, defaultClientScope, client.scope)
+	})
+
+	t.Run("settings from environment", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", mock.Anything).Return("", errors.New("not found"))
+		for name, value := range map[string]string{
+			"OPERA_GATEWAY_URL":   "https://gateway.example.com",
+			"OPERA_APP_KEY":       "env-app-key",
+			"OPERA_CLIENT_ID":     "env-client-id",
+			"OPERA_CLIENT_SECRET": "env-client-secret",
+			"OPERA_HOTEL_ID":      "HOTEL2",
+			"OPERA_USERNAME":      "env-user",
+			"OPERA_PASSWORD":      "env-password",
+		} {
+			os.Setenv(name, value)
+			defer os.Unsetenv(name)
+		}
+
+		client, err := New(log, store, configMap)
+		require.NoError(t, err)
+		assert.Equal(t, "env-app-key", client.appKey)
+		assert.Equal(t, "env-user", client.username)
+		assert.Equal(t, "env-password", client.password)
+	})
+
+	t.Run("settings are missing", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		_, err := New(log, store, configMap)
+		assert.EqualError(t, err, "not all required env variables are set. Missed one of: OPERA_GATEWAY_URL, OPERA_APP_KEY, OPERA_CLIENT_ID, OPERA_CLIENT_SECRET, OPERA_HOTEL_ID")
+	})
+
+	t.Run("grant credentials are missing", func(t *testing.T) {
+		store := new(secretstest.MockSecretsStore)
+		for _, name := range []string{"OPERA_GATEWAY_URL", "OPERA_APP_KEY", "OPERA_CLIENT_ID", "OPERA_CLIENT_SECRET", "OPERA_HOTEL_ID"} {
+			store.On("RetrieveVar", name).Return("value", nil)
+		}
+		store.On("RetrieveVar", mock.Anything).Return("", nil)
+
+		_, err := New(log, store, configMap)
+		assert.EqualError(t, err, "OPERA_USERNAME/OPERA_PASSWORD (integration user) or OPERA_ENTERPRISE_ID (client credentials) must be set")
+	})
+}
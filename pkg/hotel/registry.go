@@ -0,0 +1,44 @@
+package hotel
+
+import (
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/registry"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultProviderName is used when HOTEL_PROVIDER is not set
+const DefaultProviderName = "cloudbeds"
+
+// Provider is a hospitality provider that holds resources (secret store) and must be closed
+type Provider interface {
+	HospitalityProvider
+	Close() error
+}
+
+// ProviderFactory creates a hospitality provider. Providers register their factory with Register in init()
+type ProviderFactory func(log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (Provider, error)
+
+var providers = registry.New[ProviderFactory]("hospitality provider")
+
+// Register makes the hospitality provider available by name (HOTEL_PROVIDER). It panics if name is registered twice
+func Register(name string, factory ProviderFactory) {
+	providers.Register(name, factory)
+}
+
+// NewProvider creates the hospitality provider registered under name. Empty name means DefaultProviderName
+func NewProvider(name string, log *logrus.Logger, secretStore secrets.SecretsStore, configMap *configuration.ConfigMap) (Provider, error) {
+	if name == "" {
+		name = DefaultProviderName
+	}
+	factory, err := providers.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return factory(log, secretStore, configMap)
+}
+
+// Providers returns sorted names of the registered hospitality providers
+func Providers() []string {
+	return providers.Names()
+}
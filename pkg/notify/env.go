@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewFromEnv creates the notifier with the senders configured in the environment:
+//
+//	NOTIFY_SMTP_ADDR (host:port), NOTIFY_SMTP_USERNAME, NOTIFY_SMTP_PASSWORD, NOTIFY_SMTP_FROM, NOTIFY_SMTP_TO (comma separated)
+//	NOTIFY_SLACK_WEBHOOK_URL, NOTIFY_TEAMS_WEBHOOK_URL
+//	NOTIFY_WEBHOOK_URL, NOTIFY_WEBHOOK_TOKEN
+//	NOTIFY_DEDUPE_WINDOW (e.g. 1h), NOTIFY_RATE_LIMIT (alerts per hour)
+//
+// Without senders the alerts are only logged
+func NewFromEnv(log *logrus.Logger) (*Notifier, error) {
+	var senders []Sender
+
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		from := os.Getenv("NOTIFY_SMTP_FROM")
+		to := splitList(os.Getenv("NOTIFY_SMTP_TO"))
+		if from == "" || len(to) == 0 {
+			return nil, fmt.Errorf("NOTIFY_SMTP_FROM and NOTIFY_SMTP_TO are required with NOTIFY_SMTP_ADDR")
+		}
+		senders = append(senders, NewSMTPSender(addr, os.Getenv("NOTIFY_SMTP_USERNAME"), os.Getenv("NOTIFY_SMTP_PASSWORD"), from, to))
+	}
+	if url := os.Getenv("NOTIFY_SLACK_WEBHOOK_URL"); url != "" {
+		senders = append(senders, NewSlackSender(url))
+	}
+	if url := os.Getenv("NOTIFY_TEAMS_WEBHOOK_URL"); url != "" {
+		senders = append(senders, NewTeamsSender(url))
+	}
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		senders = append(senders, NewWebhookSender(url, os.Getenv("NOTIFY_WEBHOOK_TOKEN")))
+	}
+
+	n := New(log, senders...)
+
+	if dedupeWindow := os.Getenv("NOTIFY_DEDUPE_WINDOW"); dedupeWindow != "" {
+		window, err := time.ParseDuration(dedupeWindow)
+		if err != nil || window < 0 {
+			return nil, fmt.Errorf("NOTIFY_DEDUPE_WINDOW has invalid value '%s'. Example: 1h", dedupeWindow)
+		}
+		n.DedupeWindow = window
+	}
+	if rateLimit := os.Getenv("NOTIFY_RATE_LIMIT"); rateLimit != "" {
+		limit, err := strconv.Atoi(rateLimit)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("NOTIFY_RATE_LIMIT has invalid value '%s'. Example: 10", rateLimit)
+		}
+		n.RateLimit = limit
+	}
+
+	log.Debugf("Alerts are sent via %d sender(s)", len(senders))
+	return n, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Kind is the reason of an alert. Alerts of the same kind and subject are de-duplicated
+type Kind string
+
+const (
+	// KindReloginRequired means the hospitality provider has no valid token: the admin must login again (/api/v1/login)
+	KindReloginRequired Kind = "relogin_required"
+	// KindUpdateFailures means several room updates in a row failed to reach the hospitality provider
+	KindUpdateFailures Kind = "update_failures"
+	// KindTokenRefreshFailed means the scheduled token refresh failed
+	KindTokenRefreshFailed Kind = "token_refresh_failed"
+)
+
+const (
+	// DefaultDedupeWindow is how long an alert with the same kind and subject is not repeated
+	DefaultDedupeWindow = time.Hour
+	// DefaultRateLimit is the max amount of alerts sent within DefaultRateWindow
+	DefaultRateLimit = 10
+	// DefaultRateWindow is the window of DefaultRateLimit
+	DefaultRateWindow = time.Hour
+)
+
+// Alert is a message for the admins
+type Alert struct {
+	Kind    Kind      `json:"kind"`
+	Subject string    `json:"subject"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Sender delivers alerts to one channel: email, chat or webhook
+type Sender interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Notifier sends alerts to all its senders. Repeated alerts are de-duplicated and the amount of alerts is rate limited,
+// so admins aren't spammed when the hospitality provider is down for hours. nil Notifier only logs the alerts
+type Notifier struct {
+	log     *logrus.Logger
+	senders []Sender
+	// DedupeWindow is how long an alert with the same kind and subject is not repeated
+	DedupeWindow time.Duration
+	// RateLimit is the max amount of alerts sent within RateWindow. Zero means no limit
+	RateLimit  int
+	RateWindow time.Duration
+	mu         sync.Mutex
+	lastSent   map[string]time.Time
+	sent       []time.Time
+	now        func() time.Time
+}
+
+func New(log *logrus.Logger, senders ...Sender) *Notifier {
+	return &Notifier{
+		log:          log,
+		senders:      senders,
+		DedupeWindow: DefaultDedupeWindow,
+		RateLimit:    DefaultRateLimit,
+		RateWindow:   DefaultRateWindow,
+		lastSent:     make(map[string]time.Time),
+		now:          time.Now,
+	}
+}
+
+// HasSenders reports whether alerts are delivered anywhere besides the log
+func (n *Notifier) HasSenders() bool {
+	return n != nil && len(n.senders) > 0
+}
+
+// Notify sends alert to all senders unless the same alert was sent within DedupeWindow or RateLimit is reached.
+// Returns the error of the failed senders. Skipped alerts are not errors
+func (n *Notifier) Notify(ctx context.Context, alert Alert) error {
+	if n == nil {
+		return nil
+	}
+	if alert.Time.IsZero() {
+		alert.Time = n.now()
+	}
+	n.log.Warnf("Alert %s: %s. %s", alert.Kind, alert.Subject, alert.Message)
+	if len(n.senders) == 0 {
+		return nil
+	}
+	if !n.allow(alert) {
+		return nil
+	}
+
+	failed := 0
+	var firstErr error
+	for _, sender := range n.senders {
+		err := sender.Send(ctx, alert)
+		if err != nil {
+			n.log.Errorf("failed to send alert %s: %s", alert.Kind, err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to send alert via %d of %d senders: %w", failed, len(n.senders), firstErr)
+	}
+	return nil
+}
+
+// allow records alert as sent if it is neither a duplicate nor over the rate limit
+func (n *Notifier) allow(alert Alert) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := string(alert.Kind) + "/" + alert.Subject
+	if last, ok := n.lastSent[key]; ok && alert.Time.Sub(last) < n.DedupeWindow {
+		n.log.Debugf("Alert %s was already sent at %s. Skipping", key, last.Format(time.RFC3339))
+		return false
+	}
+
+	//forget alerts older than RateWindow
+	recent := n.sent[:0]
+	for _, sentAt := range n.sent {
+		if alert.Time.Sub(sentAt) < n.RateWindow {
+			recent = append(recent, sentAt)
+		}
+	}
+	n.sent = recent
+	if n.RateLimit > 0 && len(n.sent) >= n.RateLimit {
+		n.log.Warnf("Alert %s is not sent: %d alerts were sent within %s", key, len(n.sent), n.RateWindow)
+		return false
+	}
+
+	n.lastSent[key] = alert.Time
+	n.sent = append(n.sent, alert.Time)
+	return true
+}
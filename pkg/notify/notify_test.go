@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	err    error
+	alerts []Alert
+}
+
+func (f *fakeSender) Send(ctx context.Context, alert Alert) error {
+	f.alerts = append(f.alerts, alert)
+	return f.err
+}
+
+func newTestNotifier(senders ...Sender) (*Notifier, *time.Time) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	now := time.Date(2023, 8, 2, 12, 0, 0, 0, time.UTC)
+	n := New(log, senders...)
+	n.now = func() time.Time { return now }
+	return n, &now
+}
+
+func TestNotifier_Dedupe(t *testing.T) {
+	sender := &fakeSender{}
+	n, now := newTestNotifier(sender)
+
+	alert := Alert{Kind: KindReloginRequired, Subject: "Cloudbeds login required", Message: "Login again: /api/v1/login"}
+	assert.NoError(t, n.Notify(context.Background(), alert))
+	assert.NoError(t, n.Notify(context.Background(), alert))
+	assert.Len(t, sender.alerts, 1)
+	assert.Equal(t, *now, sender.alerts[0].Time)
+
+	// another subject is not a duplicate
+	assert.NoError(t, n.Notify(context.Background(), Alert{Kind: KindReloginRequired, Subject: "Mews login required"}))
+	assert.Len(t, sender.alerts, 2)
+
+	// the alert is repeated after the window
+	*now = now.Add(DefaultDedupeWindow)
+	assert.NoError(t, n.Notify(context.Background(), alert))
+	assert.Len(t, sender.alerts, 3)
+}
+
+func TestNotifier_RateLimit(t *testing.T) {
+	sender := &fakeSender{}
+	n, now := newTestNotifier(sender)
+	n.RateLimit = 2
+
+	assert.NoError(t, n.Notify(context.Background(), Alert{Kind: KindUpdateFailures, Subject: "1"}))
+	assert.NoError(t, n.Notify(context.Background(), Alert{Kind: KindUpdateFailures, Subject: "2"}))
+	assert.NoError(t, n.Notify(context.Background(), Alert{Kind: KindUpdateFailures, Subject: "3"}))
+	assert.Len(t, sender.alerts, 2)
+
+	// the limit is per window
+	*now = now.Add(DefaultRateWindow)
+	assert.NoError(t, n.Notify(context.Background(), Alert{Kind: KindUpdateFailures, Subject: "3"}))
+	assert.Len(t, sender.alerts, 3)
+}
+
+func TestNotifier_SenderError(t *testing.T) {
+	sendErr := errors.New("smtp is down")
+	failing := &fakeSender{err: sendErr}
+	working := &fakeSender{}
+	n, _ := newTestNotifier(failing, working)
+
+	// other senders still get the alert
+	err := n.Notify(context.Background(), Alert{Kind: KindTokenRefreshFailed, Subject: "Token refresh failed"})
+	assert.ErrorIs(t, err, sendErr)
+	assert.Len(t, failing.alerts, 1)
+	assert.Len(t, working.alerts, 1)
+}
+
+func TestNotifier_Nil(t *testing.T) {
+	var n *Notifier
+	assert.NoError(t, n.Notify(context.Background(), Alert{Kind: KindReloginRequired}))
+	assert.False(t, n.HasSenders())
+}
+
+func TestNewFromEnv(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	t.Run("no senders", func(t *testing.T) {
+		n, err := NewFromEnv(log)
+		assert.NoError(t, err)
+		assert.False(t, n.HasSenders())
+	})
+
+	t.Run("all senders", func(t *testing.T) {
+		t.Setenv("NOTIFY_SMTP_ADDR", "smtp.example.com:587")
+		t.Setenv("NOTIFY_SMTP_FROM", "hotelito@example.com")
+		t.Setenv("NOTIFY_SMTP_TO", "admin@example.com, frontdesk@example.com")
+		t.Setenv("NOTIFY_SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/T/B/X")
+		t.Setenv("NOTIFY_TEAMS_WEBHOOK_URL", "https://example.webhook.office.com/webhookb2/X")
+		t.Setenv("NOTIFY_WEBHOOK_URL", "https://example.com/alerts")
+		t.Setenv("NOTIFY_DEDUPE_WINDOW", "30m")
+		t.Setenv("NOTIFY_RATE_LIMIT", "3")
+
+		n, err := NewFromEnv(log)
+		assert.NoError(t, err)
+		assert.Len(t, n.senders, 4)
+		assert.Equal(t, []string{"admin@example.com", "frontdesk@example.com"}, n.senders[0].(*SMTPSender).to)
+		assert.Equal(t, 30*time.Minute, n.DedupeWindow)
+		assert.Equal(t, 3, n.RateLimit)
+	})
+
+	t.Run("smtp without recipients", func(t *testing.T) {
+		t.Setenv("NOTIFY_SMTP_ADDR", "smtp.example.com:587")
+		_, err := NewFromEnv(log)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid rate limit", func(t *testing.T) {
+		t.Setenv("NOTIFY_RATE_LIMIT", "ten")
+		_, err := NewFromEnv(log)
+		assert.Error(t, err)
+	})
+}
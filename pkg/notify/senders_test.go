@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAlert = Alert{
+	Kind:    KindReloginRequired,
+	Subject: "Cloudbeds login required",
+	Message: "Login again: /api/v1/login",
+	Time:    time.Date(2023, 8, 2, 12, 0, 0, 0, time.UTC),
+}
+
+func TestWebhookSenders(t *testing.T) {
+	tests := []struct {
+		name          string
+		sender        func(url string) *WebhookSender
+		expectedBody  string
+		expectedToken string
+	}{
+		{"slack", NewSlackSender, `{"text":"*Cloudbeds login required*\nLogin again: /api/v1/login"}`, ""},
+		{"teams", NewTeamsSender, `{"text":"**Cloudbeds login required**\n\nLogin again: /api/v1/login"}`, ""},
+		{
+			"webhook",
+			func(url string) *WebhookSender { return NewWebhookSender(url, "secret") },
+			`{"kind":"relogin_required","subject":"Cloudbeds login required","message":"Login again: /api/v1/login","time":"2023-08-02T12:00:00Z"}`,
+			"Bearer secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			var authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				authorization = r.Header.Get("Authorization")
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			}))
+			defer server.Close()
+
+			err := tt.sender(server.URL).Send(context.Background(), testAlert)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedBody, string(body))
+			assert.Equal(t, tt.expectedToken, authorization)
+		})
+	}
+}
+
+func TestWebhookSender_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("no_service"))
+	}))
+	defer server.Close()
+
+	err := NewSlackSender(server.URL).Send(context.Background(), testAlert)
+	assert.EqualError(t, err, "alert webhook returned 404: no_service")
+}
+
+func TestSMTPSender(t *testing.T) {
+	sender := NewSMTPSender("smtp.example.com:587", "user", "password", "hotelito@example.com", []string{"admin@example.com"})
+
+	var sentAddr, sentFrom string
+	var sentTo []string
+	var sentAuth smtp.Auth
+	var sentMsg []byte
+	sender.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sentAddr, sentAuth, sentFrom, sentTo, sentMsg = addr, a, from, to, msg
+		return nil
+	}
+
+	assert.NoError(t, sender.Send(context.Background(), testAlert))
+	assert.Equal(t, "smtp.example.com:587", sentAddr)
+	assert.NotNil(t, sentAuth)
+	assert.Equal(t, "hotelito@example.com", sentFrom)
+	assert.Equal(t, []string{"admin@example.com"}, sentTo)
+	assert.True(t, strings.HasPrefix(string(sentMsg), "From: hotelito@example.com\r\nTo: admin@example.com\r\nSubject: [hotelito] Cloudbeds login required\r\n"))
+	assert.True(t, strings.HasSuffix(string(sentMsg), "\r\n\r\nLogin again: /api/v1/login\r\n"))
+
+	// without username the server is used without auth
+	sender = NewSMTPSender("localhost:25", "", "", "hotelito@example.com", []string{"admin@example.com"})
+	sender.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sentAuth = a
+		return errors.New("connection refused")
+	}
+	assert.EqualError(t, sender.Send(context.Background(), testAlert), "failed to send alert email: connection refused")
+	assert.Nil(t, sentAuth)
+}
+
+// the generic webhook payload is the alert, so receivers can decode it back
+func TestWebhookSender_Payload(t *testing.T) {
+	var received Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	assert.NoError(t, NewWebhookSender(server.URL, "").Send(context.Background(), testAlert))
+	assert.Equal(t, testAlert, received)
+}
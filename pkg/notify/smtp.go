@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPSender emails alerts through an SMTP server
+type SMTPSender struct {
+	addr     string // host:port
+	username string
+	password string
+	from     string
+	to       []string
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error // smtp.SendMail, replaced in tests
+}
+
+// NewSMTPSender emails alerts from "from" to "to" through addr (host:port). PLAIN auth is used if username is set
+func NewSMTPSender(addr, username, password, from string, to []string) *SMTPSender {
+	return &SMTPSender{
+		addr:     addr,
+		username: username,
+		password: password,
+		from:     from,
+		to:       to,
+		sendMail: smtp.SendMail,
+	}
+}
+
+// Send emails alert. net/smtp doesn't support ctx: the send is not cancelled
+func (s *SMTPSender) Send(ctx context.Context, alert Alert) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address %s: %w", s.addr, err)
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	err := s.sendMail(s.addr, auth, s.from, s.to, s.message(alert))
+	if err != nil {
+		return fmt.Errorf("failed to send alert email: %w", err)
+	}
+	return nil
+}
+
+func (s *SMTPSender) message(alert Alert) []byte {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: [hotelito] %s\r\n", alert.Subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(alert.Message)
+	msg.WriteString("\r\n")
+	return []byte(msg.String())
+}
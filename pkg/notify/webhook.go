@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookSender posts alerts as JSON to an HTTP endpoint. Payload formats the request body for the endpoint:
+// Slack and Teams incoming webhooks expect {"text": ...}, the generic webhook gets the Alert itself
+type WebhookSender struct {
+	url        string
+	token      string
+	payload    func(alert Alert) interface{}
+	httpClient *http.Client
+}
+
+// NewSlackSender sends alerts to a Slack incoming webhook
+func NewSlackSender(url string) *WebhookSender {
+	return newWebhookSender(url, "", func(alert Alert) interface{} {
+		return map[string]string{"text": fmt.Sprintf("*%s*\n%s", alert.Subject, alert.Message)}
+	})
+}
+
+// NewTeamsSender sends alerts to a Microsoft Teams incoming webhook
+func NewTeamsSender(url string) *WebhookSender {
+	return newWebhookSender(url, "", func(alert Alert) interface{} {
+		return map[string]string{"text": fmt.Sprintf("**%s**\n\n%s", alert.Subject, alert.Message)}
+	})
+}
+
+// NewWebhookSender posts alerts as JSON to url. If token is set, it is sent as a Bearer token
+func NewWebhookSender(url, token string) *WebhookSender {
+	return newWebhookSender(url, token, func(alert Alert) interface{} {
+		return alert
+	})
+}
+
+func newWebhookSender(url, token string, payload func(alert Alert) interface{}) *WebhookSender {
+	return &WebhookSender{
+		url:        url,
+		token:      token,
+		payload:    payload,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookSender) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(s.payload(alert))
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("alert webhook returned %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
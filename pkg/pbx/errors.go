@@ -0,0 +1,19 @@
+package pbx
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Classification of PBX requests that are not room updates. ProcessPBXRequest returns these errors (possibly wrapped),
+// callers check them with errors.Is to choose the log level and the reply to PBX
+var (
+	// ErrIgnored means the request is not related to rooms. It is not a failure: reply with success and log at debug level
+	ErrIgnored = errors.New("ignored")
+	// ErrIncomingCall is an incoming call. 3CX sends requests for incoming calls too (through loopback)
+	ErrIncomingCall = fmt.Errorf("incoming call %w", ErrIgnored)
+	// ErrRegularCall is an outbound call to a number that is not a housekeeper number, e.g. a guest calling a taxi
+	ErrRegularCall = fmt.Errorf("regular outgoing call %w", ErrIgnored)
+	// ErrInvalidRequest means the request can't be decoded or the dialed number is incomplete, e.g. unknown item code. Reply with 400
+	ErrInvalidRequest = errors.New("invalid pbx request")
+)
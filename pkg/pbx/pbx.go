@@ -1,14 +1,48 @@
 package pbx
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+)
 
+// PBXProvider converts PBX requests to room updates and answers PBX contact lookups.
+// ctx is the context of the PBX request. Implementations that call the PBX API must stop when it is cancelled
 type PBXProvider interface {
-	ProcessPBXRequest(jsonDecoder *json.Decoder) (Room, error)
-	ProcessLookupByNumber(number string) (bodyAsBytes []byte)
+	ProcessPBXRequest(ctx context.Context, jsonDecoder *json.Decoder) (Room, error)
+	ProcessLookupByNumber(ctx context.Context, number string, guest *Guest) (bodyAsBytes []byte)
 }
 
 type Room struct {
 	PhoneNumber     string `json:"RoomStatusPhoneNumber"`
 	RoomCondition   string `json:"RoomCondition"`
 	HousekeeperName string `json:"HousekeeperName"`
+	Action          Action `json:"Action,omitempty"` // what to do with the room. Empty action type means "update room condition"
+}
+
+// ActionType is the kind of room update requested from the phone
+type ActionType string
+
+const (
+	ActionRoomCondition   ActionType = ""             // update room condition (clean, dirty) with Room.RoomCondition
+	ActionDoNotDisturbOn  ActionType = "dnd_on"       // set "Do Not Disturb" on the room
+	ActionDoNotDisturbOff ActionType = "dnd_off"      // clear "Do Not Disturb" on the room
+	ActionRoomBlock       ActionType = "room_block"   // take the room out of service with Action.Reason
+	ActionRoomUnblock     ActionType = "room_unblock" // return the room to inventory
+	ActionPostCharge      ActionType = "charge"       // post Action.Quantity of Action.ItemCode to the guest folio
+)
+
+// Action carries the room update requested from the phone and its arguments
+type Action struct {
+	Type     ActionType `json:"Type,omitempty"`
+	Reason   string     `json:"Reason,omitempty"`
+	ItemCode string     `json:"ItemCode,omitempty"`
+	Quantity int        `json:"Quantity,omitempty"`
+}
+
+// Guest is the caller information that PBX shows for a room with an in-house guest
+type Guest struct {
+	ReservationID string
+	FirstName     string
+	LastName      string
+	Email         string
 }
@@ -1,11 +1,14 @@
 package pbx3cx
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/olegromanchuk/hotelito/internal/configuration"
 	"github.com/olegromanchuk/hotelito/pkg/pbx"
 	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
 )
 
 type RequestBody struct {
@@ -24,10 +27,44 @@ type PBX3CX struct {
 }
 
 type Contact struct {
-	ID          int    `json:"id"`
-	FirstName   string `json:"firstname"`
-	Company     string `json:"company"`
-	MobilePhone string `json:"mobilephone"`
+	ID          ContactID `json:"id"`
+	FirstName   string    `json:"firstname"`
+	LastName    string    `json:"lastname,omitempty"`
+	Company     string    `json:"company"`
+	Email       string    `json:"email,omitempty"`
+	MobilePhone string    `json:"mobilephone"`
+}
+
+// ContactID is the id of the 3CX contact. 3CX uses numeric ids: numeric values are sent as JSON numbers,
+// other values (e.g. reservation IDs of some hospitality providers) as strings. Both are accepted by UnmarshalJSON
+type ContactID string
+
+// MarshalJSON writes the id as a number only if it is the canonical form of the number: "0123" or "+5" are written as strings
+func (id ContactID) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.ParseInt(string(id), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(id) {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+func (id *ContactID) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*id = ContactID(value)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("contact id must be a number or a string: %s", data)
+	}
+	*id = ContactID(number.String())
+	return nil
+}
+
+func init() {
+	pbx.Register("3cx", func(log *logrus.Logger, configMap *configuration.ConfigMap) (pbx.PBXProvider, error) {
+		return New(log, configMap), nil
+	})
 }
 
 // New creates new PBX3CX client
@@ -41,7 +78,7 @@ func New(log *logrus.Logger, configMapInfo *configuration.ConfigMap) *PBX3CX {
 	return pbx3cx
 }
 
-func (pbx3cx *PBX3CX) ProcessPBXRequest(jsonDecoder *json.Decoder) (room pbx.Room, err error) {
+func (pbx3cx *PBX3CX) ProcessPBXRequest(ctx context.Context, jsonDecoder *json.Decoder) (room pbx.Room, err error) {
 
 	pbx3cx.log.Debugf("Parsing request body from 3CX")
 
@@ -53,14 +90,11 @@ func (pbx3cx *PBX3CX) ProcessPBXRequest(jsonDecoder *json.Decoder) (room pbx.Roo
 	pbx3cx.log.Debugf("Got %v", requestBody)
 
 	if requestBody.CallType == "Inbound" { //junk. Due to 3CX specific we receive incoming calls also, but we do not need them.
-		return room, fmt.Errorf("incoming-call-ignoring")
+		return room, pbx.ErrIncomingCall
 	}
 
 	if requestBody.CallType == "Outbound" {
-		room, err = pbx3cx.processOutboundCall(requestBody)
-		if err != nil && err.Error() == "outgoing-regular-call-ignoring" { //regular outbound call is not related to room status
-			return room, err
-		}
+		return pbx3cx.processOutboundCall(requestBody)
 	}
 	return room, nil
 }
@@ -70,7 +104,7 @@ func (pbx3cx *PBX3CX) decodeRequestBody(jsonDecoder *json.Decoder) (RequestBody,
 	var requestBody RequestBody
 	err := jsonDecoder.Decode(&requestBody)
 	if err != nil || requestBody.CallType == "" {
-		return RequestBody{}, fmt.Errorf("error decoding request body / no callType provided: %s", err)
+		return RequestBody{}, fmt.Errorf("%w: error decoding request body / no callType provided: %v", pbx.ErrInvalidRequest, err)
 	}
 	return requestBody, nil
 }
@@ -92,42 +126,134 @@ func (pbx3cx *PBX3CX) processOutboundCall(requestBody RequestBody) (room pbx.Roo
 	}
 
 	numberInformation := mapHousekeeperNumbers[PhoneNumber4HouseKeeper] //if not found we got empty map
-	//if we got empty map - silently discard this call. It is a regular outbound call is not related to room status
 	if numberInformation.RoomStatusPhoneNumber == "" {
+		//charge numbers are prefixes: prefix + item code + quantity
+		chargeNumber, found := pbx3cx.searchChargePrefix(PhoneNumber4HouseKeeper)
+		if found {
+			return pbx3cx.processChargeCall(RoomExtension, PhoneNumber4HouseKeeper, chargeNumber)
+		}
+		//if we got empty map - silently discard this call. It is a regular outbound call is not related to room status
 		pbx3cx.log.Debugf("housekeeper number not found: %s", PhoneNumber4HouseKeeper)
-		return room, fmt.Errorf("outgoing-regular-call-ignoring")
+		return room, pbx.ErrRegularCall
 	}
 
-	pbx3cx.log.Debugf("found housekeeper number: %s. Housekeeper: %s. Room condition: %s", numberInformation.RoomStatusPhoneNumber, numberInformation.HousekeeperName, numberInformation.NumberType)
-	roomCondition := numberInformation.NumberType
+	pbx3cx.log.Debugf("found housekeeper number: %s. Housekeeper: %s. Number type: %s", numberInformation.RoomStatusPhoneNumber, numberInformation.HousekeeperName, numberInformation.NumberType)
 
 	room = pbx.Room{
 		PhoneNumber:     RoomExtension,
-		RoomCondition:   roomCondition,
-		HousekeeperName: mapHousekeeperNumbers[PhoneNumber4HouseKeeper].HousekeeperName,
+		HousekeeperName: numberInformation.HousekeeperName,
+	}
+
+	switch numberInformation.NumberType {
+	case configuration.NumberTypeDoNotDisturbOn:
+		room.Action = pbx.Action{Type: pbx.ActionDoNotDisturbOn}
+	case configuration.NumberTypeDoNotDisturbOff:
+		room.Action = pbx.Action{Type: pbx.ActionDoNotDisturbOff}
+	case configuration.NumberTypeRoomBlock:
+		room.Action = pbx.Action{Type: pbx.ActionRoomBlock, Reason: numberInformation.BlockReason}
+	case configuration.NumberTypeRoomUnblock:
+		room.Action = pbx.Action{Type: pbx.ActionRoomUnblock}
+	case configuration.NumberTypeCharge: //charge prefix dialed without item code
+		return pbx.Room{}, fmt.Errorf("%w: no item code dialed after charge number %s", pbx.ErrInvalidRequest, PhoneNumber4HouseKeeper)
+	default: //number type is a room condition: clean, dirty
+		room.RoomCondition = numberInformation.NumberType
+	}
+	return room, nil
+}
+
+// searchChargePrefix returns the charge number that dialedNumber starts with. The longest matching prefix wins
+func (pbx3cx *PBX3CX) searchChargePrefix(dialedNumber string) (chargeNumber configuration.Housekeeper, found bool) {
+	for _, housekeeper := range pbx3cx.configMap.HousekeeperMap {
+		if housekeeper.NumberType != configuration.NumberTypeCharge || housekeeper.RoomStatusPhoneNumber == "" {
+			continue
+		}
+		if strings.HasPrefix(dialedNumber, housekeeper.RoomStatusPhoneNumber) && len(housekeeper.RoomStatusPhoneNumber) > len(chargeNumber.RoomStatusPhoneNumber) {
+			chargeNumber = housekeeper
+			found = true
+		}
+	}
+	return chargeNumber, found
+}
+
+// processChargeCall parses the digits dialed after the charge prefix: item code from the item catalog (the longest matching code wins) and quantity.
+// Quantity is optional and is 1 by default
+func (pbx3cx *PBX3CX) processChargeCall(roomExtension, dialedNumber string, chargeNumber configuration.Housekeeper) (room pbx.Room, err error) {
+	digits := strings.TrimPrefix(dialedNumber, chargeNumber.RoomStatusPhoneNumber)
+
+	itemCode := ""
+	for _, item := range pbx3cx.configMap.ItemCatalog {
+		if item.ItemCode != "" && strings.HasPrefix(digits, item.ItemCode) && len(item.ItemCode) > len(itemCode) {
+			itemCode = item.ItemCode
+		}
+	}
+	if itemCode == "" {
+		return room, fmt.Errorf("%w: unknown item code in dialed number %s", pbx.ErrInvalidRequest, dialedNumber)
+	}
+
+	quantity := 1
+	if quantityDigits := strings.TrimPrefix(digits, itemCode); quantityDigits != "" {
+		quantity, err = strconv.Atoi(quantityDigits)
+		if err != nil || quantity <= 0 {
+			return pbx.Room{}, fmt.Errorf("%w: invalid quantity %s in dialed number %s", pbx.ErrInvalidRequest, quantityDigits, dialedNumber)
+		}
+	}
+
+	pbx3cx.log.Debugf("found charge number: %s. Housekeeper: %s. Item: %s, quantity: %d", chargeNumber.RoomStatusPhoneNumber, chargeNumber.HousekeeperName, itemCode, quantity)
+	room = pbx.Room{
+		PhoneNumber:     roomExtension,
+		HousekeeperName: chargeNumber.HousekeeperName,
+		Action:          pbx.Action{Type: pbx.ActionPostCharge, ItemCode: itemCode, Quantity: quantity},
 	}
 	return room, nil
 }
 
 // ProcessLookupByNumber returns the []byte that contain contact information with the given number
-// This function does not contain any meaningful logic. It just converts input number to the json Contact
+// If the number belongs to a room with an in-house guest, the guest is returned as a contact, so 3CX shows the guest name on the phone.
+// Otherwise, we just take incoming number and generate a dummy contact to satisfy 3cx.
 // We need it to satisfy 3cx API request for number lookup. 3cx sends API request and expects json with contact information
-// if no lookup information is provided back, the next request will not be sent. So, we just take incoming number and generate a dummy contact to satisfy 3cx.
-func (pbx3cx *PBX3CX) ProcessLookupByNumber(number string) (bodyAsBytes []byte) {
-	return ProcessLookupByNumber(number)
+// if no lookup information is provided back, the next request (call journaling) will not be sent.
+func (pbx3cx *PBX3CX) ProcessLookupByNumber(ctx context.Context, number string, guest *pbx.Guest) (bodyAsBytes []byte) {
+	if guest != nil {
+		pbx3cx.log.Debugf("found guest %s %s for number %s", guest.FirstName, guest.LastName, number)
+	}
+	bodyAsBytes, err := ProcessLookupByNumber(number, guest)
+	if err != nil {
+		//3CX needs a contact back. Reply with the dummy contact
+		pbx3cx.log.Errorf("failed to encode contact for number %s: %s", number, err)
+		bodyAsBytes, err = ProcessLookupByNumber(number, nil)
+		if err != nil {
+			pbx3cx.log.Errorf("failed to encode dummy contact for number %s: %s", number, err)
+		}
+	}
+	return bodyAsBytes
 }
 
-func ProcessLookupByNumber(number string) (bodyAsBytes []byte) {
+// ProcessLookupByNumber returns JSON reply with the guest as a contact or the dummy contact if guest is nil
+func ProcessLookupByNumber(number string, guest *pbx.Guest) (bodyAsBytes []byte, err error) {
 	contact := Contact{
-		ID:          12345,
+		ID:          "12345",
 		FirstName:   "dummyFirstName",
 		Company:     "dummyCompany",
 		MobilePhone: number,
 	}
 
+	if guest != nil && guest.ReservationID != "" {
+		contact = Contact{
+			ID:          ContactID(guest.ReservationID),
+			FirstName:   guest.FirstName,
+			LastName:    guest.LastName,
+			Company:     fmt.Sprintf("Room %s", number),
+			Email:       guest.Email,
+			MobilePhone: number,
+		}
+	}
+
 	returnStruct := struct {
 		Contact Contact `json:"contact"`
 	}{Contact: contact}
-	bodyAsBytes, _ = json.Marshal(returnStruct)
-	return bodyAsBytes
+	bodyAsBytes, err = json.Marshal(returnStruct)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode contact: %w", err)
+	}
+	return bodyAsBytes, nil
 }
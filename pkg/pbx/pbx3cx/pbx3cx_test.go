@@ -2,7 +2,9 @@ package pbx3cx
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/olegromanchuk/hotelito/internal/configuration"
 	"github.com/olegromanchuk/hotelito/pkg/pbx"
@@ -28,6 +30,7 @@ func TestPBX3CX_ProcessPBXRequest(t *testing.T) {
 		args     args
 		wantRoom pbx.Room
 		wantErr  bool
+		errIs    error // classification of the error checked with errors.Is
 	}{
 		{
 			name: "Test Inbound Call",
@@ -40,6 +43,7 @@ func TestPBX3CX_ProcessPBXRequest(t *testing.T) {
 			},
 			wantRoom: pbx.Room{},
 			wantErr:  true,
+			errIs:    pbx.ErrIncomingCall,
 		},
 		{
 			name: "Test Outbound Call",
@@ -102,6 +106,7 @@ func TestPBX3CX_ProcessPBXRequest(t *testing.T) {
 				HousekeeperName: "",
 			},
 			wantErr: true,
+			errIs:   pbx.ErrIgnored,
 		},
 		{
 			name: "Test bad decoder",
@@ -114,6 +119,28 @@ func TestPBX3CX_ProcessPBXRequest(t *testing.T) {
 			},
 			wantRoom: pbx.Room{},
 			wantErr:  true,
+			errIs:    pbx.ErrInvalidRequest,
+		},
+		{
+			name: "Test Outbound Call - charge without item code",
+			fields: fields{
+				log: logrus.New(),
+				configMap: &configuration.ConfigMap{
+					HousekeeperMap: []configuration.Housekeeper{
+						{
+							RoomStatusPhoneNumber: "22260",
+							HousekeeperName:       "Minibar",
+							NumberType:            configuration.NumberTypeCharge,
+						},
+					},
+				},
+			},
+			args: args{
+				jsonDecoder: json.NewDecoder(bytes.NewBufferString(`{"CallType": "Outbound", "Number": "22260", "Agent": "101"}`)),
+			},
+			wantRoom: pbx.Room{},
+			wantErr:  true,
+			errIs:    pbx.ErrInvalidRequest,
 		},
 	}
 
@@ -123,11 +150,14 @@ func TestPBX3CX_ProcessPBXRequest(t *testing.T) {
 				log:       tt.fields.log,
 				configMap: tt.fields.configMap,
 			}
-			gotRoom, err := pbx3cx.ProcessPBXRequest(tt.args.jsonDecoder)
+			gotRoom, err := pbx3cx.ProcessPBXRequest(context.Background(), tt.args.jsonDecoder)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ProcessPBXRequest() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+				t.Errorf("ProcessPBXRequest() error = %v, want errors.Is %v", err, tt.errIs)
+			}
 			if !reflect.DeepEqual(gotRoom, tt.wantRoom) {
 				t.Errorf("ProcessPBXRequest() gotRoom = %v, want %v", gotRoom, tt.wantRoom)
 			}
@@ -237,7 +267,89 @@ func TestPBX3CX_processOutboundCall(t *testing.T) {
 				HousekeeperName: "",
 			},
 			wantErr:    true,
-			wantErrMsg: "outgoing-regular-call-ignoring",
+			wantErrMsg: "regular outgoing call ignored",
+		},
+		{
+			name: "Test Outbound Call - do not disturb on",
+			fields: fields{
+				log: logrus.New(),
+				configMap: &configuration.ConfigMap{
+					HousekeeperMap: []configuration.Housekeeper{
+						{
+							RoomStatusPhoneNumber: "2222222225",
+							HousekeeperName:       "John Doe",
+							NumberType:            configuration.NumberTypeDoNotDisturbOn,
+						},
+					},
+				},
+			},
+			args: args{
+				requestBody: RequestBody{
+					Number:   "2222222225",
+					Agent:    "101",
+					CallType: "Outbound",
+				},
+			},
+			wantRoom: pbx.Room{
+				PhoneNumber:     "101",
+				HousekeeperName: "John Doe",
+				Action:          pbx.Action{Type: pbx.ActionDoNotDisturbOn},
+			},
+		},
+		{
+			name: "Test Outbound Call - do not disturb off",
+			fields: fields{
+				log: logrus.New(),
+				configMap: &configuration.ConfigMap{
+					HousekeeperMap: []configuration.Housekeeper{
+						{
+							RoomStatusPhoneNumber: "2222222226",
+							HousekeeperName:       "John Doe",
+							NumberType:            configuration.NumberTypeDoNotDisturbOff,
+						},
+					},
+				},
+			},
+			args: args{
+				requestBody: RequestBody{
+					Number:   "2222222226",
+					Agent:    "101",
+					CallType: "Outbound",
+				},
+			},
+			wantRoom: pbx.Room{
+				PhoneNumber:     "101",
+				HousekeeperName: "John Doe",
+				Action:          pbx.Action{Type: pbx.ActionDoNotDisturbOff},
+			},
+		},
+		{
+			name: "Test Outbound Call - room block",
+			fields: fields{
+				log: logrus.New(),
+				configMap: &configuration.ConfigMap{
+					HousekeeperMap: []configuration.Housekeeper{
+						{
+							RoomStatusPhoneNumber: "2222222251",
+							HousekeeperName:       "Engineer",
+							NumberType:            configuration.NumberTypeRoomBlock,
+							BlockReason:           "AC repair",
+						},
+					},
+				},
+			},
+			args: args{
+				requestBody: RequestBody{
+					Number:   "2222222251",
+					Agent:    "101",
+					CallType: "Outbound",
+				},
+			},
+			wantRoom: pbx.Room{
+				PhoneNumber:     "101",
+				HousekeeperName: "Engineer",
+				Action:          pbx.Action{Type: pbx.ActionRoomBlock, Reason: "AC repair"},
+			},
 		},
 	}
 
@@ -257,9 +369,9 @@ func TestPBX3CX_processOutboundCall(t *testing.T) {
 					t.Errorf("processOutboundCall() error = %v, wantErrMsg %v", err.Error(), tt.wantErrMsg)
 					return
 				}
-				if !reflect.DeepEqual(gotRoom, tt.wantRoom) {
-					t.Errorf("processOutboundCall() gotRoom = %v, want %v", gotRoom, tt.wantRoom)
-				}
+			}
+			if !reflect.DeepEqual(gotRoom, tt.wantRoom) {
+				t.Errorf("processOutboundCall() gotRoom = %v, want %v", gotRoom, tt.wantRoom)
 			}
 		},
 		)
@@ -267,6 +379,93 @@ func TestPBX3CX_processOutboundCall(t *testing.T) {
 
 }
 
+func TestPBX3CX_processOutboundCall_Charge(t *testing.T) {
+	pbx3cx := &PBX3CX{
+		log: logrus.New(),
+		configMap: &configuration.ConfigMap{
+			HousekeeperMap: []configuration.Housekeeper{
+				{
+					RoomStatusPhoneNumber: "2222222221",
+					HousekeeperName:       "John Doe",
+					NumberType:            "dirty",
+				},
+				{
+					RoomStatusPhoneNumber: "22260",
+					HousekeeperName:       "Minibar",
+					NumberType:            configuration.NumberTypeCharge,
+				},
+			},
+			ItemCatalog: []configuration.Item{
+				{ItemCode: "1", Name: "Water", Price: 2},
+				{ItemCode: "12", Name: "Beer", Price: 5},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		number     string
+		wantRoom   pbx.Room
+		wantErrMsg string
+	}{
+		{
+			name:   "item without quantity",
+			number: "2226012",
+			wantRoom: pbx.Room{
+				PhoneNumber:     "101",
+				HousekeeperName: "Minibar",
+				Action:          pbx.Action{Type: pbx.ActionPostCharge, ItemCode: "12", Quantity: 1},
+			},
+		},
+		{
+			name:   "longest item code and quantity",
+			number: "22260123",
+			wantRoom: pbx.Room{
+				PhoneNumber:     "101",
+				HousekeeperName: "Minibar",
+				Action:          pbx.Action{Type: pbx.ActionPostCharge, ItemCode: "12", Quantity: 3},
+			},
+		},
+		{
+			name:   "short item code and quantity",
+			number: "2226014",
+			wantRoom: pbx.Room{
+				PhoneNumber:     "101",
+				HousekeeperName: "Minibar",
+				Action:          pbx.Action{Type: pbx.ActionPostCharge, ItemCode: "1", Quantity: 4},
+			},
+		},
+		{
+			name:       "unknown item code",
+			number:     "222609",
+			wantErrMsg: "invalid pbx request: unknown item code in dialed number 222609",
+		},
+		{
+			name:       "zero quantity",
+			number:     "22260120",
+			wantErrMsg: "invalid pbx request: invalid quantity 0 in dialed number 22260120",
+		},
+		{
+			name:       "prefix without item code",
+			number:     "22260",
+			wantErrMsg: "invalid pbx request: no item code dialed after charge number 22260",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRoom, err := pbx3cx.processOutboundCall(RequestBody{Number: tt.number, Agent: "101", CallType: "Outbound"})
+			if tt.wantErrMsg != "" {
+				assert.EqualError(t, err, tt.wantErrMsg)
+				assert.ErrorIs(t, err, pbx.ErrInvalidRequest)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantRoom, gotRoom)
+		})
+	}
+}
+
 func TestProcessLookupByNumber(t *testing.T) {
 
 	pbx3cxClient := &PBX3CX{
@@ -278,7 +477,7 @@ func TestProcessLookupByNumber(t *testing.T) {
 	t.Run("returns contact", func(t *testing.T) {
 		number := "1234567890"
 		expectedContact := Contact{
-			ID:          12345,
+			ID:          "12345",
 			FirstName:   "dummyFirstName",
 			Company:     "dummyCompany",
 			MobilePhone: number,
@@ -288,10 +487,67 @@ func TestProcessLookupByNumber(t *testing.T) {
 			Contact Contact `json:"contact"`
 		}{Contact: expectedContact})
 
-		body := pbx3cxClient.ProcessLookupByNumber(number)
+		body := pbx3cxClient.ProcessLookupByNumber(context.Background(), number, nil)
 		assert.Equal(t, expectedBody, body)
 	})
 
+	t.Run("returns guest as contact", func(t *testing.T) {
+		number := "1001"
+		guest := &pbx.Guest{
+			ReservationID: "9876543210",
+			FirstName:     "Jane",
+			LastName:      "Smith",
+			Email:         "jane.smith@example.com",
+		}
+
+		body := pbx3cxClient.ProcessLookupByNumber(context.Background(), number, guest)
+		assert.Equal(t, `{"contact":{"id":9876543210,"firstname":"Jane","lastname":"Smith","company":"Room 1001","email":"jane.smith@example.com","mobilephone":"1001"}}`, string(body))
+	})
+
+	t.Run("returns guest with leading zero reservation id", func(t *testing.T) {
+		guest := &pbx.Guest{ReservationID: "0123", FirstName: "Jane"}
+
+		body := pbx3cxClient.ProcessLookupByNumber(context.Background(), "1001", guest)
+		assert.Equal(t, `{"contact":{"id":"0123","firstname":"Jane","company":"Room 1001","mobilephone":"1001"}}`, string(body))
+	})
+
+	t.Run("returns guest with non-numeric reservation id", func(t *testing.T) {
+		guest := &pbx.Guest{ReservationID: "ABCD-1", FirstName: "Jane"}
+
+		body := pbx3cxClient.ProcessLookupByNumber(context.Background(), "1001", guest)
+		assert.Equal(t, `{"contact":{"id":"ABCD-1","firstname":"Jane","company":"Room 1001","mobilephone":"1001"}}`, string(body))
+	})
+
+}
+
+func TestContact_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		json       string
+		expectedID ContactID
+		wantErr    assert.ErrorAssertionFunc
+	}{
+		{name: "numeric id", json: `{"id":12345,"firstname":"dummyFirstName"}`, expectedID: "12345", wantErr: assert.NoError},
+		{name: "string id", json: `{"id":"ABCD-1","firstname":"dummyFirstName"}`, expectedID: "ABCD-1", wantErr: assert.NoError},
+		{name: "invalid id", json: `{"id":true}`, wantErr: assert.Error},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var contact Contact
+			err := json.Unmarshal([]byte(tt.json), &contact)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.expectedID, contact.ID)
+		})
+	}
+
+	// only canonical numbers are sent back as numbers
+	for id, expected := range map[ContactID]string{"12345": `12345`, "-7": `-7`, "0123": `"0123"`, "+5": `"+5"`, "ABCD-1": `"ABCD-1"`, "99999999999999999999": `"99999999999999999999"`} {
+		body, err := json.Marshal(Contact{ID: id})
+		assert.NoError(t, err, id)
+		assert.Contains(t, string(body), `"id":`+expected, id)
+	}
 }
 
 func TestPBX3CX_decodeRequestBody(t *testing.T) {
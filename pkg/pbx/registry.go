@@ -0,0 +1,37 @@
+package pbx
+
+import (
+	"github.com/olegromanchuk/hotelito/internal/configuration"
+	"github.com/olegromanchuk/hotelito/internal/registry"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultProviderName is used when PBX_PROVIDER is not set
+const DefaultProviderName = "3cx"
+
+// ProviderFactory creates a PBX provider. Providers register their factory with Register in init()
+type ProviderFactory func(log *logrus.Logger, configMap *configuration.ConfigMap) (PBXProvider, error)
+
+var providers = registry.New[ProviderFactory]("PBX provider")
+
+// Register makes the PBX provider available by name (PBX_PROVIDER). It panics if name is registered twice
+func Register(name string, factory ProviderFactory) {
+	providers.Register(name, factory)
+}
+
+// NewProvider creates the PBX provider registered under name. Empty name means DefaultProviderName
+func NewProvider(name string, log *logrus.Logger, configMap *configuration.ConfigMap) (PBXProvider, error) {
+	if name == "" {
+		name = DefaultProviderName
+	}
+	factory, err := providers.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return factory(log, configMap)
+}
+
+// Providers returns sorted names of the registered PBX providers
+func Providers() []string {
+	return providers.Names()
+}
@@ -1,14 +1,28 @@
 package awsstore
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
 	"github.com/sirupsen/logrus"
 	"strings"
+	"time"
 )
 
+func init() {
+	secrets.Register("aws", func(log *logrus.Logger, options secrets.Options) (secrets.SecretsStore, error) {
+		store, err := Initialize(log, options.StorePrefix, options.AWSRegion, options.AWSConfig)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	})
+}
+
 type storageManager interface {
 	PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error)
 	GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
@@ -18,6 +32,8 @@ type storageManager interface {
 type AWSSecretsStore struct {
 	AccessTokenParamName  string
 	RefreshTokenParamName string
+	TokenParamName        string
+	TokenLockParamName    string
 	AWSSession            *session.Session
 	StorePrefix           string
 	Log                   *logrus.Logger
@@ -72,8 +88,7 @@ func (s *AWSSecretsStore) RetrieveRefreshToken() (string, error) {
 
 	result, err := s.SSM.GetParameter(input)
 	if err != nil {
-		errMsg := err.Error()
-		if errMsg == "ParameterNotFound: " {
+		if hasErrorCode(err, ssm.ErrCodeParameterNotFound) {
 			return "", nil
 		}
 		return "", err
@@ -82,6 +97,214 @@ func (s *AWSSecretsStore) RetrieveRefreshToken() (string, error) {
 	return *result.Parameter.Value, nil
 }
 
+// StoreToken saves the token record as JSON in TokenParamName, then access and refresh tokens in their own parameters
+func (s *AWSSecretsStore) StoreToken(token secrets.Token) error {
+	record, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(s.TokenParamName),
+		Overwrite: aws.Bool(true),
+		Type:      aws.String("SecureString"),
+		Value:     aws.String(string(record)),
+	}
+	_, err = s.SSM.PutParameter(input)
+	if err != nil {
+		return err
+	}
+
+	err = s.StoreAccessToken(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	return s.StoreRefreshToken(token.RefreshToken)
+}
+
+func (s *AWSSecretsStore) RetrieveToken() (secrets.Token, error) {
+	input := &ssm.GetParameterInput{
+		Name:           aws.String(s.TokenParamName),
+		WithDecryption: aws.Bool(true),
+	}
+
+	result, err := s.SSM.GetParameter(input)
+	if err != nil {
+		if !hasErrorCode(err, ssm.ErrCodeParameterNotFound) {
+			return secrets.Token{}, err
+		}
+		//saved by the version without token record
+		return s.retrievePlainTokens()
+	}
+
+	var token secrets.Token
+	err = json.Unmarshal([]byte(*result.Parameter.Value), &token)
+	if err != nil {
+		return secrets.Token{}, fmt.Errorf("failed to decode token record: %w", err)
+	}
+	return token, nil
+}
+
+// retrievePlainTokens returns the token made of access and refresh token parameters. Missing parameters are empty
+func (s *AWSSecretsStore) retrievePlainTokens() (secrets.Token, error) {
+	var token secrets.Token
+	var err error
+	token.AccessToken, err = s.RetrieveAccessToken()
+	if err != nil && !hasErrorCode(err, ssm.ErrCodeParameterNotFound) {
+		return secrets.Token{}, err
+	}
+	token.RefreshToken, err = s.RetrieveRefreshToken()
+	if err != nil {
+		return secrets.Token{}, err
+	}
+	return token, nil
+}
+
+// StoreProviderToken saves the token record of provider as JSON in the parameter token_<provider> of the store prefix
+func (s *AWSSecretsStore) StoreProviderToken(provider string, token secrets.Token) error {
+	record, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(s.providerTokenParamName(provider)),
+		Overwrite: aws.Bool(true),
+		Type:      aws.String("SecureString"),
+		Value:     aws.String(string(record)),
+	}
+	_, err = s.SSM.PutParameter(input)
+	return err
+}
+
+func (s *AWSSecretsStore) RetrieveProviderToken(provider string) (secrets.Token, error) {
+	input := &ssm.GetParameterInput{
+		Name:           aws.String(s.providerTokenParamName(provider)),
+		WithDecryption: aws.Bool(true),
+	}
+
+	result, err := s.SSM.GetParameter(input)
+	if err != nil {
+		if hasErrorCode(err, ssm.ErrCodeParameterNotFound) {
+			return secrets.Token{}, nil
+		}
+		return secrets.Token{}, err
+	}
+
+	var token secrets.Token
+	err = json.Unmarshal([]byte(*result.Parameter.Value), &token)
+	if err != nil {
+		return secrets.Token{}, fmt.Errorf("failed to decode token record of %s: %w", provider, err)
+	}
+	return token, nil
+}
+
+func (s *AWSSecretsStore) providerTokenParamName(provider string) string {
+	return fmt.Sprintf("/%s/token_%s", s.StorePrefix, provider)
+}
+
+// LockToken creates the lock parameter without overwrite, so only one of concurrent callers succeeds.
+// The owner extends its lock by overwriting it. The expired lock is deleted and created again. The take over is not atomic,
+// but it only happens if the owner crashed in the middle of the refresh
+func (s *AWSSecretsStore) LockToken(owner string, ttl time.Duration) (acquired bool, err error) {
+	now := time.Now()
+	acquired, err = s.putTokenLock(owner, now.Add(ttl), false)
+	if acquired || err != nil {
+		return acquired, err
+	}
+
+	lock, err := s.retrieveTokenLock()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case lock.Owner == owner:
+		return s.putTokenLock(owner, now.Add(ttl), true)
+	case lock.Held(now):
+		return false, nil
+	}
+
+	s.Log.Debugf("Token lock of %s expired at %s. Taking it over", lock.Owner, lock.Expires)
+	err = s.deleteTokenLock()
+	if err != nil {
+		return false, err
+	}
+	return s.putTokenLock(owner, now.Add(ttl), false)
+}
+
+func (s *AWSSecretsStore) UnlockToken(owner string) error {
+	lock, err := s.retrieveTokenLock()
+	if err != nil {
+		return err
+	}
+	if lock.Owner != owner {
+		return nil //the lock expired and was taken by someone else
+	}
+	return s.deleteTokenLock()
+}
+
+// putTokenLock saves the lock. Without overwrite acquired is false if the lock already exists
+func (s *AWSSecretsStore) putTokenLock(owner string, expires time.Time, overwrite bool) (acquired bool, err error) {
+	value, err := json.Marshal(secrets.TokenLock{Owner: owner, Expires: expires})
+	if err != nil {
+		return false, err
+	}
+
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(s.TokenLockParamName),
+		Overwrite: aws.Bool(overwrite),
+		Type:      aws.String("String"),
+		Value:     aws.String(string(value)),
+	}
+	_, err = s.SSM.PutParameter(input)
+	if err != nil {
+		if hasErrorCode(err, ssm.ErrCodeParameterAlreadyExists) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// retrieveTokenLock returns the saved lock. Missing lock is empty
+func (s *AWSSecretsStore) retrieveTokenLock() (lock secrets.TokenLock, err error) {
+	input := &ssm.GetParameterInput{
+		Name: aws.String(s.TokenLockParamName),
+	}
+
+	result, err := s.SSM.GetParameter(input)
+	if err != nil {
+		if hasErrorCode(err, ssm.ErrCodeParameterNotFound) {
+			return lock, nil
+		}
+		return lock, err
+	}
+
+	err = json.Unmarshal([]byte(*result.Parameter.Value), &lock)
+	if err != nil {
+		return lock, fmt.Errorf("failed to decode token lock: %w", err)
+	}
+	return lock, nil
+}
+
+func (s *AWSSecretsStore) deleteTokenLock() error {
+	input := &ssm.DeleteParameterInput{
+		Name: aws.String(s.TokenLockParamName),
+	}
+
+	_, err := s.SSM.DeleteParameter(input)
+	if err != nil && !hasErrorCode(err, ssm.ErrCodeParameterNotFound) {
+		return err
+	}
+	return nil
+}
+
+// hasErrorCode reports whether err is AWS error with code
+func hasErrorCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
+
 func (s *AWSSecretsStore) StoreOauthState(state string) error {
 
 	s.Log.Debugf("Storing state %s", state)
@@ -174,6 +397,8 @@ func (s *AWSSecretsStore) RetrieveVar(varName string) (varValue string, err erro
 func Initialize(log *logrus.Logger, storePrefix string, awsRegion string, customAWSConfig *aws.Config) (*AWSSecretsStore, error) {
 	accessTokenParamName := fmt.Sprintf("/%s/access_token", storePrefix)
 	refreshTokenParamName := fmt.Sprintf("/%s/refresh_token", storePrefix)
+	tokenParamName := fmt.Sprintf("/%s/token", storePrefix)
+	tokenLockParamName := fmt.Sprintf("/%s/token_lock", storePrefix)
 
 	// Initialize a session that the SDK uses to load
 	// credentials from the shared credentials file. (~/.aws/credentials).
@@ -189,6 +414,8 @@ func Initialize(log *logrus.Logger, storePrefix string, awsRegion string, custom
 	return &AWSSecretsStore{
 		AccessTokenParamName:  accessTokenParamName,
 		RefreshTokenParamName: refreshTokenParamName,
+		TokenParamName:        tokenParamName,
+		TokenLockParamName:    tokenLockParamName,
 		AWSSession:            sess,
 		StorePrefix:           storePrefix,
 		Log:                   log,
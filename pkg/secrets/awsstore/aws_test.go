@@ -1,17 +1,21 @@
 package awsstore
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/golang/mock/gomock"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"testing"
+	"time"
 )
 
 func TestStoreAccessToken(t *testing.T) {
@@ -209,7 +213,7 @@ func TestRetrieveRefreshToken(t *testing.T) {
 		{
 			name:            "GetParameter returns ParameterNotFound",
 			mockReturnValue: nil,
-			mockReturnErr:   errors.New("ParameterNotFound: "),
+			mockReturnErr:   awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil),
 			expectedValue:   "",
 			expectedErr:     nil,
 		},
@@ -250,6 +254,336 @@ func TestRetrieveRefreshToken(t *testing.T) {
 	}
 }
 
+func TestStoreToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	token := secrets.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+	record := `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expiry":"2023-07-01T12:00:00Z","refreshed_at":"0001-01-01T00:00:00Z"}`
+
+	tests := []struct {
+		name        string
+		recordErr   error
+		accessErr   error
+		expectedErr error
+	}{
+		{
+			name: "Successful PutParameter calls",
+		},
+		{
+			name:        "Token record is not saved",
+			recordErr:   errors.New("PutParameter error"),
+			expectedErr: errors.New("PutParameter error"),
+		},
+		{
+			name:        "Access token is not saved",
+			accessErr:   errors.New("PutParameter error"),
+			expectedErr: errors.New("PutParameter error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSM := NewMockstorageManager(ctrl)
+
+			store := &AWSSecretsStore{
+				AccessTokenParamName:  "accessParam",
+				RefreshTokenParamName: "refreshParam",
+				TokenParamName:        "tokenParam",
+				SSM:                   mockSM,
+			}
+
+			putInput := func(name, value string) *ssm.PutParameterInput {
+				return &ssm.PutParameterInput{
+					Name:      aws.String(name),
+					Overwrite: aws.Bool(true),
+					Type:      aws.String("SecureString"),
+					Value:     aws.String(value),
+				}
+			}
+
+			mockSM.EXPECT().PutParameter(putInput("tokenParam", record)).Return(&ssm.PutParameterOutput{}, tt.recordErr)
+			if tt.recordErr == nil {
+				mockSM.EXPECT().PutParameter(putInput("accessParam", "access")).Return(&ssm.PutParameterOutput{}, tt.accessErr)
+			}
+			if tt.recordErr == nil && tt.accessErr == nil {
+				mockSM.EXPECT().PutParameter(putInput("refreshParam", "refresh")).Return(&ssm.PutParameterOutput{}, nil)
+			}
+
+			err := store.StoreToken(token)
+			if tt.expectedErr != nil {
+				assert.EqualError(t, err, tt.expectedErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestRetrieveToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	getOutput := func(value string) *ssm.GetParameterOutput {
+		return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}
+	}
+
+	tests := []struct {
+		name          string
+		recordOutput  *ssm.GetParameterOutput
+		recordErr     error
+		plainTokens   bool // token record is not found and access/refresh token parameters are read
+		accessErr     error
+		expectedValue secrets.Token
+		expectedErr   error
+	}{
+		{
+			name:          "Token record",
+			recordOutput:  getOutput(`{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expiry":"2023-07-01T12:00:00Z"}`),
+			expectedValue: secrets.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:          "Token record is not found",
+			recordErr:     awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil),
+			plainTokens:   true,
+			expectedValue: secrets.Token{AccessToken: "access", RefreshToken: "refresh"},
+		},
+		{
+			name:          "Token record and access token are not found",
+			recordErr:     awserr.NewRequestFailure(awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil), 400, "request-id"),
+			plainTokens:   true,
+			accessErr:     awserr.NewRequestFailure(awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil), 400, "request-id"),
+			expectedValue: secrets.Token{RefreshToken: "refresh"},
+		},
+		{
+			name:        "GetParameter returns generic error",
+			recordErr:   errors.New("Some other error"),
+			expectedErr: errors.New("Some other error"),
+		},
+		{
+			name:         "Broken token record",
+			recordOutput: getOutput("{broken"),
+			expectedErr:  errors.New("failed to decode token record: invalid character 'b' looking for beginning of object key string"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSM := NewMockstorageManager(ctrl)
+
+			store := &AWSSecretsStore{
+				AccessTokenParamName:  "accessParam",
+				RefreshTokenParamName: "refreshParam",
+				TokenParamName:        "tokenParam",
+				SSM:                   mockSM,
+			}
+
+			getInput := func(name string) *ssm.GetParameterInput {
+				return &ssm.GetParameterInput{
+					Name:           aws.String(name),
+					WithDecryption: aws.Bool(true),
+				}
+			}
+
+			mockSM.EXPECT().GetParameter(getInput("tokenParam")).Return(tt.recordOutput, tt.recordErr)
+			if tt.plainTokens {
+				accessOutput := getOutput("access")
+				if tt.accessErr != nil {
+					accessOutput = nil
+				}
+				mockSM.EXPECT().GetParameter(getInput("accessParam")).Return(accessOutput, tt.accessErr)
+				mockSM.EXPECT().GetParameter(getInput("refreshParam")).Return(getOutput("refresh"), nil)
+			}
+
+			value, err := store.RetrieveToken()
+
+			if tt.expectedErr != nil {
+				assert.EqualError(t, err, tt.expectedErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.expectedValue, value)
+		})
+	}
+}
+
+func TestProviderToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSM := NewMockstorageManager(ctrl)
+	store := &AWSSecretsStore{StorePrefix: "hotelito-app-production", TokenParamName: "tokenParam", SSM: mockSM}
+	paramName := "/hotelito-app-production/token_apaleo"
+	record := `{"access_token":"access","refresh_token":"","expiry":"2023-07-01T12:00:00Z","refreshed_at":"0001-01-01T00:00:00Z"}`
+	token := secrets.Token{AccessToken: "access", Expiry: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+
+	mockSM.EXPECT().PutParameter(&ssm.PutParameterInput{
+		Name:      aws.String(paramName),
+		Overwrite: aws.Bool(true),
+		Type:      aws.String("SecureString"),
+		Value:     aws.String(record),
+	}).Return(&ssm.PutParameterOutput{}, nil)
+	assert.NoError(t, store.StoreProviderToken("apaleo", token))
+
+	getInput := &ssm.GetParameterInput{Name: aws.String(paramName), WithDecryption: aws.Bool(true)}
+	mockSM.EXPECT().GetParameter(getInput).Return(&ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(record)}}, nil)
+	value, err := store.RetrieveProviderToken("apaleo")
+	assert.NoError(t, err)
+	assert.Equal(t, token, value)
+
+	//nothing saved yet
+	mockSM.EXPECT().GetParameter(getInput).Return(nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil))
+	value, err = store.RetrieveProviderToken("apaleo")
+	assert.NoError(t, err)
+	assert.Equal(t, secrets.Token{}, value)
+
+	mockSM.EXPECT().GetParameter(getInput).Return(nil, errors.New("Some other error"))
+	_, err = store.RetrieveProviderToken("apaleo")
+	assert.EqualError(t, err, "Some other error")
+}
+
+func TestLockToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	alreadyExists := awserr.New(ssm.ErrCodeParameterAlreadyExists, "exists", nil)
+	lockOutput := func(owner string, expires time.Time) *ssm.GetParameterOutput {
+		value, _ := json.Marshal(secrets.TokenLock{Owner: owner, Expires: expires})
+		return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(string(value))}}
+	}
+
+	tests := []struct {
+		name             string
+		putErrs          []error // results of PutParameter calls in order
+		existingLock     *ssm.GetParameterOutput
+		expectOverwrite  bool
+		expectDelete     bool
+		expectedAcquired bool
+		expectedErr      error
+	}{
+		{
+			name:             "lock is created",
+			putErrs:          []error{nil},
+			expectedAcquired: true,
+		},
+		{
+			name:             "lock is held by another owner",
+			putErrs:          []error{alreadyExists},
+			existingLock:     lockOutput("other", time.Now().Add(time.Minute)),
+			expectedAcquired: false,
+		},
+		{
+			name:             "own lock is extended",
+			putErrs:          []error{alreadyExists, nil},
+			existingLock:     lockOutput("me", time.Now().Add(time.Minute)),
+			expectOverwrite:  true,
+			expectedAcquired: true,
+		},
+		{
+			name:             "expired lock is taken over",
+			putErrs:          []error{alreadyExists, nil},
+			existingLock:     lockOutput("crashed", time.Now().Add(-time.Minute)),
+			expectDelete:     true,
+			expectedAcquired: true,
+		},
+		{
+			name:        "PutParameter returns generic error",
+			putErrs:     []error{errors.New("Some other error")},
+			expectedErr: errors.New("Some other error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSM := NewMockstorageManager(ctrl)
+
+			store := &AWSSecretsStore{
+				TokenLockParamName: "lockParam",
+				Log:                logrus.New(),
+				SSM:                mockSM,
+			}
+
+			var calls []*gomock.Call
+			for i := range tt.putErrs {
+				putErr := tt.putErrs[i]
+				overwrite := i > 0 && tt.expectOverwrite
+				call := mockSM.EXPECT().PutParameter(gomock.Any()).DoAndReturn(func(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+					assert.Equal(t, "lockParam", *input.Name)
+					assert.Equal(t, overwrite, *input.Overwrite)
+					var lock secrets.TokenLock
+					assert.NoError(t, json.Unmarshal([]byte(*input.Value), &lock))
+					assert.Equal(t, "me", lock.Owner)
+					return &ssm.PutParameterOutput{}, putErr
+				})
+				calls = append(calls, call)
+			}
+			if tt.existingLock != nil {
+				mockSM.EXPECT().GetParameter(&ssm.GetParameterInput{Name: aws.String("lockParam")}).Return(tt.existingLock, nil)
+			}
+			if tt.expectDelete {
+				mockSM.EXPECT().DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String("lockParam")}).Return(&ssm.DeleteParameterOutput{}, nil)
+			}
+			gomock.InOrder(calls...)
+
+			acquired, err := store.LockToken("me", time.Minute)
+
+			if tt.expectedErr != nil {
+				assert.EqualError(t, err, tt.expectedErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.expectedAcquired, acquired)
+		})
+	}
+}
+
+func TestUnlockToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name         string
+		lockOwner    string
+		lockErr      error
+		expectDelete bool
+		expectedErr  error
+	}{
+		{name: "own lock is deleted", lockOwner: "me", expectDelete: true},
+		{name: "lock of another owner is kept", lockOwner: "other"},
+		{name: "no lock", lockErr: awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)},
+		{name: "GetParameter returns generic error", lockErr: errors.New("Some other error"), expectedErr: errors.New("Some other error")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSM := NewMockstorageManager(ctrl)
+
+			store := &AWSSecretsStore{
+				TokenLockParamName: "lockParam",
+				SSM:                mockSM,
+			}
+
+			var output *ssm.GetParameterOutput
+			if tt.lockErr == nil {
+				value, _ := json.Marshal(secrets.TokenLock{Owner: tt.lockOwner, Expires: time.Now().Add(time.Minute)})
+				output = &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(string(value))}}
+			}
+			mockSM.EXPECT().GetParameter(&ssm.GetParameterInput{Name: aws.String("lockParam")}).Return(output, tt.lockErr)
+			if tt.expectDelete {
+				mockSM.EXPECT().DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String("lockParam")}).Return(&ssm.DeleteParameterOutput{}, nil)
+			}
+
+			err := store.UnlockToken("me")
+
+			if tt.expectedErr != nil {
+				assert.EqualError(t, err, tt.expectedErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
 func TestStoreOauthState(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -491,6 +825,8 @@ func TestInitialize(t *testing.T) {
 		awsRegion             string
 		AccessTokenParamName  string
 		RefreshTokenParamName string
+		TokenParamName        string
+		TokenLockParamName    string
 		mockSession           *session.Session
 		mockErr               error
 		expectErr             bool
@@ -501,6 +837,8 @@ func TestInitialize(t *testing.T) {
 			awsRegion:             "us-west-2",
 			AccessTokenParamName:  "/test/access_token",
 			RefreshTokenParamName: "/test/refresh_token",
+			TokenParamName:        "/test/token",
+			TokenLockParamName:    "/test/token_lock",
 			mockSession:           &session.Session{},
 			expectErr:             false,
 		},
@@ -516,6 +854,8 @@ func TestInitialize(t *testing.T) {
 			assert.Equal(t, tt.storePrefix, store.StorePrefix)
 			assert.Equal(t, tt.AccessTokenParamName, store.AccessTokenParamName)
 			assert.Equal(t, tt.RefreshTokenParamName, store.RefreshTokenParamName)
+			assert.Equal(t, tt.TokenParamName, store.TokenParamName)
+			assert.Equal(t, tt.TokenLockParamName, store.TokenLockParamName)
 			assert.Equal(t, tt.storePrefix, store.StorePrefix)
 			assert.Equal(t, tt.awsRegion, *store.AWSSession.Config.Region)
 		})
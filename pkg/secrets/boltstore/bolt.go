@@ -1,9 +1,25 @@
 package boltstore
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
+	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
+	"os"
+	"time"
 )
 
+func init() {
+	secrets.Register("bolt", func(log *logrus.Logger, options secrets.Options) (secrets.SecretsStore, error) {
+		store, err := InitializeFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	})
+}
+
 type BoltDBStore struct {
 	Db         *bolt.DB
 	BucketName string
@@ -90,6 +106,164 @@ func (s *BoltDBStore) RetrieveRefreshToken() (string, error) {
 	return token, nil
 }
 
+func (s *BoltDBStore) StoreToken(token secrets.Token) error {
+	record, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	//one transaction: the record and the plain tokens are never out of sync
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(s.BucketName))
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put([]byte("token"), record)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte("access_token"), []byte(token.AccessToken))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("refresh_token"), []byte(token.RefreshToken))
+	})
+}
+
+func (s *BoltDBStore) RetrieveToken() (secrets.Token, error) {
+	var token secrets.Token
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(s.BucketName))
+		if bucket == nil {
+			return nil //do not return error here!!! This is a valid case when token is not set
+		}
+
+		record := bucket.Get([]byte("token"))
+		if record == nil {
+			//saved by the version without token record
+			token.AccessToken = string(bucket.Get([]byte("access_token")))
+			token.RefreshToken = string(bucket.Get([]byte("refresh_token")))
+			return nil
+		}
+
+		err := json.Unmarshal(record, &token)
+		if err != nil {
+			return fmt.Errorf("failed to decode token record: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return secrets.Token{}, err
+	}
+
+	return token, nil
+}
+
+// StoreProviderToken saves the token record of provider under the key token_<provider>
+func (s *BoltDBStore) StoreProviderToken(provider string, token secrets.Token) error {
+	record, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(s.BucketName))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("token_"+provider), record)
+	})
+}
+
+func (s *BoltDBStore) RetrieveProviderToken(provider string) (secrets.Token, error) {
+	var token secrets.Token
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(s.BucketName))
+		if bucket == nil {
+			return nil //nothing saved yet
+		}
+
+		record := bucket.Get([]byte("token_" + provider))
+		if record == nil {
+			return nil
+		}
+		err := json.Unmarshal(record, &token)
+		if err != nil {
+			return fmt.Errorf("failed to decode token record of %s: %w", provider, err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return secrets.Token{}, err
+	}
+
+	return token, nil
+}
+
+// LockToken checks and takes the lock in one transaction. Bolt allows only one writer at a time, so it is atomic
+func (s *BoltDBStore) LockToken(owner string, ttl time.Duration) (acquired bool, err error) {
+	err = s.Db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(s.BucketName))
+		if err != nil {
+			return err
+		}
+
+		lock, err := decodeTokenLock(bucket.Get([]byte("token_lock")))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if lock.Held(now) && lock.Owner != owner {
+			return nil
+		}
+
+		value, err := json.Marshal(secrets.TokenLock{Owner: owner, Expires: now.Add(ttl)})
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte("token_lock"), value)
+		if err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return acquired, nil
+}
+
+func (s *BoltDBStore) UnlockToken(owner string) error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(s.BucketName))
+		if bucket == nil {
+			return nil
+		}
+
+		lock, err := decodeTokenLock(bucket.Get([]byte("token_lock")))
+		if err != nil {
+			return err
+		}
+		if lock.Owner != owner {
+			return nil //the lock expired and was taken by someone else
+		}
+		return bucket.Delete([]byte("token_lock"))
+	})
+}
+
+func decodeTokenLock(value []byte) (lock secrets.TokenLock, err error) {
+	if value == nil {
+		return lock, nil
+	}
+	err = json.Unmarshal(value, &lock)
+	if err != nil {
+		return lock, fmt.Errorf("failed to decode token lock: %w", err)
+	}
+	return lock, nil
+}
+
 func (s *BoltDBStore) StoreOauthState(state string) error {
 	return s.Db.Update(func(tx *bolt.Tx) error {
 		// we need custom bucket "state" here to effectively delete if after the state is retrieved
@@ -178,6 +352,28 @@ func Initialize(dbFileName, bucket_name string) (*BoltDBStore, error) {
 	}, nil
 }
 
+// InitializeFromEnv opens the database STANDALONE_VERSION_BOLT_DB_FILENAME with bucket STANDALONE_VERSION_BOLT_DB_BUCKET_NAME
+func InitializeFromEnv() (*BoltDBStore, error) {
+
+	//get file name from env
+	dbFileName := os.Getenv("STANDALONE_VERSION_BOLT_DB_FILENAME")
+	if dbFileName == "" {
+		return nil, fmt.Errorf("STANDALONE_VERSION_BOLT_DB_FILENAME env variable is not set")
+	}
+
+	bucketName := os.Getenv("STANDALONE_VERSION_BOLT_DB_BUCKET_NAME")
+	if bucketName == "" {
+		return nil, fmt.Errorf("STANDALONE_VERSION_BOLT_DB_BUCKET_NAME env variable is not set")
+	}
+
+	storeClient, err := Initialize(dbFileName, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing bolt store: %s", err)
+	}
+
+	return storeClient, nil
+}
+
 func (s *BoltDBStore) Close() error {
 	return s.Db.Close()
 }
@@ -1,10 +1,12 @@
 package boltstore
 
 import (
+	"github.com/olegromanchuk/hotelito/pkg/secrets"
 	"github.com/stretchr/testify/assert"
 	bolt "go.etcd.io/bbolt"
 	"os"
 	"testing"
+	"time"
 )
 
 var (
@@ -155,6 +157,174 @@ func TestRetrieveRefreshToken(t *testing.T) {
 	}
 }
 
+func TestStoreToken(t *testing.T) {
+	expiry := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		token      secrets.Token
+		bucketName string
+		expectErr  bool
+	}{
+		{"Valid Token", secrets.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: expiry}, "test_bucket", false},
+		{"Empty bucket name", secrets.Token{AccessToken: "access"}, "", true},
+		{"Empty token", secrets.Token{}, "test_bucket", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setup()
+			defer teardown(store)
+			store.BucketName = tt.bucketName
+
+			err := store.StoreToken(tt.token)
+
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			//plain tokens are saved too
+			accessToken, err := store.RetrieveAccessToken()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.token.AccessToken, accessToken)
+			refreshToken, err := store.RetrieveRefreshToken()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.token.RefreshToken, refreshToken)
+		})
+	}
+}
+
+func TestRetrieveToken(t *testing.T) {
+	expiry := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		prepare     func(store *BoltDBStore)
+		expectValue secrets.Token
+		expectErr   bool
+	}{
+		{
+			name: "Token record",
+			prepare: func(store *BoltDBStore) {
+				_ = store.StoreToken(secrets.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: expiry})
+			},
+			expectValue: secrets.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: expiry},
+		},
+		{
+			name: "Only plain tokens are saved",
+			prepare: func(store *BoltDBStore) {
+				_ = store.StoreAccessToken("access")
+				_ = store.StoreRefreshToken("refresh")
+			},
+			expectValue: secrets.Token{AccessToken: "access", RefreshToken: "refresh"},
+		},
+		{
+			name:        "Nothing saved",
+			prepare:     func(store *BoltDBStore) {},
+			expectValue: secrets.Token{},
+		},
+		{
+			name: "Broken record",
+			prepare: func(store *BoltDBStore) {
+				_ = store.Db.Update(func(tx *bolt.Tx) error {
+					bucket, _ := tx.CreateBucketIfNotExists([]byte(store.BucketName))
+					return bucket.Put([]byte("token"), []byte("{broken"))
+				})
+			},
+			expectValue: secrets.Token{},
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setup()
+			defer teardown(store)
+			tt.prepare(store)
+
+			token, err := store.RetrieveToken()
+
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectValue, token)
+		})
+	}
+}
+
+func TestProviderToken(t *testing.T) {
+	store := setup()
+	defer teardown(store)
+
+	token, err := store.RetrieveProviderToken("apaleo")
+	assert.NoError(t, err)
+	assert.Equal(t, secrets.Token{}, token)
+
+	expiry := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, store.StoreProviderToken("apaleo", secrets.Token{AccessToken: "apaleo-access", Expiry: expiry}))
+	assert.NoError(t, store.StoreProviderToken("opera", secrets.Token{AccessToken: "opera-access"}))
+
+	token, err = store.RetrieveProviderToken("apaleo")
+	assert.NoError(t, err)
+	assert.Equal(t, secrets.Token{AccessToken: "apaleo-access", Expiry: expiry}, token)
+
+	//the token of the provider with the interactive login is not changed
+	accessToken, err := store.RetrieveAccessToken()
+	assert.NoError(t, err)
+	assert.Empty(t, accessToken)
+}
+
+func TestLockToken(t *testing.T) {
+	store := setup()
+	defer teardown(store)
+
+	acquired, err := store.LockToken("first", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	//held by another owner
+	acquired, err = store.LockToken("second", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	//the owner may extend its lock
+	acquired, err = store.LockToken("first", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	//unlock by another owner is ignored
+	assert.NoError(t, store.UnlockToken("second"))
+	acquired, err = store.LockToken("second", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	assert.NoError(t, store.UnlockToken("first"))
+	acquired, err = store.LockToken("second", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestLockToken_Expired(t *testing.T) {
+	store := setup()
+	defer teardown(store)
+
+	acquired, err := store.LockToken("crashed", -time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = store.LockToken("second", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	//the expired owner doesn't release the lock of the new one
+	assert.NoError(t, store.UnlockToken("crashed"))
+	acquired, err = store.LockToken("third", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+}
+
 func TestStoreOauthState(t *testing.T) {
 
 	tests := []struct {
@@ -267,3 +437,58 @@ func TestInitializeAndClose(t *testing.T) {
 	assert.Nil(t, err)
 	os.Remove(testDbName)
 }
+
+func TestInitializeFromEnv(t *testing.T) {
+
+	dbFileName := "test.db"
+	tests := []struct {
+		name           string
+		dbEnv          string
+		bucketEnv      string
+		expectError    bool
+		expectedErrMsg string
+		expectedBucket string
+	}{
+		{
+			name:           "valid environment variables",
+			dbEnv:          dbFileName,
+			bucketEnv:      "test_bucket",
+			expectError:    false,
+			expectedBucket: "test_bucket",
+		},
+		{
+			name:           "missing db env",
+			dbEnv:          "",
+			bucketEnv:      "test_bucket",
+			expectError:    true,
+			expectedErrMsg: "STANDALONE_VERSION_BOLT_DB_FILENAME env variable is not set",
+		},
+		{
+			name:           "missing bucket env",
+			dbEnv:          dbFileName,
+			bucketEnv:      "",
+			expectError:    true,
+			expectedErrMsg: "STANDALONE_VERSION_BOLT_DB_BUCKET_NAME env variable is not set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Mock environment variables
+			os.Setenv("STANDALONE_VERSION_BOLT_DB_FILENAME", tt.dbEnv)
+			os.Setenv("STANDALONE_VERSION_BOLT_DB_BUCKET_NAME", tt.bucketEnv)
+
+			store, err := InitializeFromEnv()
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErrMsg, err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBucket, store.BucketName)
+				store.Close()
+			}
+		})
+	}
+	os.Remove(dbFileName)
+}
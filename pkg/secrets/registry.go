@@ -0,0 +1,38 @@
+package secrets
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/olegromanchuk/hotelito/internal/registry"
+	"github.com/sirupsen/logrus"
+)
+
+// Options are the settings a binary passes to the secret store. Each store uses the ones it needs
+type Options struct {
+	StorePrefix string      // application-name/environment, e.g. hotelito-app/production
+	AWSRegion   string      // region of AWS based stores
+	AWSConfig   *aws.Config // custom AWS config (localstack in tests). nil in production
+}
+
+// StoreFactory creates a secret store. Stores register their factory with Register in init()
+type StoreFactory func(log *logrus.Logger, options Options) (SecretsStore, error)
+
+var stores = registry.New[StoreFactory]("secret store")
+
+// Register makes the secret store available by name (SECRETS_STORE). It panics if name is registered twice
+func Register(name string, factory StoreFactory) {
+	stores.Register(name, factory)
+}
+
+// NewStore creates the secret store registered under name
+func NewStore(name string, log *logrus.Logger, options Options) (SecretsStore, error) {
+	factory, err := stores.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return factory(log, options)
+}
+
+// Stores returns sorted names of the registered secret stores
+func Stores() []string {
+	return stores.Names()
+}
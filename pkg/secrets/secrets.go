@@ -1,12 +1,61 @@
 package secrets
 
+import "time"
+
 type SecretsStore interface {
 	StoreAccessToken(token string) error
 	StoreRefreshToken(token string) error
 	RetrieveAccessToken() (string, error)
 	RetrieveRefreshToken() (string, error)
+	// StoreToken saves the whole token record. Access and refresh tokens are also saved under their own names,
+	// so RetrieveAccessToken and RetrieveRefreshToken return them as well
+	StoreToken(token Token) error
+	// RetrieveToken returns the token record. If only the access and refresh tokens were saved (by an older version),
+	// they are returned with empty TokenType and zero Expiry. Nothing saved is not an error: an empty Token is returned
+	RetrieveToken() (Token, error)
 	StoreOauthState(state string) error
 	RetrieveOauthState(state string) (string, error)
 	RetrieveVar(varName string) (varValue string, err error)
 	Close() error
 }
+
+// Token is the OAuth token record kept in the secret store. Expiry is zero if the provider did not report it
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	// RefreshedAt is the time the token was issued or refreshed last time. Zero if unknown
+	RefreshedAt time.Time `json:"refreshed_at,omitempty"`
+}
+
+// TokenLocker is implemented by secret stores that can be shared by several processes, e.g. concurrent lambda invocations.
+// Refreshing the token invalidates the previous refresh token, so only the holder of the lock refreshes it.
+// Others wait until the new token is saved and reuse it
+type TokenLocker interface {
+	// LockToken acquires the lock for owner. The lock expires after ttl, so a crashed owner doesn't block others forever.
+	// acquired is false if the lock is held by another owner
+	LockToken(owner string, ttl time.Duration) (acquired bool, err error)
+	// UnlockToken releases the lock if it is held by owner
+	UnlockToken(owner string) error
+}
+
+// TokenLock is the value of the token lock saved by TokenLocker implementations
+type TokenLock struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// Held reports whether the lock is held by someone at the moment now
+func (l TokenLock) Held(now time.Time) bool {
+	return l.Owner != "" && now.Before(l.Expires)
+}
+
+// ProviderTokenStore is implemented by secret stores that keep tokens of several providers. StoreToken is used by the
+// hospitality provider with the interactive login (Cloudbeds). Providers that request their own tokens (e.g. Apaleo, OPERA)
+// save them under their name, so the tokens don't overwrite each other
+type ProviderTokenStore interface {
+	StoreProviderToken(provider string, token Token) error
+	// RetrieveProviderToken returns the token saved for provider. Nothing saved is not an error: an empty Token is returned
+	RetrieveProviderToken(provider string) (Token, error)
+}